@@ -0,0 +1,340 @@
+// Package age writes and reads files in the age v1 format
+// (age-encryption.org/v1), with the file key wrapped by a wrapper.
+//
+// Files list one stanza per recipient. A wrapper's stanza, of type
+// StanzaType, holds the blob of the file key and can be decrypted through
+// this package, the kmswrap command, or an age plugin built on it. Adding an
+// X25519 recipient alongside lets standard age tooling decrypt the same file
+// with the matching identity, for example during an outage of the KMS.
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Version is the first line of every age v1 file
+const Version = "age-encryption.org/v1"
+
+const (
+	fileKeySize = 16
+	nonceSize   = 16
+	chunkSize   = 64 * 1024
+
+	// columns is the length of full lines of stanza bodies
+	columns = 64
+)
+
+// ErrIncorrectIdentity is returned by Identity.Unwrap for stanzas that are
+// not meant for it
+var ErrIncorrectIdentity = errors.New("incorrect identity for recipient stanza")
+
+// Stanza is a recipient's entry in the header, holding the wrapped file key
+type Stanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// Recipient wraps file keys into stanzas
+type Recipient interface {
+	Wrap(ctx context.Context, fileKey []byte) (*Stanza, error)
+}
+
+// Identity unwraps file keys from stanzas, returning ErrIncorrectIdentity for
+// stanzas of another recipient
+type Identity interface {
+	Unwrap(ctx context.Context, s *Stanza) ([]byte, error)
+}
+
+var b64 = base64.RawStdEncoding
+
+// Encrypt encrypts plaintext into an age file for the recipients
+func Encrypt(ctx context.Context, plaintext []byte, recipients ...Recipient) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients given")
+	}
+	fileKey := make([]byte, fileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, fmt.Errorf("error generating file key: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(Version + "\n")
+	for _, r := range recipients {
+		s, err := r.Wrap(ctx, fileKey)
+		if err != nil {
+			return nil, fmt.Errorf("error wrapping file key: %w", err)
+		}
+		if err := writeStanza(&buf, s); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString("---")
+	mac, err := headerMAC(fileKey, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString(" " + b64.EncodeToString(mac) + "\n")
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	buf.Write(nonce)
+	aead, err := payloadAEAD(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	var counter [chacha20poly1305.NonceSize]byte
+	for {
+		n := len(plaintext)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		last := n == len(plaintext)
+		if last {
+			counter[len(counter)-1] = 1
+		}
+		buf.Write(aead.Seal(nil, counter[:], plaintext[:n], nil))
+		if last {
+			return buf.Bytes(), nil
+		}
+		plaintext = plaintext[n:]
+		if err := increment(&counter); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Decrypt decrypts an age file with the first identity able to unwrap one of
+// its stanzas
+func Decrypt(ctx context.Context, file []byte, identities ...Identity) ([]byte, error) {
+	stanzas, header, mac, payload, err := parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileKey []byte
+Stanzas:
+	for _, s := range stanzas {
+		for _, id := range identities {
+			key, err := id.Unwrap(ctx, s)
+			if errors.Is(err, ErrIncorrectIdentity) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error unwrapping file key from %s stanza: %w", s.Type, err)
+			}
+			fileKey = key
+			break Stanzas
+		}
+	}
+	if fileKey == nil {
+		return nil, errors.New("no identity matched any of the recipients")
+	}
+	if len(fileKey) != fileKeySize {
+		return nil, fmt.Errorf("invalid file key length %d", len(fileKey))
+	}
+
+	expected, err := headerMAC(fileKey, header)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, expected) {
+		return nil, errors.New("bad header MAC")
+	}
+
+	if len(payload) < nonceSize {
+		return nil, errors.New("payload is truncated")
+	}
+	aead, err := payloadAEAD(fileKey, payload[:nonceSize])
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[nonceSize:]
+	var counter [chacha20poly1305.NonceSize]byte
+	var plaintext []byte
+	for {
+		n := len(payload)
+		if n > chunkSize+aead.Overhead() {
+			n = chunkSize + aead.Overhead()
+		}
+		last := n == len(payload)
+		if last {
+			counter[len(counter)-1] = 1
+		}
+		chunk, err := aead.Open(nil, counter[:], payload[:n], nil)
+		if err != nil {
+			return nil, errors.New("error decrypting payload chunk")
+		}
+		// Only an empty file has an empty chunk
+		if len(chunk) == 0 && (plaintext != nil || !last) {
+			return nil, errors.New("unexpected empty payload chunk")
+		}
+		plaintext = append(plaintext, chunk...)
+		if last {
+			if plaintext == nil {
+				plaintext = []byte{}
+			}
+			return plaintext, nil
+		}
+		payload = payload[n:]
+		if err := increment(&counter); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// IsAge reports whether data starts like an age v1 file
+func IsAge(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Version+"\n"))
+}
+
+func writeStanza(w *bytes.Buffer, s *Stanza) error {
+	for _, v := range append([]string{s.Type}, s.Args...) {
+		if !isArg(v) {
+			return fmt.Errorf("invalid stanza argument %q", v)
+		}
+	}
+	w.WriteString("-> " + strings.Join(append([]string{s.Type}, s.Args...), " ") + "\n")
+	body := b64.EncodeToString(s.Body)
+	for len(body) >= columns {
+		w.WriteString(body[:columns] + "\n")
+		body = body[columns:]
+	}
+	// The final line is always short, if need be empty
+	w.WriteString(body + "\n")
+	return nil
+}
+
+// parse splits an age file into its stanzas, the header as covered by the
+// MAC, the MAC and the payload
+func parse(file []byte) (stanzas []*Stanza, header, mac, payload []byte, err error) {
+	r := bufio.NewReader(bytes.NewReader(file))
+	read := 0
+	line := func() (string, error) {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return "", errors.New("header is truncated")
+		}
+		read += len(l)
+		return strings.TrimSuffix(l, "\n"), nil
+	}
+
+	l, err := line()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if l != Version {
+		return nil, nil, nil, nil, fmt.Errorf("unsupported format %q", l)
+	}
+	for {
+		if l, err = line(); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if strings.HasPrefix(l, "--- ") {
+			break
+		}
+		if !strings.HasPrefix(l, "-> ") {
+			return nil, nil, nil, nil, fmt.Errorf("malformed header line %q", l)
+		}
+		fields := strings.Split(strings.TrimPrefix(l, "-> "), " ")
+		for _, f := range fields {
+			if !isArg(f) {
+				return nil, nil, nil, nil, fmt.Errorf("malformed stanza line %q", l)
+			}
+		}
+		s := &Stanza{Type: fields[0], Args: fields[1:]}
+		var body strings.Builder
+		for {
+			if l, err = line(); err != nil {
+				return nil, nil, nil, nil, err
+			}
+			if len(l) > columns {
+				return nil, nil, nil, nil, errors.New("stanza body line is too long")
+			}
+			body.WriteString(l)
+			if len(l) < columns {
+				break
+			}
+		}
+		if s.Body, err = b64.Strict().DecodeString(body.String()); err != nil {
+			return nil, nil, nil, nil, fmt.Errorf("error decoding %s stanza body: %w", s.Type, err)
+		}
+		stanzas = append(stanzas, s)
+	}
+	if len(stanzas) == 0 {
+		return nil, nil, nil, nil, errors.New("file has no recipients")
+	}
+	if mac, err = b64.Strict().DecodeString(strings.TrimPrefix(l, "--- ")); err != nil || len(mac) != sha256.Size {
+		return nil, nil, nil, nil, errors.New("malformed header MAC")
+	}
+	// The MAC covers the header up to and including "---"
+	header = file[:read-len(l)-1+3]
+	return stanzas, header, mac, file[read:], nil
+}
+
+// isArg reports whether s is a valid stanza type or argument: a non-empty
+// string of printable ASCII without spaces
+func isArg(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func headerMAC(fileKey, header []byte) ([]byte, error) {
+	key, err := derive(fileKey, nil, "header", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(header)
+	return h.Sum(nil), nil
+}
+
+func payloadAEAD(fileKey, nonce []byte) (cipher.AEAD, error) {
+	key, err := derive(fileKey, nonce, "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+func derive(secret, salt []byte, info string, size int) ([]byte, error) {
+	out := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), out); err != nil {
+		return nil, fmt.Errorf("error deriving key: %w", err)
+	}
+	return out, nil
+}
+
+// increment advances the 11 byte chunk counter at the start of nonce
+func increment(nonce *[chacha20poly1305.NonceSize]byte) error {
+	for i := len(nonce) - 2; i >= 0; i-- {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return nil
+		}
+	}
+	return errors.New("payload is too large")
+}
@@ -0,0 +1,177 @@
+package age
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	w := NewWrapperRecipient(wrapping.NewTestEnvelopeWrapper([]byte("secret")))
+	id, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2*chunkSize + 7} {
+		plaintext := bytes.Repeat([]byte{'a'}, size)
+		file, err := Encrypt(ctx, plaintext, w, id.Recipient())
+		if err != nil {
+			t.Fatalf("%d: err: %s", size, err)
+		}
+		if !IsAge(file) {
+			t.Fatalf("%d: not an age file: %q", size, file[:32])
+		}
+
+		for _, identity := range []Identity{w, id} {
+			pt, err := Decrypt(ctx, file, identity)
+			if err != nil {
+				t.Fatalf("%d: err: %s", size, err)
+			}
+			if !bytes.Equal(pt, plaintext) {
+				t.Fatalf("%d: plaintext mismatch", size)
+			}
+		}
+
+		// A trailing empty chunk, or a missing final chunk, is rejected
+		if size == chunkSize {
+			if _, err := Decrypt(ctx, file[:len(file)-1], w); err == nil {
+				t.Fatal("expected error for a truncated payload")
+			}
+		}
+	}
+
+	other, _ := GenerateX25519Identity()
+	file, err := Encrypt(ctx, []byte("foo"), id.Recipient())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := Decrypt(ctx, file, other, w); err == nil {
+		t.Fatal("expected error without a matching identity")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	ctx := context.Background()
+	w := NewWrapperRecipient(wrapping.NewTestEnvelopeWrapper([]byte("secret")))
+	file, err := Encrypt(ctx, []byte("foo"), w)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	lines := strings.Split(string(file), "\n")
+	if lines[0] != Version || lines[1] != "-> kms-wrap test-auto" {
+		t.Fatalf("unexpected header %q", lines[:2])
+	}
+	var i int
+	for i = 2; len(lines[i]) == columns; i++ {
+	}
+	if !strings.HasPrefix(lines[i+1], "--- ") {
+		t.Fatalf("unexpected MAC line %q", lines[i+1])
+	}
+
+	// Any change to the header is caught by its MAC
+	tampered := strings.Replace(string(file), "\n--- ", "\n-> other\n\n--- ", 1)
+	if _, err := Decrypt(ctx, []byte(tampered), w); err == nil || !strings.Contains(err.Error(), "MAC") {
+		t.Fatalf("expected a MAC error, got %v", err)
+	}
+
+	for _, input := range []string{
+		"age-encryption.org/v2\n",
+		Version + "\n--- AAAA\n",
+		Version + "\n-> X25519\n",
+		Version + "\n->  X25519\n\n--- AAAA\n",
+		Version + "\nnot a stanza\n",
+	} {
+		if _, _, _, _, err := parse([]byte(input)); err == nil {
+			t.Fatalf("%q: expected error", input)
+		}
+	}
+}
+
+func TestDecrypt_AgeFiles(t *testing.T) {
+	// Files written by the reference implementation, filippo.io/age v1.1.1:
+	// example.age is its testdata/example.age, multichunk.age was encrypted
+	// to the testkit recipient and spans two payload chunks
+	testCases := []struct {
+		File     string
+		Identity string
+		Expected []byte
+	}{
+		{
+			File:     "example.age",
+			Identity: "AGE-SECRET-KEY-184JMZMVQH3E6U0PSL869004Y3U2NYV7R30EU99CSEDNPH02YUVFSZW44VU",
+			Expected: []byte("Black lives matter."),
+		},
+		{
+			File:     "multichunk.age",
+			Identity: "AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX",
+			Expected: bytes.Repeat([]byte("0123456789abcdef"), chunkSize/16+100),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.File, func(t *testing.T) {
+			file, err := ioutil.ReadFile(filepath.Join("testdata", tc.File))
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			id, err := ParseX25519Identity(tc.Identity)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			pt, err := Decrypt(context.Background(), file, id)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !bytes.Equal(pt, tc.Expected) {
+				t.Fatalf("expected %d bytes of plaintext, got %q", len(tc.Expected), pt)
+			}
+
+			// An extra stanza is skipped but breaks the header MAC
+			tampered := bytes.Replace(file, []byte(Version+"\n"), []byte(Version+"\n-> grease\n\n"), 1)
+			if _, err := Decrypt(context.Background(), tampered, id); err == nil || !strings.Contains(err.Error(), "MAC") {
+				t.Fatalf("expected a header MAC error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestX25519Encoding(t *testing.T) {
+	// The test vector of age's testkit
+	id, err := ParseX25519Identity("AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(id.secretKey, bytes.Repeat([]byte{0x42}, 32)) {
+		t.Fatalf("unexpected secret key %x", id.secretKey)
+	}
+	const recipient = "age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj"
+	if got := id.Recipient().String(); got != recipient {
+		t.Fatalf("expected %s, got %s", recipient, got)
+	}
+	r, err := ParseX25519Recipient(recipient)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(r.publicKey, id.publicKey) {
+		t.Fatal("public key mismatch")
+	}
+
+	for _, input := range []string{
+		"age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwq",
+		"Age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj",
+		"bc1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj",
+		"age1qqqq",
+	} {
+		if _, err := ParseX25519Recipient(input); err == nil {
+			t.Fatalf("%q: expected error", input)
+		}
+	}
+}
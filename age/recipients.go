@@ -0,0 +1,314 @@
+package age
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// StanzaType is the type of wrapper stanzas. Its single argument is the
+// wrapper type and its body the marshaled blob of the file key.
+const StanzaType = "kms-wrap"
+
+// wrapperAAD binds the blobs of file keys to their purpose
+var wrapperAAD = []byte(Version + "/" + StanzaType)
+
+// WrapperRecipient wraps file keys with a wrapper. It is also the identity
+// that unwraps them.
+type WrapperRecipient struct {
+	w wrapping.Wrapper
+}
+
+var (
+	_ Recipient = (*WrapperRecipient)(nil)
+	_ Identity  = (*WrapperRecipient)(nil)
+)
+
+// NewWrapperRecipient returns a recipient whose stanzas hold file keys
+// encrypted by w
+func NewWrapperRecipient(w wrapping.Wrapper) *WrapperRecipient {
+	return &WrapperRecipient{w: w}
+}
+
+// Wrap encrypts the file key with the wrapper
+func (r *WrapperRecipient) Wrap(ctx context.Context, fileKey []byte) (*Stanza, error) {
+	blob, err := r.w.Encrypt(ctx, fileKey, wrapperAAD)
+	if err != nil {
+		return nil, err
+	}
+	body, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{Type: StanzaType, Args: []string{r.w.Type()}, Body: body}, nil
+}
+
+// Unwrap decrypts the file key of a wrapper stanza of the same wrapper type
+func (r *WrapperRecipient) Unwrap(ctx context.Context, s *Stanza) ([]byte, error) {
+	if s.Type != StanzaType || len(s.Args) != 1 || s.Args[0] != r.w.Type() {
+		return nil, ErrIncorrectIdentity
+	}
+	blob, _, err := format.Unmarshal(s.Body)
+	if err != nil {
+		return nil, err
+	}
+	return r.w.Decrypt(ctx, blob, wrapperAAD)
+}
+
+const (
+	x25519Label    = Version + "/X25519"
+	recipientHRP   = "age"
+	identityPrefix = "AGE-SECRET-KEY-"
+
+	poly1305TagSize = 16
+)
+
+// X25519Recipient is a standard age public key, age1...
+type X25519Recipient struct {
+	publicKey []byte
+}
+
+var _ Recipient = (*X25519Recipient)(nil)
+
+// ParseX25519Recipient parses a public key as printed by age-keygen
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed recipient %q: %w", s, err)
+	}
+	if hrp != recipientHRP || len(data) != curve25519.PointSize {
+		return nil, fmt.Errorf("malformed recipient %q", s)
+	}
+	return &X25519Recipient{publicKey: data}, nil
+}
+
+// String returns the age1... encoding of the recipient
+func (r *X25519Recipient) String() string {
+	s, _ := bech32Encode(recipientHRP, r.publicKey)
+	return s
+}
+
+// Wrap encrypts the file key to the recipient with an ephemeral key
+func (r *X25519Recipient) Wrap(_ context.Context, fileKey []byte) (*Stanza, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, err
+	}
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral, r.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := derive(shared, append(share, r.publicKey...), x25519Label, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Stanza{
+		Type: "X25519",
+		Args: []string{b64.EncodeToString(share)},
+		Body: aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil),
+	}, nil
+}
+
+// X25519Identity is a standard age secret key, AGE-SECRET-KEY-1...
+type X25519Identity struct {
+	secretKey, publicKey []byte
+}
+
+var _ Identity = (*X25519Identity)(nil)
+
+// GenerateX25519Identity returns a new random identity
+func GenerateX25519Identity() (*X25519Identity, error) {
+	secret := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return newX25519Identity(secret)
+}
+
+// ParseX25519Identity parses a secret key as written by age-keygen
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, errors.New("malformed secret key")
+	}
+	if hrp != strings.ToLower(identityPrefix) || len(data) != curve25519.ScalarSize {
+		return nil, errors.New("malformed secret key")
+	}
+	return newX25519Identity(data)
+}
+
+func newX25519Identity(secret []byte) (*X25519Identity, error) {
+	public, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &X25519Identity{secretKey: secret, publicKey: public}, nil
+}
+
+// Recipient returns the public key of the identity
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{publicKey: i.publicKey}
+}
+
+// String returns the AGE-SECRET-KEY-1... encoding of the identity
+func (i *X25519Identity) String() string {
+	s, _ := bech32Encode(identityPrefix, i.secretKey)
+	return strings.ToUpper(s)
+}
+
+// Unwrap decrypts the file key of an X25519 stanza addressed to the identity
+func (i *X25519Identity) Unwrap(_ context.Context, s *Stanza) ([]byte, error) {
+	if s.Type != "X25519" {
+		return nil, ErrIncorrectIdentity
+	}
+	if len(s.Args) != 1 {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	share, err := b64.Strict().DecodeString(s.Args[0])
+	if err != nil || len(share) != curve25519.PointSize {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	if len(s.Body) != fileKeySize+poly1305TagSize {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	shared, err := curve25519.X25519(i.secretKey, share)
+	if err != nil {
+		return nil, errors.New("invalid X25519 stanza")
+	}
+	key, err := derive(shared, append(share, i.publicKey...), x25519Label, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), s.Body, nil)
+	if err != nil {
+		// Stanzas of other recipients fail to open in the same way
+		return nil, ErrIncorrectIdentity
+	}
+	return fileKey, nil
+}
+
+// The bech32 encoding of BIP 173, without its length limit, as used by age
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// convertBits regroups data from frombits to tobits bits per byte
+func convertBits(data []byte, frombits, tobits uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	var out []byte
+	maxv := uint(1)<<tobits - 1
+	for _, v := range data {
+		if uint(v)>>frombits != 0 {
+			return nil, errors.New("invalid data")
+		}
+		acc = acc<<frombits | uint(v)
+		bits += frombits
+		for bits >= tobits {
+			bits -= tobits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(tobits-bits)&maxv))
+		}
+	} else if bits >= frombits || acc<<(tobits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
+
+func bech32Encode(hrp string, data []byte) (string, error) {
+	hrp = strings.ToLower(hrp)
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	polymod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	var b strings.Builder
+	b.WriteString(hrp + "1")
+	for _, v := range values {
+		b.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		b.WriteByte(bech32Charset[polymod>>uint(5*(5-i))&31])
+	}
+	return b.String(), nil
+}
+
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+	hrp := s[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, errors.New("invalid character in prefix")
+		}
+	}
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, errors.New("invalid character")
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
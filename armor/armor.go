@@ -0,0 +1,128 @@
+// Package armor encodes blobs as ASCII-armored text blocks, for embedding
+// ciphertexts in configuration files, tickets and email:
+//
+//	-----BEGIN KMS WRAPPED DATA-----
+//	Key-ID: arn:aws:kms:us-east-1:111122223333:key/1234abcd
+//	Wrapper: awskms
+//
+//	CiQAf9zQ... (the blob, base64 encoded in lines of 64 characters)
+//	-----END KMS WRAPPED DATA-----
+//
+// Blocks are PEM blocks (RFC 1421) and can be read with encoding/pem as
+// well. The header fields are informational and not authenticated; since
+// blobs do not record the wrapper that produced them, the Wrapper field helps
+// readers pick one. Decode checks that the Key-ID and Version fields agree
+// with the blob.
+package armor
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+)
+
+// BlockType is the type of armored blocks
+const BlockType = "KMS WRAPPED DATA"
+
+// Header fields set by Encode
+const (
+	// HeaderWrapper is the wrapper type that produced the blob
+	HeaderWrapper = "Wrapper"
+	// HeaderKeyID is the ID of the key that encrypted the blob
+	HeaderKeyID = "Key-ID"
+	// HeaderVersion is the format version of the blob, when it is not
+	// format.Version0
+	HeaderVersion = "Version"
+)
+
+// Options holds optional settings for Encode
+type Options struct {
+	// WrapperType sets the Wrapper header field
+	WrapperType string
+
+	// Version is the format version of the armored blob
+	Version format.Version
+
+	// Headers are additional header fields, such as a Comment
+	Headers map[string]string
+}
+
+// Block is a decoded armored block
+type Block struct {
+	Blob    *wrapping.EncryptedBlobInfo
+	Version format.Version
+	Headers map[string]string
+}
+
+// Encode armors blob in a text block ending with a newline
+func Encode(blob *wrapping.EncryptedBlobInfo, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	raw, err := format.Marshal(blob, opts.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string, len(opts.Headers)+3)
+	for k, v := range opts.Headers {
+		switch k {
+		case HeaderWrapper, HeaderKeyID, HeaderVersion:
+			return nil, fmt.Errorf("header field %q is set by the encoder", k)
+		}
+		headers[k] = v
+	}
+	if opts.WrapperType != "" {
+		headers[HeaderWrapper] = opts.WrapperType
+	}
+	if blob.KeyInfo != nil && blob.KeyInfo.KeyID != "" {
+		headers[HeaderKeyID] = blob.KeyInfo.KeyID
+	}
+	if opts.Version != format.Version0 {
+		headers[HeaderVersion] = opts.Version.String()
+	}
+	for k, v := range headers {
+		if k == "" || strings.ContainsAny(k, ":\r\n") || strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("invalid header field %q", k)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, &pem.Block{Type: BlockType, Headers: headers, Bytes: raw}); err != nil {
+		return nil, fmt.Errorf("error encoding block: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the first armored block in data, skipping any text and
+// PEM blocks of other types before it, and returns the data after the block
+func Decode(data []byte) (*Block, []byte, error) {
+	var p *pem.Block
+	rest := data
+	for {
+		p, rest = pem.Decode(rest)
+		if p == nil {
+			return nil, data, errors.New("no armored block found")
+		}
+		if p.Type == BlockType {
+			break
+		}
+	}
+
+	blob, v, err := format.Unmarshal(p.Bytes)
+	if err != nil {
+		return nil, rest, err
+	}
+	if h, ok := p.Headers[HeaderKeyID]; ok && (blob.KeyInfo == nil || blob.KeyInfo.KeyID != h) {
+		return nil, rest, fmt.Errorf("%s header does not match the blob's key ID", HeaderKeyID)
+	}
+	if h := p.Headers[HeaderVersion]; h != v.String() && (h != "" || v != format.Version0) {
+		return nil, rest, fmt.Errorf("%s header does not match the blob's format version %d", HeaderVersion, v)
+	}
+	return &Block{Blob: blob, Version: v, Headers: p.Headers}, rest, nil
+}
@@ -0,0 +1,146 @@
+package armor
+
+import (
+	"bytes"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+	"google.golang.org/protobuf/proto"
+)
+
+func testBlob() *wrapping.EncryptedBlobInfo {
+	return &wrapping.EncryptedBlobInfo{
+		Ciphertext: bytes.Repeat([]byte("ciphertext"), 10),
+		IV:         []byte("iv"),
+		KeyInfo: &wrapping.KeyInfo{
+			KeyID:      "arn:aws:kms:us-east-1:111122223333:key/1234abcd",
+			WrappedKey: []byte("wrapped"),
+		},
+	}
+}
+
+func TestEncode(t *testing.T) {
+	blob := testBlob()
+
+	cases := []struct {
+		Title   string
+		Options *Options
+		Headers map[string]string
+	}{
+		{"defaults", nil, map[string]string{HeaderKeyID: blob.KeyInfo.KeyID}},
+		{"wrapper", &Options{WrapperType: "awskms"}, map[string]string{HeaderKeyID: blob.KeyInfo.KeyID, HeaderWrapper: "awskms"}},
+		{"version", &Options{Version: format.Version1}, map[string]string{HeaderKeyID: blob.KeyInfo.KeyID, HeaderVersion: "1"}},
+		{"comment", &Options{Headers: map[string]string{"Comment": "db password"}}, map[string]string{HeaderKeyID: blob.KeyInfo.KeyID, "Comment": "db password"}},
+	}
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			text, err := Encode(blob, c.Options)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !bytes.HasPrefix(text, []byte("-----BEGIN KMS WRAPPED DATA-----\n")) || !bytes.HasSuffix(text, []byte("-----END KMS WRAPPED DATA-----\n")) {
+				t.Fatalf("unexpected block %q", text)
+			}
+
+			block, rest, err := Decode(text)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if len(rest) != 0 {
+				t.Fatalf("unexpected rest %q", rest)
+			}
+			if !proto.Equal(block.Blob, blob) {
+				t.Fatalf("expected %v, got %v", blob, block.Blob)
+			}
+			if len(block.Headers) != len(c.Headers) {
+				t.Fatalf("expected headers %v, got %v", c.Headers, block.Headers)
+			}
+			for k, v := range c.Headers {
+				if block.Headers[k] != v {
+					t.Fatalf("expected headers %v, got %v", c.Headers, block.Headers)
+				}
+			}
+
+			// Blocks are standard PEM
+			p, _ := pem.Decode(text)
+			if p == nil || p.Type != BlockType {
+				t.Fatal("expected a PEM block")
+			}
+		})
+	}
+
+	for _, opts := range []*Options{
+		{Headers: map[string]string{HeaderKeyID: "other"}},
+		{Headers: map[string]string{"Bad Key:": "x"}},
+		{Headers: map[string]string{"Comment": "two\nlines"}},
+		{WrapperType: "aead\n"},
+		{Version: 9},
+	} {
+		if _, err := Encode(blob, opts); err == nil {
+			t.Fatalf("%v: expected error", opts)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	blob := testBlob()
+	text, err := Encode(blob, &Options{WrapperType: "awskms"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Surrounding text and other blocks are skipped
+	other := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("cert")})
+	data := append(append([]byte("password:\n"), other...), text...)
+	data = append(data, "trailer\n"...)
+	block, rest, err := Decode(data)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if block.Headers[HeaderWrapper] != "awskms" || !proto.Equal(block.Blob, blob) {
+		t.Fatalf("unexpected block %v", block)
+	}
+	if string(rest) != "trailer\n" {
+		t.Fatalf("unexpected rest %q", rest)
+	}
+
+	// Line endings of Windows editors are accepted
+	crlf := bytes.Replace(text, []byte("\n"), []byte("\r\n"), -1)
+	if block, _, err := Decode(crlf); err != nil || !proto.Equal(block.Blob, blob) {
+		t.Fatalf("unexpected block %v: %v", block, err)
+	}
+
+	// Headers can be left out
+	bare := pem.EncodeToMemory(&pem.Block{Type: BlockType, Bytes: mustMarshal(t, blob, format.Version0)})
+	if block, _, err := Decode(bare); err != nil || len(block.Headers) != 0 || block.Version != format.Version0 {
+		t.Fatalf("unexpected block %v: %v", block, err)
+	}
+	v1 := pem.EncodeToMemory(&pem.Block{Type: BlockType, Bytes: mustMarshal(t, blob, format.Version1)})
+	if _, _, err := Decode(v1); err == nil {
+		t.Fatal("expected error for a missing version header")
+	}
+
+	for _, data := range [][]byte{
+		nil,
+		other,
+		[]byte(strings.Replace(string(text), blob.KeyInfo.KeyID, "other", 1)),
+		pem.EncodeToMemory(&pem.Block{Type: BlockType, Headers: map[string]string{HeaderVersion: "1"}, Bytes: mustMarshal(t, blob, format.Version0)}),
+		pem.EncodeToMemory(&pem.Block{Type: BlockType, Bytes: []byte{0xff}}),
+	} {
+		if _, _, err := Decode(data); err == nil {
+			t.Fatalf("%q: expected error", data)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, blob *wrapping.EncryptedBlobInfo, v format.Version) []byte {
+	t.Helper()
+	raw, err := format.Marshal(blob, v)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return raw
+}
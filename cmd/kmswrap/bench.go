@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+const benchUsage = `Usage: kmswrap bench [options]
+
+  Measures Encrypt and Decrypt latency and throughput of the configured
+  wrapper for every combination of payload size and concurrency, and reports
+  percentiles. Each call goes to the live backend, so a run counts against
+  KMS request quotas and may be billed.
+
+  Sizes accept k and m suffixes for KiB and MiB. Latency percentiles only
+  cover successful calls; failed calls are counted as errors, and the first
+  error of each combination is reported. A caller whose call fails backs off
+  before its next call, from 10ms doubling up to 1s, so that a throttling
+  backend is not hammered.`
+
+func (c *cli) bench(args []string) error {
+	fs := c.flagSet("bench", benchUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	sizesFlag := fs.String("sizes", "32,1k,64k", "comma separated payload sizes in bytes")
+	concurrencyFlag := fs.String("concurrency", "1,4,16", "comma separated numbers of concurrent callers")
+	ops := fs.String("ops", "encrypt,decrypt", "comma separated operations to measure")
+	duration := fs.Duration("duration", 5*time.Second, "how long to run each combination")
+	requests := fs.Int("requests", 0, "stop each combination after this many calls instead of after -duration")
+	format := fs.String("format", "text", "output format, text or json")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(c.stderr, "bench takes no arguments\n")
+		return errUsage
+	}
+
+	sizes, err := parseSizeList(*sizesFlag)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "invalid -sizes: %v\n", err)
+		return errUsage
+	}
+	concurrencies, err := parseIntList(*concurrencyFlag)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "invalid -concurrency: %v\n", err)
+		return errUsage
+	}
+	var encrypt, decrypt bool
+	for _, op := range strings.Split(*ops, ",") {
+		switch strings.TrimSpace(op) {
+		case "encrypt":
+			encrypt = true
+		case "decrypt":
+			decrypt = true
+		default:
+			fmt.Fprintf(c.stderr, "unknown operation %q in -ops\n", op)
+			return errUsage
+		}
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(c.stderr, "unknown -format %q\n", *format)
+		return errUsage
+	}
+	if *requests <= 0 && *duration <= 0 {
+		fmt.Fprintf(c.stderr, "one of -duration or -requests must be positive\n")
+		return errUsage
+	}
+
+	w, err := c.wrapper(&wf)
+	if err != nil {
+		return err
+	}
+	defer w.Finalize(context.Background())
+
+	ctx := context.Background()
+	limit := benchLimit{duration: *duration, requests: *requests}
+	var results []*benchResult
+	for _, size := range sizes {
+		payload := make([]byte, size)
+		if _, err := rand.Read(payload); err != nil {
+			return err
+		}
+		// Doubles as a warm-up call and as the input to Decrypt
+		blob, err := w.Encrypt(ctx, payload, nil)
+		if err != nil {
+			return fmt.Errorf("error encrypting %d byte payload: %w", size, err)
+		}
+
+		for _, concurrency := range concurrencies {
+			if encrypt {
+				r := runBench(limit, concurrency, func() error {
+					_, err := w.Encrypt(ctx, payload, nil)
+					return err
+				})
+				r.setCombination("encrypt", size, concurrency)
+				results = append(results, r)
+			}
+			if decrypt {
+				r := runBench(limit, concurrency, func() error {
+					_, err := w.Decrypt(ctx, blob, nil)
+					return err
+				})
+				r.setCombination("decrypt", size, concurrency)
+				results = append(results, r)
+			}
+			if *format == "text" {
+				fmt.Fprintf(c.stderr, "finished size %d, concurrency %d\n", size, concurrency)
+			}
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Wrapper string         `json:"wrapper"`
+			KeyID   string         `json:"key_id"`
+			Results []*benchResult `json:"results"`
+		}{w.Type(), w.KeyID(), results})
+	}
+	return writeBenchTable(c.stdout, results)
+}
+
+// Bounds of the delay before a caller retries after a failed call
+const (
+	benchMinBackoff = 10 * time.Millisecond
+	benchMaxBackoff = time.Second
+)
+
+// benchLimit bounds a single combination by time or by number of calls
+type benchLimit struct {
+	duration time.Duration
+	requests int
+}
+
+// benchResult summarizes the calls made for one combination. Latencies are
+// in milliseconds, of the successful calls only, since a throttled or
+// rejected call says nothing about how fast the backend serves requests.
+type benchResult struct {
+	Op          string  `json:"op"`
+	Size        int     `json:"size"`
+	Concurrency int     `json:"concurrency"`
+	Calls       int     `json:"calls"`
+	Errors      int     `json:"errors"`
+	OpsPerSec   float64 `json:"ops_per_sec"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	P50         float64 `json:"p50_ms"`
+	P90         float64 `json:"p90_ms"`
+	P99         float64 `json:"p99_ms"`
+	Max         float64 `json:"max_ms"`
+
+	// FirstError is kept so that a failing configuration is diagnosable
+	FirstError string `json:"first_error,omitempty"`
+}
+
+func (r *benchResult) setCombination(op string, size, concurrency int) {
+	r.Op, r.Size, r.Concurrency = op, size, concurrency
+	r.BytesPerSec = r.OpsPerSec * float64(size)
+}
+
+// runBench calls fn from concurrency goroutines until the limit is reached.
+// A goroutine backs off after each failed call, doubling the delay until a
+// call succeeds.
+func runBench(limit benchLimit, concurrency int, fn func() error) *benchResult {
+	var (
+		l         sync.Mutex
+		latencies []time.Duration
+		calls     int
+		errors    int
+		firstErr  error
+		remaining = int64(limit.requests)
+		deadline  = time.Now().Add(limit.duration)
+		wg        sync.WaitGroup
+	)
+
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			var localCalls, localErrors int
+			var localErr error
+			var backoff time.Duration
+			for {
+				if limit.requests > 0 {
+					if atomic.AddInt64(&remaining, -1) < 0 {
+						break
+					}
+				} else if !time.Now().Before(deadline) {
+					break
+				}
+
+				callStart := time.Now()
+				err := fn()
+				latency := time.Since(callStart)
+				localCalls++
+				if err != nil {
+					localErrors++
+					if localErr == nil {
+						localErr = err
+					}
+					switch {
+					case backoff == 0:
+						backoff = benchMinBackoff
+					case backoff < benchMaxBackoff:
+						backoff *= 2
+						if backoff > benchMaxBackoff {
+							backoff = benchMaxBackoff
+						}
+					}
+					wait := backoff
+					if limit.requests == 0 {
+						if left := time.Until(deadline); left < wait {
+							wait = left
+						}
+					}
+					time.Sleep(wait)
+					continue
+				}
+				backoff = 0
+				local = append(local, latency)
+			}
+
+			l.Lock()
+			defer l.Unlock()
+			latencies = append(latencies, local...)
+			calls += localCalls
+			errors += localErrors
+			if firstErr == nil {
+				firstErr = localErr
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r := &benchResult{
+		Calls:  calls,
+		Errors: errors,
+		P50:    millis(percentile(latencies, 50)),
+		P90:    millis(percentile(latencies, 90)),
+		P99:    millis(percentile(latencies, 99)),
+		Max:    millis(percentile(latencies, 100)),
+	}
+	if firstErr != nil {
+		r.FirstError = firstErr.Error()
+	}
+	if elapsed > 0 {
+		r.OpsPerSec = float64(r.Calls-r.Errors) / elapsed.Seconds()
+	}
+	return r
+}
+
+// percentile returns the nearest-rank percentile p of sorted: the smallest
+// sample that at least p percent of the samples are less than or equal to
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted))/100)) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func writeBenchTable(w io.Writer, results []*benchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "op\tsize\tconcurrency\tcalls\terrors\tops/s\tMB/s\tp50 ms\tp90 ms\tp99 ms\tmax ms\t\n")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			r.Op, r.Size, r.Concurrency, r.Calls, r.Errors, r.OpsPerSec, r.BytesPerSec/(1<<20),
+			r.P50, r.P90, r.P99, r.Max)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.FirstError != "" {
+			fmt.Fprintf(w, "%s size %d concurrency %d: first error: %s\n", r.Op, r.Size, r.Concurrency, r.FirstError)
+		}
+	}
+	return nil
+}
+
+// parseIntList parses a comma separated list of positive integers, without
+// the suffixes of sizes
+func parseIntList(s string) ([]int, error) {
+	var out []int
+	for _, orig := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(orig))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%q is not a positive integer", orig)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// parseSizeList parses a comma separated list of positive integers, each
+// optionally suffixed with k or m
+func parseSizeList(s string) ([]int, error) {
+	var out []int
+	for _, orig := range strings.Split(s, ",") {
+		field := strings.ToLower(strings.TrimSpace(orig))
+		multiplier := 1
+		switch {
+		case strings.HasSuffix(field, "k"):
+			multiplier, field = 1<<10, strings.TrimSuffix(field, "k")
+		case strings.HasSuffix(field, "m"):
+			multiplier, field = 1<<20, strings.TrimSuffix(field, "m")
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%q is not a positive number", orig)
+		}
+		out = append(out, n*multiplier)
+	}
+	return out, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	args := append([]string{"bench", "-sizes", "16,1k", "-concurrency", "1,3", "-requests", "20"}, testAEADFlags(t)...)
+
+	text := testRun(t, args, "")
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	// A header and one row per operation, size and concurrency
+	if len(lines) != 1+2*2*2 {
+		t.Fatalf("expected 9 lines, got %d:\n%s", len(lines), text)
+	}
+	if !strings.Contains(lines[0], "p99 ms") {
+		t.Fatalf("expected a header, got %q", lines[0])
+	}
+
+	var out struct {
+		Wrapper string
+		Results []*benchResult
+	}
+	if err := json.Unmarshal([]byte(testRun(t, append(args, "-format", "json", "-ops", "decrypt"), "")), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Wrapper != "aead" || len(out.Results) != 4 {
+		t.Fatalf("unexpected output %+v", out)
+	}
+	for _, r := range out.Results {
+		if r.Op != "decrypt" || r.Calls != 20 || r.Errors != 0 || r.OpsPerSec <= 0 {
+			t.Fatalf("unexpected result %+v", r)
+		}
+		if r.P50 > r.P90 || r.P90 > r.P99 || r.P99 > r.Max {
+			t.Fatalf("percentiles out of order: %+v", r)
+		}
+	}
+	// Concurrency is a count, not a size
+	if code := run(append([]string{"bench", "-concurrency", "1k"}, testAEADFlags(t)...), nil, ioutil.Discard, ioutil.Discard); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+}
+
+func TestRunBench_Errors(t *testing.T) {
+	calls := 0
+	r := runBench(benchLimit{requests: 10}, 1, func() error {
+		calls++
+		if calls%2 == 0 {
+			return errors.New("throttled")
+		}
+		return nil
+	})
+	if r.Calls != 10 || r.Errors != 5 || r.FirstError != "throttled" {
+		t.Fatalf("unexpected result %+v", r)
+	}
+
+	// Slow failures are kept out of the percentiles
+	r = runBench(benchLimit{requests: 4}, 1, func() error {
+		time.Sleep(20 * time.Millisecond)
+		return errors.New("timed out")
+	})
+	if r.Calls != 4 || r.Errors != 4 || r.Max != 0 || r.OpsPerSec != 0 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+
+	// Failing callers back off rather than retrying at once
+	r = runBench(benchLimit{duration: 50 * time.Millisecond}, 1, func() error {
+		return errors.New("throttled")
+	})
+	if r.Calls == 0 || r.Calls > 5 || r.Errors != r.Calls {
+		t.Fatalf("expected a few calls with backoff, got %+v", r)
+	}
+
+	start := time.Now()
+	r = runBench(benchLimit{duration: 20 * time.Millisecond}, 2, func() error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if r.Calls == 0 || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("expected calls for the whole duration, got %+v", r)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	samples := func(n int) []time.Duration {
+		var sorted []time.Duration
+		for i := 1; i <= n; i++ {
+			sorted = append(sorted, time.Duration(i))
+		}
+		return sorted
+	}
+	for _, tc := range []struct {
+		Title    string
+		N        int
+		P        float64
+		Expected time.Duration
+	}{
+		{"100/p50", 100, 50, 50},
+		{"100/p90", 100, 90, 90},
+		{"100/p99", 100, 99, 99},
+		{"100/max", 100, 100, 100},
+		{"100/p0", 100, 0, 1},
+		{"1/p50", 1, 50, 1},
+		{"1/p99", 1, 99, 1},
+		{"3/p50", 3, 50, 2},
+		{"3/p90", 3, 90, 3},
+		{"16/p50", 16, 50, 8},
+		{"16/p90", 16, 90, 15},
+		{"16/p99", 16, 99, 16},
+		{"10/p70", 10, 70, 7},
+	} {
+		if got := percentile(samples(tc.N), tc.P); got != tc.Expected {
+			t.Fatalf("%s: expected %d, got %d", tc.Title, tc.Expected, got)
+		}
+	}
+	if percentile(nil, 50) != 0 {
+		t.Fatal("expected zero for no samples")
+	}
+}
+
+func TestParseIntList(t *testing.T) {
+	got, err := parseIntList("1, 4,16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 4, 16}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"", "0", "-1", "1k", "4KiB", "1,,2"} {
+		if _, err := parseIntList(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestParseSizeList(t *testing.T) {
+	got, err := parseSizeList("32, 1k,2M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{32, 1024, 2 << 20}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for _, bad := range []string{"", "0", "-1", "1g", "1,,2"} {
+		if _, err := parseSizeList(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
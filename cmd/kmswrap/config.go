@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+)
+
+// These environment variables configure the wrapper when the corresponding
+// flags are not given
+const (
+	EnvConfig  = "KMSWRAP_CONFIG"
+	EnvWrapper = "KMSWRAP_WRAPPER"
+)
+
+// sealConfig is a single wrapper configuration: its type and the values
+// passed to its SetConfig
+type sealConfig struct {
+	Type   string
+	Config map[string]string
+}
+
+// parseConfig reads the seal stanza from an HCL configuration file in the
+// format Vault uses:
+//
+//	seal "awskms" {
+//	  region     = "us-east-1"
+//	  kms_key_id = "alias/kmswrap"
+//	}
+//
+// Other stanzas are ignored so that a Vault server configuration can be used
+// directly. Seals with disabled = "true" are skipped; exactly one enabled seal
+// must remain.
+func parseConfig(src []byte) (*sealConfig, error) {
+	file, err := hcl.ParseBytes(src)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing configuration: %w", err)
+	}
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, errors.New("error parsing configuration: file doesn't contain a root object")
+	}
+
+	var seals []*sealConfig
+	for _, item := range list.Filter("seal").Items {
+		seal, err := parseSeal(item)
+		if err != nil {
+			return nil, err
+		}
+		disabled, err := parseBool(seal.Config["disabled"])
+		if err != nil {
+			return nil, fmt.Errorf("seal %q: invalid disabled value: %w", seal.Type, err)
+		}
+		delete(seal.Config, "disabled")
+		if !disabled {
+			seals = append(seals, seal)
+		}
+	}
+
+	switch len(seals) {
+	case 0:
+		return nil, errors.New("no enabled seal stanza found in configuration")
+	case 1:
+		return seals[0], nil
+	default:
+		return nil, errors.New("more than one enabled seal stanza found in configuration")
+	}
+}
+
+func parseSeal(item *ast.ObjectItem) (*sealConfig, error) {
+	if len(item.Keys) != 1 {
+		return nil, errors.New("seal stanza must have exactly one label, the wrapper type")
+	}
+	typ, err := strconv.Unquote(item.Keys[0].Token.Text)
+	if err != nil {
+		typ = item.Keys[0].Token.Text
+	}
+
+	var raw map[string]interface{}
+	if err := hcl.DecodeObject(&raw, item.Val); err != nil {
+		return nil, fmt.Errorf("seal %q: %w", typ, err)
+	}
+
+	config := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			config[k] = v
+		case bool, int, int64, float64:
+			config[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("seal %q: value for %q must be a string, number or bool", typ, k)
+		}
+	}
+
+	return &sealConfig{Type: typ, Config: config}, nil
+}
+
+func parseBool(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+// wrapperFlags are the flags shared by every command that needs a wrapper
+type wrapperFlags struct {
+	configPath  string
+	wrapperType string
+	values      keyValueFlag
+
+	// target is set for a second wrapper configured with -to-* flags; the
+	// KMSWRAP_* environment variables only apply to the primary wrapper
+	target bool
+}
+
+func (f *wrapperFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.configPath, "config", "", "HCL file containing a seal stanza (env "+EnvConfig+")")
+	fs.StringVar(&f.wrapperType, "wrapper", "", "wrapper type, overriding the configuration file (env "+EnvWrapper+")")
+	fs.Var(&f.values, "set", "configuration `key=value` passed to the wrapper, overriding the file; may be repeated")
+}
+
+// registerTarget registers the flags for a second, destination wrapper
+func (f *wrapperFlags) registerTarget(fs *flag.FlagSet) {
+	f.target = true
+	fs.StringVar(&f.configPath, "to-config", "", "HCL file containing the seal stanza of the destination wrapper")
+	fs.StringVar(&f.wrapperType, "to-wrapper", "", "destination wrapper type, overriding -to-config")
+	fs.Var(&f.values, "to-set", "configuration `key=value` passed to the destination wrapper; may be repeated")
+}
+
+// isSet reports whether any of the flags were given
+func (f *wrapperFlags) isSet() bool {
+	return f.configPath != "" || f.wrapperType != "" || len(f.values) > 0
+}
+
+// resolve combines the configuration file, environment and flags. Flags take
+// precedence over the environment, which takes precedence over the file.
+// Wrapper-specific environment variables are still honored by the wrappers
+// themselves.
+func (f *wrapperFlags) resolve() (*sealConfig, error) {
+	path := f.configPath
+	if path == "" && !f.target {
+		path = os.Getenv(EnvConfig)
+	}
+
+	seal := &sealConfig{Config: map[string]string{}}
+	if path != "" {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading configuration: %w", err)
+		}
+		if seal, err = parseConfig(src); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	switch {
+	case f.wrapperType != "":
+		seal.Type = f.wrapperType
+	case os.Getenv(EnvWrapper) != "" && !f.target:
+		seal.Type = os.Getenv(EnvWrapper)
+	}
+	if seal.Type == "" {
+		if f.target {
+			return nil, errors.New("no destination wrapper configured; use -to-config or -to-wrapper")
+		}
+		return nil, errors.New("no wrapper configured; use -config, -wrapper or " + EnvWrapper)
+	}
+
+	for k, v := range f.values {
+		seal.Config[k] = v
+	}
+	return seal, nil
+}
+
+// keyValueFlag collects repeated key=value flags
+type keyValueFlag map[string]string
+
+func (kv *keyValueFlag) String() string {
+	keys := make([]string, 0, len(*kv))
+	for k := range *kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (kv *keyValueFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	if *kv == nil {
+		*kv = make(map[string]string)
+	}
+	(*kv)[s[:i]] = s[i+1:]
+	return nil
+}
+
+// stringsFlag collects the values of a repeated flag
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *stringsFlag) Set(s string) error {
+	*sf = append(*sf, s)
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	testCases := []struct {
+		Title    string
+		Src      string
+		Expected *sealConfig
+		Err      bool
+	}{
+		{
+			Title: "Single",
+			Src: `
+seal "awskms" {
+  region     = "us-east-1"
+  kms_key_id = "alias/kmswrap"
+}`,
+			Expected: &sealConfig{Type: "awskms", Config: map[string]string{
+				"region":     "us-east-1",
+				"kms_key_id": "alias/kmswrap",
+			}},
+		},
+		{
+			// Other stanzas of a Vault server configuration are ignored,
+			// as are disabled seals left over from a migration
+			Title: "Vault-Server",
+			Src: `
+storage "raft" {
+  path = "/vault/data"
+}
+
+seal "transit" {
+  address    = "https://vault:8200"
+  key_name   = "autounseal"
+  mount_path = "transit/"
+  disabled   = "true"
+}
+
+seal "gcpckms" {
+  project    = "proj"
+  region     = "global"
+  key_ring   = "ring"
+  crypto_key = "key"
+}
+
+ui = true`,
+			Expected: &sealConfig{Type: "gcpckms", Config: map[string]string{
+				"project":    "proj",
+				"region":     "global",
+				"key_ring":   "ring",
+				"crypto_key": "key",
+			}},
+		},
+		{
+			Title: "Non-String-Values",
+			Src: `
+seal "ocikms" {
+  key_id            = "ocid1.key"
+  auth_type_api_key = true
+  retries           = 3
+}`,
+			Expected: &sealConfig{Type: "ocikms", Config: map[string]string{
+				"key_id":            "ocid1.key",
+				"auth_type_api_key": "true",
+				"retries":           "3",
+			}},
+		},
+		{
+			Title: "None",
+			Src:   `storage "file" {}`,
+			Err:   true,
+		},
+		{
+			Title: "Multiple",
+			Src:   `seal "aead" {} seal "awskms" {}`,
+			Err:   true,
+		},
+		{
+			Title: "No-Label",
+			Src:   `seal { key = "foo" }`,
+			Err:   true,
+		},
+		{
+			Title: "Nested",
+			Src:   `seal "aead" { key = { a = "b" } }`,
+			Err:   true,
+		},
+		{
+			Title: "Invalid",
+			Src:   `seal "aead" {`,
+			Err:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			seal, err := parseConfig([]byte(tc.Src))
+			if tc.Err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(seal, tc.Expected) {
+				t.Fatalf("expected %#v, got %#v", tc.Expected, seal)
+			}
+		})
+	}
+}
+
+func TestWrapperFlags_Precedence(t *testing.T) {
+	path := writeTestFile(t, "seal.hcl", `
+seal "aead" {
+  key_id    = "file-key"
+  aead_type = "aes-gcm"
+}`)
+	defer os.Remove(path)
+
+	defer setTestEnv(t, map[string]string{EnvConfig: path})()
+
+	var wf wrapperFlags
+	wf.values.Set("key_id=flag-key")
+	seal, err := wf.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &sealConfig{Type: "aead", Config: map[string]string{
+		"key_id":    "flag-key",
+		"aead_type": "aes-gcm",
+	}}
+	if !reflect.DeepEqual(seal, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, seal)
+	}
+
+	os.Setenv(EnvWrapper, "transit")
+	if seal, err = wf.resolve(); err != nil || seal.Type != "transit" {
+		t.Fatalf("expected env to override the file type, got %v, %v", seal, err)
+	}
+	wf.wrapperType = "awskms"
+	if seal, err = wf.resolve(); err != nil || seal.Type != "awskms" {
+		t.Fatalf("expected flag to override the env type, got %v, %v", seal, err)
+	}
+
+	os.Unsetenv(EnvConfig)
+	os.Unsetenv(EnvWrapper)
+	if _, err := new(wrapperFlags).resolve(); err == nil {
+		t.Fatal("expected error with no wrapper configured")
+	}
+}
+
+func TestKeyValueFlag(t *testing.T) {
+	var kv keyValueFlag
+	for _, s := range []string{"a=b", "c=d=e", "f="} {
+		if err := kv.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := keyValueFlag{"a": "b", "c": "d=e", "f": ""}
+	if !reflect.DeepEqual(kv, expected) {
+		t.Fatalf("expected %v, got %v", expected, kv)
+	}
+	if kv.String() != "a,c,f" {
+		t.Fatalf("expected only keys to be printed, got %q", kv.String())
+	}
+	for _, s := range []string{"a", "=b"} {
+		if err := kv.Set(s); err == nil {
+			t.Fatalf("expected error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+)
+
+const daemonUsage = `Usage: kmswrap daemon [options]
+
+  Serves Encrypt and Decrypt for the configured wrapper over a Unix domain
+  socket and, optionally, over HTTP on a loopback address, so that programs
+  in any language can use the wrapper without linking the library. It runs
+  until interrupted.
+
+  The API is JSON over HTTP on both listeners. Binary values are base64
+  encoded; blobs use the same encoding as the encrypt command.
+
+    POST /v1/encrypt  {"plaintext": "...", "aad": "..."}
+                      -> {"blob": "...", "key_id": "..."}
+    POST /v1/decrypt  {"blob": "...", "aad": "..."}
+                      -> {"plaintext": "..."}
+    GET  /v1/health   -> {"type": "...", "key_id": "..."}
+
+  Requests with a body must have the Content-Type application/json. Errors
+  are reported as {"error": "..."} with a 4xx or 5xx status.
+
+  Access to the socket is controlled by its file mode alone. Any local user,
+  and any web page open in a local browser, can reach the HTTP listener, so
+  it requires a bearer token and only accepts requests whose Host is a
+  loopback address or localhost:
+
+    Authorization: Bearer <token>
+
+  The token is read from the file given by -token-file. If the file does not
+  exist, a random token is generated and written to it, readable only by
+  the current user.`
+
+// maxRequestSize bounds the body of a single API request
+const maxRequestSize = 32 << 20
+
+// daemonShutdownTimeout is how long in-flight requests get to finish once
+// the daemon is told to stop
+const daemonShutdownTimeout = 10 * time.Second
+
+func (c *cli) daemon(args []string) error {
+	fs := c.flagSet("daemon", daemonUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	socket := fs.String("socket", "", "`path` of the Unix domain socket to listen on")
+	socketMode := fs.String("socket-mode", "0600", "file mode of the socket, in octal")
+	httpAddr := fs.String("http", "", "loopback `address` to also serve HTTP on, such as 127.0.0.1:8200")
+	tokenFile := fs.String("token-file", "", "`path` of the file holding the bearer token of the HTTP listener")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(c.stderr, "daemon takes no arguments\n")
+		return errUsage
+	}
+	if *socket == "" && *httpAddr == "" {
+		fmt.Fprintf(c.stderr, "at least one of -socket or -http is required\n")
+		return errUsage
+	}
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "invalid -socket-mode: %v\n", err)
+		return errUsage
+	}
+	if *httpAddr != "" {
+		if err := checkLoopback(*httpAddr); err != nil {
+			fmt.Fprintf(c.stderr, "invalid -http: %v\n", err)
+			return errUsage
+		}
+		if *tokenFile == "" {
+			fmt.Fprintf(c.stderr, "-token-file is required with -http\n")
+			return errUsage
+		}
+	}
+
+	w, err := c.wrapper(&wf)
+	if err != nil {
+		return err
+	}
+	defer w.Finalize(context.Background())
+
+	handler := newDaemonHandler(w)
+	var listeners []daemonListener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if *socket != "" {
+		l, err := listenUnix(*socket, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, daemonListener{l, handler})
+		fmt.Fprintf(c.stderr, "listening on %s\n", *socket)
+	}
+	if *httpAddr != "" {
+		token, created, err := loadDaemonToken(*tokenFile)
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Fprintf(c.stderr, "wrote a new token to %s\n", *tokenFile)
+		}
+		l, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, daemonListener{l, requireDaemonToken(token, handler)})
+		fmt.Fprintf(c.stderr, "listening on http://%s\n", l.Addr())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return serveDaemon(ctx, listeners)
+}
+
+// checkLoopback rejects addresses that are not bound to a loopback interface
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%q is not a loopback address", host)
+}
+
+// listenUnix listens on a Unix domain socket at path, replacing a stale
+// socket left by a previous run. The socket is created with mode rather than
+// changed to it afterwards, so that it is never reachable by other users.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var l net.Listener
+	err := withUmask(int(^mode&os.ModePerm), func() (err error) {
+		l, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// loadDaemonToken reads the bearer token of the HTTP listener from path,
+// generating and writing one if the file does not exist
+func loadDaemonToken(path string) (token string, created bool, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		token = strings.TrimSpace(string(data))
+		if token == "" {
+			return "", false, fmt.Errorf("token file %s is empty", path)
+		}
+		return token, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", false, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", false, fmt.Errorf("error generating token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		f.Close()
+		return "", false, err
+	}
+	if err := f.Close(); err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// requireDaemonToken guards the HTTP listener against other local users and
+// web pages: requests must carry the bearer token, and their Host must be a
+// loopback address so that DNS rebinding cannot make the daemon same-origin
+// with a page
+func requireDaemonToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !isLoopbackHost(r.Host) {
+			writeDaemonError(rw, http.StatusForbidden, fmt.Errorf("host %q is not a loopback address", r.Host))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			writeDaemonError(rw, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// isLoopbackHost reports whether the Host of a request, with or without a
+// port, is localhost or a loopback IP literal
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// daemonListener is a listener and the handler that serves it
+type daemonListener struct {
+	net.Listener
+	handler http.Handler
+}
+
+// serveDaemon serves every listener until ctx is done or one of them fails,
+// then shuts down gracefully
+func serveDaemon(ctx context.Context, listeners []daemonListener) error {
+	servers := make([]*http.Server, len(listeners))
+	errCh := make(chan error, len(listeners))
+	for i, l := range listeners {
+		srv := &http.Server{
+			Handler:           l.handler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		servers[i] = srv
+		go func(l net.Listener) {
+			errCh <- srv.Serve(l)
+		}(l.Listener)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), daemonShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if serr := srv.Shutdown(shutdownCtx); err == nil {
+			err = serr
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+type daemonEncryptRequest struct {
+	Plaintext []byte `json:"plaintext"`
+	AAD       []byte `json:"aad,omitempty"`
+}
+
+type daemonEncryptResponse struct {
+	Blob  string `json:"blob"`
+	KeyID string `json:"key_id"`
+}
+
+type daemonDecryptRequest struct {
+	Blob string `json:"blob"`
+	AAD  []byte `json:"aad,omitempty"`
+}
+
+type daemonDecryptResponse struct {
+	Plaintext []byte `json:"plaintext"`
+}
+
+type daemonHealthResponse struct {
+	Type  string `json:"type"`
+	KeyID string `json:"key_id"`
+}
+
+type daemonErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// newDaemonHandler returns the API handler for w
+func newDaemonHandler(w wrapping.Wrapper) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/encrypt", func(rw http.ResponseWriter, r *http.Request) {
+		var req daemonEncryptRequest
+		if !decodeDaemonRequest(rw, r, &req) {
+			return
+		}
+		if req.Plaintext == nil {
+			writeDaemonError(rw, http.StatusBadRequest, errors.New("plaintext is required"))
+			return
+		}
+		blob, err := w.Encrypt(r.Context(), req.Plaintext, nilIfEmpty(req.AAD))
+		if err != nil {
+			writeDaemonError(rw, http.StatusInternalServerError, fmt.Errorf("error encrypting: %w", err))
+			return
+		}
+		encoded, err := encodeBlob(blob)
+		if err != nil {
+			writeDaemonError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		resp := &daemonEncryptResponse{Blob: strings.TrimSpace(string(encoded))}
+		if blob.KeyInfo != nil {
+			resp.KeyID = blob.KeyInfo.KeyID
+		}
+		writeDaemonResponse(rw, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/v1/decrypt", func(rw http.ResponseWriter, r *http.Request) {
+		var req daemonDecryptRequest
+		if !decodeDaemonRequest(rw, r, &req) {
+			return
+		}
+		blob, err := decodeBlob([]byte(req.Blob))
+		if err != nil {
+			writeDaemonError(rw, http.StatusBadRequest, err)
+			return
+		}
+		pt, err := w.Decrypt(r.Context(), blob, nilIfEmpty(req.AAD))
+		if err != nil {
+			// Most failures are a wrong key or AAD, which the caller has to
+			// fix, but a KMS outage looks the same from here
+			writeDaemonError(rw, http.StatusUnprocessableEntity, fmt.Errorf("error decrypting: %w", err))
+			return
+		}
+		if pt == nil {
+			pt = []byte{}
+		}
+		writeDaemonResponse(rw, http.StatusOK, &daemonDecryptResponse{Plaintext: pt})
+	})
+
+	mux.HandleFunc("/v1/health", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.Header().Set("Allow", http.MethodGet)
+			writeDaemonError(rw, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		writeDaemonResponse(rw, http.StatusOK, &daemonHealthResponse{Type: w.Type(), KeyID: w.KeyID()})
+	})
+
+	return mux
+}
+
+// decodeDaemonRequest parses a POST body into v, writing an error response
+// and returning false if it cannot
+func decodeDaemonRequest(rw http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		writeDaemonError(rw, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return false
+	}
+	// Browsers send text/plain and form bodies cross-origin without asking,
+	// but not JSON
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeDaemonError(rw, http.StatusUnsupportedMediaType, errors.New("request body must be of type application/json"))
+		return false
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(rw, r.Body, maxRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeDaemonError(rw, http.StatusBadRequest, fmt.Errorf("error parsing request: %w", err))
+		return false
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		writeDaemonError(rw, http.StatusBadRequest, errors.New("error parsing request: unexpected data after the request object"))
+		return false
+	}
+	return true
+}
+
+func writeDaemonResponse(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+func writeDaemonError(rw http.ResponseWriter, status int, err error) {
+	writeDaemonResponse(rw, status, &daemonErrorResponse{Error: err.Error()})
+}
+
+// nilIfEmpty maps empty AAD to nil, as aadBytes does for flags
+func nilIfEmpty(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+)
+
+func TestDaemonHandler(t *testing.T) {
+	w := testwrapper.AEAD(t)
+	handler := newDaemonHandler(w)
+
+	call := func(method, path, body string, expectedStatus int, out interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(rec, req)
+		if rec.Code != expectedStatus {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, expectedStatus, rec.Code, rec.Body.String())
+		}
+		if out != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// "c2VjcmV0" and "Y3R4" are "secret" and "ctx"
+	var enc daemonEncryptResponse
+	call("POST", "/v1/encrypt", `{"plaintext": "c2VjcmV0", "aad": "Y3R4"}`, http.StatusOK, &enc)
+	if enc.KeyID != w.KeyID() || enc.Blob == "" {
+		t.Fatalf("unexpected response %+v", enc)
+	}
+
+	var dec daemonDecryptResponse
+	call("POST", "/v1/decrypt", `{"blob": "`+enc.Blob+`", "aad": "Y3R4"}`, http.StatusOK, &dec)
+	if string(dec.Plaintext) != "secret" {
+		t.Fatalf("expected secret, got %q", dec.Plaintext)
+	}
+
+	// The blob is interchangeable with the CLI's
+	blob, err := decodeBlob([]byte(enc.Blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := w.Decrypt(context.Background(), blob, []byte("ctx")); err != nil || string(pt) != "secret" {
+		t.Fatalf("expected secret, got %q: %v", pt, err)
+	}
+
+	var health daemonHealthResponse
+	call("GET", "/v1/health", "", http.StatusOK, &health)
+	if health.Type != "aead" || health.KeyID != w.KeyID() {
+		t.Fatalf("unexpected response %+v", health)
+	}
+
+	var errResp daemonErrorResponse
+	call("POST", "/v1/decrypt", `{"blob": "`+enc.Blob+`"}`, http.StatusUnprocessableEntity, &errResp)
+	if !strings.Contains(errResp.Error, "error decrypting") {
+		t.Fatalf("unexpected error %q", errResp.Error)
+	}
+	call("POST", "/v1/encrypt", `{}`, http.StatusBadRequest, nil)
+	call("POST", "/v1/encrypt", `{"plaintext": "c2VjcmV0", "extra": 1}`, http.StatusBadRequest, nil)
+	call("POST", "/v1/encrypt", `{"plaintext": "c2VjcmV0"} {}`, http.StatusBadRequest, nil)
+	call("POST", "/v1/decrypt", `{"blob": "not a blob"}`, http.StatusBadRequest, nil)
+	call("GET", "/v1/encrypt", "", http.StatusMethodNotAllowed, nil)
+	call("POST", "/v1/health", "", http.StatusMethodNotAllowed, nil)
+	call("GET", "/v1/nope", "", http.StatusNotFound, nil)
+
+	// Browsers send these cross-origin without a preflight
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded", "application/jsonp"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/decrypt", strings.NewReader(`{"blob": "`+enc.Blob+`", "aad": "Y3R4"}`))
+		req.Header.Set("Content-Type", contentType)
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnsupportedMediaType {
+			t.Fatalf("%q: expected status %d, got %d", contentType, http.StatusUnsupportedMediaType, rec.Code)
+		}
+	}
+}
+
+func TestRequireDaemonToken(t *testing.T) {
+	handler := requireDaemonToken("s3cret", newDaemonHandler(testwrapper.AEAD(t)))
+
+	for _, tc := range []struct {
+		Title          string
+		Host           string
+		Authorization  string
+		ExpectedStatus int
+	}{
+		{"Valid", "127.0.0.1:8200", "Bearer s3cret", http.StatusOK},
+		{"Localhost", "localhost:8200", "Bearer s3cret", http.StatusOK},
+		{"IPv6", "[::1]:8200", "Bearer s3cret", http.StatusOK},
+		{"NoPort", "localhost", "Bearer s3cret", http.StatusOK},
+		{"Missing", "127.0.0.1:8200", "", http.StatusUnauthorized},
+		{"Wrong", "127.0.0.1:8200", "Bearer other", http.StatusUnauthorized},
+		{"NotBearer", "127.0.0.1:8200", "s3cret", http.StatusUnauthorized},
+		{"Rebinding", "attacker.example:8200", "Bearer s3cret", http.StatusForbidden},
+		{"OtherAddress", "10.0.0.1:8200", "Bearer s3cret", http.StatusForbidden},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/health", nil)
+			req.Host = tc.Host
+			if tc.Authorization != "" {
+				req.Header.Set("Authorization", tc.Authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.ExpectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.ExpectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestLoadDaemonToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kmswrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	token, created, err := loadDaemonToken(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !created || len(token) < 32 {
+		t.Fatalf("expected a new token, got %q", token)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// The token is kept across runs
+	again, created, err := loadDaemonToken(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if created || again != token {
+		t.Fatalf("expected %q, got %q", token, again)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadDaemonToken(path); err == nil {
+		t.Fatal("expected error for an empty token file")
+	}
+}
+
+func TestServeDaemon_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kmswrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "d.sock")
+
+	l, err := listenUnix(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// Sockets are created with their mode, not changed to it
+	group, err := listenUnix(filepath.Join(dir, "group.sock"), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "group.sock")); err != nil || info.Mode().Perm() != 0660 {
+		t.Fatalf("expected mode 0660, got %v and %v", info.Mode().Perm(), err)
+	}
+	group.Close()
+
+	// A socket that is being served cannot be taken over
+	if _, err := listenUnix(path, 0600); err == nil {
+		t.Fatal("expected error listening on a socket in use")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- serveDaemon(ctx, []daemonListener{{l, newDaemonHandler(testwrapper.AEAD(t))}})
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Post("http://kmswrap/v1/encrypt", "application/json", bytes.NewBufferString(`{"plaintext": "c2VjcmV0"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A stale socket left behind is replaced
+	stale, err := net.Listen("unix", filepath.Join(dir, "stale.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	l, err = listenUnix(filepath.Join(dir, "stale.sock"), 0600)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	l.Close()
+
+	// Other files are left alone
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(filepath.Join(dir, "file"), 0600); err == nil {
+		t.Fatal("expected error listening over a regular file")
+	}
+}
+
+func TestDaemon_Flags(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8200", "[::1]:8200", "localhost:0"} {
+		if err := checkLoopback(addr); err != nil {
+			t.Fatalf("%s: %s", addr, err)
+		}
+	}
+	for _, addr := range []string{"0.0.0.0:8200", ":8200", "example.com:80", "127.0.0.1"} {
+		if err := checkLoopback(addr); err == nil {
+			t.Fatalf("%s: expected error", addr)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"daemon"},
+		{"daemon", "-http", "0.0.0.0:8200"},
+		{"daemon", "-http", "127.0.0.1:8200"},
+		{"daemon", "-socket", "x", "-socket-mode", "999"},
+	} {
+		if code := run(args, nil, ioutil.Discard, ioutil.Discard); code != 2 {
+			t.Fatalf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
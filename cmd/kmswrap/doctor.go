@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/ocikms"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/awsutil"
+	"golang.org/x/oauth2/google"
+)
+
+const doctorUsage = `Usage: kmswrap doctor [options]
+
+  Diagnoses why the configured wrapper does not work, step by step, and
+  writes a report suitable for attaching to a support case:
+
+    1. configuration    the checks of "kmswrap validate"
+    2. credentials      which source the credentials are taken from, in the
+                        order the wrapper's SDK looks, and when they expire
+    3. endpoint         whether the KMS endpoint resolves and accepts
+                        connections
+    4. clock            the difference between the local clock and the
+                        endpoint's, which breaks request signing when it
+                        exceeds a few minutes
+    5. key permissions  an encrypt and decrypt round trip with the key
+
+  Later steps are skipped when the configuration is invalid. Secrets are
+  never printed. The exit code is 1 if any error was found.`
+
+// Thresholds for the clock and expiry checks. AWS rejects signatures more
+// than five minutes off.
+const (
+	clockSkewWarning = 30 * time.Second
+	clockSkewError   = 5 * time.Minute
+	expiryWarning    = 15 * time.Minute
+)
+
+func (c *cli) doctor(args []string) error {
+	fs := c.flagSet("doctor", doctorUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	timeout := fs.Duration("timeout", 10*time.Second, "time limit for each network check")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(c.stderr, "doctor takes no arguments\n")
+		return errUsage
+	}
+
+	var all findings
+	step := func(n int, name string, results findings) {
+		fmt.Fprintf(c.stdout, "%d. %s\n", n, name)
+		for _, f := range results {
+			fmt.Fprintf(c.stdout, "   %s\n", f)
+		}
+		all = append(all, results...)
+	}
+
+	results := findings{}
+	seal, err := wf.resolve()
+	if err != nil {
+		results.errorf("%v", err)
+	} else {
+		results = validateSeal(seal)
+		if !results.hasErrors() {
+			results.okf("%s configuration is valid", seal.Type)
+		}
+	}
+	step(1, "configuration", results)
+
+	if results.hasErrors() {
+		for n, name := range []string{"credentials", "endpoint", "clock", "key permissions"} {
+			skipped := findings{}
+			skipped.notef("skipped until the configuration is fixed")
+			step(n+2, name, skipped)
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		step(2, "credentials", doctorCredentials(ctx, seal))
+		cancel()
+
+		var probe *endpointProbe
+		endpoint, err := doctorEndpoint(seal)
+		results = findings{}
+		switch {
+		case err != nil:
+			results.errorf("%v", err)
+		case endpoint == "":
+			results.okf("the %s wrapper does not use a network endpoint", seal.Type)
+		default:
+			probe, results = probeEndpoint(endpoint, *timeout)
+		}
+		step(3, "endpoint", results)
+		step(4, "clock", checkClock(probe))
+
+		step(5, "key permissions", preflightSeal(seal))
+	}
+
+	errs, warnings := all.count("error"), all.count("warning")
+	if errs == 0 && warnings == 0 {
+		fmt.Fprintf(c.stdout, "\nno problems found\n")
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "\nfound %d error(s) and %d warning(s)\n", errs, warnings)
+	if errs > 0 {
+		return errors.New("problems found")
+	}
+	return nil
+}
+
+// doctorCredentials reports where the wrapper's credentials come from
+func doctorCredentials(ctx context.Context, seal *sealConfig) findings {
+	var fs findings
+	params := wrapperParams[seal.Type]
+
+	switch seal.Type {
+	case wrapping.AEAD, wrapping.SealedBox:
+		fs.okf("the key is part of the configuration; there are no credentials to resolve")
+
+	case wrapping.AWSKMS:
+		awsCredentials(&fs, seal, params)
+
+	case wrapping.GCPCKMS:
+		gcpCredentials(ctx, &fs, seal, params)
+
+	case wrapping.Transit:
+		transitCredentials(&fs, seal)
+
+	case wrapping.AzureKeyVault:
+		if effective(seal, params, "client_id") != "" && effective(seal, params, "client_secret") != "" {
+			fs.okf("using client credentials for client ID %s from %s", effective(seal, params, "client_id"), source(seal, params, "client_id"))
+		} else {
+			fs.notef("client_id and client_secret are not both set; the managed identity of the host will be used")
+		}
+		fs.notef("tokens are requested during the key permissions step")
+
+	case wrapping.OCIKMS:
+		apiKey, _ := strconv.ParseBool(seal.Config[ocikms.KMSConfigAuthTypeAPIKey])
+		if !apiKey {
+			fs.notef("using the instance principal of the host; set %s to use an API key", ocikms.KMSConfigAuthTypeAPIKey)
+			break
+		}
+		path := os.Getenv("OCI_CONFIG_FILE")
+		if path == "" {
+			home, _ := os.UserHomeDir()
+			path = filepath.Join(home, ".oci", "config")
+		}
+		if _, err := os.Stat(path); err != nil {
+			fs.errorf("API key authentication needs an OCI configuration file: %v", err)
+		} else {
+			fs.okf("using the API key configured in %s", path)
+		}
+
+	default:
+		// The remaining wrappers take static keys from the configuration or
+		// environment
+		for _, name := range []string{"access_key", "secret_key", "access_secret", "session_token"} {
+			if !hasParam(params, name) {
+				continue
+			}
+			if effective(seal, params, name) == "" {
+				fs.notef("%s is not set", name)
+				continue
+			}
+			fs.okf("%s is taken from %s", name, source(seal, params, name))
+		}
+	}
+	return fs
+}
+
+func awsCredentials(fs *findings, seal *sealConfig, params []wrapperParam) {
+	// The order of awsutil.GenerateCredentialChain
+	if seal.Config["access_key"] != "" && seal.Config["secret_key"] != "" {
+		fs.notef("static access_key and secret_key are set in the configuration and take precedence")
+	}
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SHARED_CREDENTIALS_FILE", "AWS_PROFILE", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN"} {
+		if os.Getenv(env) != "" {
+			fs.notef("%s is set", env)
+		}
+	}
+
+	region := effective(seal, params, "region")
+	if region == "" {
+		region = "us-east-1"
+	}
+	credsConfig := &awsutil.CredentialsConfig{
+		AccessKey:    seal.Config["access_key"],
+		SecretKey:    seal.Config["secret_key"],
+		SessionToken: seal.Config["session_token"],
+		Region:       region,
+		HTTPClient:   &http.Client{Timeout: 5 * time.Second},
+	}
+	creds, err := credsConfig.GenerateCredentialChain()
+	if err != nil {
+		fs.errorf("no AWS credentials found: %v", err)
+		return
+	}
+	value, err := creds.Get()
+	if err != nil {
+		fs.errorf("error retrieving AWS credentials: %v", err)
+		return
+	}
+	fs.okf("credentials for access key %s were found by the %s provider", maskSecret(value.AccessKeyID), value.ProviderName)
+	if expires, err := creds.ExpiresAt(); err == nil {
+		checkExpiry(fs, "the credentials", expires)
+	}
+}
+
+func gcpCredentials(ctx context.Context, fs *findings, seal *sealConfig, params []wrapperParam) {
+	const scope = "https://www.googleapis.com/auth/cloudkms"
+
+	var creds *google.Credentials
+	if path := effective(seal, params, "credentials"); path != "" {
+		fs.notef("using the credentials file %s from %s", path, source(seal, params, "credentials"))
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fs.errorf("error reading credentials: %v", err)
+			return
+		}
+		if creds, err = google.CredentialsFromJSON(ctx, data, scope); err != nil {
+			fs.errorf("error parsing credentials: %v", err)
+			return
+		}
+	} else {
+		if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+			fs.notef("using application default credentials from GOOGLE_APPLICATION_CREDENTIALS=%s", path)
+		} else {
+			fs.notef("no credentials file is configured; using gcloud's application default credentials or the metadata server")
+		}
+		var err error
+		if creds, err = google.FindDefaultCredentials(ctx, scope); err != nil {
+			fs.errorf("no GCP credentials found: %v", err)
+			return
+		}
+	}
+
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		fs.errorf("error obtaining an access token: %v", err)
+		return
+	}
+	if creds.ProjectID != "" {
+		fs.okf("obtained an access token for project %s", creds.ProjectID)
+	} else {
+		fs.okf("obtained an access token")
+	}
+	checkExpiry(fs, "the access token", token.Expiry)
+}
+
+func transitCredentials(fs *findings, seal *sealConfig) {
+	// The wrapper prefers the configuration to VAULT_TOKEN
+	token := seal.Config["token"]
+	switch {
+	case token != "":
+		fs.notef("using the token from the configuration")
+	case os.Getenv("VAULT_TOKEN") != "":
+		token = os.Getenv("VAULT_TOKEN")
+		fs.notef("using the token from VAULT_TOKEN")
+	default:
+		fs.errorf("no token is set; set token in the seal stanza or VAULT_TOKEN")
+		return
+	}
+
+	client, err := transitAPIClient(seal)
+	if err != nil {
+		fs.errorf("error configuring the Vault client: %v", err)
+		return
+	}
+	client.SetToken(token)
+	secret, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		fs.errorf("error looking up the token, it may be invalid or revoked: %v", err)
+		return
+	}
+	policies, _ := secret.TokenPolicies()
+	fs.okf("the token is valid, with policies %s", strings.Join(policies, ", "))
+
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		fs.warnf("could not read the token's TTL: %v", err)
+		return
+	}
+	if ttl == 0 {
+		checkExpiry(fs, "the token", time.Time{})
+		return
+	}
+	renewable, _ := secret.TokenIsRenewable()
+	if renewable && !disableRenewal(seal) {
+		fs.okf("the token expires in %s and will be renewed by the wrapper", ttl.Round(time.Second))
+		return
+	}
+	checkExpiry(fs, "the token", time.Now().Add(ttl))
+}
+
+func disableRenewal(seal *sealConfig) bool {
+	disabled, _ := strconv.ParseBool(effective(seal, wrapperParams[wrapping.Transit], "disable_renewal"))
+	return disabled
+}
+
+// transitAPIClient builds a Vault client the way the transit wrapper does
+func transitAPIClient(seal *sealConfig) (*api.Client, error) {
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+	if seal.Config["address"] != "" {
+		config.Address = seal.Config["address"]
+	}
+	skipVerify, _ := strconv.ParseBool(seal.Config["tls_skip_verify"])
+	if err := config.ConfigureTLS(&api.TLSConfig{
+		CACert:        seal.Config["tls_ca_cert"],
+		CAPath:        seal.Config["tls_ca_path"],
+		ClientCert:    seal.Config["tls_client_cert"],
+		ClientKey:     seal.Config["tls_client_key"],
+		TLSServerName: seal.Config["tls_server_name"],
+		Insecure:      skipVerify,
+	}); err != nil {
+		return nil, err
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	if ns := effective(seal, wrapperParams[wrapping.Transit], "namespace"); ns != "" {
+		client.SetNamespace(ns)
+	}
+	return client, nil
+}
+
+// checkExpiry reports on credentials that expire at the given time
+func checkExpiry(fs *findings, what string, expires time.Time) {
+	if expires.IsZero() {
+		fs.okf("%s: no expiry", what)
+		return
+	}
+	left := time.Until(expires).Round(time.Second)
+	switch {
+	case left <= 0:
+		fs.errorf("%s: expired at %s", what, expires.UTC().Format(time.RFC3339))
+	case left < expiryWarning:
+		fs.warnf("%s: expiring in %s, at %s", what, left, expires.UTC().Format(time.RFC3339))
+	default:
+		fs.okf("%s: valid for %s, until %s", what, left, expires.UTC().Format(time.RFC3339))
+	}
+}
+
+// doctorEndpoint returns the URL the wrapper sends requests to, or "" if it
+// makes none
+func doctorEndpoint(seal *sealConfig) (string, error) {
+	params := wrapperParams[seal.Type]
+	region := effective(seal, params, "region")
+
+	switch seal.Type {
+	case wrapping.AEAD, wrapping.SealedBox:
+		return "", nil
+	case wrapping.AWSKMS:
+		if endpoint := effective(seal, params, "endpoint"); endpoint != "" {
+			return endpoint, nil
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		return "https://kms." + region + ".amazonaws.com", nil
+	case wrapping.GCPCKMS:
+		return "https://cloudkms.googleapis.com", nil
+	case wrapping.Transit:
+		if address := effective(seal, params, "address"); address != "" {
+			return address, nil
+		}
+		return "https://127.0.0.1:8200", nil
+	case wrapping.AzureKeyVault:
+		env := azure.PublicCloud
+		if name := effective(seal, params, "environment"); name != "" {
+			var err error
+			if env, err = azure.EnvironmentFromName(name); err != nil {
+				return "", err
+			}
+		}
+		return "https://" + effective(seal, params, "vault_name") + "." + env.KeyVaultDNSSuffix, nil
+	case wrapping.OCIKMS:
+		return effective(seal, params, ocikms.KMSConfigCryptoEndpoint), nil
+	case wrapping.AliCloudKMS:
+		if domain := effective(seal, params, "domain"); domain != "" {
+			return "https://" + domain, nil
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		return "https://kms." + region + ".aliyuncs.com", nil
+	case wrapping.HuaweiCloudKMS:
+		return "https://kms." + region + ".myhuaweicloud.com", nil
+	case wrapping.TencentCloudKMS:
+		return "https://kms.tencentcloudapi.com", nil
+	default:
+		return "", fmt.Errorf("unsupported wrapper type %q", seal.Type)
+	}
+}
+
+// endpointProbe is the outcome of contacting an endpoint
+type endpointProbe struct {
+	host string
+
+	// skew is the server's clock minus ours, or nil if the server did not
+	// send its time
+	skew *time.Duration
+}
+
+// probeEndpoint resolves and connects to endpoint, then makes an HTTP
+// request to learn the server's time
+func probeEndpoint(endpoint string, timeout time.Duration) (*endpointProbe, findings) {
+	var fs findings
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		fs.errorf("invalid endpoint %q", endpoint)
+		return nil, fs
+	}
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		fs.errorf("could not resolve %s: %v", host, err)
+		return nil, fs
+	}
+	fs.okf("%s resolves to %s", host, strings.Join(addrs, ", "))
+
+	start := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fs.errorf("could not connect to %s: %v; check firewalls and proxies", net.JoinHostPort(host, port), err)
+		return nil, fs
+	}
+	conn.Close()
+	fs.okf("connected to %s in %s", net.JoinHostPort(host, port), time.Since(start).Round(time.Millisecond))
+	probe := &endpointProbe{host: host}
+
+	// Only the Date header is wanted. Certificates are checked by the key
+	// permissions step, through the wrapper's own client.
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	start = time.Now()
+	resp, err := client.Head(u.Scheme + "://" + u.Host + "/")
+	if err != nil {
+		fs.warnf("HTTP request to %s failed: %v", u.Host, err)
+		return probe, fs
+	}
+	resp.Body.Close()
+	rtt := time.Since(start)
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		// Assume the server stamped the response halfway through
+		skew := date.Sub(start.Add(rtt / 2))
+		probe.skew = &skew
+	}
+	return probe, fs
+}
+
+// checkClock reports the clock difference measured by probe
+func checkClock(probe *endpointProbe) findings {
+	var fs findings
+	switch {
+	case probe == nil:
+		fs.notef("skipped, no endpoint was reached")
+		return fs
+	case probe.skew == nil:
+		fs.warnf("%s did not report its time; check the local clock with NTP", probe.host)
+		return fs
+	}
+
+	skew := *probe.skew
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	// The Date header only has a resolution of one second
+	offset := skew.Round(time.Second)
+	direction := "behind"
+	if skew < 0 {
+		direction = "ahead of"
+		offset = -offset
+	}
+	switch {
+	case abs > clockSkewError:
+		fs.errorf("the local clock is %s %s %s; signed requests will be rejected", offset, direction, probe.host)
+	case abs > clockSkewWarning:
+		fs.warnf("the local clock is %s %s %s", offset, direction, probe.host)
+	default:
+		fs.okf("the local clock is within %s of %s", clockSkewWarning, probe.host)
+	}
+	return fs
+}
+
+// source describes where the value of the named parameter comes from
+func source(seal *sealConfig, params []wrapperParam, name string) string {
+	for _, p := range params {
+		if p.name != name {
+			continue
+		}
+		if _, source := p.resolve(seal.Config); source != "configuration" {
+			return source
+		}
+	}
+	return "the configuration"
+}
+
+func hasParam(params []wrapperParam, name string) bool {
+	for _, p := range params {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSecret shows only the last four characters of an identifier
+func maskSecret(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
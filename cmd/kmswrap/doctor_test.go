@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoctor_AEAD(t *testing.T) {
+	defer setTestEnv(t, nil)()
+
+	out := testRun(t, append([]string{"doctor"}, testAEADFlags(t)...), "")
+	for _, want := range []string{
+		"1. configuration\n   ok: aead configuration is valid\n",
+		"3. endpoint\n   ok: the aead wrapper does not use a network endpoint\n",
+		"4. clock\n   note: skipped, no endpoint was reached\n",
+		"   ok: test decryption succeeded\n",
+		"\nno problems found\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	var stdout bytes.Buffer
+	if code := run([]string{"doctor", "-wrapper", "aead", "-set", "aead_type=aes-gcm"}, nil, &stdout, ioutil.Discard); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if n := strings.Count(stdout.String(), "note: skipped until the configuration is fixed"); n != 4 {
+		t.Fatalf("expected 4 skipped steps, got %d:\n%s", n, stdout.String())
+	}
+}
+
+// fakeVault serves the token lookup and transit endpoints, stamping
+// responses with its clock skewed by skew
+func fakeVault(skew time.Duration) *httptest.Server {
+	write := func(w http.ResponseWriter, data map[string]interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/token/lookup-self", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.test" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		write(w, map[string]interface{}{"ttl": 600, "renewable": false, "policies": []string{"default", "transit"}})
+	})
+	// Ciphertexts are the plaintext in disguise
+	mux.HandleFunc("/v1/transit/encrypt/k", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		write(w, map[string]interface{}{"ciphertext": "vault:v1:" + req["plaintext"]})
+	})
+	mux.HandleFunc("/v1/transit/decrypt/k", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		write(w, map[string]interface{}{"plaintext": strings.TrimPrefix(req["ciphertext"], "vault:v1:")})
+	})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func TestDoctor_Transit(t *testing.T) {
+	defer setTestEnv(t, nil)()
+
+	srv := fakeVault(0)
+	defer srv.Close()
+	args := []string{"doctor", "-wrapper", "transit",
+		"-set", "address=" + srv.URL,
+		"-set", "mount_path=transit",
+		"-set", "key_name=k",
+		"-set", "disable_renewal=true",
+	}
+
+	out := testRun(t, append(args, "-set", "token=s.test"), "")
+	for _, want := range []string{
+		"   note: using the token from the configuration\n",
+		"   ok: the token is valid, with policies default, transit\n",
+		"   warning: the token: expiring in ",
+		"   ok: connected to 127.0.0.1:",
+		"   ok: the local clock is within 30s of 127.0.0.1\n",
+		"   ok: test encryption succeeded\n",
+		"\nfound 0 error(s) and 1 warning(s)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	var stdout bytes.Buffer
+	if code := run(append(args, "-set", "token=s.revoked"), nil, &stdout, ioutil.Discard); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "error: error looking up the token, it may be invalid or revoked") {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+
+	skewed := fakeVault(10 * time.Minute)
+	defer skewed.Close()
+	stdout.Reset()
+	args[4] = "address=" + skewed.URL
+	if code := run(append(args, "-set", "token=s.test"), nil, &stdout, ioutil.Discard); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	// The Date header is truncated to the second
+	if !strings.Contains(stdout.String(), "behind 127.0.0.1; signed requests will be rejected") {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	skew := func(d time.Duration) *endpointProbe {
+		return &endpointProbe{host: "kms", skew: &d}
+	}
+	for _, tc := range []struct {
+		Title    string
+		Probe    *endpointProbe
+		Expected string
+	}{
+		{"Unreached", nil, "note: skipped, no endpoint was reached"},
+		{"NoDate", &endpointProbe{host: "kms"}, "warning: kms did not report its time; check the local clock with NTP"},
+		{"Close", skew(2 * time.Second), "ok: the local clock is within 30s of kms"},
+		{"Ahead", skew(-45 * time.Second), "warning: the local clock is 45s ahead of kms"},
+		{"Behind", skew(6 * time.Minute), "error: the local clock is 6m0s behind kms; signed requests will be rejected"},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			fs := checkClock(tc.Probe)
+			if len(fs) != 1 || fs[0].String() != tc.Expected {
+				t.Fatalf("expected %q, got %v", tc.Expected, fs)
+			}
+		})
+	}
+}
+
+func TestCheckExpiry(t *testing.T) {
+	for _, tc := range []struct {
+		Expires time.Time
+		Level   string
+	}{
+		{time.Time{}, "ok"},
+		{time.Now().Add(-time.Minute), "error"},
+		{time.Now().Add(5 * time.Minute), "warning"},
+		{time.Now().Add(time.Hour), "ok"},
+	} {
+		var fs findings
+		checkExpiry(&fs, "the token", tc.Expires)
+		if len(fs) != 1 || fs[0].level != tc.Level {
+			t.Fatalf("%v: expected a finding at level %s, got %v", tc.Expires, tc.Level, fs)
+		}
+	}
+}
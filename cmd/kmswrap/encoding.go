@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/age"
+	"github.com/hashicorp/go-kms-wrapping/armor"
+	"github.com/hashicorp/go-kms-wrapping/format"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// blobEncoding is a way of writing an EncryptedBlobInfo
+type blobEncoding string
+
+const (
+	// encodingBase64 is the protobuf encoding, base64 encoded on one line.
+	// It is the default.
+	encodingBase64 blobEncoding = "base64"
+
+	// encodingRaw is the protobuf encoding as it is
+	encodingRaw blobEncoding = "raw"
+
+	// encodingJSON is the protobuf JSON mapping on one line, with the field
+	// names of the .proto file
+	encodingJSON blobEncoding = "json"
+
+	// encodingPEM is the protobuf encoding in an armored block, with the
+	// wrapper type and key ID in its headers
+	encodingPEM blobEncoding = "pem"
+
+	// encodingAge is an age v1 file rather than a blob: the plaintext under
+	// a file key that the wrapper encrypts. Only encrypt and decrypt handle
+	// it.
+	encodingAge blobEncoding = "age"
+
+	// encodingAuto detects any of the others when reading
+	encodingAuto blobEncoding = "auto"
+)
+
+// parseEncoding validates the value of an -encoding flag. auto is only
+// meaningful for input.
+func parseEncoding(s string, input bool) (blobEncoding, error) {
+	switch enc := blobEncoding(s); enc {
+	case encodingBase64, encodingRaw, encodingJSON, encodingPEM, encodingAge:
+		return enc, nil
+	case encodingAuto:
+		if input {
+			return enc, nil
+		}
+	}
+	if input {
+		return "", fmt.Errorf("unknown encoding %q; must be auto, base64, raw, json, pem or age", s)
+	}
+	return "", fmt.Errorf("unknown encoding %q; must be base64, raw, json, pem or age", s)
+}
+
+// encodeBlob marshals blob and base64 encodes it, followed by a newline
+func encodeBlob(blob *wrapping.EncryptedBlobInfo) ([]byte, error) {
+	return encodeBlobAs(blob, encodingBase64, "")
+}
+
+// encodeBlobAs marshals blob in the given encoding. Every encoding except raw
+// ends with a newline. wrapperType, if set, is recorded in the headers of
+// PEM blocks.
+func encodeBlobAs(blob *wrapping.EncryptedBlobInfo, enc blobEncoding, wrapperType string) ([]byte, error) {
+	switch enc {
+	case encodingJSON:
+		out, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(blob)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding blob: %w", err)
+		}
+		return append(out, '\n'), nil
+	case encodingPEM:
+		out, err := armor.Encode(blob, &armor.Options{WrapperType: wrapperType})
+		if err != nil {
+			return nil, fmt.Errorf("error encoding blob: %w", err)
+		}
+		return out, nil
+	}
+
+	raw, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding blob: %w", err)
+	}
+	switch enc {
+	case encodingBase64:
+		return []byte(base64.StdEncoding.EncodeToString(raw) + "\n"), nil
+	case encodingRaw:
+		return raw, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", enc)
+	}
+}
+
+// decodeBlob parses an EncryptedBlobInfo in any encoding
+func decodeBlob(input []byte) (*wrapping.EncryptedBlobInfo, error) {
+	blob, _, err := decodeBlobAs(input, encodingAuto)
+	return blob, err
+}
+
+// decodeBlobAs parses an EncryptedBlobInfo in the given encoding, or detects
+// it with encodingAuto, and returns the encoding that was used
+func decodeBlobAs(input []byte, enc blobEncoding) (*wrapping.EncryptedBlobInfo, blobEncoding, error) {
+	if enc == encodingAuto {
+		enc = detectEncoding(input)
+	}
+
+	var raw []byte
+	switch enc {
+	case encodingRaw:
+		raw = input
+	case encodingBase64:
+		var err error
+		if raw, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(input))); err != nil {
+			return nil, enc, fmt.Errorf("error decoding blob: %w", err)
+		}
+	case encodingPEM:
+		block, rest, err := armor.Decode(input)
+		if err != nil {
+			return nil, enc, fmt.Errorf("error decoding blob: %w", err)
+		}
+		if len(bytes.TrimSpace(rest)) != 0 {
+			return nil, enc, errors.New("error decoding blob: unexpected data after the PEM block")
+		}
+		return block.Blob, enc, nil
+	case encodingJSON:
+		var blob wrapping.EncryptedBlobInfo
+		if err := protojson.Unmarshal(input, &blob); err != nil {
+			return nil, enc, fmt.Errorf("error decoding blob: %w", err)
+		}
+		return &blob, enc, nil
+	case encodingAge:
+		return nil, enc, errors.New("input is an age file, not a blob; only decrypt reads age files")
+	default:
+		return nil, enc, fmt.Errorf("unknown encoding %q", enc)
+	}
+
+	blob, _, err := format.Unmarshal(raw)
+	if err != nil {
+		return nil, enc, fmt.Errorf("error decoding blob: %w", err)
+	}
+	return blob, enc, nil
+}
+
+// detectEncoding guesses the encoding of input. A marshaled blob is binary
+// because of its random ciphertext, so anything that is not printable text
+// is taken to be raw, unless it has the header of an age file.
+func detectEncoding(input []byte) blobEncoding {
+	if age.IsAge(input) {
+		return encodingAge
+	}
+	for _, b := range input {
+		if (b < 0x20 || b > 0x7e) && b != '\n' && b != '\r' && b != '\t' {
+			return encodingRaw
+		}
+	}
+	text := bytes.TrimSpace(input)
+	switch {
+	case bytes.HasPrefix(text, []byte("-----BEGIN ")):
+		return encodingPEM
+	case bytes.HasPrefix(text, []byte("{")):
+		return encodingJSON
+	default:
+		return encodingBase64
+	}
+}
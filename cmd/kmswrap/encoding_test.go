@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/age"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/sealedbox"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestBlobEncodings(t *testing.T) {
+	blob := &wrapping.EncryptedBlobInfo{
+		Ciphertext: []byte{0x00, 0x01, 0xfe, 0xff, '\n'},
+		IV:         []byte("0123456789ab"),
+		KeyInfo: &wrapping.KeyInfo{
+			Mechanism: 1,
+			KeyID:     "test",
+		},
+	}
+
+	for _, enc := range []blobEncoding{encodingBase64, encodingRaw, encodingJSON, encodingPEM} {
+		t.Run(string(enc), func(t *testing.T) {
+			encoded, err := encodeBlobAs(blob, enc, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if enc != encodingRaw && !bytes.HasSuffix(encoded, []byte("\n")) {
+				t.Fatalf("expected a trailing newline in %q", encoded)
+			}
+			if (enc == encodingBase64 || enc == encodingJSON) && bytes.Count(encoded, []byte("\n")) != 1 {
+				t.Fatalf("expected a single line, got %q", encoded)
+			}
+
+			for _, want := range []blobEncoding{enc, encodingAuto} {
+				decoded, got, err := decodeBlobAs(encoded, want)
+				if err != nil {
+					t.Fatalf("%s: err: %s", want, err)
+				}
+				if got != enc {
+					t.Fatalf("%s: expected encoding %s, got %s", want, enc, got)
+				}
+				if !proto.Equal(decoded, blob) {
+					t.Fatalf("%s: expected %v, got %v", want, blob, decoded)
+				}
+			}
+		})
+	}
+
+	// JSON uses the field names of the .proto file
+	encoded, err := encodeBlobAs(blob, encodingJSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["key_info"]; !ok {
+		t.Fatalf("expected a key_info field in %s", encoded)
+	}
+
+	for _, input := range []string{
+		"-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n",
+		"-----BEGIN KMS WRAPPED DATA-----\nCgA=\n-----END KMS WRAPPED DATA-----\nextra",
+		"-----BEGIN KMS WRAPPED DATA-----\n",
+		`{"ciphertext": 1}`,
+		"not base64!",
+	} {
+		if _, err := decodeBlob([]byte(input)); err == nil {
+			t.Fatalf("%q: expected error", input)
+		}
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	if enc, err := parseEncoding("pem", false); err != nil || enc != encodingPEM {
+		t.Fatalf("expected pem, got %q: %v", enc, err)
+	}
+	if enc, err := parseEncoding("auto", true); err != nil || enc != encodingAuto {
+		t.Fatalf("expected auto, got %q: %v", enc, err)
+	}
+	if _, err := parseEncoding("auto", false); err == nil {
+		t.Fatal("expected error for auto output")
+	}
+	if _, err := parseEncoding("hex", true); err == nil {
+		t.Fatal("expected error for an unknown encoding")
+	}
+}
+
+func TestRun_Encodings(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	flags := testAEADFlags(t)
+
+	for _, enc := range []string{"base64", "raw", "json", "pem"} {
+		blob := testRun(t, append(append([]string{"encrypt"}, flags...), "-encoding", enc), "secret")
+		if enc == "pem" && (!strings.HasPrefix(blob, "-----BEGIN KMS WRAPPED DATA-----\n") || !strings.Contains(blob, "\nWrapper: aead\n")) {
+			t.Fatalf("unexpected PEM output %q", blob)
+		}
+
+		if pt := testRun(t, append([]string{"decrypt"}, flags...), blob); pt != "secret" {
+			t.Fatalf("%s: expected secret, got %q", enc, pt)
+		}
+		if pt := testRun(t, append(append([]string{"decrypt"}, flags...), "-encoding", enc), blob); pt != "secret" {
+			t.Fatalf("%s: expected secret, got %q", enc, pt)
+		}
+
+		var report blobReport
+		if err := json.Unmarshal([]byte(testRun(t, []string{"inspect", "-format", "json"}, blob)), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Encoding != enc {
+			t.Fatalf("expected encoding %s, got %s", enc, report.Encoding)
+		}
+		// The Wrapper header of PEM blocks names the wrapper
+		if enc == "pem" && (report.WrapperType != "aead" || report.Inferred) {
+			t.Fatalf("expected the aead wrapper from the header, got %q", report.WrapperType)
+		}
+	}
+
+	if code := run([]string{"encrypt", "-encoding", "auto"}, nil, &bytes.Buffer{}, &bytes.Buffer{}); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+}
+
+func TestRun_SealedBox(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	_, priv, err := sealedbox.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := []string{"-wrapper", "sealedbox", "-set", "private_key=" + priv}
+
+	blob := testRun(t, append([]string{"encrypt"}, flags...), "secret")
+	if pt := testRun(t, append([]string{"decrypt"}, flags...), blob); pt != "secret" {
+		t.Fatalf("expected secret, got %q", pt)
+	}
+	if out := testRun(t, []string{"inspect", "-wrapper", "sealedbox"}, blob); !strings.Contains(out, "sealed box") {
+		t.Fatalf("unexpected inspect output %q", out)
+	}
+}
+
+func TestRun_Age(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	flags := testAEADFlags(t)
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	file := testRun(t, append(append([]string{"encrypt"}, flags...), "-encoding", "age", "-age-recipient", id.Recipient().String()), "secret")
+	if !strings.HasPrefix(file, age.Version+"\n-> kms-wrap aead\n") {
+		t.Fatalf("unexpected age output %q", file)
+	}
+	if pt := testRun(t, append([]string{"decrypt"}, flags...), file); pt != "secret" {
+		t.Fatalf("expected secret, got %q", pt)
+	}
+	// The X25519 stanza opens the same file without the wrapper
+	pt, err := age.Decrypt(context.Background(), []byte(file), id)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "secret" {
+		t.Fatalf("expected secret, got %q", pt)
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"inspect"}, strings.NewReader(file), &bytes.Buffer{}, &stderr); code != 1 || !strings.Contains(stderr.String(), "age file") {
+		t.Fatalf("expected an age file error, got %d: %s", code, stderr.String())
+	}
+	for _, args := range [][]string{
+		{"encrypt", "-age-recipient", id.Recipient().String()},
+		{"encrypt", "-encoding", "age", "-aad", "foo"},
+		{"encrypt", "-encoding", "age", "-age-recipient", "age1foo"},
+	} {
+		if code := run(append(args, flags...), strings.NewReader("secret"), &bytes.Buffer{}, &bytes.Buffer{}); code != 2 {
+			t.Fatalf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/age"
+)
+
+const encryptUsage = `Usage: kmswrap encrypt [options] [file]
+
+  Encrypts file, or stdin if no file or "-" is given, and writes the
+  resulting blob, an EncryptedBlobInfo, to stdout.
+
+  -encoding selects how the blob is written: base64 (the protobuf encoding,
+  base64 encoded), raw (the protobuf encoding itself), json (the protobuf
+  JSON mapping) or pem (the protobuf encoding in a "KMS WRAPPED DATA" PEM
+  block, with the wrapper type and key ID as headers). Every encoding except
+  raw is a single line or block of text ending in a newline.
+
+  -encoding age writes an age v1 file instead of a blob. The file key is
+  wrapped in a "kms-wrap" stanza by the configured wrapper and, for each
+  -age-recipient, in an X25519 stanza, so that standard age tooling can
+  decrypt the file with the matching identity. -aad cannot be used with age
+  files.`
+
+func (c *cli) encrypt(args []string) error {
+	fs := c.flagSet("encrypt", encryptUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	aad := fs.String("aad", "", "additional authenticated data to bind to the blob")
+	out := fs.String("out", "", "write to this file instead of stdout")
+	encoding := fs.String("encoding", "base64", "blob encoding: base64, raw, json, pem or age")
+	var ageRecipients stringsFlag
+	fs.Var(&ageRecipients, "age-recipient", "age public `key` that can also decrypt with -encoding age; may be repeated")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	enc, err := parseEncoding(*encoding, false)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "invalid -encoding: %v\n", err)
+		return errUsage
+	}
+	if enc != encodingAge && len(ageRecipients) > 0 {
+		fmt.Fprintln(c.stderr, "-age-recipient requires -encoding age")
+		return errUsage
+	}
+	if enc == encodingAge && *aad != "" {
+		fmt.Fprintln(c.stderr, "-aad cannot be used with -encoding age")
+		return errUsage
+	}
+	var recipients []age.Recipient
+	for _, r := range ageRecipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "invalid -age-recipient: %v\n", err)
+			return errUsage
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	plaintext, err := c.readInput(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	w, err := c.wrapper(&wf)
+	if err != nil {
+		return err
+	}
+	defer w.Finalize(context.Background())
+
+	if enc == encodingAge {
+		recipients = append([]age.Recipient{age.NewWrapperRecipient(w)}, recipients...)
+		file, err := age.Encrypt(context.Background(), plaintext, recipients...)
+		if err != nil {
+			return fmt.Errorf("error encrypting: %w", err)
+		}
+		return c.writeOutput(*out, file)
+	}
+
+	blob, err := w.Encrypt(context.Background(), plaintext, aadBytes(*aad))
+	if err != nil {
+		return fmt.Errorf("error encrypting: %w", err)
+	}
+	encoded, err := encodeBlobAs(blob, enc, w.Type())
+	if err != nil {
+		return err
+	}
+
+	return c.writeOutput(*out, encoded)
+}
+
+const decryptUsage = `Usage: kmswrap decrypt [options] [file]
+
+  Decrypts the blob in file, or stdin if no file or "-" is given, and
+  writes the plaintext to stdout.
+
+  The blob's encoding is detected unless -encoding names one of those
+  written by encrypt. age files are decrypted through their "kms-wrap"
+  stanza.`
+
+func (c *cli) decrypt(args []string) error {
+	fs := c.flagSet("decrypt", decryptUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	aad := fs.String("aad", "", "additional authenticated data the blob was bound to")
+	out := fs.String("out", "", "write to this file instead of stdout")
+	encoding := fs.String("encoding", "auto", "blob encoding: auto, base64, raw, json, pem or age")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	enc, err := parseEncoding(*encoding, true)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "invalid -encoding: %v\n", err)
+		return errUsage
+	}
+
+	input, err := c.readInput(fs.Args())
+	if err != nil {
+		return err
+	}
+	if enc == encodingAuto && age.IsAge(input) {
+		enc = encodingAge
+	}
+	var blob *wrapping.EncryptedBlobInfo
+	if enc == encodingAge {
+		if *aad != "" {
+			fmt.Fprintln(c.stderr, "-aad cannot be used with age files")
+			return errUsage
+		}
+	} else if blob, _, err = decodeBlobAs(input, enc); err != nil {
+		return err
+	}
+
+	w, err := c.wrapper(&wf)
+	if err != nil {
+		return err
+	}
+	defer w.Finalize(context.Background())
+
+	if enc == encodingAge {
+		plaintext, err := age.Decrypt(context.Background(), input, age.NewWrapperRecipient(w))
+		if err != nil {
+			return fmt.Errorf("error decrypting: %w", err)
+		}
+		return c.writeOutput(*out, plaintext)
+	}
+	plaintext, err := w.Decrypt(context.Background(), blob, aadBytes(*aad))
+	if err != nil {
+		return fmt.Errorf("error decrypting: %w", err)
+	}
+
+	return c.writeOutput(*out, plaintext)
+}
+
+// wrapper resolves the configuration given by wf and builds the wrapper
+func (c *cli) wrapper(wf *wrapperFlags) (wrapping.Wrapper, error) {
+	seal, err := wf.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return newWrapper(seal)
+}
+
+// aadBytes returns nil for empty AAD so that wrappers see no AAD rather than
+// an empty one
+func aadBytes(aad string) []byte {
+	if aad == "" {
+		return nil
+	}
+	return []byte(aad)
+}
+
+// readInput reads the single file named in args, or stdin if there is none
+// or it is "-"
+func (c *cli) readInput(args []string) ([]byte, error) {
+	switch {
+	case len(args) > 1:
+		return nil, errors.New("at most one input file may be given")
+	case len(args) == 0 || args[0] == "-":
+		return ioutil.ReadAll(c.stdin)
+	default:
+		return ioutil.ReadFile(args[0])
+	}
+}
+
+// writeOutput writes data to the named file, or stdout if path is empty.
+// Files are created readable only by the owner since they may hold
+// plaintext.
+func (c *cli) writeOutput(path string, data []byte) error {
+	if path == "" || path == "-" {
+		_, err := c.stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, os.FileMode(0600))
+}
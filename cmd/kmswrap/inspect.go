@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/armor"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/gcpckms"
+)
+
+const inspectUsage = `Usage: kmswrap inspect [options] [file]
+
+  Decodes the blob in file, or stdin if no file or "-" is given, and
+  describes it without decrypting it: the key that encrypted it, how it was
+  encrypted, and the size of each part. No wrapper configuration is needed.
+
+  Blobs do not record which wrapper produced them, so the wrapper type is
+  inferred from the shape of the blob and its key ID, unless a PEM block
+  names it in its Wrapper header. Use -wrapper when the inference is
+  ambiguous.
+
+  The blob's encoding is detected unless -encoding names one of those
+  written by encrypt.`
+
+func (c *cli) inspect(args []string) error {
+	fs := c.flagSet("inspect", inspectUsage)
+	format := fs.String("format", "text", "output format, text or json")
+	wrapperType := fs.String("wrapper", "", "wrapper type that produced the blob, overriding inference")
+	encoding := fs.String("encoding", "auto", "blob encoding: auto, base64, raw, json or pem")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(c.stderr, "unknown -format %q\n", *format)
+		return errUsage
+	}
+	enc, err := parseEncoding(*encoding, true)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "invalid -encoding: %v\n", err)
+		return errUsage
+	}
+
+	input, err := c.readInput(fs.Args())
+	if err != nil {
+		return err
+	}
+	blob, enc, err := decodeBlobAs(input, enc)
+	if err != nil {
+		return err
+	}
+
+	if enc == encodingPEM && *wrapperType == "" {
+		if block, _, err := armor.Decode(input); err == nil {
+			*wrapperType = block.Headers[armor.HeaderWrapper]
+		}
+	}
+
+	report := inspectBlob(blob, *wrapperType)
+	report.Encoding = string(enc)
+	if *format == "json" {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return report.writeText(c.stdout)
+}
+
+// blobReport describes an EncryptedBlobInfo. Byte values are hex encoded.
+type blobReport struct {
+	WrapperType    string `json:"wrapper_type"`
+	Inferred       bool   `json:"wrapper_type_inferred"`
+	KeyID          string `json:"key_id"`
+	KeyVersion     string `json:"key_version,omitempty"`
+	HMACKeyID      string `json:"hmac_key_id,omitempty"`
+	Mechanism      uint64 `json:"mechanism"`
+	MechanismName  string `json:"mechanism_name,omitempty"`
+	HMACMechanism  uint64 `json:"hmac_mechanism,omitempty"`
+	Cipher         string `json:"cipher"`
+	IV             string `json:"iv,omitempty"`
+	IVSize         int    `json:"iv_size"`
+	CiphertextSize int    `json:"ciphertext_size"`
+	WrappedKeySize int    `json:"wrapped_key_size"`
+	HMACSize       int    `json:"hmac_size"`
+	Wrapped        bool   `json:"wrapped"`
+	ValuePath      string `json:"value_path,omitempty"`
+	Flags          uint64 `json:"flags,omitempty"`
+	HasKeyInfo     bool   `json:"has_key_info"`
+	Encoding       string `json:"encoding,omitempty"`
+}
+
+var (
+	transitCiphertext = regexp.MustCompile(`^vault:(v[0-9]+):`)
+	gcpKeyVersion     = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+/cryptoKeyVersions/([^/]+)$`)
+	azureKeyVersion   = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+// aeadIVSize is the nonce the aead wrapper prepends to its ciphertext
+const aeadIVSize = 12
+
+// inspectBlob describes blob. wrapperType, if set, is trusted instead of
+// inferring the type.
+func inspectBlob(blob *wrapping.EncryptedBlobInfo, wrapperType string) *blobReport {
+	r := &blobReport{
+		IVSize:         len(blob.IV),
+		CiphertextSize: len(blob.Ciphertext),
+		HMACSize:       len(blob.HMAC),
+		Wrapped:        blob.Wrapped,
+		ValuePath:      blob.ValuePath,
+		HasKeyInfo:     blob.KeyInfo != nil,
+	}
+	if len(blob.IV) > 0 {
+		r.IV = hex.EncodeToString(blob.IV)
+	}
+	if ki := blob.KeyInfo; ki != nil {
+		r.KeyID = ki.KeyID
+		r.HMACKeyID = ki.HMACKeyID
+		r.Mechanism = ki.Mechanism
+		r.HMACMechanism = ki.HMACMechanism
+		r.WrappedKeySize = len(ki.WrappedKey)
+		r.Flags = ki.Flags
+	}
+
+	r.WrapperType = wrapperType
+	if r.WrapperType == "" {
+		r.WrapperType = inferWrapperType(blob)
+		r.Inferred = true
+	}
+
+	switch r.WrapperType {
+	case wrapping.AEAD:
+		r.Cipher = "AES-GCM"
+		// The IV is carried at the front of the ciphertext
+		if len(blob.IV) == 0 && len(blob.Ciphertext) >= aeadIVSize {
+			r.IV = hex.EncodeToString(blob.Ciphertext[:aeadIVSize])
+			r.IVSize = aeadIVSize
+			r.CiphertextSize -= aeadIVSize
+		}
+	case wrapping.SealedBox:
+		r.Cipher = "X25519-XSalsa20-Poly1305 sealed box"
+	case wrapping.Transit:
+		r.Cipher = "Vault Transit"
+		if m := transitCiphertext.FindSubmatch(blob.Ciphertext); m != nil {
+			r.KeyVersion = string(m[1])
+		}
+	case wrapping.AWSKMS:
+		switch r.Mechanism {
+		case awskms.AWSKMSEncrypt:
+			r.MechanismName, r.Cipher = "AWSKMSEncrypt", "AWS KMS"
+		case awskms.AWSKMSEnvelopeAESGCMEncrypt:
+			r.MechanismName, r.Cipher = "AWSKMSEnvelopeAESGCMEncrypt", "AES-256-GCM envelope"
+		}
+	case wrapping.GCPCKMS:
+		switch r.Mechanism {
+		case gcpckms.GCPKMSEncrypt:
+			r.MechanismName, r.Cipher = "GCPKMSEncrypt", "GCP Cloud KMS"
+		case gcpckms.GCPKMSEnvelopeAESGCMEncrypt:
+			r.MechanismName, r.Cipher = "GCPKMSEnvelopeAESGCMEncrypt", "AES-256-GCM envelope"
+		}
+		if m := gcpKeyVersion.FindStringSubmatch(r.KeyID); m != nil {
+			r.KeyVersion = m[1]
+		}
+	case wrapping.AzureKeyVault, wrapping.OCIKMS:
+		// These wrappers store the key version as the key ID
+		r.KeyVersion = r.KeyID
+	}
+	if r.Cipher == "" {
+		if r.WrappedKeySize > 0 {
+			r.Cipher = "AES-256-GCM envelope"
+		} else {
+			r.Cipher = "unknown"
+		}
+	}
+
+	return r
+}
+
+// inferWrapperType guesses which wrapper produced blob, returning "" if the
+// blob does not identify one
+func inferWrapperType(blob *wrapping.EncryptedBlobInfo) string {
+	if transitCiphertext.Match(blob.Ciphertext) {
+		return wrapping.Transit
+	}
+	if blob.KeyInfo == nil {
+		return ""
+	}
+
+	keyID := blob.KeyInfo.KeyID
+	switch {
+	case strings.HasPrefix(keyID, "arn:aws:kms:") || strings.HasPrefix(keyID, "arn:aws-"):
+		return wrapping.AWSKMS
+	case gcpKeyVersion.MatchString(keyID):
+		return wrapping.GCPCKMS
+	case strings.HasPrefix(keyID, "ocid1."):
+		return wrapping.OCIKMS
+	}
+
+	envelope := len(blob.KeyInfo.WrappedKey) > 0
+	switch {
+	case envelope && azureKeyVersion.MatchString(keyID):
+		return wrapping.AzureKeyVault
+	case !envelope && len(blob.IV) == 0 && blob.KeyInfo.Mechanism == 0 && len(blob.Ciphertext) > aeadIVSize:
+		// Cloud KMSes other than AWS and GCP always use envelopes, and
+		// their key IDs were ruled out above
+		return wrapping.AEAD
+	}
+	// Alibaba, Huawei and Tencent key IDs are all bare UUIDs
+	return ""
+}
+
+func (r *blobReport) writeText(w io.Writer) error {
+	typ := r.WrapperType
+	switch {
+	case typ == "":
+		typ = "unknown (use -wrapper to specify)"
+	case r.Inferred:
+		typ += " (inferred)"
+	}
+	mechanism := fmt.Sprint(r.Mechanism)
+	if r.MechanismName != "" {
+		mechanism += " (" + r.MechanismName + ")"
+	}
+
+	lines := [][2]string{
+		{"Wrapper type", typ},
+		{"Key ID", r.KeyID},
+		{"Key version", r.KeyVersion},
+		{"HMAC key ID", r.HMACKeyID},
+		{"Mechanism", mechanism},
+		{"Cipher", r.Cipher},
+		{"IV", r.IV},
+		{"IV size", fmt.Sprint(r.IVSize)},
+		{"Ciphertext size", fmt.Sprint(r.CiphertextSize)},
+		{"Wrapped key size", fmt.Sprint(r.WrappedKeySize)},
+		{"Encoding", r.Encoding},
+	}
+	if r.HMACSize > 0 {
+		lines = append(lines, [2]string{"HMAC size", fmt.Sprint(r.HMACSize)})
+	}
+	if r.HMACMechanism != 0 {
+		lines = append(lines, [2]string{"HMAC mechanism", fmt.Sprint(r.HMACMechanism)})
+	}
+	if r.Wrapped {
+		lines = append(lines, [2]string{"Wrapped", "true"})
+	}
+	if r.ValuePath != "" {
+		lines = append(lines, [2]string{"Value path", r.ValuePath})
+	}
+	if r.Flags != 0 {
+		lines = append(lines, [2]string{"Flags", fmt.Sprintf("%#x", r.Flags)})
+	}
+	if !r.HasKeyInfo {
+		lines = append(lines, [2]string{"Key info", "missing"})
+	}
+
+	for _, l := range lines {
+		if l[1] == "" {
+			l[1] = "-"
+		}
+		if _, err := fmt.Fprintf(w, "%-17s %s\n", l[0]+":", l[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
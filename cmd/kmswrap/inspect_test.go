@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/gcpckms"
+)
+
+func TestInspect_AEAD(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	blob := testRun(t, append([]string{"encrypt"}, testAEADFlags(t)...), "0123456789")
+
+	text := testRun(t, []string{"inspect"}, blob)
+	for _, want := range []string{
+		"Wrapper type:     aead (inferred)\n",
+		"Key ID:           test\n",
+		"Cipher:           AES-GCM\n",
+		"IV size:          12\n",
+		// Plaintext plus the GCM tag
+		"Ciphertext size:  26\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in output:\n%s", want, text)
+		}
+	}
+
+	var report blobReport
+	if err := json.Unmarshal([]byte(testRun(t, []string{"inspect", "-format", "json"}, blob)), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.WrapperType != wrapping.AEAD || !report.Inferred || report.KeyID != "test" || len(report.IV) != 24 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestInspect_Infer(t *testing.T) {
+	envelope := func(keyID string, mechanism uint64) *wrapping.EncryptedBlobInfo {
+		return &wrapping.EncryptedBlobInfo{
+			Ciphertext: make([]byte, 40),
+			IV:         make([]byte, 12),
+			KeyInfo: &wrapping.KeyInfo{
+				Mechanism:  mechanism,
+				KeyID:      keyID,
+				WrappedKey: make([]byte, 64),
+			},
+		}
+	}
+
+	testCases := []struct {
+		Title             string
+		Blob              *wrapping.EncryptedBlobInfo
+		Hint              string
+		ExpectedType      string
+		ExpectedVersion   string
+		ExpectedMechanism string
+	}{
+		{
+			Title:             "AWS",
+			Blob:              envelope("arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab", awskms.AWSKMSEnvelopeAESGCMEncrypt),
+			ExpectedType:      wrapping.AWSKMS,
+			ExpectedMechanism: "AWSKMSEnvelopeAESGCMEncrypt",
+		},
+		{
+			Title:             "GCP",
+			Blob:              envelope("projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/7", gcpckms.GCPKMSEnvelopeAESGCMEncrypt),
+			ExpectedType:      wrapping.GCPCKMS,
+			ExpectedVersion:   "7",
+			ExpectedMechanism: "GCPKMSEnvelopeAESGCMEncrypt",
+		},
+		{
+			Title:           "Azure",
+			Blob:            envelope("0123456789abcdef0123456789abcdef", 0),
+			ExpectedType:    wrapping.AzureKeyVault,
+			ExpectedVersion: "0123456789abcdef0123456789abcdef",
+		},
+		{
+			Title:           "OCI",
+			Blob:            envelope("ocid1.keyversion.oc1.iad.abc", 0),
+			ExpectedType:    wrapping.OCIKMS,
+			ExpectedVersion: "ocid1.keyversion.oc1.iad.abc",
+		},
+		{
+			Title: "Transit",
+			Blob: &wrapping.EncryptedBlobInfo{
+				Ciphertext: []byte("vault:v3:c2VjcmV0"),
+				KeyInfo:    &wrapping.KeyInfo{KeyID: "v3"},
+			},
+			ExpectedType:    wrapping.Transit,
+			ExpectedVersion: "v3",
+		},
+		{
+			Title:        "Ambiguous",
+			Blob:         envelope("1234abcd-12ab-34cd-56ef-1234567890ab", 0),
+			ExpectedType: "",
+		},
+		{
+			Title:        "Hint",
+			Blob:         envelope("1234abcd-12ab-34cd-56ef-1234567890ab", 0),
+			Hint:         wrapping.TencentCloudKMS,
+			ExpectedType: wrapping.TencentCloudKMS,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			r := inspectBlob(tc.Blob, tc.Hint)
+			if r.WrapperType != tc.ExpectedType {
+				t.Fatalf("expected type %q, got %q", tc.ExpectedType, r.WrapperType)
+			}
+			if r.Inferred != (tc.Hint == "") {
+				t.Fatalf("expected inferred to be %t", tc.Hint == "")
+			}
+			if r.KeyVersion != tc.ExpectedVersion {
+				t.Fatalf("expected key version %q, got %q", tc.ExpectedVersion, r.KeyVersion)
+			}
+			if r.MechanismName != tc.ExpectedMechanism {
+				t.Fatalf("expected mechanism %q, got %q", tc.ExpectedMechanism, r.MechanismName)
+			}
+		})
+	}
+}
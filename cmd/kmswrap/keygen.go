@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/shamir"
+	uuid "github.com/hashicorp/go-uuid"
+)
+
+const keygenUsage = `Usage: kmswrap keygen [options]
+
+  Generates a random AES key suitable for the "key" value of the aead
+  wrapper and writes it to stdout.
+
+  With -shares and -threshold the key is split with Shamir's secret sharing,
+  in the share format Vault uses, and one share is written per line.
+
+  With -format keyset the key is encrypted by the wrapper configured with
+  -config, -wrapper and -set, and written as a JSON keyset that records the
+  key ID and AEAD type next to the encrypted key.`
+
+func (c *cli) keygen(args []string) error {
+	fs := c.flagSet("keygen", keygenUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	bits := fs.Int("bits", 256, "key size in bits: 128, 192 or 256")
+	format := fs.String("format", "base64", "output format: base64, hex or keyset")
+	shares := fs.Int("shares", 0, "split the key into this many Shamir shares")
+	threshold := fs.Int("threshold", 0, "number of shares required to reconstruct the key")
+	keyID := fs.String("key-id", "", "key ID recorded in a keyset; defaults to a random UUID")
+	out := fs.String("out", "", "write to this file instead of stdout")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(c.stderr, "keygen takes no arguments\n")
+		return errUsage
+	}
+
+	switch *bits {
+	case 128, 192, 256:
+	default:
+		fmt.Fprintf(c.stderr, "-bits must be 128, 192 or 256\n")
+		return errUsage
+	}
+	var encode func([]byte) string
+	switch *format {
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	case "hex":
+		encode = hex.EncodeToString
+	case "keyset":
+	default:
+		fmt.Fprintf(c.stderr, "unknown -format %q\n", *format)
+		return errUsage
+	}
+	if (*shares == 0) != (*threshold == 0) {
+		fmt.Fprintf(c.stderr, "-shares and -threshold must be given together\n")
+		return errUsage
+	}
+	if *shares > 0 && *format == "keyset" {
+		fmt.Fprintf(c.stderr, "Shamir shares cannot be written as a keyset\n")
+		return errUsage
+	}
+
+	key := make([]byte, *bits/8)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("error generating key: %w", err)
+	}
+
+	if *format == "keyset" {
+		ks, err := c.newKeyset(&wf, key, *keyID)
+		if err != nil {
+			return err
+		}
+		raw, err := json.MarshalIndent(ks, "", "  ")
+		if err != nil {
+			return err
+		}
+		return c.writeOutput(*out, append(raw, '\n'))
+	}
+
+	parts := [][]byte{key}
+	if *shares > 0 {
+		var err error
+		if parts, err = shamir.Split(key, *shares, *threshold); err != nil {
+			return fmt.Errorf("error splitting key: %w", err)
+		}
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString(encode(part))
+		b.WriteString("\n")
+	}
+	return c.writeOutput(*out, []byte(b.String()))
+}
+
+// keyset holds aead keys encrypted by another wrapper
+type keyset struct {
+	PrimaryKeyID string         `json:"primary_key_id"`
+	Wrapper      string         `json:"wrapper"`
+	Keys         []*keysetEntry `json:"keys"`
+}
+
+type keysetEntry struct {
+	KeyID    string `json:"key_id"`
+	AEADType string `json:"aead_type"`
+
+	// EncryptedKey is the key's EncryptedBlobInfo, encoded as by encrypt.
+	// The key ID is bound to it as AAD.
+	EncryptedKey string `json:"encrypted_key"`
+}
+
+// newKeyset encrypts key with the wrapper configured by wf
+func (c *cli) newKeyset(wf *wrapperFlags, key []byte, keyID string) (*keyset, error) {
+	if keyID == "" {
+		var err error
+		if keyID, err = uuid.GenerateUUID(); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := c.wrapper(wf)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Finalize(context.Background())
+
+	blob, err := w.Encrypt(context.Background(), key, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting key: %w", err)
+	}
+	encoded, err := encodeBlob(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyset{
+		PrimaryKeyID: keyID,
+		Wrapper:      w.Type(),
+		Keys: []*keysetEntry{{
+			KeyID:        keyID,
+			AEADType:     "aes-gcm",
+			EncryptedKey: strings.TrimSpace(string(encoded)),
+		}},
+	}, nil
+}
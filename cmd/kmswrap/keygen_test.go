@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/shamir"
+)
+
+func TestKeygen(t *testing.T) {
+	defer setTestEnv(t, nil)()
+
+	// The default output is usable as an aead key
+	key := strings.TrimSpace(testRun(t, []string{"keygen"}, ""))
+	flags := []string{"-wrapper", "aead", "-set", "aead_type=aes-gcm", "-set", "key=" + key}
+	blob := testRun(t, append([]string{"encrypt"}, flags...), "secret")
+	if pt := testRun(t, append([]string{"decrypt"}, flags...), blob); pt != "secret" {
+		t.Fatalf("expected secret, got %q", pt)
+	}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(testRun(t, []string{"keygen", "-format", "hex", "-bits", "128"}, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 16 {
+		t.Fatalf("expected a 16 byte key, got %d bytes", len(raw))
+	}
+
+	for _, args := range [][]string{
+		{"keygen", "-bits", "512"},
+		{"keygen", "-format", "pem"},
+		{"keygen", "-shares", "3"},
+		{"keygen", "-format", "keyset", "-shares", "3", "-threshold", "2"},
+	} {
+		if code := run(args, nil, ioutil.Discard, ioutil.Discard); code != 2 {
+			t.Fatalf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
+
+func TestKeygen_Shares(t *testing.T) {
+	out := testRun(t, []string{"keygen", "-shares", "5", "-threshold", "3"}, "")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(lines))
+	}
+
+	var parts [][]byte
+	for _, line := range lines[1:4] {
+		part, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part)
+	}
+	key, err := shamir.Combine(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("expected a 32 byte key, got %d bytes", len(key))
+	}
+}
+
+func TestKeygen_Keyset(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	flags := testAEADFlags(t)
+
+	var ks keyset
+	out := testRun(t, append([]string{"keygen", "-format", "keyset", "-key-id", "data-key"}, flags...), "")
+	if err := json.Unmarshal([]byte(out), &ks); err != nil {
+		t.Fatal(err)
+	}
+	if ks.PrimaryKeyID != "data-key" || ks.Wrapper != "aead" || len(ks.Keys) != 1 || ks.Keys[0].KeyID != "data-key" {
+		t.Fatalf("unexpected keyset %+v", ks)
+	}
+
+	// The key ID is bound as AAD
+	key := testRun(t, append([]string{"decrypt", "-aad", "data-key"}, flags...), ks.Keys[0].EncryptedKey)
+	if len(key) != 32 {
+		t.Fatalf("expected a 32 byte key, got %d bytes", len(key))
+	}
+}
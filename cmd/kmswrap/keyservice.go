@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/go-kms-wrapping/sops"
+	"google.golang.org/grpc"
+)
+
+const keyserviceUsage = `Usage: kmswrap keyservice [options]
+
+  Serves the sops key service protocol for the configured wrapper over a
+  Unix domain socket, so that sops can keep the data keys of its files under
+  any KMS the wrapper supports. It runs until
+  interrupted. Point sops at it with --keyservice, for instance:
+
+    kmswrap keyservice -config seal.hcl -socket /run/kmswrap-sops.sock
+    sops --enable-local-keyservice=false \
+      --keyservice unix:///run/kmswrap-sops.sock --age age1... -e file
+
+  sops tries its built-in local key service before those given with
+  --keyservice, and the local one handles real age recipients, KMS ARNs and
+  the like itself, so this server would never be called.
+  --enable-local-keyservice=false leaves the master keys to it alone.
+
+  Every master key of the file is served by the wrapper, whatever its type,
+  unless -key restricts them. Keys are named as in sops files, prefixed by
+  their type: kms:ARN, pgp:FINGERPRINT, gcp_kms:RESOURCE_ID, azure_kv:URL,
+  hc_vault:URL or age:RECIPIENT. Data keys are bound to their master key, so
+  renaming a master key in a file makes its data key unreadable.
+
+  sops cannot authenticate to a key service, so access is controlled by the
+  socket's file mode alone. There is no TCP listener, since any local user
+  could reach it and have the wrapper decrypt data keys.`
+
+func (c *cli) keyservice(args []string) error {
+	fs := c.flagSet("keyservice", keyserviceUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	socket := fs.String("socket", "", "`path` of the Unix domain socket to listen on")
+	socketMode := fs.String("socket-mode", "0600", "file mode of the socket, in octal")
+	var keys stringsFlag
+	fs.Var(&keys, "key", "master `key` to serve, such as age:age1...; may be repeated")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(c.stderr, "keyservice takes no arguments\n")
+		return errUsage
+	}
+	if *socket == "" {
+		fmt.Fprintf(c.stderr, "-socket is required\n")
+		return errUsage
+	}
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "invalid -socket-mode: %v\n", err)
+		return errUsage
+	}
+	for _, k := range keys {
+		if i := strings.IndexByte(k, ':'); i <= 0 || i == len(k)-1 {
+			fmt.Fprintf(c.stderr, "invalid -key %q: must be type:id\n", k)
+			return errUsage
+		}
+	}
+
+	w, err := c.wrapper(&wf)
+	if err != nil {
+		return err
+	}
+	defer w.Finalize(context.Background())
+
+	l, err := listenUnix(*socket, os.FileMode(mode))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Fprintf(c.stderr, "listening on unix://%s\n", *socket)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return serveKeyService(ctx, sops.NewServer(w, &sops.Options{Keys: keys}), l)
+}
+
+// serveKeyService serves s on l until ctx is done or l fails, then lets
+// in-flight calls finish
+func serveKeyService(ctx context.Context, s *sops.Server, l net.Listener) error {
+	srv := grpc.NewServer()
+	sops.RegisterKeyServiceServer(srv, s)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(l)
+	}()
+
+	select {
+	case <-ctx.Done():
+		srv.GracefulStop()
+		return <-errCh
+	case err := <-errCh:
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+	"github.com/hashicorp/go-kms-wrapping/sops"
+	"google.golang.org/grpc"
+)
+
+func TestServeKeyService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kmswrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ks.sock")
+
+	l, err := listenUnix(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- serveKeyService(ctx, sops.NewServer(testwrapper.AEAD(t), nil), l)
+	}()
+
+	// As sops dials a unix:// key service
+	conn, err := grpc.Dial("unix://"+path, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := sops.NewKeyServiceClient(conn)
+
+	key := &sops.Key{KeyType: &sops.Key_AgeKey{AgeKey: &sops.AgeKey{Recipient: "age1test"}}}
+	enc, err := client.Encrypt(ctx, &sops.EncryptRequest{Key: key, Plaintext: []byte("data key")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dec, err := client.Decrypt(ctx, &sops.DecryptRequest{Key: key, Ciphertext: enc.Ciphertext})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(dec.Plaintext) != "data key" {
+		t.Fatalf("expected data key, got %q", dec.Plaintext)
+	}
+
+	// The ciphertext is a blob in the CLI's default encoding
+	if _, err := decodeBlob(enc.Ciphertext); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestKeyService_Flags(t *testing.T) {
+	for _, args := range [][]string{
+		{"keyservice"},
+		{"keyservice", "-addr", "127.0.0.1:5000"},
+		{"keyservice", "-socket", "x", "-socket-mode", "999"},
+		{"keyservice", "-socket", "x", "-key", "age1test"},
+		{"keyservice", "-socket", "x", "extra"},
+	} {
+		if code := run(args, nil, ioutil.Discard, ioutil.Discard); code != 2 {
+			t.Fatalf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Command kmswrap encrypts and decrypts data with any of the library's
+// wrappers, using the same code path as the services that embed the library.
+//
+// A wrapper is configured with a Vault-style seal stanza in an HCL file, with
+// the KMSWRAP_* environment variables, or with flags; see "kmswrap help".
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// errUsage is returned by commands when they were invoked incorrectly. The
+// flag set has already printed the details.
+var errUsage = errors.New("usage error")
+
+type command struct {
+	synopsis string
+	run      func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"encrypt":    {"Encrypt a file or stdin into a blob", (*cli).encrypt},
+	"decrypt":    {"Decrypt a blob from a file or stdin", (*cli).decrypt},
+	"bench":      {"Measure latency and throughput of a wrapper", (*cli).bench},
+	"daemon":     {"Serve encrypt and decrypt over a local socket", (*cli).daemon},
+	"doctor":     {"Diagnose credential, network and permission problems", (*cli).doctor},
+	"inspect":    {"Describe a blob without decrypting it", (*cli).inspect},
+	"keygen":     {"Generate an aead key, Shamir shares or an encrypted keyset", (*cli).keygen},
+	"keyservice": {"Serve the sops key service protocol", (*cli).keyservice},
+	"rotate":     {"List, rotate and destroy versions of a KMS key", (*cli).rotate},
+	"rewrap":     {"Rewrap blobs in a directory, S3 prefix or stream under a new key", (*cli).rewrap},
+	"validate":   {"Check a wrapper configuration before deploying it", (*cli).validate},
+}
+
+// cli holds the process's standard streams so that commands can be run
+// in-process by tests
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command named by args[0] and returns the exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		c.usage()
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "kmswrap: unknown command %q\n\n", args[0])
+		c.usage()
+		return 2
+	}
+
+	switch err := cmd.run(c, args[1:]); {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "kmswrap %s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+func (c *cli) usage() {
+	fmt.Fprintf(c.stderr, "Usage: kmswrap <command> [options] [args]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  %-10s %s\n", name, commands[name].synopsis)
+	}
+	fmt.Fprintf(c.stderr, "\nRun \"kmswrap <command> -h\" for the options of a command.\n")
+}
+
+// flagSet returns a flag set for the named command that reports errors to
+// stderr and prints usage, followed by the flag defaults, on -h
+func (c *cli) flagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "%s\n\nOptions:\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args into fs, mapping parse failures to errUsage
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun_Usage(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := run(nil, nil, ioutil.Discard, &stderr); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "encrypt") {
+		t.Fatalf("expected usage to list commands, got %q", stderr.String())
+	}
+	if code := run([]string{"help"}, nil, ioutil.Discard, ioutil.Discard); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if code := run([]string{"nope"}, nil, ioutil.Discard, ioutil.Discard); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+	if code := run([]string{"encrypt", "-nope"}, nil, ioutil.Discard, ioutil.Discard); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+	if code := run([]string{"encrypt", "-h"}, nil, ioutil.Discard, ioutil.Discard); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+}
+
+func TestRun_EncryptDecrypt(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	flags := testAEADFlags(t)
+
+	// Through stdin and stdout
+	blob := testRun(t, append([]string{"encrypt", "-aad", "ctx"}, flags...), "secret value")
+	pt := testRun(t, append([]string{"decrypt", "-aad", "ctx"}, flags...), blob)
+	if pt != "secret value" {
+		t.Fatalf("expected secret value, got %q", pt)
+	}
+
+	// Mismatched AAD fails
+	var stderr bytes.Buffer
+	args := append([]string{"decrypt", "-aad", "other"}, flags...)
+	if code := run(args, strings.NewReader(blob), ioutil.Discard, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+
+	// Through files
+	dir, err := ioutil.TempDir("", "kmswrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	enc := filepath.Join(dir, "enc")
+	out := filepath.Join(dir, "out")
+	if err := ioutil.WriteFile(in, []byte("file value"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	testRun(t, append(append([]string{"encrypt", "-out", enc}, flags...), in), "")
+	testRun(t, append(append([]string{"decrypt", "-out", out}, flags...), enc), "")
+	raw, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "file value" {
+		t.Fatalf("expected file value, got %q", raw)
+	}
+}
+
+func TestRun_ConfigFile(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	path := writeTestFile(t, "seal.hcl", `
+seal "aead" {
+  aead_type = "aes-gcm"
+  key_id    = "test"
+  key       = "`+base64.StdEncoding.EncodeToString(key)+`"
+}`)
+	defer os.Remove(path)
+
+	defer setTestEnv(t, map[string]string{EnvConfig: path})()
+
+	blob := testRun(t, []string{"encrypt"}, "from env config")
+	if pt := testRun(t, []string{"decrypt", "-config", path}, blob); pt != "from env config" {
+		t.Fatalf("expected from env config, got %q", pt)
+	}
+}
+
+// testAEADFlags returns flags configuring an aead wrapper with a fresh key
+func testAEADFlags(t *testing.T) []string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return []string{
+		"-wrapper", "aead",
+		"-set", "aead_type=aes-gcm",
+		"-set", "key_id=test",
+		"-set", "key=" + base64.StdEncoding.EncodeToString(key),
+	}
+}
+
+// testRun runs kmswrap with the given stdin, fails the test on a non-zero
+// exit, and returns stdout
+func testRun(t *testing.T, args []string, stdin string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if code := run(args, strings.NewReader(stdin), &stdout, &stderr); code != 0 {
+		t.Fatalf("kmswrap %s exited %d: %s", strings.Join(args, " "), code, stderr.String())
+	}
+	return stdout.String()
+}
+
+func writeTestFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// setTestEnv clears the KMSWRAP_* env vars, sets the given ones, and returns
+// a func that restores the previous environment
+func setTestEnv(t *testing.T, env map[string]string) func() {
+	t.Helper()
+
+	names := []string{EnvConfig, EnvWrapper}
+	old := make(map[string]string, len(names))
+	for _, name := range names {
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = v
+		}
+		os.Unsetenv(name)
+	}
+	for name, v := range env {
+		if err := os.Setenv(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return func() {
+		for _, name := range names {
+			os.Unsetenv(name)
+			if v, ok := old[name]; ok {
+				os.Setenv(name, v)
+			}
+		}
+	}
+}
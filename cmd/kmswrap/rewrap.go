@@ -0,0 +1,681 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+)
+
+const rewrapUsage = `Usage: kmswrap rewrap [options] <dir | s3://bucket/prefix | ->
+
+  Decrypts every blob in the source and encrypts it again, under the current
+  key of the same wrapper or under the destination wrapper given with the
+  -to-* flags, then writes it back in place.
+
+  A directory is walked recursively and each regular file whose name matches
+  -match holds one blob. An s3:// URL covers every object under the prefix.
+  With "-", stdin is read as one blob per line and the rewrapped blobs are
+  written to stdout in the same order; blobs that are skipped or fail are
+  passed through unchanged. Lines have no names to resume by, so -resume
+  cannot be used with "-".
+
+  Each blob is written back in the encoding it was read in, as detected by
+  decrypt. On stdin only the single-line base64 and json encodings can be
+  used.
+
+  A summary is written to stderr. The exit code is 1 if any blob failed.`
+
+// maxLineSize bounds a single blob read from stdin
+const maxLineSize = 64 << 20
+
+// maxRate is the highest -rate accepted; any higher and the interval between
+// blobs would round down to zero
+const maxRate = 1e9
+
+func (c *cli) rewrap(args []string) error {
+	fs := c.flagSet("rewrap", rewrapUsage)
+	var from, to wrapperFlags
+	from.register(fs)
+	to.registerTarget(fs)
+	aad := fs.String("aad", "", "additional authenticated data the blobs are bound to; kept when rewrapping")
+	concurrency := fs.Int("concurrency", 4, "number of blobs rewrapped in parallel")
+	rate := fs.Float64("rate", 0, "maximum number of blobs rewrapped per second; 0 means no limit")
+	dryRun := fs.Bool("dry-run", false, "decrypt and encrypt each blob but write nothing back")
+	resume := fs.String("resume", "", "`file` listing blobs already rewrapped; they are skipped and newly rewrapped blobs are appended")
+	force := fs.Bool("force", false, "also rewrap blobs already encrypted under the destination key ID")
+	match := fs.String("match", "*", "glob a file's base name must match to be rewrapped, for directory sources")
+	s3Region := fs.String("s3-region", "", "region of the S3 bucket, for s3:// sources")
+	s3Endpoint := fs.String("s3-endpoint", "", "S3 endpoint URL, for S3-compatible stores")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(c.stderr, "rewrap takes exactly one source\n")
+		return errUsage
+	}
+	if *concurrency < 1 {
+		fmt.Fprintf(c.stderr, "-concurrency must be at least 1\n")
+		return errUsage
+	}
+	if *rate < 0 || *rate > maxRate {
+		fmt.Fprintf(c.stderr, "-rate must be between 0 and %g\n", maxRate)
+		return errUsage
+	}
+	if _, err := filepath.Match(*match, ""); err != nil {
+		fmt.Fprintf(c.stderr, "invalid -match pattern: %v\n", err)
+		return errUsage
+	}
+	if *resume != "" && fs.Arg(0) == "-" {
+		fmt.Fprintf(c.stderr, "-resume cannot be used with a stdin source\n")
+		return errUsage
+	}
+
+	store, err := c.openStore(fs.Arg(0), &storeOptions{
+		match:      *match,
+		exclude:    *resume,
+		dryRun:     *dryRun,
+		s3Region:   *s3Region,
+		s3Endpoint: *s3Endpoint,
+	})
+	if err != nil {
+		return err
+	}
+
+	src, err := c.wrapper(&from)
+	if err != nil {
+		return err
+	}
+	defer src.Finalize(context.Background())
+	dst := src
+	if to.isSet() {
+		if dst, err = c.wrapper(&to); err != nil {
+			return err
+		}
+		defer dst.Finalize(context.Background())
+	}
+
+	var done *resumeLog
+	if *resume != "" {
+		if done, err = openResumeLog(*resume); err != nil {
+			return err
+		}
+		defer done.close()
+	}
+
+	r := &rewrapper{
+		store:       store,
+		from:        src,
+		to:          dst,
+		aad:         aadBytes(*aad),
+		concurrency: *concurrency,
+		dryRun:      *dryRun,
+		force:       *force,
+		done:        done,
+		log:         c.stderr,
+	}
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer ticker.Stop()
+		r.limit = ticker.C
+	}
+
+	stats, err := r.run(context.Background())
+	verb := "rewrapped"
+	if *dryRun {
+		verb = "would rewrap"
+	}
+	fmt.Fprintf(c.stderr, "%s %d, already current %d, previously done %d, failed %d\n",
+		verb, stats.rewrapped, stats.current, stats.resumed, stats.failed)
+	if err != nil {
+		return err
+	}
+	if stats.failed > 0 {
+		return fmt.Errorf("%d blobs failed to rewrap", stats.failed)
+	}
+	return nil
+}
+
+// rewrapStats counts the outcome for each blob
+type rewrapStats struct {
+	rewrapped int
+	current   int
+	resumed   int
+	failed    int
+}
+
+// rewrapper moves every blob in a store from one wrapper to another
+type rewrapper struct {
+	store       blobStore
+	from, to    wrapping.Wrapper
+	aad         []byte
+	concurrency int
+	dryRun      bool
+	force       bool
+	done        *resumeLog
+
+	// limit, if set, is received from before each blob is processed
+	limit <-chan time.Time
+
+	l     sync.Mutex
+	log   io.Writer
+	stats rewrapStats
+}
+
+// run rewraps every blob the store yields. Failures of individual blobs are
+// logged and counted; the returned error reports a failure of the store
+// itself.
+func (r *rewrapper) run(ctx context.Context) (rewrapStats, error) {
+	names := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				r.rewrapOne(ctx, name)
+			}
+		}()
+	}
+
+	err := r.store.walk(func(name string) error {
+		names <- name
+		return nil
+	})
+	close(names)
+	wg.Wait()
+
+	if !r.dryRun {
+		if cerr := r.store.close(); err == nil {
+			err = cerr
+		}
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.stats, err
+}
+
+func (r *rewrapper) rewrapOne(ctx context.Context, name string) {
+	if r.done.contains(name) {
+		r.finish(name, &r.stats.resumed)
+		return
+	}
+	if r.limit != nil {
+		<-r.limit
+	}
+
+	data, err := r.store.read(name)
+	if err != nil {
+		r.abandon(name, err)
+		return
+	}
+	blob, enc, err := decodeBlobAs(data, encodingAuto)
+	if err != nil {
+		r.abandon(name, err)
+		return
+	}
+	// The other encodings could not be written back on a single line
+	if _, ok := r.store.(*streamStore); ok && enc != encodingBase64 && enc != encodingJSON {
+		r.abandon(name, fmt.Errorf("%s encoding cannot be used on stdin", enc))
+		return
+	}
+
+	if !r.force && blob.KeyInfo != nil && r.to.KeyID() != "" &&
+		r.from.Type() == r.to.Type() && blob.KeyInfo.KeyID == r.to.KeyID() {
+		r.finish(name, &r.stats.current)
+		return
+	}
+
+	pt, err := r.from.Decrypt(ctx, blob, r.aad)
+	if err != nil {
+		r.abandon(name, fmt.Errorf("error decrypting: %w", err))
+		return
+	}
+	newBlob, err := r.to.Encrypt(ctx, pt, r.aad)
+	if err != nil {
+		r.abandon(name, fmt.Errorf("error encrypting: %w", err))
+		return
+	}
+	encoded, err := encodeBlobAs(newBlob, enc, r.to.Type())
+	if err != nil {
+		r.abandon(name, err)
+		return
+	}
+
+	if r.dryRun {
+		r.finish(name, &r.stats.rewrapped)
+		return
+	}
+	if err := r.store.put(name, encoded); err != nil {
+		r.fail(name, fmt.Errorf("error writing: %w", err))
+		return
+	}
+	if err := r.done.add(name); err != nil {
+		r.fail(name, fmt.Errorf("rewrapped but could not record progress: %w", err))
+		return
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.stats.rewrapped++
+}
+
+// finish counts a blob that is left as it is, letting the store pass it
+// through
+func (r *rewrapper) finish(name string, counter *int) {
+	if err := r.store.put(name, nil); err != nil {
+		r.fail(name, fmt.Errorf("error writing: %w", err))
+		return
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	*counter++
+}
+
+// abandon leaves a blob that could not be rewrapped as it is and reports err
+func (r *rewrapper) abandon(name string, err error) {
+	// The store must still be told so that stdin order is kept; the
+	// original error is the one worth reporting
+	r.store.put(name, nil)
+	r.fail(name, err)
+}
+
+func (r *rewrapper) fail(name string, err error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.stats.failed++
+	fmt.Fprintf(r.log, "%s: %v\n", name, err)
+}
+
+// blobStore is a source of blobs that rewrapped blobs are written back to.
+// walk is called once; read and put may be called concurrently for the names
+// it yields.
+type blobStore interface {
+	// walk calls fn with the name of each blob in turn
+	walk(fn func(name string) error) error
+
+	// read returns the encoded blob
+	read(name string) ([]byte, error)
+
+	// put replaces the blob with data, or leaves it unchanged if data is
+	// nil. It is called exactly once for every name walk yields, always with
+	// nil data in a dry run.
+	put(name string, data []byte) error
+
+	// close flushes any pending output
+	close() error
+}
+
+type storeOptions struct {
+	match      string
+	exclude    string // a file never treated as a blob, such as the resume log
+	dryRun     bool
+	s3Region   string
+	s3Endpoint string
+}
+
+// openStore returns the store for a source argument
+func (c *cli) openStore(source string, opts *storeOptions) (blobStore, error) {
+	switch {
+	case source == "-":
+		out := c.stdout
+		if opts.dryRun {
+			out = ioutil.Discard
+		}
+		return newStreamStore(c.stdin, out), nil
+	case strings.HasPrefix(source, "s3://"):
+		bucket := strings.TrimPrefix(source, "s3://")
+		var prefix string
+		if i := strings.Index(bucket, "/"); i >= 0 {
+			bucket, prefix = bucket[:i], bucket[i+1:]
+		}
+		if bucket == "" {
+			return nil, fmt.Errorf("no bucket in %q", source)
+		}
+		client, err := newS3Client(opts.s3Region, opts.s3Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return &s3Store{client: client, bucket: bucket, prefix: prefix}, nil
+	default:
+		info, err := os.Stat(source)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", source)
+		}
+		d := &dirStore{root: source, match: opts.match}
+		if opts.exclude != "" {
+			if d.exclude, err = filepath.Abs(opts.exclude); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	}
+}
+
+// dirStore holds one blob per file under root. Files are replaced
+// atomically.
+type dirStore struct {
+	root    string
+	match   string
+	exclude string
+}
+
+func (d *dirStore) walk(fn func(string) error) error {
+	return filepath.Walk(d.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		// The pattern was validated before the store was opened
+		if ok, _ := filepath.Match(d.match, info.Name()); !ok {
+			return nil
+		}
+		if d.exclude != "" {
+			if abs, err := filepath.Abs(path); err == nil && abs == d.exclude {
+				return nil
+			}
+		}
+		return fn(path)
+	})
+}
+
+func (d *dirStore) read(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (d *dirStore) put(name string, data []byte) error {
+	if data == nil {
+		return nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".rewrap")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (d *dirStore) close() error {
+	return nil
+}
+
+// s3Store holds one blob per object under a prefix. Objects are replaced
+// with a single PutObject each.
+type s3Store struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// newS3Client is a variable so that tests can substitute a fake
+var newS3Client = func(region, endpoint string) (s3iface.S3API, error) {
+	config := aws.NewConfig()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating S3 session: %w", err)
+	}
+	return s3.New(sess), nil
+}
+
+func (s *s3Store) walk(fn func(string) error) error {
+	var fnErr error
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			if fnErr = fn(key); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("error listing s3://%s/%s: %w", s.bucket, s.prefix, err)
+	}
+	return fnErr
+}
+
+func (s *s3Store) read(name string) ([]byte, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (s *s3Store) put(name string, data []byte) error {
+	if data == nil {
+		return nil
+	}
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(name),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (s *s3Store) close() error {
+	return nil
+}
+
+// streamStore reads one blob per line and writes the results in input
+// order, buffering those that finish early. Blank lines are copied through.
+type streamStore struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	l       sync.Mutex
+	lines   map[int][]byte
+	results map[int][]byte
+	next    int
+	err     error
+}
+
+func newStreamStore(in io.Reader, out io.Writer) *streamStore {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, maxLineSize)
+	return &streamStore{
+		in:      scanner,
+		out:     out,
+		lines:   make(map[int][]byte),
+		results: make(map[int][]byte),
+		next:    1,
+	}
+}
+
+func (s *streamStore) walk(fn func(string) error) error {
+	for n := 1; s.in.Scan(); n++ {
+		line := append([]byte(nil), s.in.Bytes()...)
+		s.l.Lock()
+		s.lines[n] = line
+		s.l.Unlock()
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err := s.put(streamName(n), nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(streamName(n)); err != nil {
+			return err
+		}
+	}
+	if err := s.in.Err(); err != nil {
+		return fmt.Errorf("error reading stdin: %w", err)
+	}
+	return nil
+}
+
+func streamName(n int) string {
+	return "stdin:" + strconv.Itoa(n)
+}
+
+func (s *streamStore) line(name string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "stdin:"))
+	if err != nil {
+		return 0, fmt.Errorf("unknown blob %q", name)
+	}
+	return n, nil
+}
+
+func (s *streamStore) read(name string) ([]byte, error) {
+	n, err := s.line(name)
+	if err != nil {
+		return nil, err
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	line, ok := s.lines[n]
+	if !ok {
+		return nil, fmt.Errorf("unknown blob %q", name)
+	}
+	return line, nil
+}
+
+func (s *streamStore) put(name string, data []byte) error {
+	n, err := s.line(name)
+	if err != nil {
+		return err
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	if data == nil {
+		data = append(s.lines[n], '\n')
+	}
+	s.results[n] = data
+	delete(s.lines, n)
+
+	for s.err == nil {
+		out, ok := s.results[s.next]
+		if !ok {
+			break
+		}
+		delete(s.results, s.next)
+		s.next++
+		_, s.err = s.out.Write(out)
+	}
+	return s.err
+}
+
+func (s *streamStore) close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.err == nil && len(s.results) > 0 {
+		return errors.New("output for some input lines is missing")
+	}
+	return s.err
+}
+
+// resumeLog records the names of blobs that have been rewrapped, one per
+// line, so that an interrupted run can be restarted. A nil *resumeLog records
+// nothing.
+type resumeLog struct {
+	l    sync.Mutex
+	f    *os.File
+	done map[string]bool
+}
+
+func openResumeLog(path string) (*resumeLog, error) {
+	done := make(map[string]bool)
+	existing, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		for _, name := range strings.Split(string(existing), "\n") {
+			if name != "" {
+				done[name] = true
+			}
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("error reading resume file: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening resume file: %w", err)
+	}
+	return &resumeLog{f: f, done: done}, nil
+}
+
+func (r *resumeLog) contains(name string) bool {
+	if r == nil {
+		return false
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.done[name]
+}
+
+func (r *resumeLog) add(name string) error {
+	if r == nil {
+		return nil
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	if _, err := fmt.Fprintln(r.f, name); err != nil {
+		return err
+	}
+	r.done[name] = true
+	return nil
+}
+
+func (r *resumeLog) close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+)
+
+func TestRewrap_Directory(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	oldFlags := testAEADFlags(t)
+	newFlags := withKeyID(testAEADFlags(t), "new")
+
+	dir, err := ioutil.TempDir("", "kmswrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a.blob":     "value a",
+		"sub/b.blob": "value b",
+		"c.blob":     "value c",
+		"d.blob":     "value d",
+	}
+	for name, value := range files {
+		args := append([]string{"encrypt"}, oldFlags...)
+		if name == "d.blob" {
+			args = append(args, "-encoding", "pem")
+		}
+		blob := testRun(t, args, value)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(blob), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a blob"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	toFlags := targetFlags(newFlags)
+	base := append(append([]string{"rewrap", "-match", "*.blob"}, oldFlags...), toFlags...)
+
+	// A dry run changes nothing
+	before, err := ioutil.ReadFile(filepath.Join(dir, "a.blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRun(t, append(append([]string{}, base...), "-dry-run", dir), "")
+	after, err := ioutil.ReadFile(filepath.Join(dir, "a.blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("dry run modified a blob")
+	}
+
+	// Rewrite one blob under the new key first, so the run must skip it
+	testRun(t, append(append([]string{}, base...), "-match", "c.blob", dir), "")
+
+	var stderr bytes.Buffer
+	args := append(append([]string{}, base...), "-concurrency", "2", "-rate", "1000", dir)
+	if code := run(args, nil, ioutil.Discard, &stderr); code != 0 {
+		t.Fatalf("rewrap exited %d: %s", code, stderr.String())
+	}
+	if want := "rewrapped 3, already current 1, previously done 0, failed 0"; !strings.Contains(stderr.String(), want) {
+		t.Fatalf("expected summary %q, got %q", want, stderr.String())
+	}
+
+	// Blobs keep their encoding
+	if pem, err := ioutil.ReadFile(filepath.Join(dir, "d.blob")); err != nil || !bytes.HasPrefix(pem, []byte("-----BEGIN KMS WRAPPED DATA-----")) {
+		t.Fatalf("expected d.blob to still be PEM, got %q: %v", pem, err)
+	}
+
+	for name, value := range files {
+		path := filepath.Join(dir, name)
+		if pt := testRun(t, append(append([]string{"decrypt"}, newFlags...), path), ""); pt != value {
+			t.Fatalf("%s: expected %q, got %q", name, value, pt)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Fatalf("%s: expected mode 0640, got %v", name, info.Mode().Perm())
+		}
+	}
+
+	// Leftover temporary files would be picked up by the next run
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(entries))
+	}
+}
+
+func TestRewrap_Resume(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	oldFlags := testAEADFlags(t)
+	newFlags := withKeyID(testAEADFlags(t), "new")
+
+	dir, err := ioutil.TempDir("", "kmswrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	good := filepath.Join(dir, "good")
+	bad := filepath.Join(dir, "bad")
+	if err := ioutil.WriteFile(good, []byte(testRun(t, append([]string{"encrypt"}, oldFlags...), "good")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Encrypted under a key the run doesn't have
+	otherFlags := withKeyID(testAEADFlags(t), "other")
+	if err := ioutil.WriteFile(bad, []byte(testRun(t, append([]string{"encrypt"}, otherFlags...), "bad")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	state := filepath.Join(dir, "state")
+	args := append(append(append([]string{"rewrap", "-resume", state}, oldFlags...), targetFlags(newFlags)...), "-match", "[bg]*", dir)
+	var stderr bytes.Buffer
+	if code := run(args, nil, ioutil.Discard, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), bad+": error decrypting") {
+		t.Fatalf("expected failure of %s to be reported, got %q", bad, stderr.String())
+	}
+	raw, err := ioutil.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != good+"\n" {
+		t.Fatalf("expected resume file to list %s, got %q", good, raw)
+	}
+
+	// The second run must not try the finished blob again: it is under the
+	// new key now and the source wrapper could not decrypt it
+	stderr.Reset()
+	if code := run(args, nil, ioutil.Discard, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stderr.String())
+	}
+	if want := "rewrapped 0, already current 0, previously done 1, failed 1"; !strings.Contains(stderr.String(), want) {
+		t.Fatalf("expected summary %q, got %q", want, stderr.String())
+	}
+
+	// The resume file is not a blob, even when it matches
+	args = append(append(append([]string{"rewrap", "-resume", state}, oldFlags...), targetFlags(newFlags)...), dir)
+	stderr.Reset()
+	if code := run(args, nil, ioutil.Discard, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stderr.String())
+	}
+	if want := "rewrapped 0, already current 0, previously done 1, failed 1"; !strings.Contains(stderr.String(), want) || strings.Contains(stderr.String(), state) {
+		t.Fatalf("expected summary %q without %s, got %q", want, state, stderr.String())
+	}
+}
+
+func TestRewrap_Stream(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	flags := testAEADFlags(t)
+
+	values := []string{"one", "two", "three", "four", "five", "six"}
+	var input strings.Builder
+	for i, v := range values {
+		input.WriteString(testRun(t, append([]string{"encrypt"}, flags...), v))
+		if i == 2 {
+			input.WriteString("\n")
+		}
+	}
+	input.WriteString("garbage\n")
+
+	// Rewrapping under the same wrapper checks nothing but the key ID
+	var stdout, stderr bytes.Buffer
+	args := append(append([]string{"rewrap", "-force", "-concurrency", "3"}, flags...), "-")
+	if code := run(args, strings.NewReader(input.String()), &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "stdin:8: error decoding blob") {
+		t.Fatalf("expected the garbage line to be reported, got %q", stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != len(values)+2 {
+		t.Fatalf("expected %d lines, got %d: %q", len(values)+2, len(lines), stdout.String())
+	}
+	if lines[3] != "" || lines[7] != "garbage" {
+		t.Fatalf("expected blank and invalid lines to be passed through, got %q", stdout.String())
+	}
+	inputLines := strings.Split(input.String(), "\n")
+	var got []string
+	for i, line := range lines {
+		if i == 3 || i == 7 {
+			continue
+		}
+		if line == inputLines[i] {
+			t.Fatalf("line %d was not rewrapped", i+1)
+		}
+		got = append(got, testRun(t, append([]string{"decrypt"}, flags...), line))
+	}
+	if strings.Join(got, ",") != strings.Join(values, ",") {
+		t.Fatalf("expected %v in order, got %v", values, got)
+	}
+
+	// Lines have no names to resume by
+	dir, err := ioutil.TempDir("", "kmswrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	args = append(append([]string{"rewrap", "-resume", filepath.Join(dir, "state")}, flags...), "-")
+	if code := run(args, strings.NewReader(input.String()), ioutil.Discard, ioutil.Discard); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+
+	// A rate too high to turn into an interval is rejected
+	args = append(append([]string{"rewrap", "-rate", "1e10"}, flags...), "-")
+	if code := run(args, strings.NewReader(input.String()), ioutil.Discard, ioutil.Discard); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+
+	// A dry run writes nothing and lets go of every line it read
+	store := newStreamStore(strings.NewReader(input.String()), ioutil.Discard)
+	src := aead.NewWrapper(nil)
+	for _, f := range flags {
+		if strings.HasPrefix(f, "key=") {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(f, "key="))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := src.SetAESGCMKeyBytes(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	r := &rewrapper{store: store, from: src, to: src, concurrency: 2, dryRun: true, force: true, log: ioutil.Discard}
+	stats, err := r.run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.rewrapped != len(values) || stats.failed != 1 {
+		t.Fatalf("unexpected dry run stats %+v", stats)
+	}
+	if len(store.lines) != 0 || len(store.results) != 0 {
+		t.Fatalf("dry run kept %d lines and %d results", len(store.lines), len(store.results))
+	}
+
+	// A raw blob that fits on a line is passed through rather than written
+	// back in an encoding that may span several
+	raw, err := encodeBlobAs(&wrapping.EncryptedBlobInfo{KeyInfo: &wrapping.KeyInfo{KeyID: "raw"}}, encodingRaw, src.Type())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.ContainsAny(raw, "\r\n") {
+		t.Fatalf("raw blob %q spans several lines", raw)
+	}
+	stdout.Reset()
+	stderr.Reset()
+	r = &rewrapper{store: newStreamStore(bytes.NewReader(append(raw, '\n')), &stdout), from: src, to: src, concurrency: 1, force: true, log: &stderr}
+	if stats, err = r.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats.failed != 1 || !strings.Contains(stderr.String(), "raw encoding cannot be used on stdin") {
+		t.Fatalf("expected the raw blob to be rejected, got %+v and %q", stats, stderr.String())
+	}
+	if stdout.String() != string(raw)+"\n" {
+		t.Fatalf("expected the raw blob to be passed through, got %q", stdout.String())
+	}
+}
+
+func TestRewrap_S3(t *testing.T) {
+	defer setTestEnv(t, nil)()
+	oldFlags := testAEADFlags(t)
+	newFlags := withKeyID(testAEADFlags(t), "new")
+
+	client := &fakeS3{objects: map[string][]byte{}}
+	for _, key := range []string{"blobs/x", "blobs/y/z", "other/w"} {
+		client.objects[key] = []byte(testRun(t, append([]string{"encrypt"}, oldFlags...), key))
+	}
+	client.objects["blobs/dir/"] = nil
+
+	oldNewS3Client := newS3Client
+	defer func() { newS3Client = oldNewS3Client }()
+	newS3Client = func(region, endpoint string) (s3iface.S3API, error) {
+		if region != "test-region" {
+			t.Fatalf("expected region test-region, got %q", region)
+		}
+		return client, nil
+	}
+
+	testRun(t, append(append(append([]string{"rewrap", "-s3-region", "test-region"}, oldFlags...), targetFlags(newFlags)...), "s3://bucket/blobs/"), "")
+
+	for _, key := range []string{"blobs/x", "blobs/y/z"} {
+		if pt := testRun(t, append([]string{"decrypt"}, newFlags...), string(client.objects[key])); pt != key {
+			t.Fatalf("%s: expected %q, got %q", key, key, pt)
+		}
+	}
+	if pt := testRun(t, append([]string{"decrypt"}, oldFlags...), string(client.objects["other/w"])); pt != "other/w" {
+		t.Fatalf("object outside the prefix was modified")
+	}
+	if client.puts != 2 {
+		t.Fatalf("expected 2 puts, got %d", client.puts)
+	}
+}
+
+// withKeyID replaces the key ID in flags returned by testAEADFlags
+func withKeyID(flags []string, keyID string) []string {
+	for i, f := range flags {
+		if strings.HasPrefix(f, "key_id=") {
+			flags[i] = "key_id=" + keyID
+		}
+	}
+	return flags
+}
+
+// targetFlags turns wrapper flags into the equivalent destination flags
+func targetFlags(flags []string) []string {
+	out := make([]string, len(flags))
+	for i, f := range flags {
+		if strings.HasPrefix(f, "-") {
+			f = "-to-" + strings.TrimPrefix(f, "-")
+		}
+		out[i] = f
+	}
+	return out
+}
+
+// fakeS3 is an in-memory bucket implementing the calls s3Store makes
+type fakeS3 struct {
+	s3iface.S3API
+
+	l       sync.Mutex
+	objects map[string][]byte
+	puts    int
+}
+
+func (f *fakeS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	f.l.Lock()
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.StringValue(in.Prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	f.l.Unlock()
+	sort.Strings(keys)
+
+	// One object per page to exercise paging
+	for i, key := range keys {
+		page := &s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(key)}}}
+		if !fn(page, i == len(keys)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(f.objects[aws.StringValue(in.Key)])),
+	}, nil
+}
+
+func (f *fakeS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	raw, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.objects[aws.StringValue(in.Key)] = raw
+	f.puts++
+	return &s3.PutObjectOutput{}, nil
+}
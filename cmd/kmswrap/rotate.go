@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cloudkms "cloud.google.com/go/kms/apiv1"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/golang/protobuf/ptypes"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/gcpckms"
+	"google.golang.org/api/iterator"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+const rotateUsage = `Usage: kmswrap rotate [options] list
+       kmswrap rotate [options] now
+       kmswrap rotate [options] set-primary VERSION
+       kmswrap rotate [options] destroy VERSION
+
+  Manages the versions of the configured wrapper's key, so that rotation
+  runbooks can use the same configuration as the services. Supported for
+  the awskms and gcpckms wrappers; there is no Yandex Cloud KMS wrapper in
+  this tree to manage keys of.
+
+    list         Shows the key's versions and which one is primary
+    now          Creates a new version and makes it primary
+    set-primary  Makes VERSION primary, for example to roll back
+    destroy      Schedules VERSION for destruction
+
+  For gcpckms, versions are the crypto key's versions and VERSION is the
+  version number or its full resource name, which must be a version of the
+  configured crypto key. Destruction happens after the delay configured on
+  the key.
+
+  For awskms, kms_key_id must be an alias, and versions are the keys it
+  points to: now creates a key with the current key's description, usage and
+  policy and points the alias at it, and set-primary points the alias at the
+  key ID given as VERSION. AWS does not record an alias's past targets, so
+  now tags both the old and the new key with kmswrap:alias, and list shows
+  the current key and those tagged for the alias, looking through every key
+  of the account. destroy only accepts the tagged keys, so that a mistyped
+  or pasted key ID cannot delete an unrelated key; -force skips this check.
+  Destruction happens after -pending-days.
+
+  Blobs encrypted under a version remain decryptable until it is destroyed,
+  and the primary version cannot be destroyed; use "kmswrap rewrap" first.`
+
+// keyVersion describes one version of a managed key
+type keyVersion struct {
+	ID        string     `json:"id"`
+	State     string     `json:"state"`
+	Primary   bool       `json:"primary"`
+	Created   *time.Time `json:"created,omitempty"`
+	DestroyAt *time.Time `json:"destroy_at,omitempty"`
+}
+
+// keyManager performs key administration for a backend. Version IDs are
+// backend-specific.
+type keyManager interface {
+	versions(ctx context.Context) ([]*keyVersion, error)
+	rotate(ctx context.Context) (*keyVersion, error)
+	setPrimary(ctx context.Context, id string) error
+	destroy(ctx context.Context, id string, pendingDays int, force bool) (*keyVersion, error)
+	close() error
+}
+
+// newKeyManager builds the key manager for seal. It is a variable so that
+// tests can substitute a fake backend.
+var newKeyManager = func(seal *sealConfig) (keyManager, error) {
+	switch seal.Type {
+	case wrapping.AWSKMS:
+		w := awskms.NewWrapper(nil)
+		info, err := w.SetConfig(seal.Config)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring %s wrapper: %w", seal.Type, err)
+		}
+		client, err := w.GetAWSKMSClient()
+		if err != nil {
+			return nil, fmt.Errorf("error initializing AWS KMS client: %w", err)
+		}
+		return newAWSKeyManager(client, info["kms_key_id"])
+
+	case wrapping.GCPCKMS:
+		// The key manager only administers the key, so the wrapper's check
+		// that it can encrypt is skipped, and its client is the one used
+		w := gcpckms.NewWrapper(nil)
+		if _, err := w.SetConfigForKeyAdmin(seal.Config); err != nil {
+			return nil, fmt.Errorf("error configuring %s wrapper: %w", seal.Type, err)
+		}
+		client, err := w.KeyManagementClient()
+		if err != nil {
+			w.Finalize(context.Background())
+			return nil, fmt.Errorf("error initializing GCP CKMS client: %w", err)
+		}
+		return &gcpKeyManager{client: &gcpIteratingClient{client}, name: w.CryptoKeyName()}, nil
+
+	default:
+		return nil, fmt.Errorf("key management is not supported for %s wrappers; supported types are %s and %s",
+			seal.Type, wrapping.AWSKMS, wrapping.GCPCKMS)
+	}
+}
+
+func (c *cli) rotate(args []string) error {
+	fs := c.flagSet("rotate", rotateUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	format := fs.String("format", "text", "output format of list, text or json")
+	pendingDays := fs.Int("pending-days", 0, "days before a destroyed awskms key is deleted, 7 to 30; defaults to 30")
+	force := fs.Bool("force", false, "destroy an awskms key that is not tagged for the alias")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(c.stderr, "unknown -format %q\n", *format)
+		return errUsage
+	}
+
+	var action string
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+	wantArgs := 1
+	switch action {
+	case "list", "now":
+	case "set-primary", "destroy":
+		wantArgs = 2
+	case "":
+		fmt.Fprintf(c.stderr, "rotate requires an action: list, now, set-primary or destroy\n")
+		return errUsage
+	default:
+		fmt.Fprintf(c.stderr, "unknown rotate action %q\n", action)
+		return errUsage
+	}
+	if fs.NArg() != wantArgs {
+		if wantArgs == 2 {
+			fmt.Fprintf(c.stderr, "rotate %s takes exactly one VERSION\n", action)
+		} else {
+			fmt.Fprintf(c.stderr, "rotate %s takes no arguments\n", action)
+		}
+		return errUsage
+	}
+	if *pendingDays != 0 && action != "destroy" {
+		fmt.Fprintf(c.stderr, "-pending-days only applies to destroy\n")
+		return errUsage
+	}
+	if *force && action != "destroy" {
+		fmt.Fprintf(c.stderr, "-force only applies to destroy\n")
+		return errUsage
+	}
+
+	seal, err := wf.resolve()
+	if err != nil {
+		return err
+	}
+	m, err := newKeyManager(seal)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+
+	ctx := context.Background()
+	switch action {
+	case "list":
+		versions, err := m.versions(ctx)
+		if err != nil {
+			return err
+		}
+		if *format == "json" {
+			enc := json.NewEncoder(c.stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(versions)
+		}
+		return writeVersionTable(c.stdout, versions)
+
+	case "now":
+		v, err := m.rotate(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "version %s is now primary\n", v.ID)
+
+	case "set-primary":
+		if err := m.setPrimary(ctx, fs.Arg(1)); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "version %s is now primary\n", fs.Arg(1))
+
+	case "destroy":
+		v, err := m.destroy(ctx, fs.Arg(1), *pendingDays, *force)
+		if err != nil {
+			return err
+		}
+		if v.DestroyAt != nil {
+			fmt.Fprintf(c.stdout, "version %s will be destroyed at %s\n", v.ID, v.DestroyAt.UTC().Format(time.RFC3339))
+		} else {
+			fmt.Fprintf(c.stdout, "version %s is scheduled for destruction\n", v.ID)
+		}
+	}
+	return nil
+}
+
+func writeVersionTable(w io.Writer, versions []*keyVersion) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "version\tstate\tprimary\tcreated\tdestroy at\n")
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	for _, v := range versions {
+		primary := ""
+		if v.Primary {
+			primary = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.ID, v.State, primary, formatTime(v.Created), formatTime(v.DestroyAt))
+	}
+	return tw.Flush()
+}
+
+// awsAliasTag is the tag marking the keys that rotate creates or replaces as
+// versions of an alias, whose value is the alias name
+const awsAliasTag = "kmswrap:alias"
+
+// awsKeyManager manages the keys behind an AWS KMS alias
+type awsKeyManager struct {
+	client kmsiface.KMSAPI
+	alias  string
+}
+
+func newAWSKeyManager(client kmsiface.KMSAPI, keyID string) (*awsKeyManager, error) {
+	if !strings.HasPrefix(keyID, "alias/") {
+		return nil, fmt.Errorf("kms_key_id %q is not an alias; AWS KMS keys are rotated by pointing an alias at a new key", keyID)
+	}
+	return &awsKeyManager{client: client, alias: keyID}, nil
+}
+
+func (m *awsKeyManager) current() (*kms.KeyMetadata, error) {
+	out, err := m.client.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(m.alias)})
+	if err != nil {
+		return nil, fmt.Errorf("error describing %s: %w", m.alias, err)
+	}
+	if out.KeyMetadata == nil || out.KeyMetadata.KeyId == nil {
+		return nil, errors.New("no key information returned")
+	}
+	return out.KeyMetadata, nil
+}
+
+func awsKeyVersion(md *kms.KeyMetadata, primary bool) *keyVersion {
+	return &keyVersion{
+		ID:        aws.StringValue(md.KeyId),
+		State:     aws.StringValue(md.KeyState),
+		Primary:   primary,
+		Created:   md.CreationDate,
+		DestroyAt: md.DeletionDate,
+	}
+}
+
+func (m *awsKeyManager) versions(_ context.Context) ([]*keyVersion, error) {
+	md, err := m.current()
+	if err != nil {
+		return nil, err
+	}
+	versions := []*keyVersion{awsKeyVersion(md, true)}
+
+	var ids []*string
+	err = m.client.ListKeysPages(&kms.ListKeysInput{}, func(out *kms.ListKeysOutput, _ bool) bool {
+		for _, k := range out.Keys {
+			ids = append(ids, k.KeyId)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys: %w", err)
+	}
+	for _, id := range ids {
+		if aws.StringValue(id) == aws.StringValue(md.KeyId) {
+			continue
+		}
+		tagged, err := m.tagged(id)
+		if err != nil {
+			return nil, err
+		}
+		if !tagged {
+			continue
+		}
+		out, err := m.client.DescribeKey(&kms.DescribeKeyInput{KeyId: id})
+		if err != nil {
+			return nil, fmt.Errorf("error describing %s: %w", aws.StringValue(id), err)
+		}
+		versions = append(versions, awsKeyVersion(out.KeyMetadata, false))
+	}
+	return versions, nil
+}
+
+// tagged reports whether the key id is tagged as a version of the alias
+func (m *awsKeyManager) tagged(id *string) (bool, error) {
+	var found bool
+	input := &kms.ListResourceTagsInput{KeyId: id}
+	for {
+		out, err := m.client.ListResourceTags(input)
+		if err != nil {
+			return false, fmt.Errorf("error listing the tags of %s: %w", aws.StringValue(id), err)
+		}
+		for _, tag := range out.Tags {
+			if aws.StringValue(tag.TagKey) == awsAliasTag && aws.StringValue(tag.TagValue) == m.alias {
+				found = true
+			}
+		}
+		if found || !aws.BoolValue(out.Truncated) {
+			return found, nil
+		}
+		input.Marker = out.NextMarker
+	}
+}
+
+func (m *awsKeyManager) aliasTags() []*kms.Tag {
+	return []*kms.Tag{{TagKey: aws.String(awsAliasTag), TagValue: aws.String(m.alias)}}
+}
+
+func (m *awsKeyManager) rotate(_ context.Context) (*keyVersion, error) {
+	md, err := m.current()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := m.client.GetKeyPolicy(&kms.GetKeyPolicyInput{
+		KeyId:      md.KeyId,
+		PolicyName: aws.String("default"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading the policy of %s: %w", aws.StringValue(md.KeyId), err)
+	}
+
+	// The replaced key stays a version of the alias that can be listed and
+	// destroyed later
+	if _, err := m.client.TagResource(&kms.TagResourceInput{KeyId: md.KeyId, Tags: m.aliasTags()}); err != nil {
+		return nil, fmt.Errorf("error tagging %s: %w", aws.StringValue(md.KeyId), err)
+	}
+	created, err := m.client.CreateKey(&kms.CreateKeyInput{
+		Description:           md.Description,
+		KeyUsage:              md.KeyUsage,
+		CustomerMasterKeySpec: md.CustomerMasterKeySpec,
+		Policy:                policy.Policy,
+		Tags:                  m.aliasTags(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating key: %w", err)
+	}
+	if created.KeyMetadata == nil || created.KeyMetadata.KeyId == nil {
+		return nil, errors.New("no key information returned")
+	}
+
+	if err := m.setPrimary(context.Background(), aws.StringValue(created.KeyMetadata.KeyId)); err != nil {
+		return nil, fmt.Errorf("created key %s but could not point %s at it: %w",
+			aws.StringValue(created.KeyMetadata.KeyId), m.alias, err)
+	}
+	return awsKeyVersion(created.KeyMetadata, true), nil
+}
+
+func (m *awsKeyManager) setPrimary(_ context.Context, id string) error {
+	if _, err := m.client.UpdateAlias(&kms.UpdateAliasInput{
+		AliasName:   aws.String(m.alias),
+		TargetKeyId: aws.String(id),
+	}); err != nil {
+		return fmt.Errorf("error updating %s: %w", m.alias, err)
+	}
+	return nil
+}
+
+func (m *awsKeyManager) destroy(_ context.Context, id string, pendingDays int, force bool) (*keyVersion, error) {
+	if pendingDays != 0 && (pendingDays < 7 || pendingDays > 30) {
+		return nil, fmt.Errorf("the pending deletion window must be between 7 and 30 days, not %d", pendingDays)
+	}
+	md, err := m.current()
+	if err != nil {
+		return nil, err
+	}
+	out, err := m.client.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(id)})
+	if err != nil {
+		return nil, fmt.Errorf("error describing %s: %w", id, err)
+	}
+	if out.KeyMetadata == nil || out.KeyMetadata.KeyId == nil {
+		return nil, errors.New("no key information returned")
+	}
+	keyID := out.KeyMetadata.KeyId
+	if aws.StringValue(keyID) == aws.StringValue(md.KeyId) {
+		return nil, fmt.Errorf("%s is the primary version; make another version primary first", id)
+	}
+	if !force {
+		tagged, err := m.tagged(keyID)
+		if err != nil {
+			return nil, err
+		}
+		if !tagged {
+			return nil, fmt.Errorf("%s is not tagged as a version of %s; check the key ID, or pass -force to destroy it anyway", id, m.alias)
+		}
+	}
+
+	input := &kms.ScheduleKeyDeletionInput{KeyId: keyID}
+	if pendingDays != 0 {
+		input.PendingWindowInDays = aws.Int64(int64(pendingDays))
+	}
+	scheduled, err := m.client.ScheduleKeyDeletion(input)
+	if err != nil {
+		return nil, fmt.Errorf("error scheduling deletion of %s: %w", id, err)
+	}
+	return &keyVersion{ID: aws.StringValue(keyID), State: kms.KeyStatePendingDeletion, DestroyAt: scheduled.DeletionDate}, nil
+}
+
+func (m *awsKeyManager) close() error {
+	return nil
+}
+
+// gcpKeyClient is the subset of the Cloud KMS API used by gcpKeyManager,
+// with listing flattened so that it can be faked
+type gcpKeyClient interface {
+	GetCryptoKey(ctx context.Context, name string) (*kmspb.CryptoKey, error)
+	ListCryptoKeyVersions(ctx context.Context, parent string) ([]*kmspb.CryptoKeyVersion, error)
+	CreateCryptoKeyVersion(ctx context.Context, parent string) (*kmspb.CryptoKeyVersion, error)
+	UpdateCryptoKeyPrimaryVersion(ctx context.Context, name, version string) error
+	DestroyCryptoKeyVersion(ctx context.Context, name string) (*kmspb.CryptoKeyVersion, error)
+	Close() error
+}
+
+// gcpKeyManager manages the versions of a Cloud KMS crypto key
+type gcpKeyManager struct {
+	client gcpKeyClient
+	name   string
+}
+
+// versionName expands a version number to the version's resource name,
+// rejecting the names of versions of other crypto keys
+func (m *gcpKeyManager) versionName(id string) (string, error) {
+	prefix := m.name + "/cryptoKeyVersions/"
+	version := strings.TrimPrefix(id, prefix)
+	if version == "" || strings.Contains(version, "/") {
+		return "", fmt.Errorf("%q is not a version of %s", id, m.name)
+	}
+	return prefix + version, nil
+}
+
+func (m *gcpKeyManager) keyVersion(v *kmspb.CryptoKeyVersion, primary string) *keyVersion {
+	kv := &keyVersion{
+		ID:      v.Name[strings.LastIndex(v.Name, "/")+1:],
+		State:   v.State.String(),
+		Primary: v.Name == primary,
+	}
+	if t, err := ptypes.Timestamp(v.CreateTime); err == nil {
+		kv.Created = &t
+	}
+	if t, err := ptypes.Timestamp(v.DestroyTime); err == nil {
+		kv.DestroyAt = &t
+	}
+	return kv
+}
+
+func (m *gcpKeyManager) primary(ctx context.Context) (string, error) {
+	key, err := m.client.GetCryptoKey(ctx, m.name)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", m.name, err)
+	}
+	if key.Primary == nil {
+		return "", nil
+	}
+	return key.Primary.Name, nil
+}
+
+func (m *gcpKeyManager) versions(ctx context.Context) ([]*keyVersion, error) {
+	primary, err := m.primary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list, err := m.client.ListCryptoKeyVersions(ctx, m.name)
+	if err != nil {
+		return nil, fmt.Errorf("error listing versions of %s: %w", m.name, err)
+	}
+	versions := make([]*keyVersion, 0, len(list))
+	for _, v := range list {
+		versions = append(versions, m.keyVersion(v, primary))
+	}
+	return versions, nil
+}
+
+func (m *gcpKeyManager) rotate(ctx context.Context) (*keyVersion, error) {
+	v, err := m.client.CreateCryptoKeyVersion(ctx, m.name)
+	if err != nil {
+		return nil, fmt.Errorf("error creating a version of %s: %w", m.name, err)
+	}
+	if err := m.setPrimary(ctx, v.Name); err != nil {
+		return nil, fmt.Errorf("created %s but could not make it primary: %w", v.Name, err)
+	}
+	return m.keyVersion(v, v.Name), nil
+}
+
+func (m *gcpKeyManager) setPrimary(ctx context.Context, id string) error {
+	name, err := m.versionName(id)
+	if err != nil {
+		return err
+	}
+	if err := m.client.UpdateCryptoKeyPrimaryVersion(ctx, m.name, name[strings.LastIndex(name, "/")+1:]); err != nil {
+		return fmt.Errorf("error updating the primary version of %s: %w", m.name, err)
+	}
+	return nil
+}
+
+func (m *gcpKeyManager) destroy(ctx context.Context, id string, pendingDays int, _ bool) (*keyVersion, error) {
+	if pendingDays != 0 {
+		return nil, errors.New("-pending-days is not supported for gcpckms; the delay is configured on the key")
+	}
+	name, err := m.versionName(id)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := m.primary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if name == primary {
+		return nil, fmt.Errorf("%s is the primary version; make another version primary first", id)
+	}
+	v, err := m.client.DestroyCryptoKeyVersion(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("error destroying %s: %w", name, err)
+	}
+	return m.keyVersion(v, primary), nil
+}
+
+func (m *gcpKeyManager) close() error {
+	return m.client.Close()
+}
+
+// gcpIteratingClient adapts cloudkms.KeyManagementClient to gcpKeyClient
+type gcpIteratingClient struct {
+	*cloudkms.KeyManagementClient
+}
+
+func (c *gcpIteratingClient) GetCryptoKey(ctx context.Context, name string) (*kmspb.CryptoKey, error) {
+	return c.KeyManagementClient.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{Name: name})
+}
+
+func (c *gcpIteratingClient) ListCryptoKeyVersions(ctx context.Context, parent string) ([]*kmspb.CryptoKeyVersion, error) {
+	it := c.KeyManagementClient.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{Parent: parent})
+	var versions []*kmspb.CryptoKeyVersion
+	for {
+		v, err := it.Next()
+		if err == iterator.Done {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+}
+
+func (c *gcpIteratingClient) CreateCryptoKeyVersion(ctx context.Context, parent string) (*kmspb.CryptoKeyVersion, error) {
+	return c.KeyManagementClient.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+		Parent:           parent,
+		CryptoKeyVersion: &kmspb.CryptoKeyVersion{},
+	})
+}
+
+func (c *gcpIteratingClient) UpdateCryptoKeyPrimaryVersion(ctx context.Context, name, version string) error {
+	_, err := c.KeyManagementClient.UpdateCryptoKeyPrimaryVersion(ctx, &kmspb.UpdateCryptoKeyPrimaryVersionRequest{
+		Name:               name,
+		CryptoKeyVersionId: version,
+	})
+	return err
+}
+
+func (c *gcpIteratingClient) DestroyCryptoKeyVersion(ctx context.Context, name string) (*kmspb.CryptoKeyVersion, error) {
+	return c.KeyManagementClient.DestroyCryptoKeyVersion(ctx, &kmspb.DestroyCryptoKeyVersionRequest{Name: name})
+}
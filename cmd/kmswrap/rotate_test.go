@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/golang/protobuf/ptypes"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+// fakeAWSKMS keeps keys and a single alias in memory
+type fakeAWSKMS struct {
+	kmsiface.KMSAPI
+
+	keys   map[string]*kms.KeyMetadata
+	tags   map[string][]*kms.Tag
+	target string
+	nextID int
+}
+
+func newFakeAWSKMS() *fakeAWSKMS {
+	f := &fakeAWSKMS{keys: map[string]*kms.KeyMetadata{}, tags: map[string][]*kms.Tag{}}
+	f.target = f.add("first")
+	return f
+}
+
+// add creates a key and returns its ID
+func (f *fakeAWSKMS) add(description string) string {
+	f.nextID++
+	id := fmt.Sprintf("key-%d", f.nextID)
+	f.keys[id] = &kms.KeyMetadata{
+		KeyId:        aws.String(id),
+		Arn:          aws.String("arn:aws:kms:us-east-1:123456789012:key/" + id),
+		Description:  aws.String(description),
+		KeyState:     aws.String(kms.KeyStateEnabled),
+		CreationDate: aws.Time(time.Unix(int64(f.nextID), 0)),
+	}
+	return id
+}
+
+// find resolves the alias, a key ID or a key ARN to a key ID
+func (f *fakeAWSKMS) find(id string) (string, error) {
+	if id == "alias/test" {
+		return f.target, nil
+	}
+	for keyID, md := range f.keys {
+		if id == keyID || id == aws.StringValue(md.Arn) {
+			return keyID, nil
+		}
+	}
+	return "", fmt.Errorf("key %s not found", id)
+}
+
+func (f *fakeAWSKMS) DescribeKey(in *kms.DescribeKeyInput) (*kms.DescribeKeyOutput, error) {
+	id, err := f.find(aws.StringValue(in.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: f.keys[id]}, nil
+}
+
+func (f *fakeAWSKMS) ListKeysPages(_ *kms.ListKeysInput, fn func(*kms.ListKeysOutput, bool) bool) error {
+	// One page per key, in order
+	for i := 1; i <= f.nextID; i++ {
+		id := fmt.Sprintf("key-%d", i)
+		if !fn(&kms.ListKeysOutput{Keys: []*kms.KeyListEntry{{KeyId: aws.String(id)}}}, i == f.nextID) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeAWSKMS) ListResourceTags(in *kms.ListResourceTagsInput) (*kms.ListResourceTagsOutput, error) {
+	id, err := f.find(aws.StringValue(in.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	return &kms.ListResourceTagsOutput{Tags: f.tags[id]}, nil
+}
+
+func (f *fakeAWSKMS) TagResource(in *kms.TagResourceInput) (*kms.TagResourceOutput, error) {
+	id, err := f.find(aws.StringValue(in.KeyId))
+	if err != nil {
+		return nil, err
+	}
+	f.tags[id] = append(f.tags[id], in.Tags...)
+	return &kms.TagResourceOutput{}, nil
+}
+
+func (f *fakeAWSKMS) GetKeyPolicy(in *kms.GetKeyPolicyInput) (*kms.GetKeyPolicyOutput, error) {
+	return &kms.GetKeyPolicyOutput{Policy: aws.String("policy of " + aws.StringValue(in.KeyId))}, nil
+}
+
+func (f *fakeAWSKMS) CreateKey(in *kms.CreateKeyInput) (*kms.CreateKeyOutput, error) {
+	id := f.add(aws.StringValue(in.Description) + " from " + aws.StringValue(in.Policy))
+	f.tags[id] = in.Tags
+	md := f.keys[id]
+	return &kms.CreateKeyOutput{KeyMetadata: md}, nil
+}
+
+func (f *fakeAWSKMS) UpdateAlias(in *kms.UpdateAliasInput) (*kms.UpdateAliasOutput, error) {
+	if _, ok := f.keys[aws.StringValue(in.TargetKeyId)]; !ok {
+		return nil, fmt.Errorf("key %s not found", aws.StringValue(in.TargetKeyId))
+	}
+	f.target = aws.StringValue(in.TargetKeyId)
+	return &kms.UpdateAliasOutput{}, nil
+}
+
+func (f *fakeAWSKMS) ScheduleKeyDeletion(in *kms.ScheduleKeyDeletionInput) (*kms.ScheduleKeyDeletionOutput, error) {
+	md := f.keys[aws.StringValue(in.KeyId)]
+	days := aws.Int64Value(in.PendingWindowInDays)
+	if days == 0 {
+		days = 30
+	}
+	md.KeyState = aws.String(kms.KeyStatePendingDeletion)
+	md.DeletionDate = aws.Time(time.Unix(0, 0).Add(time.Duration(days) * 24 * time.Hour))
+	return &kms.ScheduleKeyDeletionOutput{KeyId: md.KeyId, DeletionDate: md.DeletionDate}, nil
+}
+
+func TestAWSKeyManager(t *testing.T) {
+	if _, err := newAWSKeyManager(newFakeAWSKMS(), "key-1"); err == nil {
+		t.Fatal("expected error for a key ID that is not an alias")
+	}
+
+	f := newFakeAWSKMS()
+	m, err := newAWSKeyManager(f, "alias/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	v, err := m.rotate(ctx)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v.ID != "key-2" || f.target != "key-2" {
+		t.Fatalf("expected the alias to point at key-2, got %s and %s", v.ID, f.target)
+	}
+	if d := aws.StringValue(f.keys["key-2"].Description); d != "first from policy of key-1" {
+		t.Fatalf("expected the description and policy to be copied, got %q", d)
+	}
+
+	// Keys of the account that are not tagged for the alias are left out
+	unrelated := f.add("unrelated")
+	versions, err := m.versions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].ID != "key-2" || !versions[0].Primary || versions[1].ID != "key-1" || versions[1].Primary {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	if _, err := m.destroy(ctx, "key-2", 0, false); err == nil {
+		t.Fatal("expected error destroying the primary version")
+	}
+	if _, err := m.destroy(ctx, aws.StringValue(f.keys["key-2"].Arn), 0, true); err == nil {
+		t.Fatal("expected error destroying the primary version by its ARN")
+	}
+	if _, err := m.destroy(ctx, "key-1", 3, false); err == nil {
+		t.Fatal("expected error for a 3 day window")
+	}
+	if _, err := m.destroy(ctx, unrelated, 0, false); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Fatalf("expected error destroying an unrelated key, got %v", err)
+	}
+	if f.keys[unrelated].DeletionDate != nil {
+		t.Fatal("expected the unrelated key to be kept")
+	}
+	v, err = m.destroy(ctx, aws.StringValue(f.keys["key-1"].Arn), 7, false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v.ID != "key-1" || v.State != kms.KeyStatePendingDeletion || !v.DestroyAt.Equal(time.Unix(0, 0).Add(7*24*time.Hour)) {
+		t.Fatalf("unexpected version %+v", v)
+	}
+	if _, err := m.destroy(ctx, unrelated, 0, true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := m.setPrimary(ctx, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if f.target != "key-1" {
+		t.Fatalf("expected the alias to point at key-1, got %s", f.target)
+	}
+}
+
+// fakeGCPKMS keeps the versions of a single crypto key in memory
+type fakeGCPKMS struct {
+	name     string
+	versions []*kmspb.CryptoKeyVersion
+	primary  string
+	closed   bool
+}
+
+func newFakeGCPKMS() *fakeGCPKMS {
+	f := &fakeGCPKMS{name: "projects/p/locations/global/keyRings/r/cryptoKeys/k"}
+	v, _ := f.CreateCryptoKeyVersion(context.Background(), f.name)
+	f.primary = v.Name
+	return f
+}
+
+func (f *fakeGCPKMS) GetCryptoKey(_ context.Context, name string) (*kmspb.CryptoKey, error) {
+	if name != f.name {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	for _, v := range f.versions {
+		if v.Name == f.primary {
+			return &kmspb.CryptoKey{Name: name, Primary: v}, nil
+		}
+	}
+	return &kmspb.CryptoKey{Name: name}, nil
+}
+
+func (f *fakeGCPKMS) ListCryptoKeyVersions(_ context.Context, parent string) ([]*kmspb.CryptoKeyVersion, error) {
+	return f.versions, nil
+}
+
+func (f *fakeGCPKMS) CreateCryptoKeyVersion(_ context.Context, parent string) (*kmspb.CryptoKeyVersion, error) {
+	created, _ := ptypes.TimestampProto(time.Unix(int64(len(f.versions)+1), 0))
+	v := &kmspb.CryptoKeyVersion{
+		Name:       fmt.Sprintf("%s/cryptoKeyVersions/%d", parent, len(f.versions)+1),
+		State:      kmspb.CryptoKeyVersion_ENABLED,
+		CreateTime: created,
+	}
+	f.versions = append(f.versions, v)
+	return v, nil
+}
+
+func (f *fakeGCPKMS) UpdateCryptoKeyPrimaryVersion(_ context.Context, name, version string) error {
+	for _, v := range f.versions {
+		if v.Name == name+"/cryptoKeyVersions/"+version {
+			f.primary = v.Name
+			return nil
+		}
+	}
+	return fmt.Errorf("version %s not found", version)
+}
+
+func (f *fakeGCPKMS) DestroyCryptoKeyVersion(_ context.Context, name string) (*kmspb.CryptoKeyVersion, error) {
+	for _, v := range f.versions {
+		if v.Name == name {
+			v.State = kmspb.CryptoKeyVersion_DESTROY_SCHEDULED
+			v.DestroyTime, _ = ptypes.TimestampProto(time.Unix(86400, 0))
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
+
+func (f *fakeGCPKMS) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestGCPKeyManager(t *testing.T) {
+	f := newFakeGCPKMS()
+	m := &gcpKeyManager{client: f, name: f.name}
+	ctx := context.Background()
+
+	v, err := m.rotate(ctx)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v.ID != "2" || !v.Primary || f.primary != f.name+"/cryptoKeyVersions/2" {
+		t.Fatalf("unexpected version %+v, primary %s", v, f.primary)
+	}
+
+	versions, err := m.versions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Primary || !versions[1].Primary || versions[0].State != "ENABLED" {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+	if !versions[0].Created.Equal(time.Unix(1, 0)) || versions[0].DestroyAt != nil {
+		t.Fatalf("unexpected times %+v", versions[0])
+	}
+
+	if _, err := m.destroy(ctx, "2", 0, false); err == nil {
+		t.Fatal("expected error destroying the primary version")
+	}
+	if _, err := m.destroy(ctx, "1", 10, false); err == nil {
+		t.Fatal("expected error for -pending-days")
+	}
+
+	// Versions of other crypto keys are rejected, with -force too
+	for _, id := range []string{
+		"projects/p/locations/global/keyRings/r/cryptoKeys/other/cryptoKeyVersions/1",
+		f.name + "/cryptoKeyVersions/1/extra",
+		f.name + "/cryptoKeyVersions/",
+		"",
+	} {
+		if _, err := m.destroy(ctx, id, 0, true); err == nil {
+			t.Fatalf("%q: expected error", id)
+		}
+		if err := m.setPrimary(ctx, id); err == nil {
+			t.Fatalf("%q: expected error", id)
+		}
+	}
+
+	v, err = m.destroy(ctx, f.name+"/cryptoKeyVersions/1", 0, false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v.State != "DESTROY_SCHEDULED" || !v.DestroyAt.Equal(time.Unix(86400, 0)) {
+		t.Fatalf("unexpected version %+v", v)
+	}
+
+	if err := m.setPrimary(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if f.primary != f.name+"/cryptoKeyVersions/1" {
+		t.Fatalf("unexpected primary %s", f.primary)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	defer setTestEnv(t, nil)()
+
+	f := newFakeGCPKMS()
+	orig := newKeyManager
+	defer func() { newKeyManager = orig }()
+	newKeyManager = func(seal *sealConfig) (keyManager, error) {
+		if seal.Type != "gcpckms" {
+			return orig(seal)
+		}
+		return &gcpKeyManager{client: f, name: f.name}, nil
+	}
+
+	out := testRun(t, []string{"rotate", "-wrapper", "gcpckms", "now"}, "")
+	if out != "version 2 is now primary\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+
+	out = testRun(t, []string{"rotate", "-wrapper", "gcpckms", "list"}, "")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "version") || !strings.HasSuffix(strings.Fields(lines[2])[2], "*") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	var versions []*keyVersion
+	out = testRun(t, []string{"rotate", "-wrapper", "gcpckms", "-format", "json", "list"}, "")
+	if err := json.Unmarshal([]byte(out), &versions); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1].ID != "2" || !versions[1].Primary {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	out = testRun(t, []string{"rotate", "-wrapper", "gcpckms", "destroy", "1"}, "")
+	if out != "version 1 will be destroyed at 1970-01-02T00:00:00Z\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if !f.closed {
+		t.Fatal("expected the client to be closed")
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"rotate", "-wrapper", "gcpckms", "destroy", "2"}, nil, ioutil.Discard, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "is the primary version") {
+		t.Fatalf("unexpected error %q", stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run(append(append([]string{"rotate"}, testAEADFlags(t)...), "list"), nil, ioutil.Discard, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr.String(), "key management is not supported for aead wrappers") {
+		t.Fatalf("unexpected error %q", stderr.String())
+	}
+
+	for _, args := range [][]string{
+		{"rotate"},
+		{"rotate", "spin"},
+		{"rotate", "list", "extra"},
+		{"rotate", "destroy"},
+		{"rotate", "-pending-days", "7", "now"},
+		{"rotate", "-force", "list"},
+		{"rotate", "-format", "yaml", "list"},
+	} {
+		if code := run(args, nil, ioutil.Discard, ioutil.Discard); code != 2 {
+			t.Fatalf("%v: expected exit code 2, got %d", args, code)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+// withUmask runs fn. There is no umask on this platform, and sockets are
+// protected by the permissions of the directory that holds them.
+func withUmask(_ int, fn func() error) error {
+	return fn()
+}
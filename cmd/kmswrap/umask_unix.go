@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"sync"
+	"syscall"
+)
+
+var umaskLock sync.Mutex
+
+// withUmask runs fn with the process umask set to mask, so that the files fn
+// creates never have wider permissions than intended, even briefly. The
+// umask is shared by the whole process: files created meanwhile by other
+// goroutines get mask as well, which may be looser than the usual umask: a
+// -socket-mode of 0666 gives a mask of 0111.
+func withUmask(mask int, fn func() error) error {
+	umaskLock.Lock()
+	defer umaskLock.Unlock()
+	old := syscall.Umask(mask)
+	defer syscall.Umask(old)
+	return fn()
+}
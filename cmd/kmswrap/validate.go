@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/alicloudkms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/azurekeyvault"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/gcpckms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/huaweicloudkms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/ocikms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/sealedbox"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/tencentcloudkms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/transit"
+)
+
+const validateUsage = `Usage: kmswrap validate [options]
+
+  Checks a wrapper configuration before it is deployed: that the seal stanza
+  parses, that every parameter the wrapper requires is set, either in the
+  configuration or through its environment variable, and that the values
+  are well formed. Parameters the wrapper does not know are reported, since
+  they are usually typos.
+
+  With -preflight the wrapper is also constructed, which for the cloud KMSes
+  looks the key up, and a random value is encrypted and decrypted to check
+  that the credentials grant both permissions.
+
+  Problems are written to stdout, one per line. The exit code is 1 if any
+  error was found.`
+
+func (c *cli) validate(args []string) error {
+	fs := c.flagSet("validate", validateUsage)
+	var wf wrapperFlags
+	wf.register(fs)
+	preflight := fs.Bool("preflight", false, "connect to the KMS and round-trip a test value")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(c.stderr, "validate takes no arguments\n")
+		return errUsage
+	}
+
+	seal, err := wf.resolve()
+	if err != nil {
+		fmt.Fprintf(c.stdout, "error: %v\n", err)
+		return errors.New("configuration is invalid")
+	}
+
+	findings := validateSeal(seal)
+	if !findings.hasErrors() && *preflight {
+		findings = append(findings, preflightSeal(seal)...)
+	}
+
+	for _, f := range findings {
+		fmt.Fprintf(c.stdout, "%s\n", f)
+	}
+	if findings.hasErrors() {
+		return errors.New("configuration is invalid")
+	}
+	fmt.Fprintf(c.stdout, "%s configuration is valid\n", seal.Type)
+	return nil
+}
+
+// finding is a single result of validation
+type finding struct {
+	level   string
+	message string
+}
+
+func (f finding) String() string {
+	return f.level + ": " + f.message
+}
+
+type findings []finding
+
+func (fs *findings) errorf(format string, args ...interface{}) {
+	*fs = append(*fs, finding{"error", fmt.Sprintf(format, args...)})
+}
+
+func (fs *findings) warnf(format string, args ...interface{}) {
+	*fs = append(*fs, finding{"warning", fmt.Sprintf(format, args...)})
+}
+
+func (fs *findings) okf(format string, args ...interface{}) {
+	*fs = append(*fs, finding{"ok", fmt.Sprintf(format, args...)})
+}
+
+func (fs *findings) notef(format string, args ...interface{}) {
+	*fs = append(*fs, finding{"note", fmt.Sprintf(format, args...)})
+}
+
+func (fs findings) hasErrors() bool {
+	return fs.count("error") > 0
+}
+
+// count returns the number of findings at level
+func (fs findings) count(level string) int {
+	n := 0
+	for _, f := range fs {
+		if f.level == level {
+			n++
+		}
+	}
+	return n
+}
+
+// wrapperParam is a configuration value a wrapper reads
+type wrapperParam struct {
+	name string
+
+	// env lists the environment variables the value can also come from,
+	// highest precedence first. They take precedence over the configuration
+	// unless configFirst is set.
+	env []string
+
+	// configFirst is set for parameters that the wrapper, or the client
+	// library it uses, reads from the environment only when the
+	// configuration does not set them
+	configFirst bool
+
+	required bool
+
+	// check, if set, validates a value that was given
+	check func(string) error
+}
+
+// wrapperParams lists the parameters of each wrapper type, as read by its
+// SetConfig
+var wrapperParams = map[string][]wrapperParam{
+	wrapping.AEAD: {
+		{name: "aead_type", required: true, check: oneOf("aes-gcm")},
+		{name: "key", required: true, check: checkAESKey},
+		{name: "key_id"},
+	},
+	wrapping.AliCloudKMS: {
+		{name: "kms_key_id", required: true, env: []string{alicloudkms.EnvAliCloudKMSWrapperKeyID, alicloudkms.EnvVaultAliCloudKMSSealKeyID}},
+		{name: "region", env: []string{"ALICLOUD_REGION"}},
+		{name: "domain", env: []string{"ALICLOUD_DOMAIN"}},
+		{name: "access_key"},
+		{name: "secret_key"},
+		{name: "access_secret"},
+	},
+	wrapping.AWSKMS: {
+		{name: "kms_key_id", required: true, env: []string{awskms.EnvAWSKMSWrapperKeyID, awskms.EnvVaultAWSKMSSealKeyID}},
+		{name: "region", env: []string{"AWS_REGION", "AWS_DEFAULT_REGION"}, configFirst: true},
+		{name: "access_key"},
+		{name: "secret_key"},
+		{name: "session_token"},
+		{name: "endpoint", env: []string{"AWS_KMS_ENDPOINT"}, check: checkURL},
+	},
+	wrapping.AzureKeyVault: {
+		{name: "vault_name", required: true, env: []string{azurekeyvault.EnvAzureKeyVaultWrapperVaultName, azurekeyvault.EnvVaultAzureKeyVaultVaultName}},
+		{name: "key_name", required: true, env: []string{azurekeyvault.EnvAzureKeyVaultWrapperKeyName, azurekeyvault.EnvVaultAzureKeyVaultKeyName}},
+		{name: "tenant_id", env: []string{"AZURE_TENANT_ID"}},
+		{name: "client_id", env: []string{"AZURE_CLIENT_ID"}},
+		{name: "client_secret", env: []string{"AZURE_CLIENT_SECRET"}},
+		{name: "environment", env: []string{"AZURE_ENVIRONMENT"}, check: checkAzureEnvironment},
+	},
+	wrapping.GCPCKMS: {
+		{name: "project", required: true, env: []string{gcpckms.EnvGCPCKMSWrapperProject}},
+		{name: "region", required: true, env: []string{gcpckms.EnvGCPCKMSWrapperLocation}},
+		{name: "key_ring", required: true, env: []string{gcpckms.EnvGCPCKMSWrapperKeyRing, gcpckms.EnvVaultGCPCKMSSealKeyRing}},
+		{name: "crypto_key", required: true, env: []string{gcpckms.EnvGCPCKMSWrapperCryptoKey, gcpckms.EnvVaultGCPCKMSSealCryptoKey}},
+		{name: "credentials", env: []string{gcpckms.EnvGCPCKMSWrapperCredsPath}, check: checkReadable},
+		{name: "user_agent"},
+	},
+	wrapping.HuaweiCloudKMS: {
+		{name: "kms_key_id", required: true, env: []string{huaweicloudkms.EnvHuaweiCloudKMSWrapperKeyID}},
+		{name: "region", required: true, env: []string{"HUAWEICLOUD_REGION"}},
+		{name: "project", required: true, env: []string{"HUAWEICLOUD_PROJECT"}},
+		{name: "access_key", required: true, env: []string{"HUAWEICLOUD_ACCESS_KEY"}},
+		{name: "secret_key", required: true, env: []string{"HUAWEICLOUD_SECRET_KEY"}},
+		{name: "identity_endpoint", env: []string{"HUAWEICLOUD_IDENTITY_ENDPOINT"}, check: checkURL},
+	},
+	wrapping.OCIKMS: {
+		{name: ocikms.KMSConfigKeyID, required: true, env: []string{ocikms.EnvOCIKMSWrapperKeyID, ocikms.EnvVaultOCIKMSSealKeyID}},
+		{name: ocikms.KMSConfigCryptoEndpoint, required: true, env: []string{ocikms.EnvOCIKMSWrapperCryptoEndpoint, ocikms.EnvVaultOCIKMSSealCryptoEndpoint}, check: checkURL},
+		{name: ocikms.KMSConfigManagementEndpoint, required: true, env: []string{ocikms.EnvOCIKMSWrapperManagementEndpoint, ocikms.EnvVaultOCIKMSSealManagementEndpoint}, check: checkURL},
+		{name: ocikms.KMSConfigAuthTypeAPIKey, check: checkBool},
+	},
+	wrapping.SealedBox: {
+		{name: "public_key", check: checkX25519Key},
+		{name: "private_key", check: checkX25519Key},
+		{name: "key_id"},
+	},
+	wrapping.TencentCloudKMS: {
+		{name: "kms_key_id", required: true, env: []string{tencentcloudkms.PROVIDER_KMS_KEY_ID}},
+		{name: "access_key", required: true, env: []string{tencentcloudkms.PROVIDER_SECRET_ID}},
+		{name: "secret_key", required: true, env: []string{tencentcloudkms.PROVIDER_SECRET_KEY}},
+		{name: "region", env: []string{tencentcloudkms.PROVIDER_REGION}},
+		{name: "session_token", env: []string{tencentcloudkms.PROVIDER_SECURITY_TOKEN}},
+	},
+	wrapping.Transit: {
+		{name: "mount_path", required: true, env: []string{transit.EnvTransitWrapperMountPath, transit.EnvVaultTransitSealMountPath}},
+		{name: "key_name", required: true, env: []string{transit.EnvTransitWrapperKeyName, transit.EnvVaultTransitSealKeyName}},
+		{name: "address", env: []string{"VAULT_ADDR"}, configFirst: true, check: checkURL},
+		{name: "token", env: []string{"VAULT_TOKEN"}, configFirst: true},
+		{name: "namespace", env: []string{"VAULT_NAMESPACE"}},
+		{name: "disable_renewal", env: []string{transit.EnvTransitWrapperDisableRenewal, transit.EnvVaultTransitSealDisableRenewal}, check: checkBool},
+		{name: "tls_ca_cert", check: checkReadable},
+		{name: "tls_ca_path", check: checkReadable},
+		{name: "tls_client_cert", check: checkReadable},
+		{name: "tls_client_key", check: checkReadable},
+		{name: "tls_server_name"},
+		{name: "tls_skip_verify", check: checkBool},
+	},
+}
+
+// validateSeal checks seal against the parameters of its wrapper type
+// without contacting the KMS
+func validateSeal(seal *sealConfig) findings {
+	var fs findings
+
+	params, ok := wrapperParams[seal.Type]
+	if !ok {
+		types := make([]string, 0, len(wrapperParams))
+		for t := range wrapperParams {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		fs.errorf("unsupported wrapper type %q; supported types are %s", seal.Type, strings.Join(types, ", "))
+		return fs
+	}
+
+	known := make(map[string]bool, len(params))
+	for _, p := range params {
+		known[p.name] = true
+
+		value, source := p.resolve(seal.Config)
+
+		switch {
+		case value == "" && p.required:
+			hint := "set it in the seal stanza"
+			if len(p.env) > 0 {
+				hint += " or with " + strings.Join(p.env, " or ")
+			}
+			fs.errorf("missing required parameter %q; %s", p.name, hint)
+		case value != "" && p.check != nil:
+			if err := p.check(value); err != nil {
+				fs.errorf("invalid %q from %s: %v", p.name, source, err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(seal.Config))
+	for name := range seal.Config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if known[name] {
+			continue
+		}
+		msg := fmt.Sprintf("unknown parameter %q is ignored by the %s wrapper", name, seal.Type)
+		if suggestion := closest(name, params); suggestion != "" {
+			msg += fmt.Sprintf("; did you mean %q?", suggestion)
+		}
+		fs.warnf("%s", msg)
+	}
+
+	// Cross-parameter rules
+	switch seal.Type {
+	case wrapping.AzureKeyVault:
+		if effective(seal, params, "client_id") != "" && effective(seal, params, "client_secret") == "" {
+			fs.warnf("client_id is set without client_secret; the wrapper will not use client credentials")
+		}
+	case wrapping.AWSKMS:
+		if (seal.Config["access_key"] == "") != (seal.Config["secret_key"] == "") {
+			fs.warnf("only one of access_key and secret_key is set; static credentials need both")
+		}
+	case wrapping.SealedBox:
+		if seal.Config["public_key"] == "" && seal.Config["private_key"] == "" {
+			fs.errorf("one of \"public_key\" or \"private_key\" is required")
+		} else if seal.Config["private_key"] == "" {
+			fs.notef("no private_key is set; the wrapper can encrypt but not decrypt")
+		}
+	}
+
+	return fs
+}
+
+// resolve returns the value the wrapper will use for the parameter and
+// where it comes from
+func (p *wrapperParam) resolve(config map[string]string) (value, source string) {
+	if p.configFirst && config[p.name] != "" {
+		return config[p.name], "configuration"
+	}
+	for _, env := range p.env {
+		if v := os.Getenv(env); v != "" {
+			return v, "environment variable " + env
+		}
+	}
+	return config[p.name], "configuration"
+}
+
+// effective returns the value the wrapper will use for the named parameter
+func effective(seal *sealConfig, params []wrapperParam, name string) string {
+	for _, p := range params {
+		if p.name == name {
+			value, _ := p.resolve(seal.Config)
+			return value
+		}
+	}
+	return seal.Config[name]
+}
+
+// preflightSeal builds the wrapper and round-trips a random value through it
+func preflightSeal(seal *sealConfig) findings {
+	var fs findings
+
+	w, err := newWrapper(seal)
+	if err != nil {
+		fs.errorf("%v", err)
+		return fs
+	}
+	defer w.Finalize(context.Background())
+	fs.okf("wrapper configured; key ID %q", w.KeyID())
+
+	ctx := context.Background()
+	value := make([]byte, 32)
+	if _, err := rand.Read(value); err != nil {
+		fs.errorf("error generating test value: %v", err)
+		return fs
+	}
+	blob, err := w.Encrypt(ctx, value, nil)
+	if err != nil {
+		fs.errorf("test encryption failed, check that the credentials may encrypt with this key: %v", err)
+		return fs
+	}
+	fs.okf("test encryption succeeded")
+
+	pt, err := w.Decrypt(ctx, blob, nil)
+	switch {
+	case err != nil:
+		fs.errorf("test decryption failed, check that the credentials may decrypt with this key: %v", err)
+	case !bytes.Equal(pt, value):
+		fs.errorf("test decryption returned a different value")
+	default:
+		fs.okf("test decryption succeeded")
+	}
+	return fs
+}
+
+// closest returns the parameter name within an edit distance of two of
+// name, if there is one
+func closest(name string, params []wrapperParam) string {
+	best, bestDistance := "", 3
+	for _, p := range params {
+		if d := editDistance(name, p.name); d < bestDistance {
+			best, bestDistance = p.name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func oneOf(values ...string) func(string) error {
+	return func(s string) error {
+		for _, v := range values {
+			if s == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func checkAESKey(s string) error {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("must be base64 encoded: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("must decode to 16, 24 or 32 bytes, not %d", len(key))
+	}
+}
+
+func checkX25519Key(s string) error {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("must be base64 encoded: %w", err)
+	}
+	if len(key) != sealedbox.KeySize {
+		return fmt.Errorf("must decode to %d bytes, not %d", sealedbox.KeySize, len(key))
+	}
+	return nil
+}
+
+func checkBool(s string) error {
+	_, err := strconv.ParseBool(s)
+	return err
+}
+
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return errors.New("must be an absolute URL such as https://host:port")
+	}
+	return nil
+}
+
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func checkAzureEnvironment(name string) error {
+	_, err := azure.EnvironmentFromName(name)
+	return err
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+)
+
+func TestValidateSeal(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	testCases := []struct {
+		Title    string
+		Seal     *sealConfig
+		Expected []string
+	}{
+		{
+			Title: "Valid",
+			Seal:  &sealConfig{Type: "aead", Config: map[string]string{"aead_type": "aes-gcm", "key": key}},
+		},
+		{
+			Title: "Missing",
+			Seal:  &sealConfig{Type: "aead", Config: map[string]string{"aead_type": "aes-gcm"}},
+			Expected: []string{
+				`error: missing required parameter "key"; set it in the seal stanza`,
+			},
+		},
+		{
+			Title: "Invalid",
+			Seal:  &sealConfig{Type: "aead", Config: map[string]string{"aead_type": "aes-cbc", "key": "AAAA"}},
+			Expected: []string{
+				`error: invalid "aead_type" from configuration: must be one of aes-gcm`,
+				`error: invalid "key" from configuration: must decode to 16, 24 or 32 bytes, not 3`,
+			},
+		},
+		{
+			Title: "Unknown",
+			Seal:  &sealConfig{Type: "aead", Config: map[string]string{"aead_type": "aes-gcm", "key": key, "keyid": "x", "other": "y"}},
+			Expected: []string{
+				`warning: unknown parameter "keyid" is ignored by the aead wrapper; did you mean "key_id"?`,
+				`warning: unknown parameter "other" is ignored by the aead wrapper`,
+			},
+		},
+		{
+			Title: "Unsupported",
+			Seal:  &sealConfig{Type: "pkcs11", Config: map[string]string{}},
+			Expected: []string{
+				`error: unsupported wrapper type "pkcs11"; supported types are aead, alicloudkms,`,
+			},
+		},
+		{
+			Title: "Transit",
+			Seal:  &sealConfig{Type: "transit", Config: map[string]string{"mount_path": "transit/", "key_name": "k", "address": "vault:8200", "tls_skip_verify": "maybe"}},
+			Expected: []string{
+				`error: invalid "address" from configuration: must be an absolute URL`,
+				`error: invalid "tls_skip_verify" from configuration:`,
+			},
+		},
+		{
+			Title: "SealedBox",
+			Seal:  &sealConfig{Type: "sealedbox", Config: map[string]string{"public_key": key}},
+			Expected: []string{
+				`note: no private_key is set; the wrapper can encrypt but not decrypt`,
+			},
+		},
+		{
+			Title: "SealedBoxMissing",
+			Seal:  &sealConfig{Type: "sealedbox", Config: map[string]string{"key_id": "app", "private_key": "AAAA"}},
+			Expected: []string{
+				`error: invalid "private_key" from configuration: must decode to 32 bytes, not 3`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			fs := validateSeal(tc.Seal)
+			if len(fs) != len(tc.Expected) {
+				t.Fatalf("expected %d findings, got %v", len(tc.Expected), fs)
+			}
+			for i, f := range fs {
+				if !strings.HasPrefix(f.String(), tc.Expected[i]) {
+					t.Fatalf("expected finding starting with %q, got %q", tc.Expected[i], f)
+				}
+			}
+		})
+	}
+
+	t.Run("Env", func(t *testing.T) {
+		for _, name := range []string{awskms.EnvAWSKMSWrapperKeyID, awskms.EnvVaultAWSKMSSealKeyID} {
+			if v, ok := os.LookupEnv(name); ok {
+				defer os.Setenv(name, v)
+			}
+			os.Unsetenv(name)
+		}
+		seal := &sealConfig{Type: "awskms", Config: map[string]string{}}
+		if fs := validateSeal(seal); !fs.hasErrors() {
+			t.Fatal("expected a missing kms_key_id to be reported")
+		}
+
+		os.Setenv(awskms.EnvVaultAWSKMSSealKeyID, "alias/test")
+		defer os.Unsetenv(awskms.EnvVaultAWSKMSSealKeyID)
+		if fs := validateSeal(seal); fs.hasErrors() {
+			t.Fatalf("expected the environment variable to satisfy kms_key_id, got %v", fs)
+		}
+	})
+
+	t.Run("ConfigFirst", func(t *testing.T) {
+		for _, name := range []string{"VAULT_ADDR", "AWS_REGION"} {
+			if v, ok := os.LookupEnv(name); ok {
+				defer os.Setenv(name, v)
+			} else {
+				defer os.Unsetenv(name)
+			}
+		}
+		os.Setenv("VAULT_ADDR", "stale:8200")
+		os.Setenv("AWS_REGION", "eu-west-1")
+
+		// The Vault client only reads VAULT_ADDR if address is not set
+		seal := &sealConfig{Type: "transit", Config: map[string]string{"mount_path": "transit/", "key_name": "k", "address": "https://vault:8200"}}
+		if fs := validateSeal(seal); fs.hasErrors() {
+			t.Fatalf("expected address to take precedence over VAULT_ADDR, got %v", fs)
+		}
+		delete(seal.Config, "address")
+		fs := validateSeal(seal)
+		if len(fs) != 1 || !strings.HasPrefix(fs[0].String(), `error: invalid "address" from environment variable VAULT_ADDR`) {
+			t.Fatalf("expected VAULT_ADDR to be checked without address, got %v", fs)
+		}
+
+		seal = &sealConfig{Type: "awskms", Config: map[string]string{"kms_key_id": "alias/test", "region": "us-west-2"}}
+		if endpoint, err := doctorEndpoint(seal); err != nil || endpoint != "https://kms.us-west-2.amazonaws.com" {
+			t.Fatalf("expected the configured region's endpoint, got %q, %v", endpoint, err)
+		}
+		delete(seal.Config, "region")
+		if endpoint, err := doctorEndpoint(seal); err != nil || endpoint != "https://kms.eu-west-1.amazonaws.com" {
+			t.Fatalf("expected the AWS_REGION endpoint, got %q, %v", endpoint, err)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	defer setTestEnv(t, nil)()
+
+	var stdout bytes.Buffer
+	args := append([]string{"validate", "-preflight"}, testAEADFlags(t)...)
+	if code := run(args, nil, &stdout, ioutil.Discard); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stdout.String())
+	}
+	for _, want := range []string{"ok: test encryption succeeded\n", "ok: test decryption succeeded\n", "aead configuration is valid\n"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, stdout.String())
+		}
+	}
+
+	path := writeTestFile(t, "seal.hcl", `seal "aead" { aead_type = "aes-gcm" }`)
+	defer os.Remove(path)
+	stdout.Reset()
+	if code := run([]string{"validate", "-config", path}, nil, &stdout, ioutil.Discard); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), `missing required parameter "key"`) {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"validate"}, nil, &stdout, ioutil.Discard); code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	if !strings.HasPrefix(stdout.String(), "error: no wrapper configured") {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"key", "key", 0},
+		{"keyid", "key_id", 1},
+		{"kms_keyid", "kms_key_id", 1},
+		{"region", "regoin", 2},
+		{"abc", "", 3},
+	} {
+		if d := editDistance(tc.a, tc.b); d != tc.expected {
+			t.Fatalf("%q, %q: expected %d, got %d", tc.a, tc.b, tc.expected, d)
+		}
+	}
+}
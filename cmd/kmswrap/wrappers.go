@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/alicloudkms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/azurekeyvault"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/gcpckms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/huaweicloudkms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/ocikms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/sealedbox"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/tencentcloudkms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/transit"
+)
+
+// configurableWrapper is implemented by every wrapper kmswrap can construct
+type configurableWrapper interface {
+	wrapping.Wrapper
+	SetConfig(map[string]string) (map[string]string, error)
+}
+
+// newWrapper constructs and configures a wrapper of the given type, then
+// initializes it. Callers must Finalize it.
+func newWrapper(seal *sealConfig) (wrapping.Wrapper, error) {
+	var w configurableWrapper
+	switch seal.Type {
+	case wrapping.AEAD:
+		w = aead.NewWrapper(nil)
+	case wrapping.AliCloudKMS:
+		w = alicloudkms.NewWrapper(nil)
+	case wrapping.AWSKMS:
+		w = awskms.NewWrapper(nil)
+	case wrapping.AzureKeyVault:
+		w = azurekeyvault.NewWrapper(nil)
+	case wrapping.GCPCKMS:
+		w = gcpckms.NewWrapper(nil)
+	case wrapping.HuaweiCloudKMS:
+		w = huaweicloudkms.NewWrapper(nil)
+	case wrapping.OCIKMS:
+		w = ocikms.NewWrapper(nil)
+	case wrapping.SealedBox:
+		w = sealedbox.NewWrapper(nil)
+	case wrapping.TencentCloudKMS:
+		w = tencentcloudkms.NewWrapper(nil)
+	case wrapping.Transit:
+		w = transit.NewWrapper(nil)
+	default:
+		return nil, fmt.Errorf("unsupported wrapper type %q", seal.Type)
+	}
+
+	if _, err := w.SetConfig(seal.Config); err != nil {
+		return nil, fmt.Errorf("error configuring %s wrapper: %w", seal.Type, err)
+	}
+	if err := w.Init(context.Background()); err != nil {
+		return nil, fmt.Errorf("error initializing %s wrapper: %w", seal.Type, err)
+	}
+	return w, nil
+}
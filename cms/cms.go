@@ -0,0 +1,421 @@
+// Package cms writes and reads CMS EnvelopedData (RFC 5652), the format of
+// PKCS#7 enveloped messages used by S/MIME and many archival systems.
+//
+// Content is encrypted with a fresh AES-CBC key, which is then encrypted for
+// each recipient. Three kinds of recipients are supported:
+//
+//   - KEKRecipientInfo with a wrapper as the key-encryption key. The
+//     encrypted key is the marshaled blob of the content key, under an
+//     algorithm identified by OIDWrappedBlob.
+//   - KEKRecipientInfo with an AES key wrap (RFC 3394) key, which standard
+//     tooling such as "openssl cms -decrypt -secretkey" can use.
+//   - KeyTransRecipientInfo for an X.509 certificate with an RSA key, with
+//     PKCS#1 v1.5 or OAEP padding, as used for email.
+//
+// Messages are DER encoded. BER input with indefinite lengths, as written by
+// some streaming implementations, is not supported.
+package cms
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// Object identifiers are kept in their DER encoding, without tag and length,
+// since OIDWrappedBlob does not fit an asn1.ObjectIdentifier
+var (
+	oidData          = encodeOID(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1})
+	oidEnvelopedData = encodeOID(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3})
+
+	oidAES128CBC = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2})
+	oidAES192CBC = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22})
+	oidAES256CBC = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42})
+)
+
+func encodeOID(oid asn1.ObjectIdentifier) []byte {
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		panic(err)
+	}
+	return der[2:]
+}
+
+func addOID(b *cryptobyte.Builder, oid []byte) {
+	b.AddASN1(cbasn1.OBJECT_IDENTIFIER, func(b *cryptobyte.Builder) {
+		b.AddBytes(oid)
+	})
+}
+
+func readOID(s *cryptobyte.String, out *[]byte) bool {
+	return s.ReadASN1Bytes(out, cbasn1.OBJECT_IDENTIFIER)
+}
+
+// ContentEncryption selects the cipher of the content
+type ContentEncryption int
+
+const (
+	// AES256CBC is the default
+	AES256CBC ContentEncryption = iota
+	AES128CBC
+	AES192CBC
+)
+
+func (e ContentEncryption) params() ([]byte, int, error) {
+	switch e {
+	case AES128CBC:
+		return oidAES128CBC, 16, nil
+	case AES192CBC:
+		return oidAES192CBC, 24, nil
+	case AES256CBC:
+		return oidAES256CBC, 32, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown content encryption %d", e)
+	}
+}
+
+// Options holds optional settings for Encrypt
+type Options struct {
+	// ContentEncryption defaults to AES256CBC
+	ContentEncryption ContentEncryption
+}
+
+// Recipient encrypts the content key into a RecipientInfo. It is
+// implemented by the recipients of this package.
+type Recipient interface {
+	recipientInfo(ctx context.Context, cek []byte) (*recipientInfo, error)
+}
+
+// Identity decrypts the content key from the RecipientInfo addressed to it.
+// It is implemented by the identities of this package.
+type Identity interface {
+	decryptKey(ctx context.Context, ri *recipientInfo, keySize int) ([]byte, error)
+}
+
+// errIncorrectIdentity is returned by identities for recipient infos of
+// other recipients
+var errIncorrectIdentity = errors.New("recipient info is not for this identity")
+
+const (
+	// RecipientInfo CHOICE tags
+	tagKTRI  = 0
+	tagKEKRI = 2
+)
+
+// recipientInfo is the parsed form of a KeyTransRecipientInfo or a
+// KEKRecipientInfo
+type recipientInfo struct {
+	tag     int
+	version int64
+
+	// rid is the RecipientIdentifier of a KeyTransRecipientInfo, or the
+	// keyIdentifier of the KEKIdentifier of a KEKRecipientInfo
+	rid []byte
+	// ridTag is the tag of the RecipientIdentifier: SEQUENCE for
+	// issuerAndSerialNumber or [0] for subjectKeyIdentifier
+	ridTag cbasn1.Tag
+
+	algorithm    []byte
+	params       []byte
+	encryptedKey []byte
+}
+
+// Encrypt encrypts plaintext into a DER encoded ContentInfo holding an
+// EnvelopedData for the recipients
+func Encrypt(ctx context.Context, plaintext []byte, recipients []Recipient, opts *Options) ([]byte, error) {
+	return encrypt(ctx, rand.Reader, plaintext, recipients, opts)
+}
+
+func encrypt(ctx context.Context, randReader io.Reader, plaintext []byte, recipients []Recipient, opts *Options) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients given")
+	}
+	if opts == nil {
+		opts = new(Options)
+	}
+	oid, keySize, err := opts.ContentEncryption.params()
+	if err != nil {
+		return nil, err
+	}
+
+	cek := make([]byte, keySize)
+	if _, err := io.ReadFull(randReader, cek); err != nil {
+		return nil, fmt.Errorf("error generating content key: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(randReader, iv); err != nil {
+		return nil, fmt.Errorf("error generating IV: %w", err)
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := pad(plaintext)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	// Version 0 is only used when all recipient infos are version 0
+	version := int64(0)
+	infos := make([]*recipientInfo, 0, len(recipients))
+	for _, r := range recipients {
+		ri, err := r.recipientInfo(ctx, cek)
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting content key: %w", err)
+		}
+		if ri.version != 0 {
+			version = 2
+		}
+		infos = append(infos, ri)
+	}
+
+	var b cryptobyte.Builder
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addOID(b, oidEnvelopedData)
+		b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1Int64(version)
+				b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {
+					for _, ri := range infos {
+						addRecipientInfo(b, ri)
+					}
+				})
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					addOID(b, oidData)
+					b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						addOID(b, oid)
+						b.AddASN1OctetString(iv)
+					})
+					b.AddASN1(cbasn1.Tag(0).ContextSpecific(), func(b *cryptobyte.Builder) {
+						b.AddBytes(ciphertext)
+					})
+				})
+			})
+		})
+	})
+	return b.Bytes()
+}
+
+// Decrypt decrypts a DER encoded ContentInfo holding an EnvelopedData with
+// the first identity able to decrypt the content key
+func Decrypt(ctx context.Context, message []byte, identities ...Identity) ([]byte, error) {
+	infos, oid, iv, ciphertext, err := parse(message)
+	if err != nil {
+		return nil, err
+	}
+	var keySize int
+	switch {
+	case bytes.Equal(oid, oidAES128CBC):
+		keySize = 16
+	case bytes.Equal(oid, oidAES192CBC):
+		keySize = 24
+	case bytes.Equal(oid, oidAES256CBC):
+		keySize = 32
+	default:
+		return nil, errors.New("unsupported content encryption algorithm")
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid IV")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted content length")
+	}
+
+	var cek []byte
+Infos:
+	for _, ri := range infos {
+		for _, id := range identities {
+			key, err := id.decryptKey(ctx, ri, keySize)
+			if errors.Is(err, errIncorrectIdentity) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error decrypting content key: %w", err)
+			}
+			cek = key
+			break Infos
+		}
+	}
+	if cek == nil {
+		return nil, errors.New("no identity matched any of the recipients")
+	}
+	if len(cek) != keySize {
+		return nil, fmt.Errorf("invalid content key length %d", len(cek))
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return unpad(plaintext)
+}
+
+// IsEnvelopedData reports whether data starts like a DER encoded ContentInfo
+// of an EnvelopedData
+func IsEnvelopedData(data []byte) bool {
+	s := cryptobyte.String(data)
+	var ci cryptobyte.String
+	var oid []byte
+	return s.ReadASN1(&ci, cbasn1.SEQUENCE) && readOID(&ci, &oid) && bytes.Equal(oid, oidEnvelopedData)
+}
+
+func addRecipientInfo(b *cryptobyte.Builder, ri *recipientInfo) {
+	tag := cbasn1.SEQUENCE
+	if ri.tag == tagKEKRI {
+		tag = cbasn1.Tag(tagKEKRI).Constructed().ContextSpecific()
+	}
+	b.AddASN1(tag, func(b *cryptobyte.Builder) {
+		b.AddASN1Int64(ri.version)
+		if ri.tag == tagKEKRI {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1OctetString(ri.rid)
+			})
+		} else {
+			b.AddASN1(ri.ridTag, func(b *cryptobyte.Builder) {
+				b.AddBytes(ri.rid)
+			})
+		}
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addOID(b, ri.algorithm)
+			if ri.params != nil {
+				b.AddBytes(ri.params)
+			}
+		})
+		b.AddASN1OctetString(ri.encryptedKey)
+	})
+}
+
+var errMalformed = errors.New("malformed EnvelopedData")
+
+// parse returns the recipient infos, content encryption algorithm, IV and
+// encrypted content of a message
+func parse(message []byte) ([]*recipientInfo, []byte, []byte, []byte, error) {
+	s := cryptobyte.String(message)
+	var ci, content, ed cryptobyte.String
+	var oid []byte
+	if !s.ReadASN1(&ci, cbasn1.SEQUENCE) || !s.Empty() {
+		return nil, nil, nil, nil, errors.New("message is not a DER encoded ContentInfo")
+	}
+	if !readOID(&ci, &oid) {
+		return nil, nil, nil, nil, errMalformed
+	}
+	if !bytes.Equal(oid, oidEnvelopedData) {
+		return nil, nil, nil, nil, errors.New("content is not EnvelopedData")
+	}
+	if !ci.ReadASN1(&content, cbasn1.Tag(0).Constructed().ContextSpecific()) || !ci.Empty() ||
+		!content.ReadASN1(&ed, cbasn1.SEQUENCE) || !content.Empty() {
+		return nil, nil, nil, nil, errMalformed
+	}
+
+	var version int64
+	var set, eci cryptobyte.String
+	if !ed.ReadASN1Integer(&version) ||
+		// originatorInfo only carries certificates, which are not needed
+		!ed.SkipOptionalASN1(cbasn1.Tag(0).Constructed().ContextSpecific()) ||
+		!ed.ReadASN1(&set, cbasn1.SET) ||
+		!ed.ReadASN1(&eci, cbasn1.SEQUENCE) {
+		return nil, nil, nil, nil, errMalformed
+	}
+	if version < 0 || version > 4 {
+		return nil, nil, nil, nil, fmt.Errorf("unsupported EnvelopedData version %d", version)
+	}
+
+	var infos []*recipientInfo
+	for !set.Empty() {
+		var raw cryptobyte.String
+		var tag cbasn1.Tag
+		if !set.ReadAnyASN1(&raw, &tag) {
+			return nil, nil, nil, nil, errMalformed
+		}
+		ri, err := parseRecipientInfo(raw, tag)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if ri != nil {
+			infos = append(infos, ri)
+		}
+	}
+	if len(infos) == 0 {
+		return nil, nil, nil, nil, errors.New("message has no supported recipient infos")
+	}
+
+	var alg cryptobyte.String
+	var contentType, algorithm, iv []byte
+	var ciphertext cryptobyte.String
+	var present bool
+	if !readOID(&eci, &contentType) ||
+		!eci.ReadASN1(&alg, cbasn1.SEQUENCE) ||
+		!readOID(&alg, &algorithm) ||
+		!alg.ReadASN1Bytes(&iv, cbasn1.OCTET_STRING) || !alg.Empty() ||
+		!eci.ReadOptionalASN1(&ciphertext, &present, cbasn1.Tag(0).ContextSpecific()) || !eci.Empty() {
+		return nil, nil, nil, nil, errMalformed
+	}
+	if !present {
+		return nil, nil, nil, nil, errors.New("detached content is not supported")
+	}
+	return infos, algorithm, iv, ciphertext, nil
+}
+
+// parseRecipientInfo parses a RecipientInfo, returning nil for the kinds
+// that are not supported
+func parseRecipientInfo(s cryptobyte.String, tag cbasn1.Tag) (*recipientInfo, error) {
+	ri := new(recipientInfo)
+	switch tag {
+	case cbasn1.SEQUENCE:
+		ri.tag = tagKTRI
+		var rid cryptobyte.String
+		if !s.ReadASN1Integer(&ri.version) || !s.ReadAnyASN1(&rid, &ri.ridTag) {
+			return nil, errMalformed
+		}
+		ri.rid = rid
+	case cbasn1.Tag(tagKEKRI).Constructed().ContextSpecific():
+		ri.tag = tagKEKRI
+		var kekid cryptobyte.String
+		if !s.ReadASN1Integer(&ri.version) ||
+			!s.ReadASN1(&kekid, cbasn1.SEQUENCE) ||
+			!kekid.ReadASN1Bytes(&ri.rid, cbasn1.OCTET_STRING) {
+			return nil, errMalformed
+		}
+	default:
+		return nil, nil
+	}
+
+	var alg cryptobyte.String
+	if !s.ReadASN1(&alg, cbasn1.SEQUENCE) ||
+		!readOID(&alg, &ri.algorithm) ||
+		!s.ReadASN1Bytes(&ri.encryptedKey, cbasn1.OCTET_STRING) || !s.Empty() {
+		return nil, errMalformed
+	}
+	if !alg.Empty() {
+		ri.params = alg
+	}
+	return ri, nil
+}
+
+// pad applies PKCS#7 padding, returning a new slice
+func pad(data []byte) []byte {
+	n := aes.BlockSize - len(data)%aes.BlockSize
+	return append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func unpad(data []byte) ([]byte, error) {
+	n := int(data[len(data)-1])
+	if n == 0 || n > aes.BlockSize || n > len(data) {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, errors.New("invalid padding")
+		}
+	}
+	return data[:len(data)-n], nil
+}
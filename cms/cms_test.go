@@ -0,0 +1,199 @@
+package cms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+func testCertificate(t *testing.T) (*x509.Certificate, crypto.Decrypter) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "test"},
+		SubjectKeyId: []byte{1, 2, 3},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return cert, key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	w := NewWrapperRecipient(wrapping.NewTestEnvelopeWrapper([]byte("secret")))
+	kek, err := NewAESKeyWrapRecipient([]byte("kek"), bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	cert, key := testCertificate(t)
+	pkcs1, err := NewCertificateRecipient(cert, PKCS1v15)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	oaep, err := NewCertificateRecipient(cert, OAEPSHA256)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	identity := NewKeyTransIdentity(cert, key)
+
+	cases := []struct {
+		Title      string
+		Recipients []Recipient
+		Identity   Identity
+		Version    int64
+	}{
+		{"wrapper", []Recipient{w}, w, 2},
+		{"aes key wrap", []Recipient{kek}, kek, 2},
+		{"pkcs1", []Recipient{pkcs1}, identity, 0},
+		{"oaep", []Recipient{oaep}, identity, 0},
+		{"second recipient", []Recipient{pkcs1, w}, w, 2},
+	}
+	for _, c := range cases {
+		for _, enc := range []ContentEncryption{AES128CBC, AES192CBC, AES256CBC} {
+			t.Run(fmt.Sprintf("%s/%d", c.Title, enc), func(t *testing.T) {
+				for _, size := range []int{0, 15, 16, 17} {
+					plaintext := bytes.Repeat([]byte{'a'}, size)
+					message, err := Encrypt(ctx, plaintext, c.Recipients, &Options{ContentEncryption: enc})
+					if err != nil {
+						t.Fatalf("err: %s", err)
+					}
+					if !IsEnvelopedData(message) {
+						t.Fatal("not an EnvelopedData")
+					}
+					if v := version(t, message); v != c.Version {
+						t.Fatalf("expected version %d, got %d", c.Version, v)
+					}
+					pt, err := Decrypt(ctx, message, c.Identity)
+					if err != nil {
+						t.Fatalf("err: %s", err)
+					}
+					if !bytes.Equal(pt, plaintext) {
+						t.Fatalf("expected %q, got %q", plaintext, pt)
+					}
+				}
+			})
+		}
+	}
+
+	message, err := Encrypt(ctx, []byte("foo"), []Recipient{w}, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := Decrypt(ctx, message, kek, identity); err == nil {
+		t.Fatal("expected error without a matching identity")
+	}
+	if _, err := Decrypt(ctx, message[:len(message)-1]); err == nil {
+		t.Fatal("expected error for a truncated message")
+	}
+	if _, err := Encrypt(ctx, []byte("foo"), nil, nil); err == nil {
+		t.Fatal("expected error without recipients")
+	}
+}
+
+// version returns the version of the EnvelopedData in message
+func version(t *testing.T, message []byte) int64 {
+	s := cryptobyte.String(message)
+	var ci, content, ed cryptobyte.String
+	var v int64
+	if !s.ReadASN1(&ci, cbasn1.SEQUENCE) || !ci.SkipASN1(cbasn1.OBJECT_IDENTIFIER) ||
+		!ci.ReadASN1(&content, cbasn1.Tag(0).Constructed().ContextSpecific()) ||
+		!content.ReadASN1(&ed, cbasn1.SEQUENCE) || !ed.ReadASN1Integer(&v) {
+		t.Fatal("malformed message")
+	}
+	return v
+}
+
+func TestOpenSSLInterop(t *testing.T) {
+	ctx := context.Background()
+	read := func(name string) []byte {
+		data, err := ioutil.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return data
+	}
+	block, _ := pem.Decode(read("cert.pem"))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	block, _ = pem.Decode(read("key.pem"))
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	identity := NewKeyTransIdentity(cert, key.(crypto.Decrypter))
+	kekBytes, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	kek, err := NewAESKeyWrapRecipient([]byte("key"), kekBytes)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Written by:
+	//   openssl cms -encrypt -outform DER -aes256 -secretkey ... -secretkeyid 6b6579
+	//   openssl cms -encrypt -outform DER -aes128 -binary cert.pem
+	//   openssl cms -encrypt -outform DER -aes256 -binary -recip cert.pem \
+	//     -keyopt rsa_padding_mode:oaep -keyopt rsa_oaep_md:sha256
+	for _, name := range []string{"kek.der", "ktri.der", "oaep.der"} {
+		pt, err := Decrypt(ctx, read(name), kek, identity)
+		if err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+		if string(pt) != "hello from openssl" {
+			t.Fatalf("%s: unexpected plaintext %q", name, pt)
+		}
+	}
+}
+
+func TestKeyWrap(t *testing.T) {
+	// RFC 3394 section 4.6, 256 bits of key data with a 256-bit KEK
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F")
+	expected, _ := hex.DecodeString("28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21")
+
+	wrapped, err := wrapKey(kek, key)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(wrapped, expected) {
+		t.Fatalf("expected %x, got %x", expected, wrapped)
+	}
+	unwrapped, err := unwrapKey(kek, wrapped)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Fatalf("expected %x, got %x", key, unwrapped)
+	}
+	wrapped[0] ^= 1
+	if _, err := unwrapKey(kek, wrapped); err == nil {
+		t.Fatal("expected integrity check error")
+	}
+}
@@ -0,0 +1,383 @@
+package cms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1" // for crypto.SHA1
+	"crypto/sha256"
+	_ "crypto/sha512" // for crypto.SHA384 and crypto.SHA512
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// OIDWrappedBlob identifies the key encryption algorithm of wrapper
+// recipients: the encrypted key is a marshaled EncryptedBlobInfo and the
+// parameters are the wrapper type as a UTF8String. It is a UUID based OID
+// (X.667), which needs no registration.
+const OIDWrappedBlob = "2.25.275026944697345964229074793390837762093"
+
+var oidWrappedBlob = encodeUUIDOID(OIDWrappedBlob)
+
+// encodeUUIDOID encodes an OID of the 2.25 arc, whose second arc does not
+// fit an int
+func encodeUUIDOID(s string) []byte {
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(s, "2.25."), 10)
+	if !ok {
+		panic("invalid UUID OID " + s)
+	}
+	// The first two arcs share the first byte, 2*40+25
+	var arc []byte
+	for last := true; last || n.Sign() > 0; last = false {
+		b := byte(new(big.Int).And(n, big.NewInt(0x7f)).Int64())
+		if !last {
+			b |= 0x80
+		}
+		arc = append([]byte{b}, arc...)
+		n.Rsh(n, 7)
+	}
+	return append([]byte{2*40 + 25}, arc...)
+}
+
+// wrapperAAD binds the blobs of content keys to their purpose
+var wrapperAAD = []byte("cms/" + OIDWrappedBlob)
+
+// WrapperRecipient encrypts content keys with a wrapper, in a
+// KEKRecipientInfo whose key identifier is the wrapper's key ID. It is also
+// the identity that decrypts them.
+type WrapperRecipient struct {
+	w wrapping.Wrapper
+}
+
+var (
+	_ Recipient = (*WrapperRecipient)(nil)
+	_ Identity  = (*WrapperRecipient)(nil)
+)
+
+// NewWrapperRecipient returns a recipient whose content keys are encrypted
+// by w
+func NewWrapperRecipient(w wrapping.Wrapper) *WrapperRecipient {
+	return &WrapperRecipient{w: w}
+}
+
+func (r *WrapperRecipient) params() []byte {
+	var b cryptobyte.Builder
+	b.AddASN1(cbasn1.UTF8String, func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte(r.w.Type()))
+	})
+	return b.BytesOrPanic()
+}
+
+func (r *WrapperRecipient) recipientInfo(ctx context.Context, cek []byte) (*recipientInfo, error) {
+	blob, err := r.w.Encrypt(ctx, cek, wrapperAAD)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return nil, err
+	}
+	keyID := r.w.Type()
+	if blob.KeyInfo != nil && blob.KeyInfo.KeyID != "" {
+		keyID = blob.KeyInfo.KeyID
+	}
+	return &recipientInfo{
+		tag:          tagKEKRI,
+		version:      4,
+		rid:          []byte(keyID),
+		algorithm:    oidWrappedBlob,
+		params:       r.params(),
+		encryptedKey: encryptedKey,
+	}, nil
+}
+
+func (r *WrapperRecipient) decryptKey(ctx context.Context, ri *recipientInfo, _ int) ([]byte, error) {
+	if ri.tag != tagKEKRI || !bytes.Equal(ri.algorithm, oidWrappedBlob) || !bytes.Equal(ri.params, r.params()) {
+		return nil, errIncorrectIdentity
+	}
+	blob, _, err := format.Unmarshal(ri.encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	return r.w.Decrypt(ctx, blob, wrapperAAD)
+}
+
+var (
+	oidAES128Wrap = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5})
+	oidAES192Wrap = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25})
+	oidAES256Wrap = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45})
+)
+
+// AESKeyWrapRecipient encrypts content keys with AES key wrap (RFC 3394
+// and RFC 3565) under a shared key-encryption key, in a KEKRecipientInfo. It
+// is also the identity that decrypts them.
+type AESKeyWrapRecipient struct {
+	keyID, kek []byte
+	algorithm  []byte
+}
+
+var (
+	_ Recipient = (*AESKeyWrapRecipient)(nil)
+	_ Identity  = (*AESKeyWrapRecipient)(nil)
+)
+
+// NewAESKeyWrapRecipient returns a recipient for a 16, 24 or 32 byte
+// key-encryption key, identified by keyID
+func NewAESKeyWrapRecipient(keyID, kek []byte) (*AESKeyWrapRecipient, error) {
+	r := &AESKeyWrapRecipient{keyID: keyID, kek: kek}
+	switch len(kek) {
+	case 16:
+		r.algorithm = oidAES128Wrap
+	case 24:
+		r.algorithm = oidAES192Wrap
+	case 32:
+		r.algorithm = oidAES256Wrap
+	default:
+		return nil, fmt.Errorf("invalid key-encryption key length %d", len(kek))
+	}
+	return r, nil
+}
+
+func (r *AESKeyWrapRecipient) recipientInfo(_ context.Context, cek []byte) (*recipientInfo, error) {
+	encryptedKey, err := wrapKey(r.kek, cek)
+	if err != nil {
+		return nil, err
+	}
+	return &recipientInfo{
+		tag:          tagKEKRI,
+		version:      4,
+		rid:          r.keyID,
+		algorithm:    r.algorithm,
+		encryptedKey: encryptedKey,
+	}, nil
+}
+
+func (r *AESKeyWrapRecipient) decryptKey(_ context.Context, ri *recipientInfo, _ int) ([]byte, error) {
+	if ri.tag != tagKEKRI || !bytes.Equal(ri.algorithm, r.algorithm) || !bytes.Equal(ri.rid, r.keyID) {
+		return nil, errIncorrectIdentity
+	}
+	return unwrapKey(r.kek, ri.encryptedKey)
+}
+
+// Padding selects the RSA encryption scheme of certificate recipients
+type Padding int
+
+const (
+	// PKCS1v15 is rsaEncryption, which every implementation supports
+	PKCS1v15 Padding = iota
+	// OAEPSHA256 is RSAES-OAEP with SHA-256 (RFC 3560)
+	OAEPSHA256
+)
+
+var (
+	oidRSAEncryption = encodeOID(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1})
+	oidRSAOAEP       = encodeOID(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7})
+	oidMGF1          = encodeOID(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8})
+
+	oidSHA1   = encodeOID(asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26})
+	oidSHA256 = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1})
+	oidSHA384 = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2})
+	oidSHA512 = encodeOID(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3})
+)
+
+// CertificateRecipient encrypts content keys to the RSA public key of an
+// X.509 certificate, in a KeyTransRecipientInfo identified by the
+// certificate's issuer and serial number
+type CertificateRecipient struct {
+	cert    *x509.Certificate
+	key     *rsa.PublicKey
+	padding Padding
+}
+
+var _ Recipient = (*CertificateRecipient)(nil)
+
+// NewCertificateRecipient returns a recipient for cert, which must hold an
+// RSA public key
+func NewCertificateRecipient(cert *x509.Certificate, padding Padding) (*CertificateRecipient, error) {
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+	}
+	if padding != PKCS1v15 && padding != OAEPSHA256 {
+		return nil, fmt.Errorf("unknown padding %d", padding)
+	}
+	return &CertificateRecipient{cert: cert, key: key, padding: padding}, nil
+}
+
+func (r *CertificateRecipient) recipientInfo(_ context.Context, cek []byte) (*recipientInfo, error) {
+	ri := &recipientInfo{
+		tag:     tagKTRI,
+		version: 0,
+		rid:     issuerAndSerialNumber(r.cert),
+		ridTag:  cbasn1.SEQUENCE,
+	}
+	var err error
+	switch r.padding {
+	case PKCS1v15:
+		ri.algorithm = oidRSAEncryption
+		ri.params = []byte{0x05, 0x00}
+		ri.encryptedKey, err = rsa.EncryptPKCS1v15(rand.Reader, r.key, cek)
+	case OAEPSHA256:
+		ri.algorithm = oidRSAOAEP
+		ri.params = oaepSHA256Params()
+		ri.encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, r.key, cek, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ri, nil
+}
+
+// KeyTransIdentity decrypts content keys encrypted to a certificate, with
+// the matching private key. The key may live in a hardware module.
+type KeyTransIdentity struct {
+	cert *x509.Certificate
+	key  crypto.Decrypter
+}
+
+var _ Identity = (*KeyTransIdentity)(nil)
+
+// NewKeyTransIdentity returns an identity for the certificate and its RSA
+// private key
+func NewKeyTransIdentity(cert *x509.Certificate, key crypto.Decrypter) *KeyTransIdentity {
+	return &KeyTransIdentity{cert: cert, key: key}
+}
+
+func (i *KeyTransIdentity) decryptKey(_ context.Context, ri *recipientInfo, keySize int) ([]byte, error) {
+	if ri.tag != tagKTRI {
+		return nil, errIncorrectIdentity
+	}
+	switch ri.ridTag {
+	case cbasn1.SEQUENCE:
+		if !bytes.Equal(ri.rid, issuerAndSerialNumber(i.cert)) {
+			return nil, errIncorrectIdentity
+		}
+	case cbasn1.Tag(0).ContextSpecific():
+		if len(i.cert.SubjectKeyId) == 0 || !bytes.Equal(ri.rid, i.cert.SubjectKeyId) {
+			return nil, errIncorrectIdentity
+		}
+	default:
+		return nil, errIncorrectIdentity
+	}
+
+	switch {
+	case bytes.Equal(ri.algorithm, oidRSAEncryption):
+		// A wrong key is replaced by a random one rather than reported, to
+		// not hand out a padding oracle
+		return i.key.Decrypt(rand.Reader, ri.encryptedKey, &rsa.PKCS1v15DecryptOptions{SessionKeyLen: keySize})
+	case bytes.Equal(ri.algorithm, oidRSAOAEP):
+		hash, err := parseOAEPParams(ri.params)
+		if err != nil {
+			return nil, err
+		}
+		return i.key.Decrypt(rand.Reader, ri.encryptedKey, &rsa.OAEPOptions{Hash: hash})
+	default:
+		return nil, errors.New("unsupported key transport algorithm")
+	}
+}
+
+// issuerAndSerialNumber returns the content of the IssuerAndSerialNumber
+// SEQUENCE for cert
+func issuerAndSerialNumber(cert *x509.Certificate) []byte {
+	var b cryptobyte.Builder
+	b.AddBytes(cert.RawIssuer)
+	b.AddASN1BigInt(cert.SerialNumber)
+	return b.BytesOrPanic()
+}
+
+func oaepSHA256Params() []byte {
+	var b cryptobyte.Builder
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addOID(b, oidSHA256)
+			})
+		})
+		b.AddASN1(cbasn1.Tag(1).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addOID(b, oidMGF1)
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					addOID(b, oidSHA256)
+				})
+			})
+		})
+	})
+	return b.BytesOrPanic()
+}
+
+// parseOAEPParams returns the hash of RSAES-OAEP-params, which must be used
+// for MGF1 as well. Absent parameters select SHA-1.
+func parseOAEPParams(params []byte) (crypto.Hash, error) {
+	if params == nil {
+		return crypto.SHA1, nil
+	}
+	s := cryptobyte.String(params)
+	var seq, hashAlg, mgfAlg cryptobyte.String
+	var hasHash, hasMGF bool
+	if !s.ReadASN1(&seq, cbasn1.SEQUENCE) || !s.Empty() ||
+		!seq.ReadOptionalASN1(&hashAlg, &hasHash, cbasn1.Tag(0).Constructed().ContextSpecific()) ||
+		!seq.ReadOptionalASN1(&mgfAlg, &hasMGF, cbasn1.Tag(1).Constructed().ContextSpecific()) ||
+		// A pSourceFunc other than the default empty label is not supported
+		!seq.Empty() {
+		return 0, errors.New("unsupported RSAES-OAEP parameters")
+	}
+
+	hash := crypto.SHA1
+	if hasHash {
+		var err error
+		if hash, err = parseHashAlgorithm(hashAlg); err != nil {
+			return 0, err
+		}
+	}
+	mgfHash := crypto.SHA1
+	if hasMGF {
+		var alg, inner cryptobyte.String
+		var oid []byte
+		if !mgfAlg.ReadASN1(&alg, cbasn1.SEQUENCE) || !readOID(&alg, &oid) || !bytes.Equal(oid, oidMGF1) {
+			return 0, errors.New("unsupported RSAES-OAEP mask generation function")
+		}
+		if !alg.ReadASN1Element(&inner, cbasn1.SEQUENCE) {
+			return 0, errors.New("malformed RSAES-OAEP parameters")
+		}
+		var err error
+		if mgfHash, err = parseHashAlgorithm(inner); err != nil {
+			return 0, err
+		}
+	}
+	if mgfHash != hash {
+		return 0, errors.New("RSAES-OAEP with different hashes is not supported")
+	}
+	return hash, nil
+}
+
+// parseHashAlgorithm parses an AlgorithmIdentifier SEQUENCE of a hash
+func parseHashAlgorithm(s cryptobyte.String) (crypto.Hash, error) {
+	var alg cryptobyte.String
+	var oid []byte
+	if !s.ReadASN1(&alg, cbasn1.SEQUENCE) || !readOID(&alg, &oid) {
+		return 0, errors.New("malformed hash algorithm")
+	}
+	switch {
+	case bytes.Equal(oid, oidSHA1):
+		return crypto.SHA1, nil
+	case bytes.Equal(oid, oidSHA256):
+		return crypto.SHA256, nil
+	case bytes.Equal(oid, oidSHA384):
+		return crypto.SHA384, nil
+	case bytes.Equal(oid, oidSHA512):
+		return crypto.SHA512, nil
+	default:
+		return 0, errors.New("unsupported hash algorithm")
+	}
+}
@@ -0,0 +1,287 @@
+package cose
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// This file holds the subset of CBOR (RFC 8949) that COSE messages need.
+// Values are represented as uint64 for unsigned integers, int64 for negative
+// ones, []byte, string, bool, nil, []interface{}, cborMap and cborTag.
+// Encoding is deterministic: integers and lengths take their shortest form
+// and map entries are sorted by their encoded keys. Indefinite lengths and
+// floating point values are not supported.
+
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+const (
+	simpleFalse = 20
+	simpleTrue  = 21
+	simpleNull  = 22
+)
+
+// maxDepth bounds the nesting of decoded arrays, maps and tags
+const maxDepth = 16
+
+// cborMap is a map with integer or text string keys. Integer keys are int64
+// whatever their sign.
+type cborMap map[interface{}]interface{}
+
+// cborTag is a tagged value
+type cborTag struct {
+	Number uint64
+	Value  interface{}
+}
+
+// appendHead appends the initial byte and argument of an item
+func appendHead(b []byte, major byte, n uint64) []byte {
+	m := major << 5
+	switch {
+	case n < 24:
+		return append(b, m|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, m|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(append(b, m|25), byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		b = append(b, m|26)
+		return append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		b = append(b, m|27)
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], n)
+		return append(b, buf[:]...)
+	}
+}
+
+// appendValue appends the encoding of v
+func appendValue(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case uint64:
+		return appendHead(b, majorUint, v), nil
+	case int:
+		return appendValue(b, int64(v))
+	case int64:
+		if v < 0 {
+			return appendHead(b, majorNegInt, uint64(-1-v)), nil
+		}
+		return appendHead(b, majorUint, uint64(v)), nil
+	case []byte:
+		return append(appendHead(b, majorBytes, uint64(len(v))), v...), nil
+	case string:
+		return append(appendHead(b, majorText, uint64(len(v))), v...), nil
+	case bool:
+		if v {
+			return append(b, majorSimple<<5|simpleTrue), nil
+		}
+		return append(b, majorSimple<<5|simpleFalse), nil
+	case nil:
+		return append(b, majorSimple<<5|simpleNull), nil
+	case []interface{}:
+		b = appendHead(b, majorArray, uint64(len(v)))
+		var err error
+		for _, e := range v {
+			if b, err = appendValue(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case cborMap:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, len(v))
+		for k, e := range v {
+			switch k.(type) {
+			case int, int64, string:
+			default:
+				return nil, fmt.Errorf("unsupported map key type %T", k)
+			}
+			key, err := appendValue(nil, k)
+			if err != nil {
+				return nil, err
+			}
+			value, err := appendValue(nil, e)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{key, value})
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+		b = appendHead(b, majorMap, uint64(len(entries)))
+		for _, e := range entries {
+			b = append(append(b, e.key...), e.value...)
+		}
+		return b, nil
+	case cborTag:
+		return appendValue(appendHead(b, majorTag, v.Number), v.Value)
+	default:
+		return nil, fmt.Errorf("unsupported CBOR value type %T", v)
+	}
+}
+
+// decodeValue decodes exactly one item from data
+func decodeValue(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, errors.New("unexpected data after CBOR item")
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	off  int
+}
+
+var errTruncated = errors.New("truncated CBOR item")
+
+func (d *decoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, errTruncated
+	}
+	ib := d.data[d.off]
+	d.off++
+	major, info := ib>>5, ib&0x1f
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == 31:
+		return 0, 0, errors.New("indefinite-length CBOR items are not supported")
+	default:
+		return 0, 0, fmt.Errorf("invalid CBOR additional information %d", info)
+	}
+	if len(d.data)-d.off < size {
+		return 0, 0, errTruncated
+	}
+	var n uint64
+	for _, c := range d.data[d.off : d.off+size] {
+		n = n<<8 | uint64(c)
+	}
+	d.off += size
+	return major, n, nil
+}
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, errTruncated
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("CBOR item is nested too deeply")
+	}
+	major, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorUint:
+		return n, nil
+	case majorNegInt:
+		if n > math.MaxInt64 {
+			return nil, errors.New("negative integer out of range")
+		}
+		return -1 - int64(n), nil
+	case majorBytes:
+		b, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case majorText:
+		b, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(b) {
+			return nil, errors.New("text string is not valid UTF-8")
+		}
+		return string(b), nil
+	case majorArray:
+		// Every item takes at least a byte
+		if n > uint64(len(d.data)-d.off) {
+			return nil, errTruncated
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case majorMap:
+		if n > uint64(len(d.data)-d.off)/2 {
+			return nil, errTruncated
+		}
+		m := make(cborMap, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key := k.(type) {
+			case uint64:
+				if key > math.MaxInt64 {
+					return nil, errors.New("map key out of range")
+				}
+				k = int64(key)
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("unsupported map key type %T", k)
+			}
+			if _, ok := m[k]; ok {
+				return nil, fmt.Errorf("duplicate map key %v", k)
+			}
+			if m[k], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case majorTag:
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTag{Number: n, Value: v}, nil
+	default:
+		switch n {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unsupported CBOR simple value or float %d", n)
+	}
+}
@@ -0,0 +1,550 @@
+// Package cose converts encrypted blobs to and from CBOR Object Signing and
+// Encryption messages (RFC 8152), for systems that standardize on COSE
+// rather than protobuf or JWE.
+//
+// Envelope blobs become COSE_Encrypt messages with a single recipient: the
+// content is AES-GCM encrypted under A256GCM, and the recipient carries the
+// wrapped data key under the private-use algorithm AlgorithmKMS. A consumer
+// unwraps it by calling the KMS named by the "kid" and HeaderWrapper header
+// parameters. Blobs of the aead wrapper use its key directly and become
+// COSE_Encrypt0 messages.
+//
+// Everything else in the blob's KeyInfo, and its HMAC, travel in the
+// protected header of the content layer, under the same text string labels
+// as the jwe package uses.
+package cose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/blobheader"
+)
+
+const (
+	// AlgorithmKMS is the "alg" of recipients whose content encryption key
+	// was wrapped by a KMS. It is taken from the private-use range.
+	AlgorithmKMS = -65537
+
+	// AlgorithmA128GCM, AlgorithmA192GCM and AlgorithmA256GCM are the
+	// supported content encryption algorithms. Envelope blobs always use
+	// 256 bit keys.
+	AlgorithmA128GCM = 1
+	AlgorithmA192GCM = 2
+	AlgorithmA256GCM = 3
+)
+
+// Tags of the COSE messages that Marshal produces
+const (
+	TagEncrypt0 = 16
+	TagEncrypt  = 96
+)
+
+// Header parameters carrying the fields of a blob that COSE has no place
+// for. Their labels are text strings, the same as the jwe package's names.
+const (
+	HeaderWrapper       = blobheader.Wrapper
+	HeaderMechanism     = blobheader.Mechanism
+	HeaderHMACKeyID     = blobheader.HMACKeyID
+	HeaderHMACMechanism = blobheader.HMACMechanism
+	HeaderHMAC          = blobheader.HMAC
+	HeaderFlags         = blobheader.Flags
+	HeaderValuePath     = blobheader.ValuePath
+	HeaderWrapped       = blobheader.Wrapped
+)
+
+// Common header parameter labels of RFC 8152 section 3.1
+const (
+	labelAlg  = 1
+	labelCrit = 2
+	labelKID  = 4
+	labelIV   = 5
+)
+
+const (
+	ivSize  = 12
+	tagSize = 16
+)
+
+// Options configures Marshal and Encrypt. It is valid to pass nil Options.
+type Options struct {
+	// WrapperType is the type of the wrapper that produced the blob,
+	// recorded in the HeaderWrapper header parameter. Blobs without a
+	// wrapped key can only be marshaled when it is wrapping.AEAD. Encrypt
+	// sets it from the wrapper.
+	WrapperType string
+
+	// Algorithm is the content encryption algorithm of aead wrapper blobs,
+	// which depends on the size of the wrapper's key. It defaults to
+	// AlgorithmA256GCM.
+	Algorithm int
+
+	// Untagged omits the leading COSE_Encrypt or COSE_Encrypt0 tag, for
+	// protocols that identify the message type by other means
+	Untagged bool
+}
+
+// Marshal serializes blob as a COSE_Encrypt or COSE_Encrypt0 message.
+//
+// A blob's ciphertext is authenticated with the additional data given to
+// the wrapper, while a COSE message's is authenticated with its
+// Enc_structure. Generic COSE libraries can therefore only decrypt blobs
+// produced by Encrypt; Marshal is for carrying existing blobs, which must
+// be decrypted with the original additional data.
+func Marshal(blob *wrapping.EncryptedBlobInfo, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	protected, err := encodeHeader(blob, opts)
+	if err != nil {
+		return nil, err
+	}
+	return serialize(blob, protected, opts)
+}
+
+// Unmarshal parses a tagged or untagged COSE_Encrypt or COSE_Encrypt0
+// message into a blob. COSE_Encrypt messages must have a single recipient.
+func Unmarshal(data []byte) (*wrapping.EncryptedBlobInfo, error) {
+	p, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.blob, nil
+}
+
+// Encrypt encrypts plaintext with w and serializes the result as a COSE
+// message. Unlike Marshal, the wrapper's additional data is the
+// Enc_structure of RFC 8152 section 5.3, covering the protected header and
+// externalAAD, so the result can be decrypted by any COSE implementation
+// holding the content encryption key. externalAAD is not part of the
+// message and must be given again to Decrypt.
+func Encrypt(ctx context.Context, w wrapping.Wrapper, plaintext, externalAAD []byte, opts *Options) ([]byte, error) {
+	if w == nil {
+		return nil, errors.New("wrapper is nil")
+	}
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.WrapperType = w.Type()
+
+	// The protected header of the content layer is part of its
+	// Enc_structure, which the wrapper authenticates, so it describes the
+	// blob expected from the wrapper; whether that blob has a recipient
+	// selects the context of the Enc_structure
+	expected := blobheader.Expected(w)
+	protected, err := encodeHeader(expected, &o)
+	if err != nil {
+		return nil, err
+	}
+	recipient := len(expected.KeyInfo.WrappedKey) != 0
+	for attempt := 0; ; attempt++ {
+		aad, err := encStructure(recipient, protected, externalAAD)
+		if err != nil {
+			return nil, err
+		}
+		blob, err := w.Encrypt(ctx, plaintext, aad)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := encodeHeader(blob, &o)
+		if err != nil {
+			return nil, err
+		}
+		if string(actual) == string(protected) {
+			return serialize(blob, protected, &o)
+		}
+		if attempt > 0 {
+			return nil, errors.New("protected header of the encrypted blob changed between attempts")
+		}
+		protected = actual
+		recipient = blob.KeyInfo != nil && len(blob.KeyInfo.WrappedKey) != 0
+	}
+}
+
+// Decrypt parses a COSE message produced by Encrypt and decrypts it with w,
+// given the same externalAAD
+func Decrypt(ctx context.Context, w wrapping.Wrapper, data, externalAAD []byte) ([]byte, error) {
+	if w == nil {
+		return nil, errors.New("wrapper is nil")
+	}
+	p, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	aad, err := encStructure(p.recipient, p.protected, externalAAD)
+	if err != nil {
+		return nil, err
+	}
+	return w.Decrypt(ctx, p.blob, aad)
+}
+
+// encStructure encodes the Enc_structure of RFC 8152 section 5.3 for a
+// COSE_Encrypt message, or a COSE_Encrypt0 one if recipient is false
+func encStructure(recipient bool, protected, externalAAD []byte) ([]byte, error) {
+	context := "Encrypt0"
+	if recipient {
+		context = "Encrypt"
+	}
+	if externalAAD == nil {
+		externalAAD = []byte{}
+	}
+	return appendValue(nil, []interface{}{context, protected, externalAAD})
+}
+
+// encodeHeader builds the protected header describing blob and returns its
+// encoding. Map keys are sorted, so equal headers always encode
+// identically.
+func encodeHeader(blob *wrapping.EncryptedBlobInfo, opts *Options) ([]byte, error) {
+	if blob == nil {
+		return nil, errors.New("blob is nil")
+	}
+	key := blob.KeyInfo
+	if key == nil {
+		key = new(wrapping.KeyInfo)
+	}
+
+	header := cborMap{}
+	switch {
+	case len(key.WrappedKey) != 0:
+		header[labelAlg] = AlgorithmA256GCM
+	case opts.WrapperType == wrapping.AEAD:
+		header[labelAlg] = AlgorithmA256GCM
+		if opts.Algorithm != 0 {
+			if _, err := keySize(int64(opts.Algorithm)); err != nil {
+				return nil, err
+			}
+			header[labelAlg] = opts.Algorithm
+		}
+	default:
+		return nil, errors.New("blob has no wrapped key; only envelope and aead wrapper blobs can be serialized")
+	}
+
+	if key.KeyID != "" {
+		header[labelKID] = []byte(key.KeyID)
+	}
+	if opts.WrapperType != "" {
+		header[HeaderWrapper] = opts.WrapperType
+	}
+	if key.Mechanism != 0 {
+		header[HeaderMechanism] = key.Mechanism
+	}
+	if key.HMACKeyID != "" {
+		header[HeaderHMACKeyID] = key.HMACKeyID
+	}
+	if key.HMACMechanism != 0 {
+		header[HeaderHMACMechanism] = key.HMACMechanism
+	}
+	if key.Flags != 0 {
+		header[HeaderFlags] = key.Flags
+	}
+	if len(blob.HMAC) != 0 {
+		header[HeaderHMAC] = blob.HMAC
+	}
+	if blob.ValuePath != "" {
+		header[HeaderValuePath] = blob.ValuePath
+	}
+	if blob.Wrapped {
+		header[HeaderWrapped] = true
+	}
+
+	raw, err := appendValue(nil, header)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding protected header: %w", err)
+	}
+	return raw, nil
+}
+
+// serialize writes the parts of blob as a COSE message with the encoded
+// protected header
+func serialize(blob *wrapping.EncryptedBlobInfo, protected []byte, opts *Options) ([]byte, error) {
+	var encryptedKey, iv, ciphertext []byte
+	if blob.KeyInfo != nil {
+		encryptedKey = blob.KeyInfo.WrappedKey
+	}
+	ciphertext = blob.Ciphertext
+	if len(encryptedKey) != 0 {
+		iv = blob.IV
+	} else {
+		// The aead wrapper prefixes its ciphertexts with the IV
+		if len(ciphertext) < ivSize {
+			return nil, errors.New("ciphertext is too short to hold an IV")
+		}
+		iv, ciphertext = ciphertext[:ivSize], ciphertext[ivSize:]
+	}
+	if len(iv) != ivSize {
+		return nil, fmt.Errorf("invalid IV length: expected %d, got %d", ivSize, len(iv))
+	}
+	if len(ciphertext) < tagSize {
+		return nil, errors.New("ciphertext is too short to hold an authentication tag")
+	}
+
+	message := []interface{}{protected, cborMap{labelIV: iv}, ciphertext}
+	tag := uint64(TagEncrypt0)
+	if len(encryptedKey) != 0 {
+		recipient := []interface{}{[]byte{}, cborMap{labelAlg: AlgorithmKMS}, encryptedKey}
+		message = append(message, []interface{}{recipient})
+		tag = TagEncrypt
+	}
+
+	var v interface{} = message
+	if !opts.Untagged {
+		v = cborTag{Number: tag, Value: message}
+	}
+	out, err := appendValue(nil, v)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding COSE message: %w", err)
+	}
+	return out, nil
+}
+
+// parsed is a COSE message taken apart
+type parsed struct {
+	blob      *wrapping.EncryptedBlobInfo
+	protected []byte
+	recipient bool
+}
+
+func parse(data []byte) (*parsed, error) {
+	v, err := decodeValue(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding COSE message: %w", err)
+	}
+	tag := uint64(0)
+	if t, ok := v.(cborTag); ok {
+		tag, v = t.Number, t.Value
+		if tag != TagEncrypt0 && tag != TagEncrypt {
+			return nil, fmt.Errorf("unsupported COSE message tag %d", tag)
+		}
+	}
+	message, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("COSE message is not an array")
+	}
+	switch {
+	case len(message) == 3 && tag != TagEncrypt:
+	case len(message) == 4 && tag != TagEncrypt0:
+	default:
+		return nil, fmt.Errorf("COSE message has %d elements", len(message))
+	}
+
+	p := &parsed{
+		recipient: len(message) == 4,
+	}
+	protected, ok := message[0].([]byte)
+	if !ok {
+		return nil, errors.New("protected header is not a byte string")
+	}
+	p.protected = protected
+	var alg int64
+	if p.blob, alg, err = decodeHeader(protected); err != nil {
+		return nil, err
+	}
+
+	unprotected, ok := message[1].(cborMap)
+	if !ok {
+		return nil, errors.New("unprotected header is not a map")
+	}
+	var iv []byte
+	for label, value := range unprotected {
+		if label != int64(labelIV) {
+			return nil, fmt.Errorf("unsupported unprotected header parameter %v", label)
+		}
+		iv, _ = value.([]byte)
+	}
+	if len(iv) != ivSize {
+		return nil, fmt.Errorf("invalid IV length: expected %d, got %d", ivSize, len(iv))
+	}
+	ciphertext, ok := message[2].([]byte)
+	if !ok {
+		return nil, errors.New("ciphertext is not a byte string")
+	}
+	if len(ciphertext) < tagSize {
+		return nil, errors.New("ciphertext is too short to hold an authentication tag")
+	}
+
+	if !p.recipient {
+		p.blob.Ciphertext = append(iv, ciphertext...)
+		return p, nil
+	}
+	if alg != AlgorithmA256GCM {
+		return nil, fmt.Errorf("COSE_Encrypt messages must use algorithm %d", AlgorithmA256GCM)
+	}
+	encryptedKey, err := parseRecipients(message[3])
+	if err != nil {
+		return nil, err
+	}
+	p.blob.KeyInfo.WrappedKey = encryptedKey
+	p.blob.IV = iv
+	p.blob.Ciphertext = ciphertext
+	return p, nil
+}
+
+// parseRecipients returns the encrypted key of the single AlgorithmKMS
+// recipient
+func parseRecipients(v interface{}) ([]byte, error) {
+	recipients, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("recipients are not an array")
+	}
+	if len(recipients) != 1 {
+		return nil, fmt.Errorf("COSE message has %d recipients; only one is supported", len(recipients))
+	}
+	recipient, ok := recipients[0].([]interface{})
+	if !ok || len(recipient) != 3 {
+		return nil, errors.New("recipient is not an array of 3 elements")
+	}
+	if protected, ok := recipient[0].([]byte); !ok || len(protected) != 0 {
+		return nil, errors.New("recipient protected header parameters are not supported")
+	}
+	unprotected, ok := recipient[1].(cborMap)
+	if !ok {
+		return nil, errors.New("recipient unprotected header is not a map")
+	}
+	if len(unprotected) != 1 {
+		return nil, errors.New("recipient header must only hold the algorithm")
+	}
+	if alg, _ := integer(unprotected[int64(labelAlg)]); alg != AlgorithmKMS {
+		return nil, fmt.Errorf("unsupported recipient algorithm; must be %d", AlgorithmKMS)
+	}
+	encryptedKey, ok := recipient[2].([]byte)
+	if !ok || len(encryptedKey) == 0 {
+		return nil, errors.New("recipient has no encrypted key")
+	}
+	return encryptedKey, nil
+}
+
+// decodeHeader parses the encoded protected header into a blob holding its
+// fields, and returns the content encryption algorithm it names
+func decodeHeader(protected []byte) (*wrapping.EncryptedBlobInfo, int64, error) {
+	v, err := decodeValue(protected)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error decoding protected header: %w", err)
+	}
+	header, ok := v.(cborMap)
+	if !ok {
+		return nil, 0, errors.New("protected header is not a map")
+	}
+
+	blob := &wrapping.EncryptedBlobInfo{
+		KeyInfo: new(wrapping.KeyInfo),
+	}
+	str := func(label string) (string, error) {
+		v, ok := header[label]
+		if !ok {
+			return "", nil
+		}
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("header parameter %q is not a text string", label)
+		}
+		return s, nil
+	}
+	number := func(label string) (uint64, error) {
+		v, ok := header[label]
+		if !ok {
+			return 0, nil
+		}
+		n, ok := v.(uint64)
+		if !ok {
+			return 0, fmt.Errorf("header parameter %q is not an unsigned integer", label)
+		}
+		return n, nil
+	}
+
+	known := map[interface{}]bool{int64(labelAlg): true, int64(labelKID): true}
+	for _, label := range []string{
+		HeaderWrapper, HeaderMechanism, HeaderHMACKeyID, HeaderHMACMechanism,
+		HeaderHMAC, HeaderFlags, HeaderValuePath, HeaderWrapped,
+	} {
+		known[label] = true
+	}
+	for label := range header {
+		if label == int64(labelCrit) {
+			return nil, 0, errors.New("critical header parameters are not supported")
+		}
+		if !known[label] {
+			return nil, 0, fmt.Errorf("unsupported protected header parameter %v", label)
+		}
+	}
+
+	alg, ok := integer(header[int64(labelAlg)])
+	if !ok {
+		return nil, 0, errors.New("protected header has no integer algorithm")
+	}
+	if _, err := keySize(alg); err != nil {
+		return nil, 0, err
+	}
+
+	if v, ok := header[int64(labelKID)]; ok {
+		kid, ok := v.([]byte)
+		if !ok {
+			return nil, 0, errors.New("header parameter kid is not a byte string")
+		}
+		blob.KeyInfo.KeyID = string(kid)
+	}
+	if blob.KeyInfo.HMACKeyID, err = str(HeaderHMACKeyID); err != nil {
+		return nil, 0, err
+	}
+	if blob.ValuePath, err = str(HeaderValuePath); err != nil {
+		return nil, 0, err
+	}
+	if _, err = str(HeaderWrapper); err != nil {
+		return nil, 0, err
+	}
+	if blob.KeyInfo.Mechanism, err = number(HeaderMechanism); err != nil {
+		return nil, 0, err
+	}
+	if blob.KeyInfo.HMACMechanism, err = number(HeaderHMACMechanism); err != nil {
+		return nil, 0, err
+	}
+	if blob.KeyInfo.Flags, err = number(HeaderFlags); err != nil {
+		return nil, 0, err
+	}
+	if v, ok := header[HeaderHMAC]; ok {
+		if blob.HMAC, ok = v.([]byte); !ok {
+			return nil, 0, fmt.Errorf("header parameter %q is not a byte string", HeaderHMAC)
+		}
+	}
+	if v, ok := header[HeaderWrapped]; ok {
+		if blob.Wrapped, ok = v.(bool); !ok {
+			return nil, 0, fmt.Errorf("header parameter %q is not a boolean", HeaderWrapped)
+		}
+	}
+	return blob, alg, nil
+}
+
+// integer returns a decoded CBOR integer as an int64
+func integer(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// keySize returns the key size of a supported content encryption algorithm
+func keySize(alg int64) (int, error) {
+	switch alg {
+	case AlgorithmA128GCM:
+		return 16, nil
+	case AlgorithmA192GCM:
+		return 24, nil
+	case AlgorithmA256GCM:
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("unsupported content encryption algorithm %d; must be %d, %d or %d",
+			alg, AlgorithmA128GCM, AlgorithmA192GCM, AlgorithmA256GCM)
+	}
+}
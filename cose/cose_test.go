@@ -0,0 +1,327 @@
+package cose
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCBOR(t *testing.T) {
+	for _, tc := range []struct {
+		Title string
+		Value interface{}
+		Hex   string
+	}{
+		// Examples of RFC 8949 appendix A
+		{"Zero", uint64(0), "00"},
+		{"Small", uint64(23), "17"},
+		{"OneByte", uint64(24), "1818"},
+		{"TwoBytes", uint64(1000), "1903e8"},
+		{"FourBytes", uint64(1000000), "1a000f4240"},
+		{"EightBytes", uint64(1000000000000), "1b000000e8d4a51000"},
+		{"Max", uint64(18446744073709551615), "1bffffffffffffffff"},
+		{"Negative", int64(-1000), "3903e7"},
+		{"Bytes", []byte{1, 2, 3, 4}, "4401020304"},
+		{"Text", "ü", "62c3bc"},
+		{"Array", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}}, "8201820203"},
+		{"Map", cborMap{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}, "a26161016162820203"},
+		{"IntMap", cborMap{int64(1): uint64(2), int64(3): uint64(4)}, "a201020304"},
+		{"Tag", cborTag{Number: 1, Value: uint64(1363896240)}, "c11a514b67b0"},
+		{"Simple", []interface{}{false, true, nil}, "83f4f5f6"},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			out, err := appendValue(nil, tc.Value)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if hex.EncodeToString(out) != tc.Hex {
+				t.Fatalf("expected %s, got %x", tc.Hex, out)
+			}
+			v, err := decodeValue(out)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			again, err := appendValue(nil, v)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !bytes.Equal(again, out) {
+				t.Fatalf("expected %x, got %x", out, again)
+			}
+		})
+	}
+
+	// Map keys are sorted by their encoding, shorter first
+	out, err := appendValue(nil, cborMap{"kms_wrapper": "x", AlgorithmKMS: uint64(0), int64(10): uint64(0), int64(1): uint64(0)})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := "a4" + "0100" + "0a00" + "3a0001000000" + "6b6b6d735f77726170706572" + "6178"; hex.EncodeToString(out) != expected {
+		t.Fatalf("unexpected encoding %x", out)
+	}
+
+	for _, input := range []string{
+		"",
+		"18",              // truncated
+		"5f",              // indefinite length
+		"fb3ff199999999",  // float
+		"a20101" + "0102", // duplicate key
+		"62c328",          // invalid UTF-8
+		"9bffffffffffffffff",
+		"0000", // trailing data
+	} {
+		data, _ := hex.DecodeString(input)
+		if _, err := decodeValue(data); err == nil {
+			t.Fatalf("%s: expected error", input)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	ctx := context.Background()
+	envelope, err := wrapping.NewTestEnvelopeWrapper([]byte("secret")).Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	envelope.KeyInfo.Mechanism = 1
+	envelope.KeyInfo.HMACKeyID = "hmac"
+	envelope.KeyInfo.Flags = 1<<63 + 1
+	envelope.HMAC = []byte("mac")
+	envelope.ValuePath = "a/b"
+	envelope.Wrapped = true
+
+	direct, err := testwrapper.AEADWithKey(t, bytes.Repeat([]byte{1}, 32)).Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, tc := range []struct {
+		Title string
+		Blob  *wrapping.EncryptedBlobInfo
+		Opts  *Options
+		Tag   uint64
+	}{
+		{"Envelope", envelope, nil, TagEncrypt},
+		{"EnvelopeUntagged", envelope, &Options{WrapperType: wrapping.Test, Untagged: true}, 0},
+		{"Direct", direct, &Options{WrapperType: wrapping.AEAD}, TagEncrypt0},
+		{"DirectUntagged", direct, &Options{WrapperType: wrapping.AEAD, Untagged: true}, 0},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			out, err := Marshal(tc.Blob, tc.Opts)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			v, err := decodeValue(out)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if tag, ok := v.(cborTag); tc.Tag != 0 && (!ok || tag.Number != tc.Tag) || tc.Tag == 0 && ok {
+				t.Fatalf("unexpected message %x", out)
+			}
+
+			blob, err := Unmarshal(out)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !proto.Equal(blob, tc.Blob) {
+				t.Fatalf("expected %v, got %v", tc.Blob, blob)
+			}
+		})
+	}
+
+	// Blobs that do not use AES-GCM have nothing to map onto COSE
+	transit := &wrapping.EncryptedBlobInfo{Ciphertext: []byte("vault:v1:abc")}
+	if _, err := Marshal(transit, &Options{WrapperType: wrapping.Transit}); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := Marshal(direct, &Options{WrapperType: wrapping.AEAD, Algorithm: 10}); err == nil {
+		t.Fatal("expected error for an unsupported algorithm")
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{2}, 16)
+	w := testwrapper.AEADWithKey(t, key)
+
+	for _, tc := range []struct {
+		Title string
+		AAD   []byte
+		Opts  *Options
+	}{
+		{"Tagged", nil, &Options{Algorithm: AlgorithmA128GCM}},
+		{"Untagged", nil, &Options{Algorithm: AlgorithmA128GCM, Untagged: true}},
+		{"ExternalAAD", []byte("context"), &Options{Algorithm: AlgorithmA128GCM}},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			out, err := Encrypt(ctx, w, []byte("foo"), tc.AAD, tc.Opts)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			pt, err := Decrypt(ctx, w, out, tc.AAD)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if string(pt) != "foo" {
+				t.Fatalf("expected foo, got %q", pt)
+			}
+			if _, err := Decrypt(ctx, w, out, []byte("other")); err == nil {
+				t.Fatal("expected error for different external additional data")
+			}
+
+			// Decrypt as a generic COSE implementation would, with the
+			// Enc_structure spelled out
+			p, err := parse(out)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			bstr := func(b []byte) []byte {
+				if len(b) < 24 {
+					return append([]byte{0x40 | byte(len(b))}, b...)
+				}
+				return append([]byte{0x58, byte(len(b))}, b...)
+			}
+			aad := append([]byte{0x83, 0x68}, "Encrypt0"...)
+			aad = append(aad, bstr(p.protected)...)
+			aad = append(aad, bstr(tc.AAD)...)
+
+			block, _ := aes.NewCipher(key)
+			gcm, _ := cipher.NewGCM(block)
+			ct := p.blob.Ciphertext
+			pt, err = gcm.Open(nil, ct[:12], ct[12:], aad)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if string(pt) != "foo" {
+				t.Fatalf("expected foo, got %q", pt)
+			}
+		})
+	}
+
+	// Altering the protected header breaks authentication
+	out, err := Encrypt(ctx, w, []byte("foo"), nil, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	forged := bytes.Replace(out, []byte("root"), []byte("fake"), 1)
+	if bytes.Equal(forged, out) {
+		t.Fatal("key ID not found in the message")
+	}
+	if _, err := Decrypt(ctx, w, forged, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestEncrypt_Envelope(t *testing.T) {
+	ctx := context.Background()
+	w := wrapping.NewTestEnvelopeWrapper([]byte("secret"))
+
+	out, err := Encrypt(ctx, w, []byte("foo"), []byte("aad"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	pt, err := Decrypt(ctx, w, out, []byte("aad"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %q", pt)
+	}
+	p, err := parse(out)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !p.recipient || len(p.blob.KeyInfo.WrappedKey) == 0 {
+		t.Fatalf("expected a COSE_Encrypt message, got %x", out)
+	}
+
+	// The key ID changing in between is caught by encrypting again
+	w.SetKeyID("")
+	out, err = Encrypt(ctx, &keyIDWrapper{TestWrapper: w, keyID: "before"}, []byte("foo"), nil, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	blob, err := Unmarshal(out)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if blob.KeyInfo.KeyID != "" {
+		t.Fatalf("expected no key ID, got %q", blob.KeyInfo.KeyID)
+	}
+}
+
+// keyIDWrapper reports a key ID that differs from the one it encrypts with
+type keyIDWrapper struct {
+	*wrapping.TestWrapper
+	keyID string
+}
+
+func (k *keyIDWrapper) KeyID() string {
+	return k.keyID
+}
+
+func TestUnmarshal_Invalid(t *testing.T) {
+	encode := func(v interface{}) []byte {
+		out, err := appendValue(nil, v)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return out
+	}
+	iv := make([]byte, 12)
+	ct := make([]byte, 16)
+	kms := []interface{}{[]interface{}{[]byte{}, cborMap{labelAlg: AlgorithmKMS}, []byte{0}}}
+	encrypt0 := func(header cborMap) interface{} {
+		return cborTag{Number: TagEncrypt0, Value: []interface{}{encode(header), cborMap{labelIV: iv}, ct}}
+	}
+	encrypt := func(header cborMap, recipients interface{}) interface{} {
+		return cborTag{Number: TagEncrypt, Value: []interface{}{encode(header), cborMap{labelIV: iv}, ct, recipients}}
+	}
+
+	for _, tc := range []struct {
+		Title string
+		Input interface{}
+	}{
+		{"NotArray", cborMap{}},
+		{"Tag", cborTag{Number: 98, Value: []interface{}{}}},
+		{"TagMismatch", cborTag{Number: TagEncrypt0, Value: []interface{}{encode(cborMap{labelAlg: 3}), cborMap{labelIV: iv}, ct, kms}}},
+		{"Elements", []interface{}{[]byte{}, cborMap{}}},
+		{"Algorithm", encrypt0(cborMap{labelAlg: 10})},
+		{"NoAlgorithm", encrypt0(cborMap{labelKID: []byte("root")})},
+		{"KMSKeySize", encrypt(cborMap{labelAlg: AlgorithmA128GCM}, kms)},
+		{"Critical", encrypt0(cborMap{labelAlg: 3, labelCrit: []interface{}{"x"}, "x": uint64(1)})},
+		{"UnknownHeader", encrypt0(cborMap{labelAlg: 3, "x": uint64(1)})},
+		{"KeyIDType", encrypt0(cborMap{labelAlg: 3, labelKID: "root"})},
+		{"Mechanism", encrypt0(cborMap{labelAlg: 3, HeaderMechanism: -1})},
+		{"IV", cborTag{Number: TagEncrypt0, Value: []interface{}{encode(cborMap{labelAlg: 3}), cborMap{labelIV: []byte{0}}, ct}}},
+		{"Unprotected", cborTag{Number: TagEncrypt0, Value: []interface{}{encode(cborMap{labelAlg: 3}), cborMap{labelIV: iv, labelKID: []byte("x")}, ct}}},
+		{"ShortCiphertext", cborTag{Number: TagEncrypt0, Value: []interface{}{encode(cborMap{labelAlg: 3}), cborMap{labelIV: iv}, ct[:15]}}},
+		{"Recipients", encrypt(cborMap{labelAlg: 3}, append(kms, kms[0]))},
+		{"RecipientAlgorithm", encrypt(cborMap{labelAlg: 3}, []interface{}{[]interface{}{[]byte{}, cborMap{labelAlg: -5}, []byte{0}}})},
+		{"RecipientProtected", encrypt(cborMap{labelAlg: 3}, []interface{}{[]interface{}{encode(cborMap{labelAlg: AlgorithmKMS}), cborMap{}, []byte{0}}})},
+		{"MissingKey", encrypt(cborMap{labelAlg: 3}, []interface{}{[]interface{}{[]byte{}, cborMap{labelAlg: AlgorithmKMS}, []byte{}}})},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			if _, err := Unmarshal(encode(tc.Input)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	// An untagged COSE_Encrypt is recognized by its length
+	untagged := []interface{}{encode(cborMap{labelAlg: 3}), cborMap{labelIV: iv}, ct, kms}
+	blob, err := Unmarshal(encode(untagged))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(blob.KeyInfo.WrappedKey, []byte{0}) {
+		t.Fatalf("unexpected wrapped key %v", blob.KeyInfo.WrappedKey)
+	}
+}
@@ -3,8 +3,10 @@ package wrapping
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"errors"
 	fmt "fmt"
+	"io"
 
 	uuid "github.com/hashicorp/go-uuid"
 )
@@ -16,12 +18,18 @@ func NewEnvelope(opts *EnvelopeOptions) *Envelope {
 
 // Encrypt takes in plaintext and envelope encrypts it, generating an EnvelopeInfo value
 func (e *Envelope) Encrypt(plaintext []byte, aad []byte) (*EnvelopeInfo, error) {
+	return e.encrypt(rand.Reader, plaintext, aad)
+}
+
+// encrypt performs the work of Encrypt, drawing the DEK and IV from the given
+// reader
+func (e *Envelope) encrypt(randReader io.Reader, plaintext []byte, aad []byte) (*EnvelopeInfo, error) {
 	// Generate DEK
-	key, err := uuid.GenerateRandomBytes(32)
+	key, err := uuid.GenerateRandomBytesWithReader(32, randReader)
 	if err != nil {
 		return nil, err
 	}
-	iv, err := uuid.GenerateRandomBytes(12)
+	iv, err := uuid.GenerateRandomBytesWithReader(12, randReader)
 	if err != nil {
 		return nil, err
 	}
@@ -39,11 +47,21 @@ func (e *Envelope) Encrypt(plaintext []byte, aad []byte) (*EnvelopeInfo, error)
 
 // Decrypt takes in EnvelopeInfo and potentially additional data and decrypts. Additional data is separate from the encrypted blob info as it is expected that will be sourced from a separate location.
 func (e *Envelope) Decrypt(data *EnvelopeInfo, aad []byte) ([]byte, error) {
+	if data == nil {
+		return nil, errors.New("given envelope info for decryption is nil")
+	}
+
 	aead, err := e.aeadEncrypter(data.Key)
 	if err != nil {
 		return nil, err
 	}
 
+	// The GCM implementation panics on a nonce of the wrong size, and the IV
+	// usually comes from stored, possibly attacker-controlled data
+	if len(data.IV) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid IV length: expected %d, got %d", aead.NonceSize(), len(data.IV))
+	}
+
 	return aead.Open(nil, data.IV, data.Ciphertext, aad)
 }
 
@@ -43,3 +43,25 @@ func TestEnvelopeAAD(t *testing.T) {
 		t.Fatalf("expected the same text: expected %s, got %s", string(input), string(output))
 	}
 }
+
+func TestEnvelopeInvalidInput(t *testing.T) {
+	env, err := NewEnvelope(nil).Encrypt([]byte("test"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewEnvelope(nil).Decrypt(nil, nil); err == nil {
+		t.Fatal("expected an error for nil envelope info")
+	}
+
+	for _, iv := range [][]byte{nil, env.IV[:3], append(env.IV, 0)} {
+		env := &EnvelopeInfo{
+			Ciphertext: env.Ciphertext,
+			Key:        env.Key,
+			IV:         iv,
+		}
+		if _, err := NewEnvelope(nil).Decrypt(env, nil); err == nil {
+			t.Fatalf("expected an error for IV of length %d", len(iv))
+		}
+	}
+}
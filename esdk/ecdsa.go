@@ -0,0 +1,59 @@
+package esdk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+// marshalPublicKey encodes a verification key as the base64 of its
+// compressed point, as carried in the encryption context
+func marshalPublicKey(pub *ecdsa.PublicKey) string {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	out := make([]byte, 1+size)
+	out[0] = 2 | byte(pub.Y.Bit(0))
+	x := pub.X.Bytes()
+	copy(out[1+size-len(x):], x)
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+// parsePublicKey decodes a verification key from the encryption context
+func parsePublicKey(curve elliptic.Curve, s string) (*ecdsa.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("error decoding the verification key")
+	}
+	params := curve.Params()
+	size := (params.BitSize + 7) / 8
+	if len(b) != 1+size || (b[0] != 2 && b[0] != 3) {
+		return nil, errors.New("verification key is not a compressed point")
+	}
+
+	// y² = x³ - 3x + b
+	p := params.P
+	x := new(big.Int).SetBytes(b[1:])
+	if x.Cmp(p) >= 0 {
+		return nil, errors.New("verification key is not on the curve")
+	}
+	y2 := new(big.Int).Mul(x, x)
+	y2.Mul(y2, x)
+	threeX := new(big.Int).Lsh(x, 1)
+	threeX.Add(threeX, x)
+	y2.Sub(y2, threeX)
+	y2.Add(y2, params.B)
+	y2.Mod(y2, p)
+
+	// The NIST primes are 3 mod 4, so a square root is y2^((p+1)/4)
+	exp := new(big.Int).Add(p, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(y2, exp, p)
+	if y.Bit(0) != uint(b[0]&1) {
+		y.Sub(p, y)
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("verification key is not on the curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
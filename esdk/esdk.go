@@ -0,0 +1,394 @@
+// Package esdk reads and writes the message format of the AWS Encryption SDK,
+// versions 1 and 2, with wrappers as master key providers. Messages written
+// with NewAWSKMSProvider can be decrypted by the SDK's KMS keyrings and master
+// key providers, and the reverse, which allows sharing data such as the
+// contents of a data lake with applications built on the SDK.
+//
+// All algorithm suites are supported, including signing and key committing
+// ones, as are framed and non-framed bodies. Decrypt only returns plaintext
+// once the whole message, including its signature, has been verified.
+package esdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// DefaultFrameLength is the frame length of framed messages unless Options
+// set another, matching the AWS Encryption SDK
+const DefaultFrameLength = 4096
+
+// DefaultAlgorithm is the algorithm suite of messages unless Options set
+// another, matching the AWS Encryption SDK
+const DefaultAlgorithm = AES256GCMHKDFSHA512CommitKeyECDSAP384
+
+const (
+	frameAAD       = "AWSKMSEncryptionClient Frame"
+	finalFrameAAD  = "AWSKMSEncryptionClient Final Frame"
+	singleBlockAAD = "AWSKMSEncryptionClient Single Block"
+
+	finalFrameMarker = 0xffffffff
+
+	// maxNonFramedSize is the largest content AES-GCM can encrypt under one IV
+	maxNonFramedSize = 1<<36 - 32
+)
+
+// Options configures Encrypt. It is valid to pass nil Options.
+type Options struct {
+	// Algorithm is the algorithm suite, DefaultAlgorithm if zero
+	Algorithm AlgorithmSuite
+
+	// EncryptionContext is authenticated along with the message and stored
+	// in its header. Keys beginning with aws-crypto- are reserved.
+	EncryptionContext map[string]string
+
+	// FrameLength is the frame length of framed messages,
+	// DefaultFrameLength if zero
+	FrameLength uint32
+
+	// NonFramed encrypts the content as a single block rather than in frames
+	NonFramed bool
+}
+
+// Encrypt encrypts plaintext into a message whose data key is encrypted by
+// each of the providers
+func Encrypt(ctx context.Context, providers []MasterKeyProvider, plaintext []byte, opts *Options) ([]byte, error) {
+	return encrypt(ctx, rand.Reader, providers, plaintext, opts)
+}
+
+// encrypt performs the work of Encrypt, drawing keys and IDs from the given
+// reader
+func encrypt(ctx context.Context, randReader io.Reader, providers []MasterKeyProvider, plaintext []byte, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("no master key providers given")
+	}
+	if plaintext == nil {
+		return nil, errors.New("given plaintext for encryption is nil")
+	}
+	algorithm := opts.Algorithm
+	if algorithm == 0 {
+		algorithm = DefaultAlgorithm
+	}
+	suite, err := algorithm.info()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Header{
+		Version:           suite.version(),
+		Algorithm:         algorithm,
+		EncryptionContext: make(map[string]string, len(opts.EncryptionContext)+1),
+		ContentType:       Framed,
+		FrameLength:       opts.FrameLength,
+	}
+	for k, v := range opts.EncryptionContext {
+		if strings.HasPrefix(k, "aws-crypto-") {
+			return nil, fmt.Errorf("encryption context key %q is reserved", k)
+		}
+		h.EncryptionContext[k] = v
+	}
+	switch {
+	case opts.NonFramed:
+		if len(plaintext) > maxNonFramedSize {
+			return nil, errors.New("plaintext is too large for a non-framed message")
+		}
+		h.ContentType, h.FrameLength = NonFramed, 0
+	case h.FrameLength == 0:
+		h.FrameLength = DefaultFrameLength
+	}
+	if h.ContentType == Framed && uint64(len(plaintext))/uint64(h.FrameLength) >= finalFrameMarker-1 {
+		return nil, errors.New("plaintext needs too many frames; increase the frame length")
+	}
+
+	var signer *ecdsa.PrivateKey
+	if suite.curve != nil {
+		if signer, err = ecdsa.GenerateKey(suite.curve, randReader); err != nil {
+			return nil, fmt.Errorf("error generating signing key: %w", err)
+		}
+		h.EncryptionContext[publicKeyContextKey] = marshalPublicKey(&signer.PublicKey)
+	}
+
+	h.MessageID = make([]byte, messageIDSize(h.Version))
+	if _, err := io.ReadFull(randReader, h.MessageID); err != nil {
+		return nil, fmt.Errorf("error generating message ID: %w", err)
+	}
+	dataKey := make([]byte, suite.keySize)
+	if _, err := io.ReadFull(randReader, dataKey); err != nil {
+		return nil, fmt.Errorf("error generating data key: %w", err)
+	}
+	for _, p := range providers {
+		key, err := p.EncryptDataKey(ctx, dataKey, h.EncryptionContext)
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting data key with %s provider: %w", p.ProviderID(), err)
+		}
+		h.EncryptedDataKeys = append(h.EncryptedDataKeys, key)
+	}
+
+	key, commitKey, err := suite.deriveKeys(algorithm, dataKey, h.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	h.CommitKey = commitKey
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+	w := &writer{b: raw}
+	headerIV := make([]byte, ivSize)
+	if h.Version == 1 {
+		w.bytes(headerIV)
+	}
+	w.bytes(gcm.Seal(nil, headerIV, nil, raw))
+
+	if h.ContentType == NonFramed {
+		iv := sequenceIV(1)
+		w.bytes(iv)
+		w.uint64(uint64(len(plaintext)))
+		w.b = gcm.Seal(w.b, iv, plaintext, bodyAAD(h.MessageID, singleBlockAAD, 1, uint64(len(plaintext))))
+	} else {
+		seq := uint32(1)
+		for ; uint64(len(plaintext)) > uint64(h.FrameLength); seq++ {
+			iv := sequenceIV(seq)
+			w.uint32(seq)
+			w.bytes(iv)
+			w.b = gcm.Seal(w.b, iv, plaintext[:h.FrameLength], bodyAAD(h.MessageID, frameAAD, seq, uint64(h.FrameLength)))
+			plaintext = plaintext[h.FrameLength:]
+		}
+		iv := sequenceIV(seq)
+		w.uint32(finalFrameMarker)
+		w.uint32(seq)
+		w.bytes(iv)
+		w.uint32(uint32(len(plaintext)))
+		w.b = gcm.Seal(w.b, iv, plaintext, bodyAAD(h.MessageID, finalFrameAAD, seq, uint64(len(plaintext))))
+	}
+
+	if signer != nil {
+		digest := suite.signatureHash.New()
+		digest.Write(w.b)
+		r, s, err := ecdsa.Sign(randReader, signer, digest.Sum(nil))
+		if err != nil {
+			return nil, fmt.Errorf("error signing message: %w", err)
+		}
+		signature, err := asn1.Marshal(ecdsaSignature{R: r, S: s})
+		if err != nil {
+			return nil, fmt.Errorf("error encoding signature: %w", err)
+		}
+		if err := w.field16(signature); err != nil {
+			return nil, err
+		}
+	}
+	return w.b, nil
+}
+
+// ParseHeader parses the header of a message, for example to see which
+// providers encrypted its data key, without decrypting or authenticating it
+func ParseHeader(message []byte) (*Header, error) {
+	return parseHeader(&reader{b: message})
+}
+
+// Decrypt authenticates and decrypts a message, decrypting its data key with
+// the first of its encrypted data keys that one of the providers, matched by
+// provider ID, accepts. It returns the header along with the plaintext; the
+// encryption context of the header should be checked against expectations.
+func Decrypt(ctx context.Context, providers []MasterKeyProvider, message []byte) ([]byte, *Header, error) {
+	r := &reader{b: message}
+	h, err := parseHeader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing message header: %w", err)
+	}
+	suite, err := h.Algorithm.info()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var verifier *ecdsa.PublicKey
+	if suite.curve != nil {
+		encoded, ok := h.EncryptionContext[publicKeyContextKey]
+		if !ok {
+			return nil, nil, errors.New("signed message has no verification key")
+		}
+		if verifier, err = parsePublicKey(suite.curve, encoded); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	dataKey, err := decryptDataKey(ctx, providers, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, commitKey, err := suite.deriveKeys(h.Algorithm, dataKey, h.MessageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if suite.commit && subtle.ConstantTimeCompare(commitKey, h.CommitKey) != 1 {
+		return nil, nil, errors.New("key commitment does not match the data key")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := gcm.Open(nil, h.authIV, h.authTag, h.raw); err != nil {
+		return nil, nil, fmt.Errorf("error authenticating message header: %w", err)
+	}
+
+	var plaintext []byte
+	open := func(iv, ciphertext, tag []byte, aad []byte) error {
+		if r.err != nil {
+			return r.err
+		}
+		var err error
+		if plaintext, err = gcm.Open(plaintext, iv, append(ciphertext[:len(ciphertext):len(ciphertext)], tag...), aad); err != nil {
+			return fmt.Errorf("error decrypting message body: %w", err)
+		}
+		return nil
+	}
+	if h.ContentType == NonFramed {
+		iv := r.bytes(ivSize)
+		if r.err == nil && !bytes.Equal(iv, sequenceIV(1)) {
+			return nil, nil, errors.New("message body IV is not its sequence number")
+		}
+		n := r.uint64()
+		if r.err == nil && n > maxNonFramedSize {
+			return nil, nil, errors.New("non-framed message content is too large")
+		}
+		ciphertext := r.bytes(int(n))
+		if err := open(iv, ciphertext, r.bytes(tagSize), bodyAAD(h.MessageID, singleBlockAAD, 1, n)); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		for seq := uint32(1); ; seq++ {
+			got := r.uint32()
+			final := got == finalFrameMarker
+			if final {
+				got = r.uint32()
+			}
+			if r.err == nil && got != seq {
+				return nil, nil, fmt.Errorf("frame %d is out of sequence", got)
+			}
+			iv := r.bytes(ivSize)
+			if r.err == nil && !bytes.Equal(iv, sequenceIV(seq)) {
+				return nil, nil, fmt.Errorf("IV of frame %d is not its sequence number", seq)
+			}
+			n, contentAAD := h.FrameLength, frameAAD
+			if final {
+				n, contentAAD = r.uint32(), finalFrameAAD
+				if n > h.FrameLength {
+					return nil, nil, errors.New("final frame is longer than the frame length")
+				}
+			}
+			ciphertext := r.bytes(int(n))
+			if err := open(iv, ciphertext, r.bytes(tagSize), bodyAAD(h.MessageID, contentAAD, seq, uint64(n))); err != nil {
+				return nil, nil, err
+			}
+			if final {
+				break
+			}
+			if seq == finalFrameMarker-1 {
+				return nil, nil, errors.New("message has too many frames")
+			}
+		}
+	}
+
+	if verifier != nil {
+		digest := suite.signatureHash.New()
+		digest.Write(message[:r.off])
+		encoded := r.field16()
+		if r.err != nil {
+			return nil, nil, fmt.Errorf("error reading signature: %w", r.err)
+		}
+		var signature ecdsaSignature
+		if rest, err := asn1.Unmarshal(encoded, &signature); err != nil || len(rest) != 0 {
+			return nil, nil, errors.New("error decoding signature")
+		}
+		if signature.R == nil || signature.S == nil || !ecdsa.Verify(verifier, digest.Sum(nil), signature.R, signature.S) {
+			return nil, nil, errors.New("message signature is invalid")
+		}
+	}
+	if r.off != len(message) {
+		return nil, nil, errors.New("unexpected data after the message")
+	}
+	if plaintext == nil {
+		plaintext = []byte{}
+	}
+	return plaintext, h, nil
+}
+
+// decryptDataKey decrypts the data key of a message with the first provider
+// that succeeds
+func decryptDataKey(ctx context.Context, providers []MasterKeyProvider, h *Header) ([]byte, error) {
+	var errs []string
+	for _, key := range h.EncryptedDataKeys {
+		for _, p := range providers {
+			if p.ProviderID() != key.ProviderID {
+				continue
+			}
+			dataKey, err := p.DecryptDataKey(ctx, key, h.EncryptionContext)
+			if err == nil {
+				return dataKey, nil
+			}
+			errs = append(errs, fmt.Sprintf("%s key %q: %s", key.ProviderID, key.ProviderInfo, err))
+		}
+	}
+	if len(errs) == 0 {
+		ids := make([]string, 0, len(h.EncryptedDataKeys))
+		for _, key := range h.EncryptedDataKeys {
+			ids = append(ids, key.ProviderID)
+		}
+		return nil, fmt.Errorf("no provider matches the encrypted data keys, from %s", strings.Join(ids, ", "))
+	}
+	return nil, fmt.Errorf("error decrypting data key: %s", strings.Join(errs, "; "))
+}
+
+// ecdsaSignature is the DER structure of an ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// sequenceIV is the IV of a frame: its sequence number, left padded with
+// zeros
+func sequenceIV(seq uint32) []byte {
+	iv := make([]byte, ivSize)
+	iv[ivSize-4], iv[ivSize-3], iv[ivSize-2], iv[ivSize-1] = byte(seq>>24), byte(seq>>16), byte(seq>>8), byte(seq)
+	return iv
+}
+
+// bodyAAD is the additional data of a frame or single block
+func bodyAAD(messageID []byte, content string, seq uint32, length uint64) []byte {
+	w := &writer{b: make([]byte, 0, len(messageID)+len(content)+12)}
+	w.bytes(messageID)
+	w.bytes([]byte(content))
+	w.uint32(seq)
+	w.uint64(length)
+	return w.b
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.New("failed to initialize GCM mode")
+	}
+	return gcm, nil
+}
@@ -0,0 +1,304 @@
+package esdk
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+)
+
+var allSuites = []AlgorithmSuite{
+	AES128GCM, AES192GCM, AES256GCM,
+	AES128GCMHKDFSHA256, AES192GCMHKDFSHA256, AES256GCMHKDFSHA256,
+	AES128GCMHKDFSHA256ECDSAP256, AES192GCMHKDFSHA384ECDSAP384, AES256GCMHKDFSHA384ECDSAP384,
+	AES256GCMHKDFSHA512CommitKey, AES256GCMHKDFSHA512CommitKeyECDSAP384,
+}
+
+func testProviders() []MasterKeyProvider {
+	return []MasterKeyProvider{NewWrapperProvider(wrapping.NewTestEnvelopeWrapper([]byte("secret")))}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	providers := testProviders()
+	encryptionContext := map[string]string{"purpose": "test", "tenant": "a"}
+
+	for _, suite := range allSuites {
+		for _, size := range []int{0, 1, 16, 17, 48} {
+			for _, nonFramed := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%04x/%d/%v", uint16(suite), size, nonFramed), func(t *testing.T) {
+					plaintext := bytes.Repeat([]byte{'a'}, size)
+					message, err := Encrypt(ctx, providers, plaintext, &Options{
+						Algorithm:         suite,
+						EncryptionContext: encryptionContext,
+						FrameLength:       16,
+						NonFramed:         nonFramed,
+					})
+					if err != nil {
+						t.Fatalf("err: %s", err)
+					}
+
+					pt, h, err := Decrypt(ctx, providers, message)
+					if err != nil {
+						t.Fatalf("err: %s", err)
+					}
+					if !bytes.Equal(pt, plaintext) {
+						t.Fatalf("expected %q, got %q", plaintext, pt)
+					}
+					if h.Algorithm != suite || h.EncryptionContext["tenant"] != "a" {
+						t.Fatalf("unexpected header %+v", h)
+					}
+					_, signed := h.EncryptionContext[publicKeyContextKey]
+					if info, _ := suite.info(); signed != (info.curve != nil) || h.Version != info.version() {
+						t.Fatalf("unexpected header %+v", h)
+					}
+
+					// Any change to the message is detected
+					for _, i := range []int{len(h.raw) - 1, len(message) / 2, len(message) - 1} {
+						tampered := append([]byte(nil), message...)
+						tampered[i] ^= 1
+						if _, _, err := Decrypt(ctx, providers, tampered); err == nil {
+							t.Fatalf("expected error for a change at byte %d", i)
+						}
+					}
+					if _, _, err := Decrypt(ctx, providers, message[:len(message)-1]); err == nil {
+						t.Fatal("expected error for a truncated message")
+					}
+					if _, _, err := Decrypt(ctx, providers, append(message, 0)); err == nil {
+						t.Fatal("expected error for trailing data")
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestEncrypt_Defaults(t *testing.T) {
+	message, err := Encrypt(context.Background(), testProviders(), []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	h, err := ParseHeader(message)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if h.Version != 2 || h.Algorithm != DefaultAlgorithm || h.ContentType != Framed || h.FrameLength != DefaultFrameLength {
+		t.Fatalf("unexpected header %+v", h)
+	}
+	if len(h.EncryptedDataKeys) != 1 || h.EncryptedDataKeys[0].ProviderID != WrapperProviderID {
+		t.Fatalf("unexpected data keys %+v", h.EncryptedDataKeys)
+	}
+
+	for _, opts := range []*Options{
+		{Algorithm: 0x0999},
+		{EncryptionContext: map[string]string{"aws-crypto-public-key": "x"}},
+	} {
+		if _, err := Encrypt(context.Background(), testProviders(), []byte("foo"), opts); err == nil {
+			t.Fatalf("%+v: expected error", opts)
+		}
+	}
+	if _, err := Encrypt(context.Background(), nil, []byte("foo"), nil); err == nil {
+		t.Fatal("expected error without providers")
+	}
+}
+
+// recordingProvider keeps the data key in the clear, to check the message
+// layout independently
+type recordingProvider struct {
+	dataKey []byte
+}
+
+func (p *recordingProvider) ProviderID() string {
+	return "raw"
+}
+
+func (p *recordingProvider) EncryptDataKey(_ context.Context, dataKey []byte, _ map[string]string) (*EncryptedDataKey, error) {
+	p.dataKey = dataKey
+	return &EncryptedDataKey{ProviderID: "raw", ProviderInfo: []byte("k"), Ciphertext: dataKey}, nil
+}
+
+func (p *recordingProvider) DecryptDataKey(_ context.Context, key *EncryptedDataKey, _ map[string]string) ([]byte, error) {
+	return key.Ciphertext, nil
+}
+
+func TestMessageLayout(t *testing.T) {
+	p := &recordingProvider{}
+	message, err := Encrypt(context.Background(), []MasterKeyProvider{p}, []byte("hello world"), &Options{
+		Algorithm:         AES256GCM,
+		EncryptionContext: map[string]string{"b": "2", "a": "1"},
+		FrameLength:       8,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var expected []byte
+	expected = append(expected, 0x01, 0x80, 0x00, 0x78)
+	messageID := message[4:20]
+	expected = append(expected, messageID...)
+	// Encryption context, sorted by key
+	expected = append(expected, 0, 14, 0, 2, 0, 1, 'a', 0, 1, '1', 0, 1, 'b', 0, 1, '2')
+	// One raw data key
+	expected = append(expected, 0, 1, 0, 3, 'r', 'a', 'w', 0, 1, 'k', 0, 32)
+	expected = append(expected, p.dataKey...)
+	// Framed, reserved, IV length, frame length
+	expected = append(expected, 0x02, 0, 0, 0, 0, 12, 0, 0, 0, 8)
+	if !bytes.HasPrefix(message, expected) {
+		t.Fatalf("unexpected header:\n%x\nexpected prefix:\n%x", message, expected)
+	}
+
+	block, _ := aes.NewCipher(p.dataKey)
+	gcm, _ := cipher.NewGCM(block)
+	rest := message[len(expected):]
+	if _, err := gcm.Open(nil, rest[:12], rest[12:28], expected); err != nil {
+		t.Fatalf("error authenticating header: %s", err)
+	}
+	rest = rest[28:]
+
+	aad := func(content string, seq uint32, n uint64) []byte {
+		out := append(append([]byte(nil), messageID...), content...)
+		out = append(out, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(out[len(out)-12:], seq)
+		binary.BigEndian.PutUint64(out[len(out)-8:], n)
+		return out
+	}
+
+	// A regular frame of 8 bytes, then a final frame of 3
+	if binary.BigEndian.Uint32(rest) != 1 {
+		t.Fatalf("unexpected frame %x", rest)
+	}
+	pt, err := gcm.Open(nil, rest[4:16], rest[16:40], aad("AWSKMSEncryptionClient Frame", 1, 8))
+	if err != nil || string(pt) != "hello wo" {
+		t.Fatalf("unexpected frame %q: %v", pt, err)
+	}
+	rest = rest[40:]
+	if binary.BigEndian.Uint32(rest) != 0xffffffff || binary.BigEndian.Uint32(rest[4:]) != 2 || binary.BigEndian.Uint32(rest[20:]) != 3 {
+		t.Fatalf("unexpected final frame %x", rest)
+	}
+	pt, err = gcm.Open(nil, rest[8:20], rest[24:], aad("AWSKMSEncryptionClient Final Frame", 2, 3))
+	if err != nil || string(pt) != "rld" {
+		t.Fatalf("unexpected final frame %q: %v", pt, err)
+	}
+}
+
+func TestDecrypt_SequenceIV(t *testing.T) {
+	ctx := context.Background()
+	for _, nonFramed := range []bool{false, true} {
+		p := &recordingProvider{}
+		message, err := Encrypt(ctx, []MasterKeyProvider{p}, []byte("hello world"), &Options{
+			Algorithm:   AES256GCM,
+			FrameLength: 8,
+			NonFramed:   nonFramed,
+		})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		h, err := ParseHeader(message)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		// Seal the first frame again under another IV, so that it still
+		// authenticates
+		off := len(h.raw) + 12 + 16
+		ivOff, n, aad := off+4, 8, bodyAAD(h.MessageID, frameAAD, 1, 8)
+		if nonFramed {
+			ivOff, n, aad = off, 11, bodyAAD(h.MessageID, singleBlockAAD, 1, 11)
+		}
+		ctOff := ivOff + 12
+		if nonFramed {
+			ctOff += 8
+		}
+		block, _ := aes.NewCipher(p.dataKey)
+		gcm, _ := cipher.NewGCM(block)
+		tampered := append([]byte(nil), message...)
+		pt, err := gcm.Open(nil, tampered[ivOff:ivOff+12], tampered[ctOff:ctOff+n+16], aad)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		iv := sequenceIV(7)
+		copy(tampered[ivOff:], iv)
+		gcm.Seal(tampered[ctOff:ctOff], iv, pt, aad)
+
+		if _, _, err := Decrypt(ctx, []MasterKeyProvider{p}, tampered); err == nil || !strings.Contains(err.Error(), "sequence number") {
+			t.Fatalf("non-framed %v: expected error for an IV other than the sequence number, got %v", nonFramed, err)
+		}
+	}
+}
+
+func TestAWSKMSProvider(t *testing.T) {
+	ctx := context.Background()
+	w := awskms.NewAWSKMSTestWrapper()
+	if _, err := w.SetConfig(map[string]string{"kms_key_id": "arn:aws:kms:us-east-1:111122223333:key/k"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	providers := []MasterKeyProvider{NewAWSKMSProvider(w)}
+
+	message, err := Encrypt(ctx, providers, []byte("foo"), &Options{
+		EncryptionContext: map[string]string{"purpose": "test"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	h, err := ParseHeader(message)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	key := h.EncryptedDataKeys[0]
+	if key.ProviderID != AWSKMSProviderID || string(key.ProviderInfo) != "arn:aws:kms:us-east-1:111122223333:key/k" {
+		t.Fatalf("unexpected data key %+v", key)
+	}
+
+	pt, _, err := Decrypt(ctx, providers, message)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %q", pt)
+	}
+
+	// The KMS ciphertext is bound to the full encryption context, so KMS
+	// alone refuses it under another
+	if _, err := w.DecryptDirectWithContext(ctx, &wrapping.EncryptedBlobInfo{Ciphertext: key.Ciphertext}, map[string]string{"purpose": "test"}); err == nil {
+		t.Fatal("expected error without the verification key in the context")
+	}
+
+	if _, _, err := Decrypt(ctx, testProviders(), message); err == nil {
+		t.Fatal("expected error without an aws-kms provider")
+	}
+}
+
+func TestPublicKeyEncoding(t *testing.T) {
+	for _, suite := range []AlgorithmSuite{AES128GCMHKDFSHA256ECDSAP256, AES256GCMHKDFSHA384ECDSAP384} {
+		info, _ := suite.info()
+		for i := 0; i < 8; i++ {
+			message, err := Encrypt(context.Background(), testProviders(), []byte("foo"), &Options{Algorithm: suite})
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			h, err := ParseHeader(message)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			encoded := h.EncryptionContext[publicKeyContextKey]
+			pub, err := parsePublicKey(info.curve, encoded)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if marshalPublicKey(pub) != encoded {
+				t.Fatalf("expected %s, got %s", encoded, marshalPublicKey(pub))
+			}
+		}
+	}
+	if _, err := parsePublicKey(elliptic.P384(), "AAAA"); err == nil {
+		t.Fatal("expected error")
+	}
+}
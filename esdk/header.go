@@ -0,0 +1,343 @@
+package esdk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ContentType is how the body of a message is laid out
+type ContentType byte
+
+const (
+	// NonFramed messages encrypt their content as a single block
+	NonFramed ContentType = 0x01
+
+	// Framed messages encrypt their content in frames of a fixed length
+	Framed ContentType = 0x02
+)
+
+const (
+	// messageTypeCustomerAED is the only message type of version 1 messages
+	messageTypeCustomerAED = 0x80
+
+	// publicKeyContextKey is the encryption context key holding the
+	// verification key of signing suites
+	publicKeyContextKey = "aws-crypto-public-key"
+)
+
+// EncryptedDataKey is the data key of a message, encrypted by a master key
+// provider
+type EncryptedDataKey struct {
+	// ProviderID names the master key provider, such as aws-kms
+	ProviderID string
+
+	// ProviderInfo identifies the master key to the provider. For aws-kms it
+	// is the key ARN.
+	ProviderInfo []byte
+
+	// Ciphertext is the encrypted data key
+	Ciphertext []byte
+}
+
+// Header is the header of a message
+type Header struct {
+	Version           byte
+	Algorithm         AlgorithmSuite
+	MessageID         []byte
+	EncryptionContext map[string]string
+	EncryptedDataKeys []*EncryptedDataKey
+	ContentType       ContentType
+	FrameLength       uint32
+
+	// CommitKey is the key commitment of version 2 messages
+	CommitKey []byte
+
+	// raw is the serialized header, which the header authentication tag and
+	// the signature cover
+	raw []byte
+	// authIV and authTag are the header authentication
+	authIV, authTag []byte
+}
+
+func messageIDSize(version byte) int {
+	if version == 2 {
+		return 32
+	}
+	return 16
+}
+
+// marshal serializes the header without its authentication
+func (h *Header) marshal() ([]byte, error) {
+	if len(h.MessageID) != messageIDSize(h.Version) {
+		return nil, fmt.Errorf("invalid message ID length %d", len(h.MessageID))
+	}
+	var w writer
+	w.byte(h.Version)
+	if h.Version == 1 {
+		w.byte(messageTypeCustomerAED)
+	}
+	w.uint16(uint16(h.Algorithm))
+	w.bytes(h.MessageID)
+
+	context, err := marshalContext(h.EncryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.field16(context); err != nil {
+		return nil, fmt.Errorf("encryption context: %w", err)
+	}
+
+	if len(h.EncryptedDataKeys) == 0 {
+		return nil, errors.New("message has no encrypted data keys")
+	}
+	if len(h.EncryptedDataKeys) > 0xffff {
+		return nil, errors.New("too many encrypted data keys")
+	}
+	w.uint16(uint16(len(h.EncryptedDataKeys)))
+	for _, k := range h.EncryptedDataKeys {
+		for _, f := range [][]byte{[]byte(k.ProviderID), k.ProviderInfo, k.Ciphertext} {
+			if err := w.field16(f); err != nil {
+				return nil, fmt.Errorf("encrypted data key: %w", err)
+			}
+		}
+	}
+
+	w.byte(byte(h.ContentType))
+	if h.Version == 1 {
+		w.bytes([]byte{0, 0, 0, 0})
+		w.byte(ivSize)
+	}
+	w.uint32(h.FrameLength)
+	if h.Version == 2 {
+		w.bytes(h.CommitKey)
+	}
+	return w.b, nil
+}
+
+// marshalContext serializes an encryption context, with its entries sorted
+// by key. An empty context serializes to nothing at all.
+func marshalContext(context map[string]string) ([]byte, error) {
+	if len(context) == 0 {
+		return nil, nil
+	}
+	if len(context) > 0xffff {
+		return nil, errors.New("too many encryption context entries")
+	}
+	keys := make([]string, 0, len(context))
+	for k := range context {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var w writer
+	w.uint16(uint16(len(keys)))
+	for _, k := range keys {
+		if err := w.field16([]byte(k)); err != nil {
+			return nil, err
+		}
+		if err := w.field16([]byte(context[k])); err != nil {
+			return nil, err
+		}
+	}
+	return w.b, nil
+}
+
+// parseHeader reads a header and its authentication from the start of a
+// message
+func parseHeader(r *reader) (*Header, error) {
+	start := r.off
+	h := new(Header)
+
+	h.Version = r.byte()
+	switch h.Version {
+	case 1:
+		if t := r.byte(); r.err == nil && t != messageTypeCustomerAED {
+			return nil, fmt.Errorf("unsupported message type 0x%02x", t)
+		}
+	case 2:
+	default:
+		if r.err == nil {
+			return nil, fmt.Errorf("unsupported message format version %d", h.Version)
+		}
+	}
+	h.Algorithm = AlgorithmSuite(r.uint16())
+	suite, err := h.Algorithm.info()
+	if r.err == nil && err != nil {
+		return nil, err
+	}
+	if r.err == nil && suite.version() != h.Version {
+		return nil, fmt.Errorf("algorithm suite 0x%04x is not used in version %d messages", uint16(h.Algorithm), h.Version)
+	}
+	h.MessageID = r.bytes(messageIDSize(h.Version))
+
+	if context := r.field16(); len(context) != 0 {
+		if h.EncryptionContext, err = parseContext(context); err != nil {
+			return nil, err
+		}
+	}
+
+	n := int(r.uint16())
+	if r.err == nil && n == 0 {
+		return nil, errors.New("message has no encrypted data keys")
+	}
+	for i := 0; i < n && r.err == nil; i++ {
+		h.EncryptedDataKeys = append(h.EncryptedDataKeys, &EncryptedDataKey{
+			ProviderID:   string(r.field16()),
+			ProviderInfo: r.field16(),
+			Ciphertext:   r.field16(),
+		})
+	}
+
+	h.ContentType = ContentType(r.byte())
+	if h.Version == 1 {
+		if reserved := r.bytes(4); r.err == nil && binary.BigEndian.Uint32(reserved) != 0 {
+			return nil, errors.New("reserved header field is not zero")
+		}
+		if n := r.byte(); r.err == nil && n != ivSize {
+			return nil, fmt.Errorf("unsupported IV length %d", n)
+		}
+	}
+	h.FrameLength = r.uint32()
+	if h.Version == 2 {
+		h.CommitKey = r.bytes(commitKeySize)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	switch h.ContentType {
+	case NonFramed:
+		if h.FrameLength != 0 {
+			return nil, errors.New("non-framed message has a frame length")
+		}
+	case Framed:
+		if h.FrameLength == 0 {
+			return nil, errors.New("framed message has a zero frame length")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type 0x%02x", byte(h.ContentType))
+	}
+	h.raw = r.b[start:r.off]
+
+	if h.Version == 1 {
+		h.authIV = r.bytes(ivSize)
+	} else {
+		h.authIV = make([]byte, ivSize)
+	}
+	h.authTag = r.bytes(tagSize)
+	return h, r.err
+}
+
+func parseContext(b []byte) (map[string]string, error) {
+	r := &reader{b: b}
+	n := int(r.uint16())
+	context := make(map[string]string, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		k, v := string(r.field16()), string(r.field16())
+		if _, ok := context[k]; ok && r.err == nil {
+			return nil, fmt.Errorf("duplicate encryption context key %q", k)
+		}
+		context[k] = v
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("error parsing encryption context: %w", r.err)
+	}
+	if r.off != len(b) {
+		return nil, errors.New("unexpected data after the encryption context")
+	}
+	return context, nil
+}
+
+// writer appends big-endian fields
+type writer struct {
+	b []byte
+}
+
+func (w *writer) byte(v byte) {
+	w.b = append(w.b, v)
+}
+
+func (w *writer) uint16(v uint16) {
+	w.b = append(w.b, byte(v>>8), byte(v))
+}
+
+func (w *writer) uint32(v uint32) {
+	w.b = append(w.b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(w.b[len(w.b)-4:], v)
+}
+
+func (w *writer) uint64(v uint64) {
+	w.b = append(w.b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(w.b[len(w.b)-8:], v)
+}
+
+func (w *writer) bytes(v []byte) {
+	w.b = append(w.b, v...)
+}
+
+// field16 appends v preceded by its 2 byte length
+func (w *writer) field16(v []byte) error {
+	if len(v) > 0xffff {
+		return fmt.Errorf("field of %d bytes is too long", len(v))
+	}
+	w.uint16(uint16(len(v)))
+	w.bytes(v)
+	return nil
+}
+
+// reader consumes big-endian fields, remembering the first error. Reads
+// after an error return zero values.
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+var errTruncated = errors.New("message is truncated")
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b)-r.off {
+		r.err = errTruncated
+		return nil
+	}
+	v := r.b[r.off : r.off+n]
+	r.off += n
+	return v
+}
+
+func (r *reader) byte() byte {
+	if v := r.bytes(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if v := r.bytes(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if v := r.bytes(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if v := r.bytes(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+// field16 reads a field preceded by its 2 byte length
+func (r *reader) field16() []byte {
+	return r.bytes(int(r.uint16()))
+}
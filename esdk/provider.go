@@ -0,0 +1,124 @@
+package esdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+)
+
+const (
+	// WrapperProviderID is the provider ID of data keys encrypted by a
+	// wrapper. The encrypted data key is the marshaled blob.
+	WrapperProviderID = "go-kms-wrapping"
+
+	// AWSKMSProviderID is the provider ID of the AWS Encryption SDK's KMS
+	// master keys
+	AWSKMSProviderID = "aws-kms"
+)
+
+// MasterKeyProvider encrypts and decrypts the data keys of messages. The
+// encryption context given to both is the one of the message, which a
+// provider may bind to the encrypted data key.
+type MasterKeyProvider interface {
+	ProviderID() string
+	EncryptDataKey(ctx context.Context, dataKey []byte, encryptionContext map[string]string) (*EncryptedDataKey, error)
+	DecryptDataKey(ctx context.Context, key *EncryptedDataKey, encryptionContext map[string]string) ([]byte, error)
+}
+
+// NewWrapperProvider returns a provider that encrypts data keys with w, under
+// WrapperProviderID. The serialized encryption context is the additional data
+// of the wrapper. Other implementations of the message format need a custom
+// master key provider to decrypt them; to exchange data with the AWS
+// Encryption SDK's KMS keyrings use NewAWSKMSProvider.
+func NewWrapperProvider(w wrapping.Wrapper) MasterKeyProvider {
+	return &wrapperProvider{w: w}
+}
+
+type wrapperProvider struct {
+	w wrapping.Wrapper
+}
+
+func (p *wrapperProvider) ProviderID() string {
+	return WrapperProviderID
+}
+
+func (p *wrapperProvider) EncryptDataKey(ctx context.Context, dataKey []byte, encryptionContext map[string]string) (*EncryptedDataKey, error) {
+	aad, err := marshalContext(encryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := p.w.Encrypt(ctx, dataKey, aad)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling encrypted data key: %w", err)
+	}
+	key := &EncryptedDataKey{
+		ProviderID: WrapperProviderID,
+		Ciphertext: ciphertext,
+	}
+	if blob.KeyInfo != nil {
+		key.ProviderInfo = []byte(blob.KeyInfo.KeyID)
+	}
+	return key, nil
+}
+
+func (p *wrapperProvider) DecryptDataKey(ctx context.Context, key *EncryptedDataKey, encryptionContext map[string]string) ([]byte, error) {
+	aad, err := marshalContext(encryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	blob, _, err := format.Unmarshal(key.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling encrypted data key: %w", err)
+	}
+	return p.w.Decrypt(ctx, blob, aad)
+}
+
+// ContextEncrypter is implemented by wrappers whose KMS can encrypt a value
+// with the key itself under an encryption context, such as awskms
+type ContextEncrypter interface {
+	EncryptDirectWithContext(ctx context.Context, plaintext []byte, encryptionContext map[string]string) (*wrapping.EncryptedBlobInfo, error)
+	DecryptDirectWithContext(ctx context.Context, in *wrapping.EncryptedBlobInfo, encryptionContext map[string]string) ([]byte, error)
+}
+
+// NewAWSKMSProvider returns a provider for the AWS Encryption SDK's KMS
+// master keys, which encrypt the data key with the KMS key under the
+// message's encryption context and record the key ARN as the provider info.
+// It decrypts any aws-kms data key that the wrapper's credentials can, as the
+// SDK does in discovery mode.
+func NewAWSKMSProvider(w ContextEncrypter) MasterKeyProvider {
+	return &awsKMSProvider{w: w}
+}
+
+type awsKMSProvider struct {
+	w ContextEncrypter
+}
+
+func (p *awsKMSProvider) ProviderID() string {
+	return AWSKMSProviderID
+}
+
+func (p *awsKMSProvider) EncryptDataKey(ctx context.Context, dataKey []byte, encryptionContext map[string]string) (*EncryptedDataKey, error) {
+	blob, err := p.w.EncryptDirectWithContext(ctx, dataKey, encryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	if blob.KeyInfo == nil || blob.KeyInfo.KeyID == "" {
+		return nil, errors.New("KMS did not report the key ARN")
+	}
+	return &EncryptedDataKey{
+		ProviderID:   AWSKMSProviderID,
+		ProviderInfo: []byte(blob.KeyInfo.KeyID),
+		Ciphertext:   blob.Ciphertext,
+	}, nil
+}
+
+func (p *awsKMSProvider) DecryptDataKey(ctx context.Context, key *EncryptedDataKey, encryptionContext map[string]string) ([]byte, error) {
+	return p.w.DecryptDirectWithContext(ctx, &wrapping.EncryptedBlobInfo{Ciphertext: key.Ciphertext}, encryptionContext)
+}
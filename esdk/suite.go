@@ -0,0 +1,134 @@
+package esdk
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+
+	// Register the hashes of the key derivations and signatures
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// AlgorithmSuite identifies the algorithms protecting a message. All suites
+// encrypt with AES-GCM using a 12 byte IV and a 16 byte tag.
+type AlgorithmSuite uint16
+
+// The algorithm suites of the message format. Suites that commit to their key
+// are only used in version 2 messages, all others in version 1 messages.
+const (
+	AES128GCM AlgorithmSuite = 0x0014
+	AES192GCM AlgorithmSuite = 0x0046
+	AES256GCM AlgorithmSuite = 0x0078
+
+	AES128GCMHKDFSHA256 AlgorithmSuite = 0x0114
+	AES192GCMHKDFSHA256 AlgorithmSuite = 0x0146
+	AES256GCMHKDFSHA256 AlgorithmSuite = 0x0178
+
+	AES128GCMHKDFSHA256ECDSAP256 AlgorithmSuite = 0x0214
+	AES192GCMHKDFSHA384ECDSAP384 AlgorithmSuite = 0x0346
+	AES256GCMHKDFSHA384ECDSAP384 AlgorithmSuite = 0x0378
+
+	AES256GCMHKDFSHA512CommitKey          AlgorithmSuite = 0x0478
+	AES256GCMHKDFSHA512CommitKeyECDSAP384 AlgorithmSuite = 0x0578
+)
+
+const (
+	ivSize        = 12
+	tagSize       = 16
+	commitKeySize = 32
+)
+
+// suiteInfo describes the algorithms of a suite
+type suiteInfo struct {
+	keySize int
+
+	// kdf is the hash of the HKDF, or zero if the data key is used as it is
+	kdf crypto.Hash
+
+	// curve and signatureHash are set for signing suites
+	curve         elliptic.Curve
+	signatureHash crypto.Hash
+
+	// commit is set for suites that commit to their key, which are those of
+	// version 2 messages
+	commit bool
+}
+
+func (s AlgorithmSuite) info() (*suiteInfo, error) {
+	switch s {
+	case AES128GCM:
+		return &suiteInfo{keySize: 16}, nil
+	case AES192GCM:
+		return &suiteInfo{keySize: 24}, nil
+	case AES256GCM:
+		return &suiteInfo{keySize: 32}, nil
+	case AES128GCMHKDFSHA256:
+		return &suiteInfo{keySize: 16, kdf: crypto.SHA256}, nil
+	case AES192GCMHKDFSHA256:
+		return &suiteInfo{keySize: 24, kdf: crypto.SHA256}, nil
+	case AES256GCMHKDFSHA256:
+		return &suiteInfo{keySize: 32, kdf: crypto.SHA256}, nil
+	case AES128GCMHKDFSHA256ECDSAP256:
+		return &suiteInfo{keySize: 16, kdf: crypto.SHA256, curve: elliptic.P256(), signatureHash: crypto.SHA256}, nil
+	case AES192GCMHKDFSHA384ECDSAP384:
+		return &suiteInfo{keySize: 24, kdf: crypto.SHA384, curve: elliptic.P384(), signatureHash: crypto.SHA384}, nil
+	case AES256GCMHKDFSHA384ECDSAP384:
+		return &suiteInfo{keySize: 32, kdf: crypto.SHA384, curve: elliptic.P384(), signatureHash: crypto.SHA384}, nil
+	case AES256GCMHKDFSHA512CommitKey:
+		return &suiteInfo{keySize: 32, kdf: crypto.SHA512, commit: true}, nil
+	case AES256GCMHKDFSHA512CommitKeyECDSAP384:
+		return &suiteInfo{keySize: 32, kdf: crypto.SHA512, curve: elliptic.P384(), signatureHash: crypto.SHA384, commit: true}, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm suite 0x%04x", uint16(s))
+	}
+}
+
+// version returns the message format version that uses the suite
+func (i *suiteInfo) version() byte {
+	if i.commit {
+		return 2
+	}
+	return 1
+}
+
+// deriveKeys derives the content encryption key for a message from the data
+// key, along with the commitment key of committing suites
+func (i *suiteInfo) deriveKeys(suite AlgorithmSuite, dataKey, messageID []byte) (key, commitKey []byte, err error) {
+	if len(dataKey) != i.keySize {
+		return nil, nil, fmt.Errorf("invalid data key length: expected %d, got %d", i.keySize, len(dataKey))
+	}
+	if i.kdf == 0 {
+		return dataKey, nil, nil
+	}
+
+	id := make([]byte, 2)
+	binary.BigEndian.PutUint16(id, uint16(suite))
+
+	if !i.commit {
+		// Version 1: no salt, the suite and message IDs as info
+		key, err := expand(i.kdf, dataKey, nil, append(id, messageID...), i.keySize)
+		return key, nil, err
+	}
+
+	// Version 2: the message ID as salt, with labels telling the keys apart
+	if key, err = expand(i.kdf, dataKey, messageID, append(id, "DERIVEKEY"...), i.keySize); err != nil {
+		return nil, nil, err
+	}
+	if commitKey, err = expand(i.kdf, dataKey, messageID, []byte("COMMITKEY"), commitKeySize); err != nil {
+		return nil, nil, err
+	}
+	return key, commitKey, nil
+}
+
+func expand(hash crypto.Hash, secret, salt, info []byte, size int) ([]byte, error) {
+	out := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(hash.New, secret, salt, info), out); err != nil {
+		return nil, fmt.Errorf("error deriving key: %w", err)
+	}
+	return out, nil
+}
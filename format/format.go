@@ -0,0 +1,225 @@
+// Package format defines the wire formats of EncryptedBlobInfo, so that
+// services exchanging blobs can pin the version they write and agree on one
+// in advance.
+//
+// Version0 is the bare protobuf encoding that this library, Vault and every
+// earlier release have always written. It has no marker, so it is read
+// leniently: any valid protobuf encoding is accepted.
+//
+// Version1 frames the protobuf encoding with a four byte header, the bytes
+// 0x00 'K' 'W' and the version number. No Version0 blob can start with a
+// zero byte, which tags field number 0, so the two are told apart without
+// ambiguity. Version1 encodings must be canonical, and are rejected
+// otherwise.
+//
+// The canonical encoding of a blob is the deterministic protobuf encoding:
+// fields in ascending field number order, each at most once, fields holding
+// their zero value omitted, and no unknown fields. An empty, non-nil
+// KeyInfo is kept, since wrappers may check for its presence. Marshal always
+// writes the canonical encoding, whatever the version, so equal blobs
+// marshal to equal bytes. Fields added to the message later come with a new
+// version rather than as unknown fields in an old one.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"google.golang.org/protobuf/proto"
+)
+
+// Version is a wire format version
+type Version uint8
+
+const (
+	// Version0 is the bare protobuf encoding
+	Version0 Version = 0
+	// Version1 is the canonical protobuf encoding with a version header
+	Version1 Version = 1
+
+	// Latest is the newest version this package writes
+	Latest = Version1
+)
+
+// magic starts the header of framed versions
+var magic = []byte{0x00, 'K', 'W'}
+
+const headerSize = 4
+
+// Supported returns the versions this package reads and writes, oldest
+// first
+func Supported() []Version {
+	return []Version{Version0, Version1}
+}
+
+func (v Version) supported() bool {
+	return v <= Latest
+}
+
+// String returns the version number
+func (v Version) String() string {
+	return strconv.Itoa(int(v))
+}
+
+// Marshal encodes blob in the given version
+func Marshal(blob *wrapping.EncryptedBlobInfo, v Version) ([]byte, error) {
+	if blob == nil {
+		return nil, errors.New("nil blob")
+	}
+	if !v.supported() {
+		return nil, fmt.Errorf("unsupported format version %d", v)
+	}
+	raw, err := proto.MarshalOptions{Deterministic: true}.Marshal(blob)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling blob: %w", err)
+	}
+	if v == Version0 {
+		return raw, nil
+	}
+	out := make([]byte, 0, headerSize+len(raw))
+	out = append(append(out, magic...), byte(v))
+	return append(out, raw...), nil
+}
+
+// Unmarshal decodes a blob in any supported version and returns the version
+// it was written in
+func Unmarshal(data []byte) (*wrapping.EncryptedBlobInfo, Version, error) {
+	v, raw, err := split(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	var blob wrapping.EncryptedBlobInfo
+	if err := proto.Unmarshal(raw, &blob); err != nil {
+		return nil, v, fmt.Errorf("error unmarshaling blob: %w", err)
+	}
+	if v != Version0 {
+		if err := checkCanonical(&blob, raw); err != nil {
+			return nil, v, err
+		}
+	}
+	return &blob, v, nil
+}
+
+// UnmarshalVersion decodes a blob that must be in the given version
+func UnmarshalVersion(data []byte, v Version) (*wrapping.EncryptedBlobInfo, error) {
+	blob, got, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if got != v {
+		return nil, fmt.Errorf("expected format version %d, got %d", v, got)
+	}
+	return blob, nil
+}
+
+// DetectVersion returns the version of data without decoding the blob
+func DetectVersion(data []byte) (Version, error) {
+	v, _, err := split(data)
+	return v, err
+}
+
+// split returns the version of data and the protobuf encoding it holds
+func split(data []byte) (Version, []byte, error) {
+	if len(data) == 0 || data[0] != magic[0] {
+		return Version0, data, nil
+	}
+	if len(data) < headerSize || !bytes.Equal(data[:len(magic)], magic) {
+		return 0, nil, errors.New("malformed blob header")
+	}
+	v := Version(data[len(magic)])
+	if v == Version0 || !v.supported() {
+		return 0, nil, fmt.Errorf("unsupported format version %d", v)
+	}
+	return v, data[headerSize:], nil
+}
+
+// IsCanonical reports whether data decodes as a blob and is its canonical
+// encoding, in whichever version it was written
+func IsCanonical(data []byte) bool {
+	_, raw, err := split(data)
+	if err != nil {
+		return false
+	}
+	var blob wrapping.EncryptedBlobInfo
+	if err := proto.Unmarshal(raw, &blob); err != nil {
+		return false
+	}
+	return checkCanonical(&blob, raw) == nil
+}
+
+func checkCanonical(blob *wrapping.EncryptedBlobInfo, raw []byte) error {
+	if len(blob.ProtoReflect().GetUnknown()) > 0 ||
+		blob.KeyInfo != nil && len(blob.KeyInfo.ProtoReflect().GetUnknown()) > 0 {
+		return errors.New("blob has unknown fields")
+	}
+	canonical, err := proto.MarshalOptions{Deterministic: true}.Marshal(blob)
+	if err != nil {
+		return fmt.Errorf("error marshaling blob: %w", err)
+	}
+	if !bytes.Equal(canonical, raw) {
+		return errors.New("blob encoding is not canonical")
+	}
+	return nil
+}
+
+// Negotiate returns the newest version supported by this package and both
+// lists, such as the versions pinned locally and those advertised by a peer
+func Negotiate(local, remote []Version) (Version, error) {
+	best, found := Version0, false
+	for _, l := range local {
+		if !l.supported() {
+			continue
+		}
+		for _, r := range remote {
+			if l == r && (!found || l > best) {
+				best, found = l, true
+			}
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no common format version between %s and %s", FormatVersions(local), FormatVersions(remote))
+	}
+	return best, nil
+}
+
+// FormatVersions returns a comma separated list of versions, as accepted
+// by ParseVersions, for advertising them in a header or configuration
+func FormatVersions(versions []Version) string {
+	s := make([]string, len(versions))
+	for i, v := range versions {
+		s[i] = v.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseVersions parses a comma separated list of versions. Unsupported
+// versions are kept, so that a newer peer's list can be negotiated with;
+// the result is sorted and without duplicates.
+func ParseVersions(s string) ([]Version, error) {
+	var versions []Version
+	seen := make(map[Version]bool)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid format version %q", f)
+		}
+		if v := Version(n); !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, errors.New("no format versions given")
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
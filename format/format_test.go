@@ -0,0 +1,170 @@
+package format
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func testBlob() *wrapping.EncryptedBlobInfo {
+	return &wrapping.EncryptedBlobInfo{
+		Ciphertext: []byte("ciphertext"),
+		IV:         []byte("iv"),
+		KeyInfo: &wrapping.KeyInfo{
+			Mechanism:  1,
+			KeyID:      "key",
+			WrappedKey: []byte("wrapped"),
+		},
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	blob := testBlob()
+
+	v0, err := Marshal(blob, Version0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	legacy, err := proto.Marshal(blob)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(v0, legacy) {
+		t.Fatal("version 0 differs from the protobuf encoding")
+	}
+
+	v1, err := Marshal(blob, Version1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(v1[:4], []byte{0x00, 'K', 'W', 1}) || !bytes.Equal(v1[4:], v0) {
+		t.Fatalf("unexpected version 1 encoding %x", v1)
+	}
+
+	for _, data := range [][]byte{v0, v1} {
+		out, v, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if d, _ := DetectVersion(data); d != v {
+			t.Fatalf("expected version %d, got %d", v, d)
+		}
+		if !proto.Equal(out, blob) {
+			t.Fatalf("expected %v, got %v", blob, out)
+		}
+		if !IsCanonical(data) {
+			t.Fatalf("%x: expected a canonical encoding", data)
+		}
+	}
+	if _, err := UnmarshalVersion(v1, Version0); err == nil {
+		t.Fatal("expected error for a pinned version mismatch")
+	}
+
+	// An empty key info is kept
+	data, err := Marshal(&wrapping.EncryptedBlobInfo{KeyInfo: &wrapping.KeyInfo{}}, Version1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if out, _, err := Unmarshal(data); err != nil || out.KeyInfo == nil {
+		t.Fatalf("expected an empty key info, got %v: %v", out, err)
+	}
+
+	if _, err := Marshal(blob, 7); err == nil {
+		t.Fatal("expected error for an unsupported version")
+	}
+	if _, err := Marshal(nil, Latest); err == nil {
+		t.Fatal("expected error for a nil blob")
+	}
+}
+
+func TestUnmarshal_Canonical(t *testing.T) {
+	canonical, err := Marshal(testBlob(), Version0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		Title string
+		Raw   []byte
+	}{
+		{"explicit zero value", protowire.AppendVarint(protowire.AppendTag(append([]byte(nil), canonical...), 4, protowire.VarintType), 0)},
+		{"unknown field", protowire.AppendBytes(protowire.AppendTag(append([]byte(nil), canonical...), 99, protowire.BytesType), []byte("x"))},
+		{"field order", protowire.AppendBytes(protowire.AppendTag(protowire.AppendBytes(protowire.AppendTag(nil, 2, protowire.BytesType), []byte("iv")), 1, protowire.BytesType), []byte("ct"))},
+		{"repeated field", protowire.AppendBytes(protowire.AppendTag(append([]byte(nil), canonical...), 1, protowire.BytesType), []byte("other"))},
+	}
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			// Version 0 is read leniently, version 1 strictly
+			if _, _, err := Unmarshal(c.Raw); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if _, _, err := Unmarshal(append([]byte{0x00, 'K', 'W', 1}, c.Raw...)); err == nil {
+				t.Fatal("expected error for a non-canonical encoding")
+			}
+			if IsCanonical(c.Raw) {
+				t.Fatal("expected a non-canonical encoding")
+			}
+		})
+	}
+
+	for _, data := range [][]byte{{0x00}, {0x00, 'K', 'X', 1}, {0x00, 'K', 'W', 0}, {0x00, 'K', 'W', 9}} {
+		if _, _, err := Unmarshal(data); err == nil {
+			t.Fatalf("%x: expected error", data)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		Title    string
+		Local    []Version
+		Remote   []Version
+		Expected Version
+		Error    bool
+	}{
+		{"newest common", Supported(), []Version{0, 1}, Version1, false},
+		{"pinned", []Version{Version0}, Supported(), Version0, false},
+		{"newer peer", Supported(), []Version{1, 2, 3}, Version1, false},
+		{"unsupported locally", []Version{5}, []Version{5}, 0, true},
+		{"disjoint", []Version{Version1}, []Version{Version0}, 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			v, err := Negotiate(c.Local, c.Remote)
+			if c.Error {
+				if err == nil {
+					t.Fatalf("expected error, got %d", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if v != c.Expected {
+				t.Fatalf("expected %d, got %d", c.Expected, v)
+			}
+		})
+	}
+}
+
+func TestParseVersions(t *testing.T) {
+	versions, err := ParseVersions(" 3, 1,0,1 ")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(versions, []Version{0, 1, 3}) {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+	if s := FormatVersions(versions); s != "0,1,3" {
+		t.Fatalf("unexpected list %q", s)
+	}
+	for _, s := range []string{"", "x", "1,256", ","} {
+		if _, err := ParseVersions(s); err == nil {
+			t.Fatalf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+//go:build go1.18
+// +build go1.18
+
+package wrapping
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// fuzzKey is a fixed DEK used to push fuzzed blobs through envelope
+// decryption the same way the envelope-based wrappers do
+var fuzzKey = bytes.Repeat([]byte{0x42}, 32)
+
+func fuzzSeedBlob(f *testing.F) []byte {
+	env, err := NewEnvelope(nil).Encrypt([]byte("foobar"), []byte("aad"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	blob, err := proto.Marshal(&EncryptedBlobInfo{
+		Ciphertext: env.Ciphertext,
+		IV:         env.IV,
+		KeyInfo: &KeyInfo{
+			Mechanism:  1,
+			KeyID:      "static-key",
+			WrappedKey: env.Key,
+		},
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	return blob
+}
+
+func FuzzEncryptedBlobInfoUnmarshal(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(fuzzSeedBlob(f))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var blob EncryptedBlobInfo
+		if err := proto.Unmarshal(data, &blob); err != nil {
+			return
+		}
+
+		// Anything that parsed must survive a round trip
+		remarshaled, err := proto.Marshal(&blob)
+		if err != nil {
+			t.Fatalf("error re-marshaling parsed blob: %v", err)
+		}
+		var again EncryptedBlobInfo
+		if err := proto.Unmarshal(remarshaled, &again); err != nil {
+			t.Fatalf("error parsing re-marshaled blob: %v", err)
+		}
+		if !proto.Equal(&blob, &again) {
+			t.Fatal("blob changed across a marshal round trip")
+		}
+
+		// Envelope decryption of arbitrary parsed values must fail cleanly
+		// rather than panic
+		_, _ = NewEnvelope(nil).Decrypt(&EnvelopeInfo{
+			Key:        fuzzKey,
+			IV:         blob.GetIV(),
+			Ciphertext: blob.GetCiphertext(),
+		}, nil)
+		_, _ = NewEnvelope(nil).Decrypt(&EnvelopeInfo{
+			Key:        blob.GetKeyInfo().GetWrappedKey(),
+			IV:         blob.GetIV(),
+			Ciphertext: blob.GetCiphertext(),
+		}, nil)
+	})
+}
+
+func FuzzEnvelopeDecrypt(f *testing.F) {
+	env, err := NewEnvelope(nil).Encrypt([]byte("foobar"), []byte("aad"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(env.Key, env.IV, env.Ciphertext, []byte("aad"))
+	f.Add([]byte{}, []byte{}, []byte{}, []byte{})
+
+	f.Fuzz(func(t *testing.T, key, iv, ciphertext, aad []byte) {
+		pt, err := NewEnvelope(nil).Decrypt(&EnvelopeInfo{
+			Key:        key,
+			IV:         iv,
+			Ciphertext: ciphertext,
+		}, aad)
+		if err != nil {
+			return
+		}
+		if len(pt) > len(ciphertext) {
+			t.Fatalf("plaintext of %d bytes is larger than ciphertext of %d bytes", len(pt), len(ciphertext))
+		}
+	})
+}
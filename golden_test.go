@@ -0,0 +1,68 @@
+package wrapping
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/golden"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGoldenVectors(t *testing.T) {
+	vectors := golden.Load(t, "testdata/golden.json", func(t *testing.T) []golden.Vector {
+		pt, aad := []byte("golden envelope plaintext"), []byte("golden aad")
+		env, err := NewEnvelope(nil).Encrypt(pt, aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envBlob, err := proto.Marshal(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret := []byte("golden test wrapper secret")
+		wrapped, err := NewTestEnvelopeWrapper(secret).Encrypt(context.Background(), []byte("golden wrapper plaintext"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrappedBlob, err := proto.Marshal(wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return []golden.Vector{
+			{Name: "envelope-aes-gcm", Plaintext: pt, AAD: aad, Blob: envBlob},
+			{Name: "test-wrapper-envelope", Key: secret, Plaintext: []byte("golden wrapper plaintext"), Blob: wrappedBlob},
+		}
+	})
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			var pt []byte
+			var err error
+			switch v.Name {
+			case "envelope-aes-gcm":
+				var env EnvelopeInfo
+				if err := proto.Unmarshal(v.Blob, &env); err != nil {
+					t.Fatal(err)
+				}
+				pt, err = NewEnvelope(nil).Decrypt(&env, v.AAD)
+			case "test-wrapper-envelope":
+				var blob EncryptedBlobInfo
+				if err := proto.Unmarshal(v.Blob, &blob); err != nil {
+					t.Fatal(err)
+				}
+				pt, err = NewTestEnvelopeWrapper(v.Key).Decrypt(context.Background(), &blob, v.AAD)
+			default:
+				t.Fatalf("unknown vector %q", v.Name)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.Plaintext, pt) {
+				t.Fatalf("expected %q, got %q", v.Plaintext, pt)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Package blobheader holds what the jwe and cose packages share about
+// describing an encrypted blob in the protected header of a message: the
+// names of the header parameters carrying the fields of a blob, and the blob
+// a wrapper is expected to produce.
+package blobheader
+
+import (
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/gcpckms"
+)
+
+// Names of the header parameters carrying the fields of a blob that the
+// message formats have no place for
+const (
+	Wrapper       = "kms_wrapper"
+	Mechanism     = "kms_mech"
+	HMACKeyID     = "kms_hmac_kid"
+	HMACMechanism = "kms_hmac_mech"
+	HMAC          = "kms_hmac"
+	Flags         = "kms_flags"
+	ValuePath     = "kms_value_path"
+	Wrapped       = "kms_wrapped"
+)
+
+// envelopeMechanisms are the KeyInfo mechanisms of envelope blobs for the
+// wrapper types that record one
+var envelopeMechanisms = map[string]uint64{
+	wrapping.AWSKMS:  awskms.AWSKMSEnvelopeAESGCMEncrypt,
+	wrapping.GCPCKMS: gcpckms.GCPKMSEnvelopeAESGCMEncrypt,
+}
+
+// Expected returns the blob w usually produces, with a placeholder for the
+// wrapped key of envelope blobs. The protected header is part of the
+// additional data of the encryption it describes, so it is built from this
+// prediction first; callers encrypt again on the rare occasions that the
+// prediction is wrong, such as a key rotating in between.
+func Expected(w wrapping.Wrapper) *wrapping.EncryptedBlobInfo {
+	blob := &wrapping.EncryptedBlobInfo{
+		KeyInfo: &wrapping.KeyInfo{
+			Mechanism:  envelopeMechanisms[w.Type()],
+			KeyID:      w.KeyID(),
+			HMACKeyID:  w.HMACKeyID(),
+			WrappedKey: []byte{0},
+		},
+	}
+	if w.Type() == wrapping.AEAD {
+		blob.KeyInfo.WrappedKey = nil
+	}
+	return blob
+}
@@ -0,0 +1,17 @@
+// Package ctxutil builds contexts for the adapters whose callers, such as
+// database/sql and message serializers, pass none.
+package ctxutil
+
+import (
+	"context"
+	"time"
+)
+
+// WithTimeout returns a background context bounded by d, or one without a
+// deadline when d is zero
+func WithTimeout(d time.Duration) (context.Context, context.CancelFunc) {
+	if d == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), d)
+}
@@ -0,0 +1,82 @@
+// Package golden reads and writes the test vector files used to check that
+// blobs produced by earlier versions of this library still decrypt.
+//
+// Vector files are written once, by running the owning package's tests with
+// -golden.generate, and committed. They are never regenerated; vectors for a
+// new mechanism go in a new file alongside the existing ones.
+package golden
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// generate is set to write vector files that do not exist yet. Existing files
+// are never overwritten: they record the output of a past version and must
+// keep decrypting unchanged.
+var generate = flag.Bool("golden.generate", false, "write missing golden vector files")
+
+// Vector is a single recorded encryption
+type Vector struct {
+	// Name identifies the vector, usually by mechanism
+	Name string `json:"name"`
+
+	// Key is the key material needed to decrypt, for wrappers whose key is
+	// held locally
+	Key []byte `json:"key,omitempty"`
+
+	Plaintext []byte `json:"plaintext"`
+	AAD       []byte `json:"aad,omitempty"`
+
+	// Blob is the serialized output, typically a marshaled EncryptedBlobInfo
+	Blob []byte `json:"blob"`
+}
+
+// Load reads the vectors stored at path. If the file does not exist and the
+// -golden.generate flag is set, gen is called and its output is written to
+// path first; otherwise a missing file fails the test.
+func Load(t *testing.T, path string, gen func(t *testing.T) []Vector) []Vector {
+	t.Helper()
+
+	raw, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrNotExist) && *generate:
+		raw, err = write(path, gen(t))
+		if err != nil {
+			t.Fatalf("error writing golden vectors: %v", err)
+		}
+	default:
+		t.Fatalf("error reading golden vectors: %v", err)
+	}
+
+	var vectors []Vector
+	if err := json.Unmarshal(raw, &vectors); err != nil {
+		t.Fatalf("error parsing golden vectors in %s: %v", path, err)
+	}
+	if len(vectors) == 0 {
+		t.Fatalf("no golden vectors found in %s", path)
+	}
+	return vectors
+}
+
+func write(path string, vectors []Vector) ([]byte, error) {
+	raw, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	raw = append(raw, '\n')
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		return nil, fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return raw, nil
+}
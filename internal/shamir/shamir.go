@@ -0,0 +1,170 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8), using the
+// same field and share layout as Vault's shamir package so that shares are
+// interchangeable: each share is the secret's length plus one byte, the last
+// byte holding the share's x coordinate.
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Split divides secret into parts shares, any threshold of which can
+// reconstruct it
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	return split(rand.Reader, secret, parts, threshold)
+}
+
+func split(randReader io.Reader, secret []byte, parts, threshold int) ([][]byte, error) {
+	switch {
+	case parts < threshold:
+		return nil, errors.New("parts cannot be less than threshold")
+	case parts > 255:
+		return nil, errors.New("parts cannot exceed 255")
+	case threshold < 2:
+		return nil, errors.New("threshold must be at least 2")
+	case len(secret) == 0:
+		return nil, errors.New("cannot split an empty secret")
+	}
+
+	// Distinct, non-zero x coordinates in random order
+	xs, err := perm(randReader, 255)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, parts)
+	for i := range out {
+		out[i] = make([]byte, len(secret)+1)
+		out[i][len(secret)] = xs[i] + 1
+	}
+
+	coefficients := make([]byte, threshold)
+	for j, b := range secret {
+		// A random polynomial of degree threshold-1 with the secret byte
+		// as its intercept
+		coefficients[0] = b
+		if _, err := io.ReadFull(randReader, coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("error generating polynomial: %w", err)
+		}
+		for i := range out {
+			out[i][j] = evaluate(coefficients, out[i][len(secret)])
+		}
+	}
+	return out, nil
+}
+
+// Combine reconstructs the secret from at least threshold shares. Given
+// fewer shares, or shares of different secrets, it returns a wrong value
+// rather than an error; that is inherent to the scheme.
+func Combine(parts [][]byte) ([]byte, error) {
+	if len(parts) < 2 {
+		return nil, errors.New("less than two parts cannot be used to reconstruct the secret")
+	}
+	size := len(parts[0])
+	if size < 2 {
+		return nil, errors.New("parts must be at least two bytes")
+	}
+
+	xs := make([]byte, len(parts))
+	seen := make(map[byte]bool, len(parts))
+	for i, part := range parts {
+		if len(part) != size {
+			return nil, errors.New("all parts must be the same length")
+		}
+		x := part[size-1]
+		if seen[x] {
+			return nil, errors.New("duplicate part detected")
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	secret := make([]byte, size-1)
+	ys := make([]byte, len(parts))
+	for j := range secret {
+		for i, part := range parts {
+			ys[i] = part[j]
+		}
+		secret[j] = interpolate(xs, ys)
+	}
+	return secret, nil
+}
+
+// evaluate returns the value at x of the polynomial with the given
+// coefficients, lowest degree first
+func evaluate(coefficients []byte, x byte) byte {
+	var out byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		out = add(mult(out, x), coefficients[i])
+	}
+	return out
+}
+
+// interpolate returns the value at zero of the polynomial through the given
+// points
+func interpolate(xs, ys []byte) byte {
+	var out byte
+	for i := range xs {
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			basis = mult(basis, div(xs[j], add(xs[i], xs[j])))
+		}
+		out = add(out, mult(ys[i], basis))
+	}
+	return out
+}
+
+func add(a, b byte) byte {
+	return a ^ b
+}
+
+// mult multiplies in GF(2^8) with the AES polynomial, without branching on
+// its inputs
+func mult(a, b byte) byte {
+	var out byte
+	for i := 0; i < 8; i++ {
+		out ^= a & -(b & 1)
+		b >>= 1
+		a = a<<1 ^ 0x1b&-(a>>7)
+	}
+	return out
+}
+
+// div divides a by b, which must not be zero, using b^254 as the inverse
+func div(a, b byte) byte {
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = mult(mult(inv, inv), b)
+	}
+	return mult(a, mult(inv, inv))
+}
+
+// perm returns a random permutation of 0..n-1
+func perm(randReader io.Reader, n int) ([]byte, error) {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(i)
+	}
+	var buf [2]byte
+	for i := n - 1; i > 0; i-- {
+		// Rejection sampling keeps the shuffle unbiased
+		limit := 65536 - 65536%(i+1)
+		for {
+			if _, err := io.ReadFull(randReader, buf[:]); err != nil {
+				return nil, fmt.Errorf("error generating coordinates: %w", err)
+			}
+			if v := int(buf[0])<<8 | int(buf[1]); v < limit {
+				j := v % (i + 1)
+				out[i], out[j] = out[j], out[i]
+				break
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,132 @@
+package shamir
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("test secret value")
+
+	parts, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(parts) != 5 {
+		t.Fatalf("expected 5 parts, got %d", len(parts))
+	}
+	for _, part := range parts {
+		if len(part) != len(secret)+1 {
+			t.Fatalf("bad part length: %d", len(part))
+		}
+	}
+
+	// Every combination of three parts reconstructs the secret
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				out, err := Combine([][]byte{parts[i], parts[j], parts[k]})
+				if err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				if !bytes.Equal(out, secret) {
+					t.Fatalf("parts %d, %d, %d: expected %q, got %q", i, j, k, secret, out)
+				}
+			}
+		}
+	}
+
+	// Two parts do not
+	out, err := Combine(parts[:2])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if bytes.Equal(out, secret) {
+		t.Fatal("secret reconstructed below the threshold")
+	}
+}
+
+func TestCombine_Vault(t *testing.T) {
+	// Shares of "vault shamir known answer" made by Split in Vault 1.15.0's
+	// shamir package, with a threshold of 3
+	parts := [][]byte{
+		mustDecodeHex(t, "d632089ee893c443f19f95bf848e9829a1a09e6341f2c9c02716"),
+		mustDecodeHex(t, "722f13574b2af62587c8aa106ca642a5521ed5ebfe711213a911"),
+		mustDecodeHex(t, "a7921cbd8ec78a81fcf7d70350fb85901e0d5ca243b1bb04ec6d"),
+		mustDecodeHex(t, "93f5394d4c91f0f133904bf6f2e60b02f8c15b0cca56f9454ca3"),
+		mustDecodeHex(t, "721bcd4636148c11146b3fdfa2625a0c8085a8870bd96d0bb71c"),
+	}
+	for _, subset := range [][][]byte{
+		parts[:3],
+		parts[2:],
+		{parts[4], parts[0], parts[2]},
+		parts,
+	} {
+		out, err := Combine(subset)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(out) != "vault shamir known answer" {
+			t.Fatalf("expected the secret, got %q", out)
+		}
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return b
+}
+
+func TestSplit_Invalid(t *testing.T) {
+	secret := []byte("test")
+	if _, err := Split(secret, 0, 0); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := Split(secret, 2, 3); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := Split(secret, 1000, 3); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := Split(secret, 10, 1); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := Split(nil, 3, 2); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestCombine_Invalid(t *testing.T) {
+	if _, err := Combine(nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := Combine([][]byte{[]byte("foo"), []byte("ba")}); err == nil {
+		t.Fatal("expected error for mismatched lengths")
+	}
+	if _, err := Combine([][]byte{[]byte("a"), []byte("b")}); err == nil {
+		t.Fatal("expected error for short parts")
+	}
+	if _, err := Combine([][]byte{[]byte("foo"), []byte("foo")}); err == nil {
+		t.Fatal("expected error for duplicate parts")
+	}
+}
+
+func TestField(t *testing.T) {
+	// The worked example from FIPS 197, section 4.2
+	if out := mult(0x57, 0x83); out != 0xc1 {
+		t.Fatalf("expected 0xc1, got %#x", out)
+	}
+	for a := 1; a < 256; a++ {
+		if out := mult(byte(a), div(1, byte(a))); out != 1 {
+			t.Fatalf("%#x times its inverse is %#x", a, out)
+		}
+	}
+	if out := evaluate([]byte{42}, 7); out != 42 {
+		t.Fatalf("expected 42, got %d", out)
+	}
+}
@@ -0,0 +1,46 @@
+// Package testenv isolates tests from the environment variables that the
+// code under test reads.
+package testenv
+
+import (
+	"os"
+	"testing"
+)
+
+// Set unsets the named variables, then sets those in env, and returns a func
+// that restores every variable it touched to its previous value. It is
+// meant to be deferred:
+//
+//	defer testenv.Set(t, names, map[string]string{"NAME": "value"})()
+func Set(t *testing.T, names []string, env map[string]string) func() {
+	t.Helper()
+
+	touched := append([]string(nil), names...)
+	for name := range env {
+		touched = append(touched, name)
+	}
+	old := make(map[string]string, len(touched))
+	for _, name := range touched {
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = v
+		}
+	}
+
+	for _, name := range names {
+		os.Unsetenv(name)
+	}
+	for name, v := range env {
+		if err := os.Setenv(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return func() {
+		for _, name := range touched {
+			os.Unsetenv(name)
+			if v, ok := old[name]; ok {
+				os.Setenv(name, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package testwrapper provides the wrappers that the tests of the packages
+// built on wrapping.Wrapper share.
+package testwrapper
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+)
+
+// AEAD returns an AES-GCM aead wrapper with the key ID "root" and a fixed key
+func AEAD(t *testing.T) *aead.Wrapper {
+	t.Helper()
+	return AEADWithKey(t, bytes.Repeat([]byte{1}, 32))
+}
+
+// AEADWithKey returns an AES-GCM aead wrapper with the key ID "root" and the
+// given key, for tests that check ciphertexts against the key
+func AEADWithKey(t *testing.T, key []byte) *aead.Wrapper {
+	t.Helper()
+	w := aead.NewWrapper(nil)
+	if _, err := w.SetConfig(map[string]string{"key_id": "root"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := w.SetAESGCMKeyBytes(key); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return w
+}
+
+// Counting counts the calls that reach the wrapper it embeds
+type Counting struct {
+	wrapping.Wrapper
+	Encrypts, Decrypts int64
+}
+
+// Encrypt counts the call and passes it on
+func (c *Counting) Encrypt(ctx context.Context, plaintext, aad []byte) (*wrapping.EncryptedBlobInfo, error) {
+	atomic.AddInt64(&c.Encrypts, 1)
+	return c.Wrapper.Encrypt(ctx, plaintext, aad)
+}
+
+// Decrypt counts the call and passes it on
+func (c *Counting) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, aad []byte) ([]byte, error) {
+	atomic.AddInt64(&c.Decrypts, 1)
+	return c.Wrapper.Decrypt(ctx, in, aad)
+}
@@ -0,0 +1,543 @@
+// Package jwe converts encrypted blobs to and from JSON Web Encryption (RFC
+// 7516), so that data protected by a wrapper can be stored and exchanged in
+// a format that other ecosystems understand.
+//
+// The content of a blob is always AES-GCM encrypted, which maps onto the
+// JWE "enc" values A128GCM, A192GCM and A256GCM. The wrapped data key of an
+// envelope blob becomes the JWE Encrypted Key, under the unregistered "alg"
+// value AlgorithmKMS: a consumer unwraps it by calling the KMS named by the
+// "kid" and HeaderWrapper header parameters. Blobs of the aead wrapper use
+// its key directly and are serialized with the "dir" algorithm.
+//
+// Everything else in the blob's KeyInfo, and its HMAC, travel in the
+// protected header.
+package jwe
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/blobheader"
+)
+
+const (
+	// AlgorithmKMS is the "alg" of JWEs whose content encryption key was
+	// wrapped by a KMS
+	AlgorithmKMS = "KMS"
+
+	// AlgorithmDirect is the "alg" of JWEs of the aead wrapper, which
+	// encrypts content with its key directly
+	AlgorithmDirect = "dir"
+
+	// EncryptionA128GCM, EncryptionA192GCM and EncryptionA256GCM are the
+	// supported "enc" values. Envelope blobs always use 256 bit keys.
+	EncryptionA128GCM = "A128GCM"
+	EncryptionA192GCM = "A192GCM"
+	EncryptionA256GCM = "A256GCM"
+)
+
+// Header parameters carrying the fields of a blob that JWE has no place for
+const (
+	HeaderWrapper       = blobheader.Wrapper
+	HeaderMechanism     = blobheader.Mechanism
+	HeaderHMACKeyID     = blobheader.HMACKeyID
+	HeaderHMACMechanism = blobheader.HMACMechanism
+	HeaderHMAC          = blobheader.HMAC
+	HeaderFlags         = blobheader.Flags
+	HeaderValuePath     = blobheader.ValuePath
+	HeaderWrapped       = blobheader.Wrapped
+)
+
+const (
+	ivSize  = 12
+	tagSize = 16
+)
+
+// Options configures Marshal and Encrypt. It is valid to pass nil Options.
+type Options struct {
+	// WrapperType is the type of the wrapper that produced the blob,
+	// recorded in the HeaderWrapper header parameter. Blobs without a
+	// wrapped key can only be marshaled when it is wrapping.AEAD. Encrypt
+	// sets it from the wrapper.
+	WrapperType string
+
+	// Encryption is the "enc" header parameter of aead wrapper blobs, which
+	// depends on the size of the wrapper's key. It defaults to
+	// EncryptionA256GCM.
+	Encryption string
+
+	// JSON selects the flattened JSON serialization instead of the compact
+	// one
+	JSON bool
+
+	// AAD is the JWE AAD member of the JSON serialization
+	AAD []byte
+}
+
+// Marshal serializes blob as a JWE.
+//
+// A blob's ciphertext is authenticated with the additional data given to
+// the wrapper, while a JWE's is authenticated with its encoded protected
+// header. Generic JWE libraries can therefore only decrypt blobs produced
+// by Encrypt; Marshal is for carrying existing blobs, which must be
+// decrypted with the original additional data.
+func Marshal(blob *wrapping.EncryptedBlobInfo, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	protected, err := encodeHeader(blob, opts)
+	if err != nil {
+		return nil, err
+	}
+	return serialize(blob, protected, opts)
+}
+
+// Unmarshal parses a JWE in the compact or JSON serialization into a blob.
+// The JSON serialization may be flattened or general with a single
+// recipient.
+func Unmarshal(data []byte) (*wrapping.EncryptedBlobInfo, error) {
+	p, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.blob, nil
+}
+
+// Encrypt encrypts plaintext with w and serializes the result as a JWE.
+// Unlike Marshal, the wrapper's additional data is the JWE AAD of RFC 7516,
+// covering the protected header and aad if given, so the result can be
+// decrypted by any JWE implementation holding the content encryption key.
+// aad is placed in the AAD member of the JSON serialization, which must be
+// selected through opts; the compact serialization has no room for it.
+func Encrypt(ctx context.Context, w wrapping.Wrapper, plaintext, aad []byte, opts *Options) ([]byte, error) {
+	if w == nil {
+		return nil, errors.New("wrapper is nil")
+	}
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	o.WrapperType = w.Type()
+	o.AAD = aad
+	if o.AAD != nil && !o.JSON {
+		return nil, errors.New("the compact serialization cannot carry additional data")
+	}
+
+	// The protected header is part of the JWE AAD, which the wrapper
+	// authenticates, so it describes the blob expected from the wrapper
+	expected := blobheader.Expected(w)
+	protected, err := encodeHeader(expected, &o)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		blob, err := w.Encrypt(ctx, plaintext, jweAAD(protected, o.AAD))
+		if err != nil {
+			return nil, err
+		}
+		actual, err := encodeHeader(blob, &o)
+		if err != nil {
+			return nil, err
+		}
+		if actual == protected {
+			return serialize(blob, protected, &o)
+		}
+		if attempt > 0 {
+			return nil, errors.New("protected header of the encrypted blob changed between attempts")
+		}
+		protected = actual
+	}
+}
+
+// Decrypt parses a JWE produced by Encrypt and decrypts it with w
+func Decrypt(ctx context.Context, w wrapping.Wrapper, data []byte) ([]byte, error) {
+	if w == nil {
+		return nil, errors.New("wrapper is nil")
+	}
+	p, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return w.Decrypt(ctx, p.blob, jweAAD(p.protected, p.aad))
+}
+
+// jweAAD computes the additional authenticated data of RFC 7516 section
+// 5.1, step 14
+func jweAAD(protected string, aad []byte) []byte {
+	if aad == nil {
+		return []byte(protected)
+	}
+	return []byte(protected + "." + encode(aad))
+}
+
+// encodeHeader builds the protected header describing blob and returns it
+// base64url encoded. json.Marshal sorts the keys of maps, so equal headers
+// always encode identically.
+func encodeHeader(blob *wrapping.EncryptedBlobInfo, opts *Options) (string, error) {
+	if blob == nil {
+		return "", errors.New("blob is nil")
+	}
+	key := blob.KeyInfo
+	if key == nil {
+		key = new(wrapping.KeyInfo)
+	}
+
+	header := map[string]interface{}{}
+	switch {
+	case len(key.WrappedKey) != 0:
+		header["alg"] = AlgorithmKMS
+		header["enc"] = EncryptionA256GCM
+	case opts.WrapperType == wrapping.AEAD:
+		header["alg"] = AlgorithmDirect
+		header["enc"] = EncryptionA256GCM
+		if opts.Encryption != "" {
+			if _, err := keySize(opts.Encryption); err != nil {
+				return "", err
+			}
+			header["enc"] = opts.Encryption
+		}
+	default:
+		return "", errors.New("blob has no wrapped key; only envelope and aead wrapper blobs can be serialized")
+	}
+
+	if key.KeyID != "" {
+		header["kid"] = key.KeyID
+	}
+	if opts.WrapperType != "" {
+		header[HeaderWrapper] = opts.WrapperType
+	}
+	if key.Mechanism != 0 {
+		header[HeaderMechanism] = key.Mechanism
+	}
+	if key.HMACKeyID != "" {
+		header[HeaderHMACKeyID] = key.HMACKeyID
+	}
+	if key.HMACMechanism != 0 {
+		header[HeaderHMACMechanism] = key.HMACMechanism
+	}
+	if key.Flags != 0 {
+		// Flags may not survive the float64 of a generic JSON parser
+		header[HeaderFlags] = strconv.FormatUint(key.Flags, 10)
+	}
+	if len(blob.HMAC) != 0 {
+		header[HeaderHMAC] = encode(blob.HMAC)
+	}
+	if blob.ValuePath != "" {
+		header[HeaderValuePath] = blob.ValuePath
+	}
+	if blob.Wrapped {
+		header[HeaderWrapped] = true
+	}
+
+	raw, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("error encoding protected header: %w", err)
+	}
+	return encode(raw), nil
+}
+
+// serialize writes the parts of blob as a JWE with the encoded protected
+// header
+func serialize(blob *wrapping.EncryptedBlobInfo, protected string, opts *Options) ([]byte, error) {
+	var encryptedKey, iv, ciphertext []byte
+	if blob.KeyInfo != nil {
+		encryptedKey = blob.KeyInfo.WrappedKey
+	}
+	ciphertext = blob.Ciphertext
+	if len(encryptedKey) != 0 {
+		iv = blob.IV
+	} else {
+		// The aead wrapper prefixes its ciphertexts with the IV
+		if len(ciphertext) < ivSize {
+			return nil, errors.New("ciphertext is too short to hold an IV")
+		}
+		iv, ciphertext = ciphertext[:ivSize], ciphertext[ivSize:]
+	}
+	if len(iv) != ivSize {
+		return nil, fmt.Errorf("invalid IV length: expected %d, got %d", ivSize, len(iv))
+	}
+	if len(ciphertext) < tagSize {
+		return nil, errors.New("ciphertext is too short to hold an authentication tag")
+	}
+	ciphertext, tag := ciphertext[:len(ciphertext)-tagSize], ciphertext[len(ciphertext)-tagSize:]
+
+	if !opts.JSON {
+		return []byte(strings.Join([]string{
+			protected, encode(encryptedKey), encode(iv), encode(ciphertext), encode(tag),
+		}, ".")), nil
+	}
+
+	out, err := json.Marshal(&jsonJWE{
+		Protected:    protected,
+		EncryptedKey: encode(encryptedKey),
+		IV:           encode(iv),
+		Ciphertext:   encode(ciphertext),
+		Tag:          encode(tag),
+		AAD:          encodeOptional(opts.AAD),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding JWE: %w", err)
+	}
+	return out, nil
+}
+
+// jsonJWE is the JSON serialization of a JWE, flattened or general
+type jsonJWE struct {
+	Protected    string          `json:"protected"`
+	Unprotected  json.RawMessage `json:"unprotected,omitempty"`
+	Header       json.RawMessage `json:"header,omitempty"`
+	EncryptedKey string          `json:"encrypted_key,omitempty"`
+	Recipients   []struct {
+		Header       json.RawMessage `json:"header,omitempty"`
+		EncryptedKey string          `json:"encrypted_key,omitempty"`
+	} `json:"recipients,omitempty"`
+	IV         string  `json:"iv"`
+	Ciphertext string  `json:"ciphertext"`
+	Tag        string  `json:"tag"`
+	AAD        *string `json:"aad,omitempty"`
+}
+
+// parsed is a JWE taken apart
+type parsed struct {
+	blob      *wrapping.EncryptedBlobInfo
+	protected string
+	aad       []byte
+}
+
+func parse(data []byte) (*parsed, error) {
+	data = bytes.TrimSpace(data)
+	var protected, encryptedKey, iv, ciphertext, tag string
+	var aad []byte
+	if bytes.HasPrefix(data, []byte("{")) {
+		var j jsonJWE
+		if err := json.Unmarshal(data, &j); err != nil {
+			return nil, fmt.Errorf("error decoding JWE: %w", err)
+		}
+		if len(j.Unprotected) != 0 || len(j.Header) != 0 {
+			return nil, errors.New("unprotected header parameters are not supported")
+		}
+		protected, encryptedKey, iv, ciphertext, tag = j.Protected, j.EncryptedKey, j.IV, j.Ciphertext, j.Tag
+		switch len(j.Recipients) {
+		case 0:
+		case 1:
+			if encryptedKey != "" {
+				return nil, errors.New("JWE has both a recipient and an encrypted key")
+			}
+			if len(j.Recipients[0].Header) != 0 {
+				return nil, errors.New("unprotected header parameters are not supported")
+			}
+			encryptedKey = j.Recipients[0].EncryptedKey
+		default:
+			return nil, fmt.Errorf("JWE has %d recipients; only one is supported", len(j.Recipients))
+		}
+		if j.AAD != nil {
+			var err error
+			if aad, err = decode("aad", *j.AAD); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		parts := strings.Split(string(data), ".")
+		if len(parts) != 5 {
+			return nil, fmt.Errorf("compact JWE has %d parts, not 5", len(parts))
+		}
+		protected, encryptedKey, iv, ciphertext, tag = parts[0], parts[1], parts[2], parts[3], parts[4]
+	}
+
+	p := &parsed{
+		protected: protected,
+		aad:       aad,
+	}
+	var err error
+	if p.blob, err = decodeHeader(protected); err != nil {
+		return nil, err
+	}
+	fields := map[string][]byte{}
+	for _, f := range []struct{ name, value string }{
+		{"encrypted_key", encryptedKey}, {"iv", iv}, {"ciphertext", ciphertext}, {"tag", tag},
+	} {
+		if fields[f.name], err = decode(f.name, f.value); err != nil {
+			return nil, err
+		}
+	}
+	if len(fields["iv"]) != ivSize {
+		return nil, fmt.Errorf("invalid IV length: expected %d, got %d", ivSize, len(fields["iv"]))
+	}
+	if len(fields["tag"]) != tagSize {
+		return nil, fmt.Errorf("invalid tag length: expected %d, got %d", tagSize, len(fields["tag"]))
+	}
+
+	ct := append(fields["ciphertext"], fields["tag"]...)
+	if p.blob.KeyInfo.WrappedKey != nil {
+		// Set by decodeHeader to mark the KMS algorithm
+		if len(fields["encrypted_key"]) == 0 {
+			return nil, fmt.Errorf("%s JWE has no encrypted key", AlgorithmKMS)
+		}
+		p.blob.KeyInfo.WrappedKey = fields["encrypted_key"]
+		p.blob.IV = fields["iv"]
+		p.blob.Ciphertext = ct
+	} else {
+		if len(fields["encrypted_key"]) != 0 {
+			return nil, fmt.Errorf("%s JWE must not have an encrypted key", AlgorithmDirect)
+		}
+		p.blob.Ciphertext = append(fields["iv"], ct...)
+	}
+	return p, nil
+}
+
+// decodeHeader parses the encoded protected header into a blob holding its
+// fields. The blob has a non-nil, empty WrappedKey if the header names
+// AlgorithmKMS.
+func decodeHeader(protected string) (*wrapping.EncryptedBlobInfo, error) {
+	raw, err := decode("protected header", protected)
+	if err != nil {
+		return nil, err
+	}
+	var header map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("error decoding protected header: %w", err)
+	}
+
+	blob := &wrapping.EncryptedBlobInfo{
+		KeyInfo: new(wrapping.KeyInfo),
+	}
+	str := func(name string) (string, error) {
+		v, ok := header[name]
+		if !ok {
+			return "", nil
+		}
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("header parameter %q is not a string", name)
+		}
+		return s, nil
+	}
+	number := func(name string) (uint64, error) {
+		v, ok := header[name]
+		if !ok {
+			return 0, nil
+		}
+		var s string
+		switch v := v.(type) {
+		case json.Number:
+			s = v.String()
+		case string:
+			s = v
+		default:
+			return 0, fmt.Errorf("header parameter %q is not a number", name)
+		}
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("header parameter %q is not an unsigned integer: %w", name, err)
+		}
+		return n, nil
+	}
+
+	if _, ok := header["crit"]; ok {
+		return nil, errors.New("critical header parameters are not supported")
+	}
+	if _, ok := header["zip"]; ok {
+		return nil, errors.New("compressed JWEs are not supported")
+	}
+	alg, err := str("alg")
+	if err != nil {
+		return nil, err
+	}
+	switch alg {
+	case AlgorithmKMS:
+		blob.KeyInfo.WrappedKey = []byte{}
+	case AlgorithmDirect:
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q; must be %s or %s", alg, AlgorithmKMS, AlgorithmDirect)
+	}
+	enc, err := str("enc")
+	if err != nil {
+		return nil, err
+	}
+	size, err := keySize(enc)
+	if err != nil {
+		return nil, err
+	}
+	if alg == AlgorithmKMS && size != 32 {
+		return nil, fmt.Errorf("%s JWEs must use %s", AlgorithmKMS, EncryptionA256GCM)
+	}
+
+	if blob.KeyInfo.KeyID, err = str("kid"); err != nil {
+		return nil, err
+	}
+	if blob.KeyInfo.HMACKeyID, err = str(HeaderHMACKeyID); err != nil {
+		return nil, err
+	}
+	if blob.ValuePath, err = str(HeaderValuePath); err != nil {
+		return nil, err
+	}
+	if blob.KeyInfo.Mechanism, err = number(HeaderMechanism); err != nil {
+		return nil, err
+	}
+	if blob.KeyInfo.HMACMechanism, err = number(HeaderHMACMechanism); err != nil {
+		return nil, err
+	}
+	if blob.KeyInfo.Flags, err = number(HeaderFlags); err != nil {
+		return nil, err
+	}
+	hmac, err := str(HeaderHMAC)
+	if err != nil {
+		return nil, err
+	}
+	if blob.HMAC, err = decode(HeaderHMAC, hmac); err != nil {
+		return nil, err
+	}
+	if len(blob.HMAC) == 0 {
+		blob.HMAC = nil
+	}
+	if v, ok := header[HeaderWrapped]; ok {
+		if blob.Wrapped, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("header parameter %q is not a boolean", HeaderWrapped)
+		}
+	}
+	return blob, nil
+}
+
+// keySize returns the key size of a supported "enc" value
+func keySize(enc string) (int, error) {
+	switch enc {
+	case EncryptionA128GCM:
+		return 16, nil
+	case EncryptionA192GCM:
+		return 24, nil
+	case EncryptionA256GCM:
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("unsupported content encryption %q; must be %s, %s or %s",
+			enc, EncryptionA128GCM, EncryptionA192GCM, EncryptionA256GCM)
+	}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func encodeOptional(b []byte) *string {
+	if b == nil {
+		return nil
+	}
+	s := encode(b)
+	return &s
+}
+
+func decode(name, s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", name, err)
+	}
+	return b, nil
+}
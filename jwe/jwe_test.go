@@ -0,0 +1,237 @@
+package jwe
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMarshal(t *testing.T) {
+	ctx := context.Background()
+	envelope, err := wrapping.NewTestEnvelopeWrapper([]byte("secret")).Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	envelope.KeyInfo.Mechanism = 1
+	envelope.KeyInfo.HMACKeyID = "hmac"
+	envelope.KeyInfo.Flags = 1<<63 + 1
+	envelope.HMAC = []byte("mac")
+	envelope.ValuePath = "a/b"
+	envelope.Wrapped = true
+
+	direct, err := testwrapper.AEADWithKey(t, bytes.Repeat([]byte{1}, 32)).Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, tc := range []struct {
+		Title string
+		Blob  *wrapping.EncryptedBlobInfo
+		Opts  *Options
+		Alg   string
+	}{
+		{"Envelope", envelope, nil, AlgorithmKMS},
+		{"EnvelopeJSON", envelope, &Options{WrapperType: wrapping.Test, JSON: true}, AlgorithmKMS},
+		{"Direct", direct, &Options{WrapperType: wrapping.AEAD}, AlgorithmDirect},
+		{"DirectJSON", direct, &Options{WrapperType: wrapping.AEAD, JSON: true, AAD: []byte("aad")}, AlgorithmDirect},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			out, err := Marshal(tc.Blob, tc.Opts)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if json := tc.Opts != nil && tc.Opts.JSON; json != bytes.HasPrefix(out, []byte("{")) {
+				t.Fatalf("unexpected serialization %s", out)
+			}
+
+			blob, err := Unmarshal(out)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !proto.Equal(blob, tc.Blob) {
+				t.Fatalf("expected %v, got %v", tc.Blob, blob)
+			}
+
+			p, err := parse(out)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			raw, _ := base64.RawURLEncoding.DecodeString(p.protected)
+			var header map[string]interface{}
+			if err := json.Unmarshal(raw, &header); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if header["alg"] != tc.Alg || header["enc"] != EncryptionA256GCM {
+				t.Fatalf("unexpected header %s", raw)
+			}
+		})
+	}
+
+	// Blobs that do not use AES-GCM have nothing to map onto JWE
+	transit := &wrapping.EncryptedBlobInfo{Ciphertext: []byte("vault:v1:abc")}
+	if _, err := Marshal(transit, &Options{WrapperType: wrapping.Transit}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{2}, 32)
+	w := testwrapper.AEADWithKey(t, key)
+
+	for _, tc := range []struct {
+		Title string
+		AAD   []byte
+		Opts  *Options
+	}{
+		{"Compact", nil, nil},
+		{"JSON", nil, &Options{JSON: true}},
+		{"JSONWithAAD", []byte("context"), &Options{JSON: true}},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			out, err := Encrypt(ctx, w, []byte("foo"), tc.AAD, tc.Opts)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			pt, err := Decrypt(ctx, w, out)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if string(pt) != "foo" {
+				t.Fatalf("expected foo, got %q", pt)
+			}
+
+			// Decrypt as a generic JWE implementation would
+			p, err := parse(out)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			block, _ := aes.NewCipher(key)
+			gcm, _ := cipher.NewGCM(block)
+			ct := p.blob.Ciphertext
+			aad := p.protected
+			if tc.AAD != nil {
+				aad += "." + base64.RawURLEncoding.EncodeToString(tc.AAD)
+			}
+			pt, err = gcm.Open(nil, ct[:12], ct[12:], []byte(aad))
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if string(pt) != "foo" {
+				t.Fatalf("expected foo, got %q", pt)
+			}
+		})
+	}
+
+	// Altering the protected header breaks authentication
+	out, err := Encrypt(ctx, w, []byte("foo"), nil, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	parts := strings.Split(string(out), ".")
+	header, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	header = bytes.Replace(header, []byte(`"kid":"root"`), []byte(`"kid":"fake"`), 1)
+	parts[0] = base64.RawURLEncoding.EncodeToString(header)
+	if _, err := Decrypt(ctx, w, []byte(strings.Join(parts, "."))); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := Encrypt(ctx, w, []byte("foo"), []byte("aad"), nil); err == nil {
+		t.Fatal("expected error for additional data in the compact serialization")
+	}
+}
+
+func TestEncrypt_Envelope(t *testing.T) {
+	ctx := context.Background()
+	w := wrapping.NewTestEnvelopeWrapper([]byte("secret"))
+
+	out, err := Encrypt(ctx, w, []byte("foo"), nil, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	pt, err := Decrypt(ctx, w, out)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %q", pt)
+	}
+
+	// The key ID changing in between is caught by encrypting again
+	w.SetKeyID("")
+	out, err = Encrypt(ctx, &keyIDWrapper{TestWrapper: w, keyID: "before"}, []byte("foo"), nil, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	blob, err := Unmarshal(out)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if blob.KeyInfo.KeyID != "" {
+		t.Fatalf("expected no key ID, got %q", blob.KeyInfo.KeyID)
+	}
+}
+
+// keyIDWrapper reports a key ID that differs from the one it encrypts with
+type keyIDWrapper struct {
+	*wrapping.TestWrapper
+	keyID string
+}
+
+func (k *keyIDWrapper) KeyID() string {
+	return k.keyID
+}
+
+func TestUnmarshal_Invalid(t *testing.T) {
+	header := func(h string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(h))
+	}
+	iv := base64.RawURLEncoding.EncodeToString(make([]byte, 12))
+	tag := base64.RawURLEncoding.EncodeToString(make([]byte, 16))
+	compact := func(h, key string) string {
+		return strings.Join([]string{header(h), key, iv, "", tag}, ".")
+	}
+
+	for _, tc := range []struct {
+		Title string
+		Input string
+	}{
+		{"Parts", "a.b.c"},
+		{"Algorithm", compact(`{"alg":"RSA-OAEP","enc":"A256GCM"}`, "AA")},
+		{"Encryption", compact(`{"alg":"dir","enc":"A128CBC-HS256"}`, "")},
+		{"KMSKeySize", compact(`{"alg":"KMS","enc":"A128GCM"}`, "AA")},
+		{"MissingKey", compact(`{"alg":"KMS","enc":"A256GCM"}`, "")},
+		{"DirectKey", compact(`{"alg":"dir","enc":"A256GCM"}`, "AA")},
+		{"Critical", compact(`{"alg":"dir","enc":"A256GCM","crit":["exp"],"exp":1}`, "")},
+		{"Compressed", compact(`{"alg":"dir","enc":"A256GCM","zip":"DEF"}`, "")},
+		{"Mechanism", compact(`{"alg":"dir","enc":"A256GCM","kms_mech":-1}`, "")},
+		{"IV", strings.Join([]string{header(`{"alg":"dir","enc":"A256GCM"}`), "", "AA", "", tag}, ".")},
+		{"Recipients", `{"protected":"` + header(`{"alg":"KMS","enc":"A256GCM"}`) + `","recipients":[{},{}],"iv":"` + iv + `","ciphertext":"","tag":"` + tag + `"}`},
+		{"Unprotected", `{"protected":"` + header(`{"alg":"dir","enc":"A256GCM"}`) + `","unprotected":{"kid":"x"},"iv":"` + iv + `","ciphertext":"","tag":"` + tag + `"}`},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			if _, err := Unmarshal([]byte(tc.Input)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	// The general serialization with one recipient is accepted
+	general := `{"protected":"` + header(`{"alg":"KMS","enc":"A256GCM"}`) + `","recipients":[{"encrypted_key":"AA"}],"iv":"` + iv + `","ciphertext":"","tag":"` + tag + `"}`
+	blob, err := Unmarshal([]byte(general))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(blob.KeyInfo.WrappedKey, []byte{0}) {
+		t.Fatalf("unexpected wrapped key %v", blob.KeyInfo.WrappedKey)
+	}
+}
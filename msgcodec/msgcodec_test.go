@@ -0,0 +1,150 @@
+package msgcodec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+)
+
+func TestCodec(t *testing.T) {
+	ctx := context.Background()
+	w := &testwrapper.Counting{Wrapper: testwrapper.AEAD(t)}
+	producer := NewCodec(w, nil)
+	consumer := NewCodec(w, nil)
+
+	for _, pt := range []string{"foo", "bar", ""} {
+		msg, err := producer.Encode(ctx, []byte(pt), []byte("orders"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !IsEncoded(msg) {
+			t.Fatalf("expected an encoded message, got %q", msg)
+		}
+		out, err := consumer.Decode(ctx, msg, []byte("orders"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(out) != pt {
+			t.Fatalf("expected %q, got %q", pt, out)
+		}
+		if _, err := consumer.Decode(ctx, msg, []byte("payments")); err == nil {
+			t.Fatal("expected error for other additional data")
+		}
+	}
+
+	// The data key reaches the wrapper once on either side
+	if w.Encrypts != 1 || w.Decrypts != 1 {
+		t.Fatalf("expected 1 encryption and decryption by the wrapper, got %d and %d", w.Encrypts, w.Decrypts)
+	}
+
+	for _, data := range [][]byte{nil, []byte("foo"), []byte("KMW"), []byte("KMW\x02foo")} {
+		if _, err := consumer.Decode(ctx, data, nil); !errors.Is(err, ErrNotEncoded) {
+			t.Fatalf("%q: expected %v, got %v", data, ErrNotEncoded, err)
+		}
+	}
+	if _, err := consumer.Decode(ctx, []byte("KMW\x01foo"), nil); err == nil || errors.Is(err, ErrNotEncoded) {
+		t.Fatalf("expected error decoding an invalid message, got %v", err)
+	}
+}
+
+func TestCodec_IgnoredAAD(t *testing.T) {
+	ctx := context.Background()
+
+	// Messages stay bound with a wrapper that ignores additional data
+	c := NewCodec(wrapping.NewTestEnvelopeWrapper([]byte("secret")), nil)
+	msg, err := c.Encode(ctx, []byte("foo"), []byte("orders"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := c.Decode(ctx, msg, []byte("payments")); err == nil {
+		t.Fatal("expected error for other additional data")
+	}
+}
+
+func TestValueSerializer(t *testing.T) {
+	c := NewCodec(testwrapper.AEAD(t), nil)
+	s := c.ValueSerializer()
+
+	data, err := s.Serialize("orders", []byte("foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out, err := s.Deserialize("orders", data)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(out) != "foo" {
+		t.Fatalf("expected %q, got %q", "foo", out)
+	}
+
+	// Records are bound to their topic
+	if _, err := s.Deserialize("payments", data); err == nil {
+		t.Fatal("expected error for another topic")
+	}
+
+	// Values are bound to being values, not just to the topic
+	if _, err := c.Decode(context.Background(), data, []byte("orders")); err == nil {
+		t.Fatal("expected error decoding a value without its binding")
+	}
+
+	// Tombstones are left as they are
+	if data, err := s.Serialize("orders", nil); err != nil || data != nil {
+		t.Fatalf("expected a nil value, got %q and %v", data, err)
+	}
+	if out, err := s.Deserialize("orders", nil); err != nil || out != nil {
+		t.Fatalf("expected a nil value, got %q and %v", out, err)
+	}
+}
+
+func TestPublisherHandler(t *testing.T) {
+	c := NewCodec(testwrapper.AEAD(t), nil)
+
+	// published records what reaches the bus
+	type message struct {
+		subject string
+		data    []byte
+	}
+	var published []message
+	publish := c.Publisher(func(subject string, data []byte) error {
+		published = append(published, message{subject, data})
+		return nil
+	})
+
+	var received []message
+	var errs []error
+	handle := c.Handler(func(subject string, data []byte) {
+		received = append(received, message{subject, data})
+	}, func(_ string, err error) {
+		errs = append(errs, err)
+	})
+
+	if err := publish("orders.created", []byte("foo")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(published) != 1 || bytes.Contains(published[0].data, []byte("foo")) {
+		t.Fatalf("expected an encoded message to be published, got %q", published)
+	}
+	handle(published[0].subject, published[0].data)
+	if len(received) != 1 || received[0].subject != "orders.created" || string(received[0].data) != "foo" {
+		t.Fatalf("expected the decoded message to be handled, got %q", received)
+	}
+
+	// Messages of another subject or not encoded fail to decode
+	handle("orders.deleted", published[0].data)
+	handle("orders.created", []byte("foo"))
+	if len(received) != 1 || len(errs) != 2 {
+		t.Fatalf("expected 2 errors and no other message, got %v and %q", errs, received)
+	}
+	if !errors.Is(errs[1], ErrNotEncoded) {
+		t.Fatalf("expected %v, got %v", ErrNotEncoded, errs[1])
+	}
+
+	// Without onError, messages that fail to decode are dropped
+	c.Handler(func(string, []byte) {
+		t.Fatal("unexpected message")
+	}, nil)("orders.created", []byte("foo"))
+}
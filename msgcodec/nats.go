@@ -0,0 +1,55 @@
+package msgcodec
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/ctxutil"
+)
+
+// PublishFunc publishes data to a subject, as the Publish method of a NATS
+// connection does
+type PublishFunc func(subject string, data []byte) error
+
+// HandlerFunc handles the data of a message received on a subject
+type HandlerFunc func(subject string, data []byte)
+
+// Publisher returns middleware that encodes data, bound to its subject,
+// before passing it to publish:
+//
+//	publish := codec.Publisher(nc.Publish)
+//	err := publish("orders.created", data)
+func (c *Codec) Publisher(publish PublishFunc) PublishFunc {
+	return func(subject string, data []byte) error {
+		ctx, cancel := ctxutil.WithTimeout(c.timeout)
+		defer cancel()
+		out, err := c.Encode(ctx, data, []byte(subject))
+		if err != nil {
+			return fmt.Errorf("error encoding message of subject %q: %w", subject, err)
+		}
+		return publish(subject, out)
+	}
+}
+
+// Handler returns middleware that decodes messages before passing them to
+// next. Messages that fail to decode are passed to onError instead, or
+// dropped if it is nil:
+//
+//	handle := codec.Handler(process, logError)
+//	sub, err := nc.Subscribe("orders.*", func(m *nats.Msg) { handle(m.Subject, m.Data) })
+//
+// Messages are decoded against the subject they were received on, so
+// subscriptions with wildcards are supported.
+func (c *Codec) Handler(next HandlerFunc, onError func(subject string, err error)) HandlerFunc {
+	return func(subject string, data []byte) {
+		ctx, cancel := ctxutil.WithTimeout(c.timeout)
+		out, err := c.Decode(ctx, data, []byte(subject))
+		cancel()
+		if err != nil {
+			if onError != nil {
+				onError(subject, fmt.Errorf("error decoding message of subject %q: %w", subject, err))
+			}
+			return
+		}
+		next(subject, out)
+	}
+}
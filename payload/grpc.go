@@ -0,0 +1,274 @@
+package payload
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	directionRequest  = "request"
+	directionResponse = "response"
+)
+
+// UnaryClientInterceptor encrypts the selected fields of requests and
+// decrypts those of responses. Requests are copied rather than modified.
+func (s *Sealer) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		sealed, err := s.sealMessage(ctx, req, method, directionRequest, 0)
+		if err != nil {
+			return err
+		}
+		if err := invoker(ctx, method, sealed, reply, cc, opts...); err != nil {
+			return err
+		}
+		return s.openMessage(ctx, reply, method, directionResponse, 0)
+	}
+}
+
+// UnaryServerInterceptor decrypts the selected fields of requests and
+// encrypts those of responses. Requests that fail to decrypt are rejected
+// with codes.InvalidArgument.
+func (s *Sealer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := s.openMessage(ctx, req, info.FullMethod, directionRequest, 0); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return s.sealMessage(ctx, resp, info.FullMethod, directionResponse, 0)
+	}
+}
+
+// StreamClientInterceptor encrypts the selected fields of the messages a
+// client sends on a stream and decrypts those it receives
+func (s *Sealer) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &clientStream{ClientStream: cs, s: s, method: method}, nil
+	}
+}
+
+// StreamServerInterceptor decrypts the selected fields of the messages a
+// server receives on a stream and encrypts those it sends
+func (s *Sealer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, s: s, method: info.FullMethod})
+	}
+}
+
+// clientStream seals sent messages and opens received ones, counting each
+// direction so that messages cannot be reordered or replayed in a stream
+type clientStream struct {
+	grpc.ClientStream
+	s          *Sealer
+	method     string
+	sent, recv uint64
+}
+
+func (c *clientStream) SendMsg(m interface{}) error {
+	sealed, err := c.s.sealMessage(c.Context(), m, c.method, directionRequest, c.sent)
+	if err != nil {
+		return err
+	}
+	c.sent++
+	return c.ClientStream.SendMsg(sealed)
+}
+
+func (c *clientStream) RecvMsg(m interface{}) error {
+	if err := c.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+	seq := c.recv
+	c.recv++
+	return c.s.openMessage(c.Context(), m, c.method, directionResponse, seq)
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	s          *Sealer
+	method     string
+	sent, recv uint64
+}
+
+func (ss *serverStream) SendMsg(m interface{}) error {
+	sealed, err := ss.s.sealMessage(ss.Context(), m, ss.method, directionResponse, ss.sent)
+	if err != nil {
+		return err
+	}
+	ss.sent++
+	return ss.ServerStream.SendMsg(sealed)
+}
+
+func (ss *serverStream) RecvMsg(m interface{}) error {
+	if err := ss.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	seq := ss.recv
+	ss.recv++
+	return ss.s.openMessage(ss.Context(), m, ss.method, directionRequest, seq)
+}
+
+// sealMessage returns a copy of m with its selected fields encrypted
+func (s *Sealer) sealMessage(ctx context.Context, m interface{}, method, direction string, seq uint64) (interface{}, error) {
+	if len(s.fields) == 0 {
+		return m, nil
+	}
+	msg, ok := m.(protov1.Message)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "cannot encrypt fields of %T, which is not a protobuf message", m)
+	}
+	msg = protov1.Clone(msg)
+	err := s.transformFields(protov1.MessageV2(msg).ProtoReflect(), func(name, path string, manifest, b []byte) ([]byte, error) {
+		return s.Seal(ctx, b, bindAAD(method, direction, strconv.FormatUint(seq, 10), name, path, string(manifest)))
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error encrypting %s: %v", direction, err)
+	}
+	return msg, nil
+}
+
+// openMessage decrypts the selected fields of m in place
+func (s *Sealer) openMessage(ctx context.Context, m interface{}, method, direction string, seq uint64) error {
+	if len(s.fields) == 0 {
+		return nil
+	}
+	msg, ok := m.(protov1.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "cannot decrypt fields of %T, which is not a protobuf message", m)
+	}
+	err := s.transformFields(protov1.MessageV2(msg).ProtoReflect(), func(name, path string, manifest, b []byte) ([]byte, error) {
+		return s.Open(ctx, b, bindAAD(method, direction, strconv.FormatUint(seq, 10), name, path, string(manifest)))
+	})
+	if err != nil {
+		code := codes.InvalidArgument
+		if direction == directionResponse {
+			code = codes.DataLoss
+		}
+		return status.Errorf(code, "error decrypting %s: %v", direction, err)
+	}
+	return nil
+}
+
+// selectedField is an occurrence of a selected field in a message: the
+// field of m, or its element at index if the field is repeated, at path
+type selectedField struct {
+	m     protoreflect.Message
+	fd    protoreflect.FieldDescriptor
+	index int
+	path  string
+}
+
+// transformFields replaces the values of the selected fields of m and of the
+// messages it holds with the results of fn, given the field's full name, its
+// path within m and the manifest of the paths of all the selected fields of
+// m. Binding a value to its path keeps repeated elements and the fields of
+// repeated and map value messages from being reordered or swapped, and
+// binding it to the manifest keeps selected fields from being dropped.
+func (s *Sealer) transformFields(m protoreflect.Message, fn func(name, path string, manifest, b []byte) ([]byte, error)) error {
+	var fields []selectedField
+	if err := s.selectFields(m, nil, &fields); err != nil {
+		return err
+	}
+	paths := make([]string, len(fields))
+	for i, f := range fields {
+		paths[i] = f.path
+	}
+	sort.Strings(paths)
+	manifest := bindAAD(paths...)
+
+	values := make([][]byte, len(fields))
+	for i, f := range fields {
+		v := f.m.Get(f.fd)
+		if f.index >= 0 {
+			v = v.List().Get(f.index)
+		}
+		var err error
+		if values[i], err = fn(string(f.fd.FullName()), f.path, manifest, v.Bytes()); err != nil {
+			return err
+		}
+	}
+	for i, f := range fields {
+		if f.index < 0 {
+			f.m.Set(f.fd, protoreflect.ValueOfBytes(values[i]))
+		} else {
+			f.m.Mutable(f.fd).List().Set(f.index, protoreflect.ValueOfBytes(values[i]))
+		}
+	}
+	return nil
+}
+
+// selectFields appends the selected fields of m and of the messages it holds
+// to out. The path of a field is made of the names of the fields leading to
+// it, with the index of list elements and the key of map values. Singular
+// fields that do not track presence are selected even when empty, so that
+// clearing one is detected.
+func (s *Sealer) selectFields(m protoreflect.Message, prefix []string, out *[]selectedField) error {
+	fds := m.Descriptor().Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+		name := string(fd.FullName())
+		path := append(prefix[:len(prefix):len(prefix)], string(fd.Name()))
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				if s.fields[name] {
+					return fmt.Errorf("field %s is a map, not bytes", name)
+				}
+				continue
+			}
+			var err error
+			m.Get(fd).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				err = s.selectFields(v.Message(), append(path[:len(path):len(path)], k.String()), out)
+				return err == nil
+			})
+			if err != nil {
+				return err
+			}
+
+		case fd.Message() != nil:
+			if !m.Has(fd) {
+				continue
+			}
+			if !fd.IsList() {
+				if err := s.selectFields(m.Get(fd).Message(), path, out); err != nil {
+					return err
+				}
+				continue
+			}
+			l := m.Get(fd).List()
+			for j := 0; j < l.Len(); j++ {
+				if err := s.selectFields(l.Get(j).Message(), append(path[:len(path):len(path)], strconv.Itoa(j)), out); err != nil {
+					return err
+				}
+			}
+
+		case s.fields[name]:
+			if fd.Kind() != protoreflect.BytesKind {
+				return fmt.Errorf("field %s is of kind %s, not bytes", name, fd.Kind())
+			}
+			if !fd.IsList() {
+				if m.Has(fd) || !fd.HasPresence() {
+					*out = append(*out, selectedField{m, fd, -1, string(bindAAD(path...))})
+				}
+				continue
+			}
+			for j := 0; j < m.Get(fd).List().Len(); j++ {
+				*out = append(*out, selectedField{m, fd, j, string(bindAAD(append(path, strconv.Itoa(j))...))})
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package payload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	testpb "google.golang.org/grpc/test/grpc_testing"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testService echoes the payloads it receives
+type testService struct {
+	testpb.TestServiceServer
+}
+
+func (testService) UnaryCall(_ context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	return &testpb.SimpleResponse{Payload: req.Payload}, nil
+}
+
+func (testService) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: req.Payload}); err != nil {
+			return err
+		}
+	}
+}
+
+// testGRPC serves testService behind the interceptors of s, recording the
+// bodies of the unary requests it receives before they are decrypted. It
+// returns a function dialing the server with the given options.
+func testGRPC(t *testing.T, s *Sealer, received *[][]byte) (func(...grpc.DialOption) *grpc.ClientConn, func()) {
+	t.Helper()
+	record := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		*received = append(*received, append([]byte(nil), req.(*testpb.SimpleRequest).GetPayload().GetBody()...))
+		return handler(ctx, req)
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(record, s.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(s.StreamServerInterceptor()),
+	)
+	testpb.RegisterTestServiceServer(srv, testService{})
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+
+	var conns []*grpc.ClientConn
+	dial := func(opts ...grpc.DialOption) *grpc.ClientConn {
+		opts = append(opts, grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+		conn, err := grpc.Dial("bufnet", opts...)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		conns = append(conns, conn)
+		return conn
+	}
+	return dial, func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		srv.Stop()
+	}
+}
+
+func TestInterceptors_Unary(t *testing.T) {
+	ctx := context.Background()
+	s := NewSealer(testwrapper.AEAD(t), &Options{
+		SessionKeyLifetime: time.Hour,
+		Fields:             []string{"grpc.testing.Payload.body"},
+	})
+	var received [][]byte
+	dial, stop := testGRPC(t, s, &received)
+	defer stop()
+
+	client := testpb.NewTestServiceClient(dial(grpc.WithUnaryInterceptor(s.UnaryClientInterceptor())))
+	req := &testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("secret")}}
+	resp, err := client.UnaryCall(ctx, req)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(resp.GetPayload().GetBody()) != "secret" {
+		t.Fatalf("expected %q, got %q", "secret", resp.GetPayload().GetBody())
+	}
+	if string(req.Payload.Body) != "secret" {
+		t.Fatalf("request was modified: %q", req.Payload.Body)
+	}
+	if len(received) != 1 || len(received[0]) == 0 || bytes.Contains(received[0], []byte("secret")) {
+		t.Fatalf("expected an encrypted request body, got %q", received)
+	}
+
+	// Requests that are not encrypted are rejected
+	plain := testpb.NewTestServiceClient(dial())
+	_, err = plain.UnaryCall(ctx, req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected %s, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestInterceptors_Stream(t *testing.T) {
+	ctx := context.Background()
+	s := NewSealer(testwrapper.AEAD(t), &Options{
+		SessionKeyLifetime: time.Hour,
+		Fields:             []string{"grpc.testing.Payload.body"},
+	})
+	var received [][]byte
+	dial, stop := testGRPC(t, s, &received)
+	defer stop()
+
+	client := testpb.NewTestServiceClient(dial(grpc.WithStreamInterceptor(s.StreamClientInterceptor())))
+	stream, err := client.FullDuplexCall(ctx)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, body := range []string{"foo", "bar", "baz"} {
+		if err := stream.Send(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte(body)}}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(resp.GetPayload().GetBody()) != body {
+			t.Fatalf("expected %q, got %q", body, resp.GetPayload().GetBody())
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestInterceptors_Fields(t *testing.T) {
+	ctx := context.Background()
+	s := NewSealer(testwrapper.AEAD(t), &Options{Fields: []string{"grpc.testing.Payload.type"}})
+
+	// Only bytes fields can be encrypted
+	req := &testpb.SimpleRequest{Payload: &testpb.Payload{Type: testpb.PayloadType_UNCOMPRESSABLE}}
+	if _, err := s.sealMessage(ctx, req, "/method", directionRequest, 0); status.Code(err) != codes.Internal {
+		t.Fatalf("expected %s, got %v", codes.Internal, err)
+	}
+
+	// Messages are bound to their position in a stream
+	s = NewSealer(testwrapper.AEAD(t), &Options{Fields: []string{"grpc.testing.Payload.body"}})
+	req = &testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("secret")}}
+	sealed, err := s.sealMessage(ctx, req, "/method", directionRequest, 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.openMessage(ctx, sealed, "/method", directionRequest, 1); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected %s, got %v", codes.InvalidArgument, err)
+	}
+	if err := s.openMessage(ctx, sealed, "/method", directionResponse, 0); status.Code(err) != codes.DataLoss {
+		t.Fatalf("expected %s, got %v", codes.DataLoss, err)
+	}
+}
+
+// testRecordType describes a message with selected fields at every kind of
+// position:
+//
+//	message Record {
+//	  repeated bytes chunks = 1;
+//	  bytes secret = 2;
+//	  repeated Record children = 3;
+//	  map<string, Record> named = 4;
+//	}
+func testRecordType(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	field := func(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    label.Enum(),
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional, repeated := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	bytesType, messageType := descriptorpb.FieldDescriptorProto_TYPE_BYTES, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("payload_test.proto"),
+		Package: proto.String("payloadtest"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Record"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("chunks", 1, repeated, bytesType, ""),
+				field("secret", 2, optional, bytesType, ""),
+				field("children", 3, repeated, messageType, ".payloadtest.Record"),
+				field("named", 4, repeated, messageType, ".payloadtest.Record.NamedEntry"),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("NamedEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("key", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("value", 2, optional, messageType, ".payloadtest.Record"),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+		}},
+	}, new(protoregistry.Files))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return fd.Messages().ByName("Record")
+}
+
+func TestInterceptors_FieldPaths(t *testing.T) {
+	ctx := context.Background()
+	md := testRecordType(t)
+	chunks, secret := md.Fields().ByName("chunks"), md.Fields().ByName("secret")
+	children, named := md.Fields().ByName("children"), md.Fields().ByName("named")
+	s := NewSealer(testwrapper.AEAD(t), &Options{Fields: []string{"payloadtest.Record.chunks", "payloadtest.Record.secret"}})
+
+	record := func(secretValue string) protoreflect.Message {
+		m := dynamicpb.NewMessage(md)
+		m.Set(secret, protoreflect.ValueOfBytes([]byte(secretValue)))
+		return m
+	}
+	m := record("top")
+	m.Mutable(chunks).List().Append(protoreflect.ValueOfBytes([]byte("first")))
+	m.Mutable(chunks).List().Append(protoreflect.ValueOfBytes([]byte("second")))
+	m.Mutable(children).List().Append(protoreflect.ValueOfMessage(record("child 0")))
+	m.Mutable(children).List().Append(protoreflect.ValueOfMessage(record("child 1")))
+	m.Mutable(named).Map().Set(protoreflect.ValueOfString("x").MapKey(), protoreflect.ValueOfMessage(record("named x")))
+	m.Mutable(named).Map().Set(protoreflect.ValueOfString("y").MapKey(), protoreflect.ValueOfMessage(record("named y")))
+
+	sealed, err := s.sealMessage(ctx, protov1.MessageV1(m.Interface()), "/method", directionRequest, 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	opened := protov1.Clone(sealed.(protov1.Message))
+	if err := s.openMessage(ctx, opened, "/method", directionRequest, 0); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !proto.Equal(protov1.MessageV2(opened), m.Interface()) {
+		t.Fatalf("expected %v, got %v", m, opened)
+	}
+
+	swap := func(l protoreflect.List) {
+		a, b := l.Get(0), l.Get(1)
+		l.Set(0, b)
+		l.Set(1, a)
+	}
+	x, y := protoreflect.ValueOfString("x").MapKey(), protoreflect.ValueOfString("y").MapKey()
+	for _, tc := range []struct {
+		Title  string
+		Tamper func(protoreflect.Message)
+	}{
+		{"swap repeated elements", func(m protoreflect.Message) { swap(m.Mutable(chunks).List()) }},
+		{"swap repeated messages", func(m protoreflect.Message) { swap(m.Mutable(children).List()) }},
+		{"swap map values", func(m protoreflect.Message) {
+			mp := m.Mutable(named).Map()
+			vx, vy := mp.Get(x), mp.Get(y)
+			mp.Set(x, vy)
+			mp.Set(y, vx)
+		}},
+		{"drop repeated element", func(m protoreflect.Message) { m.Mutable(chunks).List().Truncate(1) }},
+		{"drop map value", func(m protoreflect.Message) { m.Mutable(named).Map().Clear(y) }},
+		{"drop message", func(m protoreflect.Message) { m.Clear(children) }},
+		{"clear field", func(m protoreflect.Message) { m.Clear(secret) }},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			tampered := protov1.Clone(sealed.(protov1.Message))
+			tc.Tamper(protov1.MessageV2(tampered).ProtoReflect())
+			if err := s.openMessage(ctx, tampered, "/method", directionRequest, 0); status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("expected %s, got %v", codes.InvalidArgument, err)
+			}
+		})
+	}
+}
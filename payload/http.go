@@ -0,0 +1,247 @@
+package payload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// ContentType is the media type of encrypted HTTP bodies
+	ContentType = "application/x-kms-wrapped-payload"
+
+	// HeaderContentType carries the media type of the body before it was
+	// encrypted. It is bound to the body.
+	HeaderContentType = "Kms-Wrapped-Content-Type"
+)
+
+// errTooLarge is returned for bodies over the maximum size
+var errTooLarge = errors.New("body is too large")
+
+// Handler returns middleware that decrypts the bodies of requests before
+// passing them to next, and encrypts the bodies of its responses.
+//
+// Requests with a body must be encrypted, as RoundTripper does, or are
+// rejected with status 415; requests without one pass through. Responses
+// are buffered in full before they are encrypted, so next cannot stream
+// them. Bodies are bound to the method, path and query string of the
+// request, which proxies in between must therefore not rewrite; without a
+// session key lifetime, this takes a wrapper that authenticates additional
+// data.
+func (s *Sealer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code, err := s.openRequest(r); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		rec := &responseBuffer{header: http.Header{}}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		if !hasBody(r.Method, rec.status) {
+			w.WriteHeader(rec.status)
+			return
+		}
+
+		contentType := rec.header.Get("Content-Type")
+		if contentType == "" && rec.body.Len() != 0 {
+			// As net/http would have otherwise
+			contentType = http.DetectContentType(rec.body.Bytes())
+		}
+		sealed, err := s.Seal(r.Context(), rec.body.Bytes(), httpAAD(directionResponse, r.Method, r.URL.EscapedPath(), r.URL.RawQuery, contentType))
+		if err != nil {
+			for k := range w.Header() {
+				delete(w.Header(), k)
+			}
+			http.Error(w, "error encrypting response", http.StatusInternalServerError)
+			return
+		}
+		setEncrypted(w.Header(), contentType, len(sealed))
+		w.WriteHeader(rec.status)
+		w.Write(sealed)
+	})
+}
+
+// openRequest replaces the encrypted body of r with its plaintext, and
+// returns the status to respond with if it cannot
+func (s *Sealer) openRequest(r *http.Request) (int, error) {
+	if r.ContentLength == 0 {
+		return 0, nil
+	}
+	if r.Header.Get("Content-Type") != ContentType {
+		return http.StatusUnsupportedMediaType, fmt.Errorf("request body must be of type %s", ContentType)
+	}
+	data, err := s.readBody(r.Body)
+	if err == errTooLarge {
+		return http.StatusRequestEntityTooLarge, err
+	}
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("error reading request body: %w", err)
+	}
+	contentType := r.Header.Get(HeaderContentType)
+	plaintext, err := s.Open(r.Context(), data, httpAAD(directionRequest, r.Method, r.URL.EscapedPath(), r.URL.RawQuery, contentType))
+	if err != nil {
+		return http.StatusBadRequest, errors.New("error decrypting request body")
+	}
+	setPlaintext(r.Header, contentType)
+	r.Body = ioutil.NopCloser(bytes.NewReader(plaintext))
+	r.ContentLength = int64(len(plaintext))
+	return 0, nil
+}
+
+// RoundTripper returns a transport that encrypts the bodies of requests and
+// decrypts the bodies of the responses of a server using Handler. Responses
+// that are not encrypted, such as the errors of a proxy in between, are
+// returned as errors. A nil next uses http.DefaultTransport.
+func (s *Sealer) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{s: s, next: next}
+}
+
+type roundTripper struct {
+	s    *Sealer
+	next http.RoundTripper
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	path, query := req.URL.EscapedPath(), req.URL.RawQuery
+
+	// RoundTrippers must not modify the request they are given
+	out := req.Clone(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		data, err := t.s.readBody(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+		contentType := req.Header.Get("Content-Type")
+		sealed, err := t.s.Seal(ctx, data, httpAAD(directionRequest, req.Method, path, query, contentType))
+		if err != nil {
+			return nil, err
+		}
+		setEncrypted(out.Header, contentType, len(sealed))
+		out.ContentLength = int64(len(sealed))
+		out.Body = ioutil.NopCloser(bytes.NewReader(sealed))
+		out.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(sealed)), nil
+		}
+	}
+
+	resp, err := t.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	if !hasBody(req.Method, resp.StatusCode) {
+		return resp, nil
+	}
+	if err := t.s.openResponse(ctx, resp, req.Method, path, query); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// openResponse replaces the encrypted body of resp with its plaintext
+func (s *Sealer) openResponse(ctx context.Context, resp *http.Response, method, path, query string) error {
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != ContentType {
+		return fmt.Errorf("response with status %q is not encrypted", resp.Status)
+	}
+	data, err := s.readBody(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+	contentType := resp.Header.Get(HeaderContentType)
+	plaintext, err := s.Open(ctx, data, httpAAD(directionResponse, method, path, query, contentType))
+	if err != nil {
+		return fmt.Errorf("error decrypting response body: %w", err)
+	}
+	setPlaintext(resp.Header, contentType)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(plaintext))
+	resp.ContentLength = int64(len(plaintext))
+	return nil
+}
+
+func (s *Sealer) readBody(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, s.maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxBodySize {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+// httpAAD binds a body to its request and original media type
+func httpAAD(direction, method, path, query, contentType string) []byte {
+	return bindAAD(direction, method, path, query, contentType)
+}
+
+// hasBody reports whether a response may have a body
+func hasBody(method string, status int) bool {
+	switch {
+	case method == http.MethodHead,
+		status >= 100 && status < 200,
+		status == http.StatusNoContent,
+		status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+func setEncrypted(h http.Header, contentType string, length int) {
+	if contentType != "" {
+		h.Set(HeaderContentType, contentType)
+	} else {
+		h.Del(HeaderContentType)
+	}
+	h.Set("Content-Type", ContentType)
+	h.Set("Content-Length", strconv.Itoa(length))
+}
+
+func setPlaintext(h http.Header, contentType string) {
+	h.Del(HeaderContentType)
+	h.Del("Content-Length")
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	} else {
+		h.Del("Content-Type")
+	}
+}
+
+// responseBuffer records a response so that its body can be encrypted
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
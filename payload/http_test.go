@@ -0,0 +1,169 @@
+package payload
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+)
+
+func TestHandler(t *testing.T) {
+	s := NewSealer(testwrapper.AEAD(t), &Options{SessionKeyLifetime: time.Hour})
+
+	var received []byte
+	srv := httptest.NewServer(s.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/none":
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case "/sniff":
+			w.Write([]byte("<html></html>"))
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("err: %s", err)
+		}
+		received = body
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})))
+	defer srv.Close()
+	client := &http.Client{Transport: s.RoundTripper(nil)}
+
+	resp, err := client.Post(srv.URL+"/echo?to=a&amount=1", "application/json", strings.NewReader(`{"foo":"bar"}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(received) != `{"foo":"bar"}` || string(body) != `{"foo":"bar"}` {
+		t.Fatalf("expected %q, received %q and got back %q", `{"foo":"bar"}`, received, body)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected content type %q, got %q", "application/json", ct)
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Fatalf("expected content length %d, got %d", len(body), resp.ContentLength)
+	}
+
+	// Content types are sniffed before bodies are encrypted
+	resp, err = client.Get(srv.URL + "/sniff")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("expected an HTML content type, got %q", ct)
+	}
+
+	// Responses without a body pass through
+	for _, tc := range []struct {
+		Title  string
+		Method string
+		Path   string
+		Status int
+	}{
+		{"NoContent", http.MethodGet, "/none", http.StatusNoContent},
+		{"Head", http.MethodHead, "/echo", http.StatusCreated},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			req, err := http.NewRequest(tc.Method, srv.URL+tc.Path, nil)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.Status {
+				t.Fatalf("expected status %d, got %d", tc.Status, resp.StatusCode)
+			}
+			if resp.Header.Get("Content-Type") == ContentType {
+				t.Fatal("expected a response without an encrypted body")
+			}
+		})
+	}
+
+	// Plaintext requests are rejected
+	resp, err = http.Post(srv.URL+"/echo", "application/json", strings.NewReader(`{"foo":"bar"}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected status %d, got %d", http.StatusUnsupportedMediaType, resp.StatusCode)
+	}
+}
+
+func TestHandler_Binding(t *testing.T) {
+	s := NewSealer(testwrapper.AEAD(t), nil)
+	handler := s.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected request")
+	}))
+
+	sealed, err := s.Seal(context.Background(), []byte("foo"), httpAAD(directionRequest, http.MethodPost, "/foo", "to=a", "text/plain"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, tc := range []struct {
+		Title       string
+		Path        string
+		ContentType string
+		Status      int
+	}{
+		{"Path", "/bar?to=a", "text/plain", http.StatusBadRequest},
+		{"Query", "/foo?to=b", "text/plain", http.StatusBadRequest},
+		{"NoQuery", "/foo", "text/plain", http.StatusBadRequest},
+		{"ContentType", "/foo?to=a", "text/html", http.StatusBadRequest},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.Path, bytes.NewReader(sealed))
+			setEncrypted(req.Header, tc.ContentType, len(sealed))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.Status {
+				t.Fatalf("expected status %d, got %d", tc.Status, rec.Code)
+			}
+		})
+	}
+
+	// Bodies over the maximum size are rejected
+	handler = NewSealer(testwrapper.AEAD(t), &Options{MaxBodySize: 4}).Handler(http.NotFoundHandler())
+	req := httptest.NewRequest(http.MethodPost, "/foo?to=a", bytes.NewReader(sealed))
+	setEncrypted(req.Header, "text/plain", len(sealed))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
+
+func TestRoundTripper_Unencrypted(t *testing.T) {
+	s := NewSealer(testwrapper.AEAD(t), nil)
+
+	// As a proxy in between might respond
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: s.RoundTripper(nil)}
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("expected error for an unencrypted response")
+	}
+}
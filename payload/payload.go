@@ -0,0 +1,348 @@
+// Package payload encrypts the payloads exchanged between services with a
+// wrapper they share, for application-layer encryption on top of TLS with
+// keys managed by a KMS. Interceptors encrypt selected fields of gRPC
+// messages, and middleware encrypts the bodies of HTTP requests and
+// responses.
+//
+// By default every payload is encrypted by the wrapper. With
+// Options.SessionKeyLifetime set, payloads are instead encrypted with
+// AES-256-GCM under a session key that the wrapper encrypts once per
+// lifetime. The encrypted session key travels with each payload and
+// receivers cache the session keys they decrypt, so only the first payload
+// of each session reaches the KMS on either side. Sealers accept payloads
+// of both modes whatever their own.
+//
+// Payloads are bound to where they are used: the RPC method or the method,
+// path and query string of the HTTP request, whether they are a request or a
+// response, and for gRPC the path of the field in the message, the fields
+// encrypted alongside it and the position of the message in its stream.
+// Session keys bind payloads themselves, but without a session key lifetime
+// the binding is passed to the wrapper as additional data and only holds if
+// the wrapper authenticates it: with Vault Transit, which ignores it, a
+// payload opens anywhere. Wrappers that reject additional data, such as
+// sealed boxes, fail to encrypt session keys and bound payloads alike.
+package payload
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+)
+
+// Leading bytes of encrypted payloads
+const (
+	// payloadDirect is followed by the marshaled blob of the wrapper
+	payloadDirect = 1
+
+	// payloadSession is followed by the length of the marshaled blob of the
+	// session key as a uvarint, that blob, then the nonce and ciphertext of
+	// the payload
+	payloadSession = 2
+)
+
+const (
+	sessionKeySize = 32
+	nonceSize      = 12
+
+	// maxSessionMessages bounds the payloads encrypted under a session key,
+	// well within the limit of random AES-GCM nonces
+	maxSessionMessages = 1 << 30
+
+	defaultMaxSessionKeys = 1024
+)
+
+// sessionKeyAAD is the additional data of session keys encrypted by the
+// wrapper, so that no other ciphertext of the wrapper passes for one
+var sessionKeyAAD = []byte("go-kms-wrapping payload session key")
+
+// Options configures a Sealer. It is valid to pass nil Options.
+type Options struct {
+	// SessionKeyLifetime enables session keys, renewed after this long.
+	// Zero encrypts every payload with the wrapper.
+	SessionKeyLifetime time.Duration
+
+	// MaxSessionKeys bounds the number of decrypted session keys a Sealer
+	// keeps. It defaults to 1024.
+	MaxSessionKeys int
+
+	// Fields are the full names of the bytes fields that the gRPC
+	// interceptors encrypt, such as "acme.v1.Payment.card_number". Fields
+	// are found in nested, repeated and map value messages too. Each value
+	// is bound to its path in the message and to the paths of all the
+	// encrypted values of the message, so they cannot be reordered, swapped
+	// or dropped while others remain. Empty fields without presence are
+	// encrypted too, so that clearing one is detected.
+	Fields []string
+
+	// MaxBodySize bounds the HTTP bodies that the middleware reads. It
+	// defaults to 32 MiB.
+	MaxBodySize int64
+}
+
+// Sealer encrypts and decrypts payloads with a wrapper. It is safe for
+// concurrent use, and one Sealer should be shared by all the interceptors
+// and middleware of a process so that they share session keys.
+type Sealer struct {
+	w              wrapping.Wrapper
+	lifetime       time.Duration
+	maxSessionKeys int
+	fields         map[string]bool
+	maxBodySize    int64
+
+	l       sync.Mutex
+	renew   sync.Mutex
+	session *session
+
+	// keys caches decrypted session keys by the hash of their encrypted
+	// form
+	keys map[[sha256.Size]byte]cipher.AEAD
+}
+
+// session is a session key used for encryption
+type session struct {
+	// uses comes first to be 64-bit aligned for atomic access
+	uses    uint64
+	aead    cipher.AEAD
+	wrapped []byte
+	expires time.Time
+}
+
+// NewSealer returns a Sealer encrypting with w
+func NewSealer(w wrapping.Wrapper, opts *Options) *Sealer {
+	if opts == nil {
+		opts = new(Options)
+	}
+	s := &Sealer{
+		w:              w,
+		lifetime:       opts.SessionKeyLifetime,
+		maxSessionKeys: opts.MaxSessionKeys,
+		fields:         make(map[string]bool, len(opts.Fields)),
+		maxBodySize:    opts.MaxBodySize,
+		keys:           map[[sha256.Size]byte]cipher.AEAD{},
+	}
+	if s.maxSessionKeys <= 0 {
+		s.maxSessionKeys = defaultMaxSessionKeys
+	}
+	if s.maxBodySize <= 0 {
+		s.maxBodySize = 32 << 20
+	}
+	for _, f := range opts.Fields {
+		s.fields[f] = true
+	}
+	return s
+}
+
+// Seal encrypts plaintext bound to aad. Without a session key lifetime, aad
+// is passed to the wrapper, which must authenticate it for the binding to
+// hold.
+func (s *Sealer) Seal(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	if plaintext == nil {
+		plaintext = []byte{}
+	}
+	if s.lifetime == 0 {
+		blob, err := s.w.Encrypt(ctx, plaintext, aad)
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting payload: %w", err)
+		}
+		out, err := format.Marshal(blob, format.Version0)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling payload: %w", err)
+		}
+		return append([]byte{payloadDirect}, out...), nil
+	}
+
+	sess, err := s.currentSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 1+binary.MaxVarintLen64, 1+binary.MaxVarintLen64+len(sess.wrapped)+nonceSize+len(plaintext)+sess.aead.Overhead())
+	out[0] = payloadSession
+	out = out[:1+binary.PutUvarint(out[1:], uint64(len(sess.wrapped)))]
+	out = append(out, sess.wrapped...)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	return sess.aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+// Open decrypts a payload produced by Seal with the same aad
+func (s *Sealer) Open(ctx context.Context, data, aad []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("payload is empty")
+	}
+	switch data[0] {
+	case payloadDirect:
+		blob, _, err := format.Unmarshal(data[1:])
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling payload: %w", err)
+		}
+		plaintext, err := s.w.Decrypt(ctx, blob, aad)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting payload: %w", err)
+		}
+		if plaintext == nil {
+			plaintext = []byte{}
+		}
+		return plaintext, nil
+
+	case payloadSession:
+		n, size := binary.Uvarint(data[1:])
+		if size <= 0 || n > uint64(len(data)) {
+			return nil, errors.New("payload is truncated")
+		}
+		rest := data[1+size:]
+		if uint64(len(rest)) < n+nonceSize {
+			return nil, errors.New("payload is truncated")
+		}
+		wrapped, nonce, ciphertext := rest[:n], rest[n:n+nonceSize], rest[n+nonceSize:]
+		aead, err := s.sessionKey(ctx, wrapped)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting payload: %w", err)
+		}
+		if plaintext == nil {
+			plaintext = []byte{}
+		}
+		return plaintext, nil
+
+	default:
+		return nil, fmt.Errorf("unknown payload type %d", data[0])
+	}
+}
+
+// currentSession returns the session key to encrypt with, creating one if
+// there is none or it is used up
+func (s *Sealer) currentSession(ctx context.Context) (*session, error) {
+	usable := func(sess *session) bool {
+		return sess != nil && time.Now().Before(sess.expires) && atomic.AddUint64(&sess.uses, 1) <= maxSessionMessages
+	}
+	s.l.Lock()
+	sess := s.session
+	s.l.Unlock()
+	if usable(sess) {
+		return sess, nil
+	}
+
+	// Only one caller renews the session key; the others wait for it rather
+	// than each calling the KMS
+	s.renew.Lock()
+	defer s.renew.Unlock()
+	s.l.Lock()
+	sess = s.session
+	s.l.Unlock()
+	if usable(sess) {
+		return sess, nil
+	}
+
+	key := make([]byte, sessionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("error generating session key: %w", err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := s.w.Encrypt(ctx, key, sessionKeyAAD)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting session key: %w", err)
+	}
+	wrapped, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling session key: %w", err)
+	}
+
+	sess = &session{
+		aead:    aead,
+		wrapped: wrapped,
+		expires: time.Now().Add(s.lifetime),
+		uses:    1,
+	}
+	s.l.Lock()
+	s.session = sess
+	s.cacheLocked(sha256.Sum256(wrapped), aead)
+	s.l.Unlock()
+	return sess, nil
+}
+
+// sessionKey returns the cipher of an encrypted session key, decrypting it
+// with the wrapper unless it is cached
+func (s *Sealer) sessionKey(ctx context.Context, wrapped []byte) (cipher.AEAD, error) {
+	id := sha256.Sum256(wrapped)
+	s.l.Lock()
+	aead, ok := s.keys[id]
+	s.l.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	blob, _, err := format.Unmarshal(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling session key: %w", err)
+	}
+	key, err := s.w.Decrypt(ctx, blob, sessionKeyAAD)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting session key: %w", err)
+	}
+	if len(key) != sessionKeySize {
+		return nil, errors.New("session key has the wrong size")
+	}
+	if aead, err = newGCM(key); err != nil {
+		return nil, err
+	}
+	s.l.Lock()
+	s.cacheLocked(id, aead)
+	s.l.Unlock()
+	return aead, nil
+}
+
+// cacheLocked adds a session key to the cache, evicting an arbitrary one if
+// it is full
+func (s *Sealer) cacheLocked(id [sha256.Size]byte, aead cipher.AEAD) {
+	if _, ok := s.keys[id]; !ok && len(s.keys) >= s.maxSessionKeys {
+		for k := range s.keys {
+			delete(s.keys, k)
+			break
+		}
+	}
+	s.keys[id] = aead
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating GCM mode: %w", err)
+	}
+	return gcm, nil
+}
+
+// bindAAD joins the parts that a payload is bound to. Each part is length
+// prefixed so that no two lists of parts join identically.
+func bindAAD(parts ...string) []byte {
+	var out []byte
+	var buf [binary.MaxVarintLen64]byte
+	for _, p := range parts {
+		out = append(out, buf[:binary.PutUvarint(buf[:], uint64(len(p)))]...)
+		out = append(out, p...)
+	}
+	return out
+}
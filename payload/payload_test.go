@@ -0,0 +1,146 @@
+package payload
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/sealedbox"
+)
+
+func TestSealer(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		Title    string
+		Opts     *Options
+		Encrypts int64
+		Decrypts int64
+	}{
+		{"Direct", nil, 3, 0},
+		{"Session", &Options{SessionKeyLifetime: time.Hour}, 1, 1},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			w := &testwrapper.Counting{Wrapper: testwrapper.AEAD(t)}
+			sender := NewSealer(w, tc.Opts)
+			receiver := NewSealer(w, tc.Opts)
+
+			for _, pt := range []string{"foo", "bar", ""} {
+				sealed, err := sender.Seal(ctx, []byte(pt), []byte("aad"))
+				if err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				out, err := receiver.Open(ctx, sealed, []byte("aad"))
+				if err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				if string(out) != pt || out == nil {
+					t.Fatalf("expected %q, got %q", pt, out)
+				}
+
+				if _, err := receiver.Open(ctx, sealed, []byte("other")); err == nil {
+					t.Fatal("expected error for other additional data")
+				}
+				if _, err := receiver.Open(ctx, sealed[:len(sealed)-1], []byte("aad")); err == nil {
+					t.Fatal("expected error for a truncated payload")
+				}
+			}
+
+			// Every payload reaches the wrapper in direct mode, but only the
+			// first of a session otherwise
+			if w.Encrypts != tc.Encrypts {
+				t.Fatalf("expected %d encryptions by the wrapper, got %d", tc.Encrypts, w.Encrypts)
+			}
+			if tc.Opts != nil && w.Decrypts != tc.Decrypts {
+				t.Fatalf("expected %d decryptions by the wrapper, got %d", tc.Decrypts, w.Decrypts)
+			}
+		})
+	}
+}
+
+func TestSealer_Modes(t *testing.T) {
+	ctx := context.Background()
+	w := testwrapper.AEAD(t)
+	direct := NewSealer(w, nil)
+	session := NewSealer(w, &Options{SessionKeyLifetime: time.Hour})
+
+	// Sealers open payloads of either mode
+	for _, pair := range [][2]*Sealer{{direct, session}, {session, direct}} {
+		sealed, err := pair[0].Seal(ctx, []byte("foo"), nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if _, err := pair[1].Open(ctx, sealed, nil); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// Session keys are not accepted as direct payloads, or the reverse
+	sealed, err := session.Seal(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	n, size := binary.Uvarint(sealed[1:])
+	if _, err := direct.Open(ctx, append([]byte{payloadDirect}, sealed[1+size:1+size+int(n)]...), nil); err == nil {
+		t.Fatal("expected error opening a session key as a payload")
+	}
+
+	for _, data := range [][]byte{nil, {0}, {payloadSession}, {payloadSession, 200, 1}, {payloadDirect, 0xff}} {
+		if _, err := session.Open(ctx, data, nil); err == nil {
+			t.Fatalf("%v: expected error", data)
+		}
+	}
+}
+
+func TestSealer_RejectedAAD(t *testing.T) {
+	_, priv, err := sealedbox.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := sealedbox.NewWrapper(nil)
+	if _, err := w.SetConfig(map[string]string{"private_key": priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Session keys are encrypted with additional data too
+	for _, opts := range []*Options{nil, {SessionKeyLifetime: time.Hour}} {
+		if _, err := NewSealer(w, opts).Seal(context.Background(), []byte("foo"), []byte("bar")); err == nil {
+			t.Fatalf("%v: expected error with a wrapper that rejects additional data", opts)
+		}
+	}
+}
+
+func TestSealer_SessionRenewal(t *testing.T) {
+	ctx := context.Background()
+	w := &testwrapper.Counting{Wrapper: testwrapper.AEAD(t)}
+	s := NewSealer(w, &Options{SessionKeyLifetime: time.Nanosecond, MaxSessionKeys: 1})
+
+	var sealed [][]byte
+	for i := 0; i < 3; i++ {
+		out, err := s.Seal(ctx, []byte("foo"), nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		sealed = append(sealed, out)
+		time.Sleep(time.Millisecond)
+	}
+	if w.Encrypts != 3 {
+		t.Fatalf("expected a session key per payload, got %d", w.Encrypts)
+	}
+
+	// Only the last session key is cached; older ones are decrypted by the
+	// wrapper again
+	if len(s.keys) != 1 {
+		t.Fatalf("expected 1 cached session key, got %d", len(s.keys))
+	}
+	for i := len(sealed) - 1; i >= 0; i-- {
+		if _, err := s.Open(ctx, sealed[i], nil); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if w.Decrypts != 2 {
+		t.Fatalf("expected 2 decryptions by the wrapper, got %d", w.Decrypts)
+	}
+}
@@ -0,0 +1,148 @@
+// Package s3cse backs the client-side encryption of the AWS SDK's s3crypto
+// package with a wrapper, so that objects can be encrypted before upload
+// under any KMS the wrapper supports rather than AWS KMS alone.
+//
+// Objects are written by the SDK's EncryptionClient in its usual format: the
+// content is AES-GCM encrypted under a random data key, and the data key is
+// stored in the object's metadata, here encrypted by the wrapper under the
+// x-amz-wrap-alg value WrapAlgorithm. The material description is the
+// additional data of the wrapper, so it cannot be altered without the data
+// key becoming unreadable. Register NewWrapEntry with a DecryptionClient to
+// read them back:
+//
+//	client := s3crypto.NewEncryptionClient(sess, s3crypto.AESGCMContentCipherBuilder(s3cse.NewKeyGenerator(w, nil)))
+//
+//	client := s3crypto.NewDecryptionClient(sess, func(c *s3crypto.DecryptionClient) {
+//		c.WrapRegistry[s3cse.WrapAlgorithm] = s3cse.NewWrapEntry(w)
+//	})
+package s3cse
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3crypto"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+)
+
+// WrapAlgorithm is the key wrap algorithm recorded in the metadata of objects
+// whose data key was encrypted by a wrapper. The encrypted data key is the
+// marshaled blob.
+const WrapAlgorithm = "go-kms-wrapping"
+
+// MaterialDescriptionWrapper is the material description entry naming the
+// type of the wrapper that encrypted the data key
+const MaterialDescriptionWrapper = "kms_wrapper"
+
+// NewKeyGenerator returns a generator of data keys encrypted with w, for
+// s3crypto.AESGCMContentCipherBuilder. matdesc is recorded with each object
+// and may be nil; the type of w is added to it under
+// MaterialDescriptionWrapper.
+func NewKeyGenerator(w wrapping.Wrapper, matdesc s3crypto.MaterialDescription) s3crypto.CipherDataGenerator {
+	md := s3crypto.MaterialDescription{}
+	for k, v := range matdesc {
+		md[k] = v
+	}
+	md[MaterialDescriptionWrapper] = aws.String(w.Type())
+	return &keyGenerator{w: w, matdesc: md}
+}
+
+type keyGenerator struct {
+	w wrapping.Wrapper
+
+	// matdesc is read only, as the SDK's clients may share the generator
+	// across goroutines
+	matdesc s3crypto.MaterialDescription
+}
+
+// Ensure that we are implementing the interfaces the SDK checks for
+var (
+	_ s3crypto.CipherDataGeneratorWithContext = (*keyGenerator)(nil)
+	_ s3crypto.CipherDataDecrypterWithContext = (*keyDecrypter)(nil)
+)
+
+func (g *keyGenerator) GenerateCipherData(keySize, ivSize int) (s3crypto.CipherData, error) {
+	return g.GenerateCipherDataWithContext(context.Background(), keySize, ivSize)
+}
+
+func (g *keyGenerator) GenerateCipherDataWithContext(ctx aws.Context, keySize, ivSize int) (s3crypto.CipherData, error) {
+	aad, err := marshalDescription(g.matdesc)
+	if err != nil {
+		return s3crypto.CipherData{}, err
+	}
+	key := make([]byte, keySize)
+	iv := make([]byte, ivSize)
+	if _, err := rand.Read(key); err != nil {
+		return s3crypto.CipherData{}, fmt.Errorf("error generating data key: %w", err)
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return s3crypto.CipherData{}, fmt.Errorf("error generating IV: %w", err)
+	}
+
+	blob, err := g.w.Encrypt(ctx, key, aad)
+	if err != nil {
+		return s3crypto.CipherData{}, fmt.Errorf("error encrypting data key: %w", err)
+	}
+	encryptedKey, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return s3crypto.CipherData{}, fmt.Errorf("error marshaling encrypted data key: %w", err)
+	}
+	return s3crypto.CipherData{
+		Key:                 key,
+		IV:                  iv,
+		WrapAlgorithm:       WrapAlgorithm,
+		MaterialDescription: g.matdesc,
+		EncryptedKey:        encryptedKey,
+	}, nil
+}
+
+// NewWrapEntry returns the s3crypto.DecryptionClient registry entry for
+// WrapAlgorithm, which decrypts data keys with w
+func NewWrapEntry(w wrapping.Wrapper) s3crypto.WrapEntry {
+	return func(env s3crypto.Envelope) (s3crypto.CipherDataDecrypter, error) {
+		var matdesc s3crypto.MaterialDescription
+		if err := json.Unmarshal([]byte(env.MatDesc), &matdesc); err != nil {
+			return nil, fmt.Errorf("error decoding material description: %w", err)
+		}
+		aad, err := marshalDescription(matdesc)
+		if err != nil {
+			return nil, err
+		}
+		return &keyDecrypter{w: w, aad: aad}, nil
+	}
+}
+
+type keyDecrypter struct {
+	w   wrapping.Wrapper
+	aad []byte
+}
+
+func (d *keyDecrypter) DecryptKey(key []byte) ([]byte, error) {
+	return d.DecryptKeyWithContext(context.Background(), key)
+}
+
+func (d *keyDecrypter) DecryptKeyWithContext(ctx aws.Context, key []byte) ([]byte, error) {
+	blob, _, err := format.Unmarshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling encrypted data key: %w", err)
+	}
+	return d.w.Decrypt(ctx, blob, d.aad)
+}
+
+// marshalDescription encodes a material description as the additional data
+// of the wrapper. json.Marshal sorts the keys of maps, so the description
+// read back from an object's metadata encodes identically.
+func marshalDescription(matdesc s3crypto.MaterialDescription) ([]byte, error) {
+	if matdesc == nil {
+		matdesc = s3crypto.MaterialDescription{}
+	}
+	aad, err := json.Marshal(matdesc)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding material description: %w", err)
+	}
+	return aad, nil
+}
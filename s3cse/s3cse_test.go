@@ -0,0 +1,197 @@
+package s3cse
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3crypto"
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+)
+
+// fakeS3 is an in-memory bucket serving the object calls of the SDK's
+// encryption and decryption clients
+type fakeS3 struct {
+	l       sync.Mutex
+	objects map[string]*object
+}
+
+type object struct {
+	body   []byte
+	header http.Header
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		o := &object{body: body, header: http.Header{}}
+		for k, v := range r.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+				o.header[k] = v
+			}
+		}
+		f.objects[r.URL.Path] = o
+	case http.MethodGet:
+		o, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "", http.StatusNotFound)
+			return
+		}
+		for k, v := range o.header {
+			w.Header()[k] = v
+		}
+		w.Write(o.body)
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+func testSession(t *testing.T, url string) *session.Session {
+	t.Helper()
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(url),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return sess
+}
+
+func TestRoundTrip(t *testing.T) {
+	bucket := &fakeS3{objects: map[string]*object{}}
+	ts := httptest.NewServer(bucket)
+	defer ts.Close()
+	sess := testSession(t, ts.URL)
+	w := testwrapper.AEAD(t)
+
+	generator := NewKeyGenerator(w, s3crypto.MaterialDescription{"app": aws.String("billing")})
+	enc := s3crypto.NewEncryptionClient(sess, s3crypto.AESGCMContentCipherBuilder(generator))
+	if _, err := enc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("report"),
+		Body:   bytes.NewReader([]byte("quarterly numbers")),
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	o := bucket.objects["/bucket/report"]
+	if o == nil {
+		t.Fatal("object was not stored")
+	}
+	if bytes.Contains(o.body, []byte("quarterly")) {
+		t.Fatal("object was stored in plaintext")
+	}
+	if alg := o.header.Get("X-Amz-Meta-X-Amz-Wrap-Alg"); alg != WrapAlgorithm {
+		t.Fatalf("expected wrap algorithm %s, got %q", WrapAlgorithm, alg)
+	}
+	matdesc := o.header.Get("X-Amz-Meta-X-Amz-Matdesc")
+	if matdesc != `{"app":"billing","kms_wrapper":"aead"}` {
+		t.Fatalf("unexpected material description %s", matdesc)
+	}
+
+	get := func(dec *s3crypto.DecryptionClient) ([]byte, error) {
+		out, err := dec.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("report"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer out.Body.Close()
+		return ioutil.ReadAll(out.Body)
+	}
+	decrypter := func(w *aead.Wrapper) *s3crypto.DecryptionClient {
+		return s3crypto.NewDecryptionClient(sess, func(c *s3crypto.DecryptionClient) {
+			c.WrapRegistry[WrapAlgorithm] = NewWrapEntry(w)
+		})
+	}
+
+	pt, err := get(decrypter(w))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "quarterly numbers" {
+		t.Fatalf("expected quarterly numbers, got %q", pt)
+	}
+
+	// The material description is bound to the data key
+	o.header.Set("X-Amz-Meta-X-Amz-Matdesc", `{"app":"hr","kms_wrapper":"aead"}`)
+	if _, err := get(decrypter(w)); err == nil {
+		t.Fatal("expected error for an altered material description")
+	}
+	o.header.Set("X-Amz-Meta-X-Amz-Matdesc", matdesc)
+
+	other := aead.NewWrapper(nil)
+	if _, err := other.SetConfig(map[string]string{
+		"aead_type": "aes-gcm",
+		"key":       base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+	}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := get(decrypter(other)); err == nil {
+		t.Fatal("expected error for another key")
+	}
+}
+
+func TestNewKeyGenerator(t *testing.T) {
+	matdesc := s3crypto.MaterialDescription{"app": aws.String("billing")}
+	g := NewKeyGenerator(testwrapper.AEAD(t), matdesc)
+	if len(matdesc) != 1 {
+		t.Fatal("the given material description was modified")
+	}
+
+	cd, err := g.GenerateCipherData(32, 12)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(cd.Key) != 32 || len(cd.IV) != 12 || cd.WrapAlgorithm != WrapAlgorithm {
+		t.Fatalf("unexpected cipher data %+v", cd)
+	}
+	if v := cd.MaterialDescription[MaterialDescriptionWrapper]; v == nil || *v != "aead" {
+		t.Fatalf("unexpected material description %v", cd.MaterialDescription)
+	}
+
+	// The SDK's decryption client hands the entry the envelope as stored
+	aad, err := marshalDescription(cd.MaterialDescription)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	d, err := NewWrapEntry(testwrapper.AEAD(t))(s3crypto.Envelope{MatDesc: string(aad)})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	key, err := d.DecryptKey(cd.EncryptedKey)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(key, cd.Key) {
+		t.Fatal("decrypted data key does not match")
+	}
+
+	if _, err := NewWrapEntry(testwrapper.AEAD(t))(s3crypto.Envelope{MatDesc: "not json"}); err == nil {
+		t.Fatal("expected error for an invalid material description")
+	}
+	if _, err := d.DecryptKey([]byte("garbage")); err == nil {
+		t.Fatal("expected error for an invalid encrypted data key")
+	}
+}
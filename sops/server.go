@@ -0,0 +1,151 @@
+// Package sops implements the key service of Mozilla sops with a wrapper, so
+// that sops files can keep their data keys under any KMS this library
+// supports, including those sops does not.
+//
+// sops hands every master key of a file to its key services in turn,
+// starting with its built-in local one, which handles real age recipients,
+// KMS ARNs and the like itself. sops must therefore be run with
+// --enable-local-keyservice=false for a Server given with --keyservice to be
+// asked. A Server encrypts the data key of each master key it accepts with
+// its wrapper, whatever the type of the master key; a file meant for a
+// wrapper sops does not know can name, for instance, an age recipient or a
+// KMS ARN that only identifies the key to the server. The data key is bound
+// to the master key, by its KeyID and any KMS encryption context, as
+// additional authenticated data.
+package sops
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Options holds optional settings for NewServer
+type Options struct {
+	// Keys are the master keys served, by KeyID. By default every master key
+	// is served.
+	Keys []string
+}
+
+// Server is a sops key service backed by a wrapper
+type Server struct {
+	UnimplementedKeyServiceServer
+
+	wrapper wrapping.Wrapper
+	keys    map[string]bool
+}
+
+// NewServer returns a key service that encrypts with w. Register it with
+// RegisterKeyServiceServer.
+func NewServer(w wrapping.Wrapper, opts *Options) *Server {
+	if opts == nil {
+		opts = new(Options)
+	}
+	s := &Server{wrapper: w}
+	if len(opts.Keys) > 0 {
+		s.keys = make(map[string]bool, len(opts.Keys))
+		for _, k := range opts.Keys {
+			s.keys[k] = true
+		}
+	}
+	return s
+}
+
+// Encrypt encrypts a data key. The ciphertext is the base64 encoded blob,
+// since sops stores it as a string.
+func (s *Server) Encrypt(ctx context.Context, req *EncryptRequest) (*EncryptResponse, error) {
+	aad, err := s.aad(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := s.wrapper.Encrypt(ctx, req.Plaintext, aad)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error encrypting: %v", err)
+	}
+	raw, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	ct := make([]byte, base64.StdEncoding.EncodedLen(len(raw)))
+	base64.StdEncoding.Encode(ct, raw)
+	return &EncryptResponse{Ciphertext: ct}, nil
+}
+
+// Decrypt decrypts a data key encrypted by Encrypt
+func (s *Server) Decrypt(ctx context.Context, req *DecryptRequest) (*DecryptResponse, error) {
+	aad, err := s.aad(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(req.Ciphertext)))
+	n, err := base64.StdEncoding.Decode(raw, req.Ciphertext)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error decoding ciphertext: %v", err)
+	}
+	blob, _, err := format.Unmarshal(raw[:n])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error decoding ciphertext: %v", err)
+	}
+	pt, err := s.wrapper.Decrypt(ctx, blob, aad)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error decrypting: %v", err)
+	}
+	return &DecryptResponse{Plaintext: pt}, nil
+}
+
+// aad returns the additional data binding a data key to its master key,
+// failing with a gRPC status if the master key is not served
+func (s *Server) aad(key *Key) ([]byte, error) {
+	id, err := KeyID(key)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if s.keys != nil && !s.keys[id] {
+		return nil, status.Errorf(codes.PermissionDenied, "key %s is not served", id)
+	}
+	aad := []byte(id)
+	if ctx := key.GetKmsKey().GetContext(); len(ctx) > 0 {
+		// Map keys are sorted by the encoder
+		buf, err := json.Marshal(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "error encoding context: %v", err)
+		}
+		aad = append(append(aad, 0), buf...)
+	}
+	return aad, nil
+}
+
+// KeyID identifies a master key as sops does in its files, prefixed by the
+// name of its type in them, such as "kms:" or "age:"
+func KeyID(key *Key) (string, error) {
+	var kind, id string
+	switch k := key.GetKeyType().(type) {
+	case *Key_KmsKey:
+		kind, id = "kms", k.KmsKey.Arn
+	case *Key_PgpKey:
+		kind, id = "pgp", k.PgpKey.Fingerprint
+	case *Key_GcpKmsKey:
+		kind, id = "gcp_kms", k.GcpKmsKey.ResourceId
+	case *Key_AzureKeyvaultKey:
+		kind, id = "azure_kv", fmt.Sprintf("%s/keys/%s/%s", k.AzureKeyvaultKey.VaultUrl, k.AzureKeyvaultKey.Name, k.AzureKeyvaultKey.Version)
+	case *Key_VaultKey:
+		kind, id = "hc_vault", fmt.Sprintf("%s/v1/%s/keys/%s", k.VaultKey.VaultAddress, k.VaultKey.EnginePath, k.VaultKey.KeyName)
+	case *Key_AgeKey:
+		kind, id = "age", k.AgeKey.Recipient
+	case nil:
+		return "", errors.New("no key given")
+	default:
+		return "", fmt.Errorf("unsupported key type %T", k)
+	}
+	if id == "" {
+		return "", fmt.Errorf("empty %s key", kind)
+	}
+	return kind + ":" + id, nil
+}
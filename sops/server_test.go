@@ -0,0 +1,145 @@
+package sops
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testClient serves s over an in-memory connection
+func testClient(t *testing.T, s *Server) (KeyServiceClient, func()) {
+	t.Helper()
+	l := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	RegisterKeyServiceServer(srv, s)
+	go srv.Serve(l)
+
+	conn, err := grpc.Dial("bufconn", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return l.Dial()
+	}))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return NewKeyServiceClient(conn), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+// testWrapper returns an aead wrapper, which authenticates the AAD
+func testWrapper(t *testing.T) *aead.Wrapper {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	w := aead.NewWrapper(nil)
+	if _, err := w.SetConfig(map[string]string{"aead_type": "aes-gcm", "key_id": "test", "key": base64.StdEncoding.EncodeToString(key)}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return w
+}
+
+func kmsKey(arn string, ctx map[string]string) *Key {
+	return &Key{KeyType: &Key_KmsKey{KmsKey: &KmsKey{Arn: arn, Context: ctx}}}
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	w := testWrapper(t)
+	client, cleanup := testClient(t, NewServer(w, nil))
+	defer cleanup()
+
+	key := kmsKey("yckms://abj1234", map[string]string{"env": "prod", "app": "web"})
+	enc, err := client.Encrypt(ctx, &EncryptRequest{Key: key, Plaintext: []byte("data key")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dec, err := client.Decrypt(ctx, &DecryptRequest{Key: key, Ciphertext: enc.Ciphertext})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(dec.Plaintext) != "data key" {
+		t.Fatalf("expected data key, got %q", dec.Plaintext)
+	}
+
+	// The data key is bound to its master key and encryption context
+	for _, other := range []*Key{
+		kmsKey("yckms://abj5678", key.GetKmsKey().Context),
+		kmsKey("yckms://abj1234", map[string]string{"env": "dev", "app": "web"}),
+		kmsKey("yckms://abj1234", nil),
+	} {
+		if _, err := client.Decrypt(ctx, &DecryptRequest{Key: other, Ciphertext: enc.Ciphertext}); status.Code(err) != codes.Internal {
+			t.Fatalf("%v: expected an internal error, got %v", other, err)
+		}
+	}
+
+	if _, err := client.Decrypt(ctx, &DecryptRequest{Key: key, Ciphertext: []byte("!")}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an invalid argument error, got %v", err)
+	}
+	if _, err := client.Encrypt(ctx, &EncryptRequest{Plaintext: []byte("data key")}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected an invalid argument error, got %v", err)
+	}
+}
+
+func TestServer_Keys(t *testing.T) {
+	ctx := context.Background()
+	w := testWrapper(t)
+	s := NewServer(w, &Options{Keys: []string{"age:age1served"}})
+
+	served := &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: "age1served"}}}
+	enc, err := s.Encrypt(ctx, &EncryptRequest{Key: served, Plaintext: []byte("data key")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := s.Decrypt(ctx, &DecryptRequest{Key: served, Ciphertext: enc.Ciphertext}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	other := &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: "age1other"}}}
+	if _, err := s.Encrypt(ctx, &EncryptRequest{Key: other, Plaintext: []byte("data key")}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a permission denied error, got %v", err)
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	cases := []struct {
+		Title    string
+		Key      *Key
+		Expected string
+	}{
+		{"kms", kmsKey("arn:aws:kms:us-east-1:111122223333:key/1234abcd", nil), "kms:arn:aws:kms:us-east-1:111122223333:key/1234abcd"},
+		{"pgp", &Key{KeyType: &Key_PgpKey{PgpKey: &PgpKey{Fingerprint: "FBC7B9E2A4F9289AC0C1D4843D16CEE4A27381B4"}}}, "pgp:FBC7B9E2A4F9289AC0C1D4843D16CEE4A27381B4"},
+		{"gcp", &Key{KeyType: &Key_GcpKmsKey{GcpKmsKey: &GcpKmsKey{ResourceId: "projects/p/locations/global/keyRings/r/cryptoKeys/k"}}}, "gcp_kms:projects/p/locations/global/keyRings/r/cryptoKeys/k"},
+		{"azure", &Key{KeyType: &Key_AzureKeyvaultKey{AzureKeyvaultKey: &AzureKeyVaultKey{VaultUrl: "https://v.vault.azure.net", Name: "k", Version: "1"}}}, "azure_kv:https://v.vault.azure.net/keys/k/1"},
+		{"vault", &Key{KeyType: &Key_VaultKey{VaultKey: &VaultKey{VaultAddress: "https://vault:8200", EnginePath: "transit", KeyName: "k"}}}, "hc_vault:https://vault:8200/v1/transit/keys/k"},
+		{"age", &Key{KeyType: &Key_AgeKey{AgeKey: &AgeKey{Recipient: "age1abc"}}}, "age:age1abc"},
+		{"nil", nil, ""},
+		{"empty", kmsKey("", nil), ""},
+	}
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			id, err := KeyID(c.Key)
+			if c.Expected == "" {
+				if err == nil {
+					t.Fatalf("expected error, got %q", id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if id != c.Expected {
+				t.Fatalf("expected %q, got %q", c.Expected, id)
+			}
+		})
+	}
+}
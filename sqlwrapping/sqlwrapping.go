@@ -0,0 +1,197 @@
+// Package sqlwrapping provides database/sql column types that encrypt
+// values with a wrapper when they are written and decrypt them when they are
+// scanned, so that applications can encrypt columns without handling blobs
+// themselves.
+//
+// Each value is stored as a marshaled blob, in a binary column such as BYTEA
+// or VARBINARY. The Column it belongs to supplies the wrapper and the
+// additional data, which defaults to the column's name. The wrapper must
+// authenticate additional data for values to be bound to their column: then
+// a value copied into another column no longer decrypts. Wrappers that
+// ignore it, such as Vault Transit, leave values unbound, and those that
+// reject it, such as sealed boxes, fail unless Options.AAD is set empty.
+//
+//	ssn := sqlwrapping.NewColumn(w, "users.ssn", nil)
+//	_, err := db.Exec("INSERT INTO users (id, ssn) VALUES ($1, $2)", id, ssn.String("078-05-1120"))
+//
+//	v := ssn.String("")
+//	err := db.QueryRow("SELECT ssn FROM users WHERE id = $1", id).Scan(&v)
+//
+// database/sql gives Valuers and Scanners no context, so wrappers are called
+// with context.Background(); Options.Timeout bounds each call.
+package sqlwrapping
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+	"github.com/hashicorp/go-kms-wrapping/internal/ctxutil"
+)
+
+// Options configures a Column. It is valid to pass nil Options.
+type Options struct {
+	// AAD is the additional data that values of the column are bound to. It
+	// defaults to the column's name. A non-nil empty AAD binds values to no
+	// column.
+	AAD []byte
+
+	// Timeout bounds each call to the wrapper. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// Column holds what the values of an encrypted column need. It is
+// immutable and safe for concurrent use.
+type Column struct {
+	wrapper wrapping.Wrapper
+	name    string
+	aad     []byte
+	timeout time.Duration
+}
+
+// NewColumn returns a column whose values are encrypted with w. name
+// identifies the column in errors and, unless opts sets AAD, is the
+// additional data of its values; including the table, as in "users.ssn",
+// keeps values from being moved between tables.
+func NewColumn(w wrapping.Wrapper, name string, opts *Options) *Column {
+	if opts == nil {
+		opts = new(Options)
+	}
+	c := &Column{
+		wrapper: w,
+		name:    name,
+		aad:     []byte(name),
+		timeout: opts.Timeout,
+	}
+	if opts.AAD != nil {
+		c.aad = append([]byte{}, opts.AAD...)
+	}
+	return c
+}
+
+// String returns a valid EncryptedString of the column holding s, to be
+// written or scanned into
+func (c *Column) String(s string) EncryptedString {
+	return EncryptedString{Column: c, String: s, Valid: true}
+}
+
+// Bytes returns an EncryptedBytes of the column holding b, to be written or
+// scanned into
+func (c *Column) Bytes(b []byte) EncryptedBytes {
+	return EncryptedBytes{Column: c, Bytes: b}
+}
+
+// encrypt returns the marshaled blob of plaintext
+func (c *Column) encrypt(plaintext []byte) ([]byte, error) {
+	if c == nil || c.wrapper == nil {
+		return nil, errors.New("encrypted value has no column")
+	}
+	ctx, cancel := ctxutil.WithTimeout(c.timeout)
+	defer cancel()
+	blob, err := c.wrapper.Encrypt(ctx, plaintext, c.aad)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting %s: %w", c.name, err)
+	}
+	out, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling %s: %w", c.name, err)
+	}
+	return out, nil
+}
+
+// decrypt returns the plaintext of a scanned value, or nil for NULL
+func (c *Column) decrypt(src interface{}) ([]byte, error) {
+	if c == nil || c.wrapper == nil {
+		return nil, errors.New("encrypted value has no column")
+	}
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		data = src
+	case string:
+		// Some drivers return binary columns as strings
+		data = []byte(src)
+	default:
+		return nil, fmt.Errorf("cannot scan %T into an encrypted value of %s", src, c.name)
+	}
+
+	blob, _, err := format.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s: %w", c.name, err)
+	}
+	ctx, cancel := ctxutil.WithTimeout(c.timeout)
+	defer cancel()
+	plaintext, err := c.wrapper.Decrypt(ctx, blob, c.aad)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s: %w", c.name, err)
+	}
+	if plaintext == nil {
+		plaintext = []byte{}
+	}
+	return plaintext, nil
+}
+
+// EncryptedString is a string stored encrypted in a Column. Like
+// sql.NullString, it is NULL when Valid is false.
+type EncryptedString struct {
+	Column *Column
+	String string
+	Valid  bool
+}
+
+// Ensure that we are implementing the database/sql interfaces
+var (
+	_ driver.Valuer = EncryptedString{}
+	_ sql.Scanner   = (*EncryptedString)(nil)
+	_ driver.Valuer = EncryptedBytes{}
+	_ sql.Scanner   = (*EncryptedBytes)(nil)
+)
+
+// Value implements driver.Valuer, encrypting the string
+func (s EncryptedString) Value() (driver.Value, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	return s.Column.encrypt([]byte(s.String))
+}
+
+// Scan implements sql.Scanner, decrypting the string. Column must be set.
+func (s *EncryptedString) Scan(src interface{}) error {
+	plaintext, err := s.Column.decrypt(src)
+	if err != nil {
+		return err
+	}
+	s.String, s.Valid = string(plaintext), plaintext != nil
+	return nil
+}
+
+// EncryptedBytes is a byte slice stored encrypted in a Column. A nil Bytes
+// is NULL, and an empty one is stored encrypted.
+type EncryptedBytes struct {
+	Column *Column
+	Bytes  []byte
+}
+
+// Value implements driver.Valuer, encrypting the bytes
+func (b EncryptedBytes) Value() (driver.Value, error) {
+	if b.Bytes == nil {
+		return nil, nil
+	}
+	return b.Column.encrypt(b.Bytes)
+}
+
+// Scan implements sql.Scanner, decrypting the bytes. Column must be set.
+func (b *EncryptedBytes) Scan(src interface{}) error {
+	plaintext, err := b.Column.decrypt(src)
+	if err != nil {
+		return err
+	}
+	b.Bytes = plaintext
+	return nil
+}
@@ -0,0 +1,235 @@
+package sqlwrapping
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-kms-wrapping/internal/testwrapper"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/sealedbox"
+)
+
+func TestEncryptedString(t *testing.T) {
+	w := testwrapper.AEAD(t)
+	ssn := NewColumn(w, "users.ssn", nil)
+
+	for _, tc := range []struct {
+		Title string
+		In    EncryptedString
+	}{
+		{"Value", ssn.String("078-05-1120")},
+		{"Empty", ssn.String("")},
+		{"Null", EncryptedString{Column: ssn}},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			v, err := tc.In.Value()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if tc.In.Valid != (v != nil) {
+				t.Fatalf("unexpected value %v", v)
+			}
+			if tc.In.String != "" && bytes.Contains(v.([]byte), []byte(tc.In.String)) {
+				t.Fatal("value was stored in plaintext")
+			}
+
+			out := ssn.String("stale")
+			if err := out.Scan(v); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if out.String != tc.In.String || out.Valid != tc.In.Valid {
+				t.Fatalf("expected %+v, got %+v", tc.In, out)
+			}
+		})
+	}
+
+	// Values are bound to their column
+	v, err := ssn.String("078-05-1120").Value()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out := NewColumn(w, "users.name", nil).String("")
+	if err := out.Scan(v); err == nil {
+		t.Fatal("expected error scanning into another column")
+	}
+	out = NewColumn(w, "anything", &Options{AAD: []byte("users.ssn")}).String("")
+	if err := out.Scan(v); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Drivers returning binary columns as strings are supported
+	out = ssn.String("")
+	if err := out.Scan(string(v.([]byte))); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, src := range []interface{}{int64(1), []byte("garbage")} {
+		if err := out.Scan(src); err == nil {
+			t.Fatalf("%v: expected error", src)
+		}
+	}
+	if err := (&EncryptedString{}).Scan(v); err == nil {
+		t.Fatal("expected error scanning without a column")
+	}
+	if _, err := (EncryptedString{String: "x", Valid: true}).Value(); err == nil {
+		t.Fatal("expected error writing without a column")
+	}
+}
+
+func TestEncryptedBytes(t *testing.T) {
+	key := NewColumn(testwrapper.AEAD(t), "keys.material", nil)
+
+	for _, tc := range []struct {
+		Title string
+		In    []byte
+	}{
+		{"Value", []byte{0, 1, 2}},
+		{"Empty", []byte{}},
+		{"Null", nil},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			v, err := key.Bytes(tc.In).Value()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if (tc.In == nil) != (v == nil) {
+				t.Fatalf("unexpected value %v", v)
+			}
+			out := key.Bytes([]byte("stale"))
+			if err := out.Scan(v); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !bytes.Equal(out.Bytes, tc.In) || (out.Bytes == nil) != (tc.In == nil) {
+				t.Fatalf("expected %v, got %v", tc.In, out.Bytes)
+			}
+		})
+	}
+}
+
+func TestColumn_AAD(t *testing.T) {
+	_, priv, err := sealedbox.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := sealedbox.NewWrapper(nil)
+	if _, err := w.SetConfig(map[string]string{"private_key": priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrapper that rejects additional data fails with the column's name,
+	// and works once the column binds to none
+	if _, err := NewColumn(w, "users.ssn", nil).String("078-05-1120").Value(); err == nil {
+		t.Fatal("expected error with additional data the wrapper rejects")
+	}
+	ssn := NewColumn(w, "users.ssn", &Options{AAD: []byte{}})
+	v, err := ssn.String("078-05-1120").Value()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out := ssn.String("")
+	if err := out.Scan(v); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if out.String != "078-05-1120" {
+		t.Fatalf("expected 078-05-1120, got %q", out.String)
+	}
+}
+
+func init() {
+	sql.Register("sqlwrapping-test", &fakeDriver{rows: map[string]driver.Value{}})
+}
+
+func TestDatabase(t *testing.T) {
+	db, err := sql.Open("sqlwrapping-test", "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+
+	ssn := NewColumn(testwrapper.AEAD(t), "users.ssn", nil)
+	if _, err := db.Exec("put", "alice", ssn.String("078-05-1120")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := db.Exec("put", "bob", EncryptedString{Column: ssn}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	v := ssn.String("")
+	if err := db.QueryRow("get", "alice").Scan(&v); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !v.Valid || v.String != "078-05-1120" {
+		t.Fatalf("unexpected value %+v", v)
+	}
+	if err := db.QueryRow("get", "bob").Scan(&v); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v.Valid {
+		t.Fatalf("expected NULL, got %+v", v)
+	}
+}
+
+// fakeDriver is a key-value table with a single column. Statements are
+// "put" with a key and a value, and "get" with a key.
+type fakeDriver struct {
+	l    sync.Mutex
+	rows map[string]driver.Value
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if query != "put" && query != "get" {
+		return nil, errors.New("unknown statement")
+	}
+	return &fakeStmt{d: c.d, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	d     *fakeDriver
+	query string
+}
+
+func (s *fakeStmt) Close() error { return nil }
+func (s *fakeStmt) NumInput() int {
+	if s.query == "put" {
+		return 2
+	}
+	return 1
+}
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.l.Lock()
+	defer s.d.l.Unlock()
+	s.d.rows[args[0].(string)] = args[1]
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.l.Lock()
+	defer s.d.l.Unlock()
+	v, ok := s.d.rows[args[0].(string)]
+	return &fakeRows{value: v, done: !ok}, nil
+}
+
+type fakeRows struct {
+	value driver.Value
+	done  bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
@@ -8,7 +8,7 @@ import (
 	"strings"
 
 	wrapping "github.com/hashicorp/go-kms-wrapping"
-	"google.golang.org/protobuf/proto"
+	"github.com/hashicorp/go-kms-wrapping/format"
 )
 
 type entry struct {
@@ -132,13 +132,13 @@ func WrapStruct(ctx context.Context, wrapper wrapping.Wrapper, in interface{}, a
 		case *wrapping.EncryptedBlobInfo:
 			field.Set(reflect.ValueOf(blobInfo))
 		case []byte:
-			protoBytes, err := proto.Marshal(blobInfo)
+			protoBytes, err := format.Marshal(blobInfo, format.Version0)
 			if err != nil {
 				return fmt.Errorf("error marshaling proto in byte field: %w", err)
 			}
 			field.Set(reflect.ValueOf(protoBytes))
 		case string:
-			protoBytes, err := proto.Marshal(blobInfo)
+			protoBytes, err := format.Marshal(blobInfo, format.Version0)
 			if err != nil {
 				return fmt.Errorf("error marshaling proto in string field: %w", err)
 			}
@@ -178,8 +178,7 @@ func UnwrapStruct(ctx context.Context, wrapper wrapping.Wrapper, in interface{},
 		}
 		if dec == nil {
 			if decBytes != nil {
-				dec = new(wrapping.EncryptedBlobInfo)
-				if err := proto.Unmarshal(decBytes, dec); err != nil {
+				if dec, _, err = format.Unmarshal(decBytes); err != nil {
 					return fmt.Errorf("error unmarshaling encrypted blob info: %w", err)
 				}
 			} else {
@@ -0,0 +1,219 @@
+// Package tink reads and writes the ciphertext format of Tink's KMS envelope
+// AEAD, so that data can be exchanged with services that encrypt through
+// Tink with the same KMS key.
+//
+// A Tink envelope ciphertext is
+//
+//	len(encrypted DEK) || encrypted DEK || AES-GCM(DEK, plaintext, associated data)
+//
+// where the length is a 4 byte big-endian integer, the encrypted DEK is the
+// KMS ciphertext of a serialized Tink AesGcmKey, and the AES-GCM ciphertext
+// is the 12 byte IV followed by the sealed plaintext. This matches the
+// primitive itself, or a keyset whose output prefix type is RAW.
+//
+// The DEK is encrypted with the KMS key directly, not through another data
+// key, which is how Tink's KMS clients decrypt it. Wrappers implementing
+// DirectEncrypter, such as awskms and gcpckms, and wrappers whose blobs are
+// KMS ciphertexts, such as transit, can be used.
+package tink
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// AESGCMKeyTypeURL is the Tink type URL of the DEKs this package reads and
+// writes, for the DEK template of a KMS envelope AEAD key
+const AESGCMKeyTypeURL = "type.googleapis.com/google.crypto.tink.AesGcmKey"
+
+const (
+	lengthSize = 4
+	ivSize     = 12
+	tagSize    = 16
+	keySize    = 32
+)
+
+// DirectEncrypter is implemented by wrappers that can encrypt small values
+// with the KMS key itself, returning a blob whose ciphertext the KMS alone
+// can decrypt
+type DirectEncrypter interface {
+	EncryptDirect(ctx context.Context, plaintext []byte) (*wrapping.EncryptedBlobInfo, error)
+}
+
+// Encrypt encrypts plaintext in Tink's envelope format under a new AES-256
+// DEK, which is encrypted with w's KMS key
+func Encrypt(ctx context.Context, w wrapping.Wrapper, plaintext, associatedData []byte) ([]byte, error) {
+	return encrypt(ctx, rand.Reader, w, plaintext, associatedData)
+}
+
+// encrypt performs the work of Encrypt, drawing the DEK and IV from the given
+// reader
+func encrypt(ctx context.Context, randReader io.Reader, w wrapping.Wrapper, plaintext, associatedData []byte) ([]byte, error) {
+	if w == nil {
+		return nil, errors.New("wrapper is nil")
+	}
+	if plaintext == nil {
+		return nil, errors.New("given plaintext for encryption is nil")
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(randReader, key); err != nil {
+		return nil, fmt.Errorf("error generating data encryption key: %w", err)
+	}
+	iv := make([]byte, ivSize)
+	if _, err := io.ReadFull(randReader, iv); err != nil {
+		return nil, fmt.Errorf("error generating IV: %w", err)
+	}
+
+	encryptedKey, err := encryptKey(ctx, w, marshalKey(key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, lengthSize, lengthSize+len(encryptedKey)+ivSize+len(plaintext)+tagSize)
+	binary.BigEndian.PutUint32(out, uint32(len(encryptedKey)))
+	out = append(out, encryptedKey...)
+	out = append(out, iv...)
+	return gcm.Seal(out, iv, plaintext, associatedData), nil
+}
+
+// Decrypt decrypts a ciphertext in Tink's envelope format, decrypting its DEK
+// with w
+func Decrypt(ctx context.Context, w wrapping.Wrapper, ciphertext, associatedData []byte) ([]byte, error) {
+	if w == nil {
+		return nil, errors.New("wrapper is nil")
+	}
+	encryptedKey, payload, err := Split(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < ivSize+tagSize {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	// A blob without key info is taken to be a KMS ciphertext by the
+	// wrappers that also produce envelope blobs
+	serialized, err := w.Decrypt(ctx, &wrapping.EncryptedBlobInfo{Ciphertext: encryptedKey}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data encryption key: %w", err)
+	}
+	key, err := unmarshalKey(serialized)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, payload[:ivSize], payload[ivSize:], associatedData)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Split separates a ciphertext in Tink's envelope format into the KMS
+// ciphertext of its DEK and the AES-GCM ciphertext of its payload
+func Split(ciphertext []byte) (encryptedKey, payload []byte, err error) {
+	if len(ciphertext) < lengthSize {
+		return nil, nil, errors.New("ciphertext is too short")
+	}
+	n := binary.BigEndian.Uint32(ciphertext)
+	if n == 0 || uint64(n) > uint64(len(ciphertext)-lengthSize) {
+		return nil, nil, fmt.Errorf("invalid encrypted key length %d", n)
+	}
+	return ciphertext[lengthSize : lengthSize+n], ciphertext[lengthSize+n:], nil
+}
+
+// encryptKey encrypts a serialized DEK with the KMS key of w
+func encryptKey(ctx context.Context, w wrapping.Wrapper, serialized []byte) ([]byte, error) {
+	var blob *wrapping.EncryptedBlobInfo
+	var err error
+	if d, ok := w.(DirectEncrypter); ok {
+		blob, err = d.EncryptDirect(ctx, serialized)
+	} else {
+		blob, err = w.Encrypt(ctx, serialized, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting data encryption key: %w", err)
+	}
+	if len(blob.IV) != 0 || (blob.KeyInfo != nil && len(blob.KeyInfo.WrappedKey) != 0) {
+		return nil, fmt.Errorf("%s wrapper blobs are envelope encrypted, which Tink cannot decrypt", w.Type())
+	}
+	return blob.Ciphertext, nil
+}
+
+// marshalKey serializes key as a Tink AesGcmKey message, version 0
+func marshalKey(key []byte) []byte {
+	b := protowire.AppendTag(nil, 3, protowire.BytesType)
+	return protowire.AppendBytes(b, key)
+}
+
+// unmarshalKey parses a serialized Tink AesGcmKey message and returns its key
+// value. AES-128 and AES-256 keys are accepted, as produced by Tink's
+// AES128_GCM and AES256_GCM templates.
+func unmarshalKey(b []byte) ([]byte, error) {
+	var key []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("error parsing data encryption key: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			version, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, fmt.Errorf("error parsing data encryption key: %w", protowire.ParseError(n))
+			}
+			if version != 0 {
+				return nil, fmt.Errorf("unsupported data encryption key version %d", version)
+			}
+			b = b[n:]
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, fmt.Errorf("error parsing data encryption key: %w", protowire.ParseError(n))
+			}
+			key = v
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, fmt.Errorf("error parsing data encryption key: %w", protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	switch len(key) {
+	case 16, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("data encryption key is not an %s with a 128 or 256 bit key", AESGCMKeyTypeURL)
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.New("failed to initialize GCM mode")
+	}
+	return gcm, nil
+}
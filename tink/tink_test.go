@@ -0,0 +1,147 @@
+package tink
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/awskms"
+)
+
+func testAWSKMSWrapper(t *testing.T) *awskms.Wrapper {
+	t.Helper()
+	w := awskms.NewAWSKMSTestWrapper()
+	if _, err := w.SetConfig(map[string]string{"kms_key_id": "foo"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return w
+}
+
+// tinkCiphertext assembles a Tink envelope ciphertext by hand, as Tink would
+// for a DEK of the given size whose KMS ciphertext is encryptedKey(dek)
+func tinkCiphertext(t *testing.T, size int, encryptedKey func([]byte) []byte, plaintext, associatedData []byte) []byte {
+	t.Helper()
+	key := bytes.Repeat([]byte{7}, size)
+	// AesGcmKey{key_value: key}
+	dek := append([]byte{0x1a, byte(size)}, key...)
+	edek := encryptedKey(dek)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	iv := bytes.Repeat([]byte{9}, 12)
+
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, uint32(len(edek)))
+	out = append(append(out, edek...), iv...)
+	return gcm.Seal(out, iv, plaintext, associatedData)
+}
+
+func TestDecrypt_Tink(t *testing.T) {
+	ctx := context.Background()
+	w := testAWSKMSWrapper(t)
+	// The mock KMS client's ciphertexts are base64 encoded plaintexts
+	mockKMS := func(b []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(b))
+	}
+
+	for _, size := range []int{16, 32} {
+		ct := tinkCiphertext(t, size, mockKMS, []byte("foo"), []byte("context"))
+		pt, err := Decrypt(ctx, w, ct, []byte("context"))
+		if err != nil {
+			t.Fatalf("%d: err: %s", size, err)
+		}
+		if string(pt) != "foo" {
+			t.Fatalf("%d: expected foo, got %q", size, pt)
+		}
+		if _, err := Decrypt(ctx, w, ct, nil); err == nil {
+			t.Fatalf("%d: expected error with the wrong associated data", size)
+		}
+	}
+
+	// A DEK that is not an AesGcmKey
+	ct := tinkCiphertext(t, 32, func(b []byte) []byte {
+		b[0] = 0x12
+		return mockKMS(b)
+	}, []byte("foo"), nil)
+	if _, err := Decrypt(ctx, w, ct, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		Title   string
+		Wrapper wrapping.Wrapper
+	}{
+		{"Direct", testAWSKMSWrapper(t)},
+		{"KMSCiphertext", wrapping.NewTestWrapper([]byte("secret"))},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			ct, err := Encrypt(ctx, tc.Wrapper, []byte("foo"), []byte("context"))
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			pt, err := Decrypt(ctx, tc.Wrapper, ct, []byte("context"))
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if string(pt) != "foo" {
+				t.Fatalf("expected foo, got %q", pt)
+			}
+		})
+	}
+
+	// The encrypted DEK is the KMS ciphertext of a serialized AesGcmKey
+	rand := bytes.NewReader(bytes.Repeat([]byte{7}, 44))
+	ct, err := encrypt(ctx, rand, testAWSKMSWrapper(t), []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	edek, _, err := Split(ct)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := base64.StdEncoding.EncodeToString(append([]byte{0x1a, 32}, bytes.Repeat([]byte{7}, 32)...))
+	if string(edek) != expected {
+		t.Fatalf("expected encrypted key %s, got %s", expected, edek)
+	}
+
+	if _, err := Encrypt(ctx, wrapping.NewTestEnvelopeWrapper([]byte("secret")), []byte("foo"), nil); err == nil {
+		t.Fatal("expected error for an envelope wrapper")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for _, input := range [][]byte{
+		nil,
+		{0, 0, 0},
+		{0, 0, 0, 0, 1},
+		{0, 0, 0, 2, 1},
+		{0xff, 0xff, 0xff, 0xff, 1},
+	} {
+		if _, _, err := Split(input); err == nil {
+			t.Fatalf("%v: expected error", input)
+		}
+	}
+
+	edek, payload, err := Split([]byte{0, 0, 0, 1, 'k', 'p'})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(edek) != "k" || string(payload) != "p" {
+		t.Fatalf("unexpected split %q %q", edek, payload)
+	}
+}
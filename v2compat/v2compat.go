@@ -0,0 +1,179 @@
+// Package v2compat reads blobs written by version 2 of the upstream
+// go-kms-wrapping module, so that values encrypted with either module can be
+// moved to the other without decrypting them.
+//
+// The upstream BlobInfo message is EncryptedBlobInfo under another name:
+// fields 1 to 6 of both messages, and of both KeyInfo messages, have the same
+// numbers and types. Upstream added fields after them, which Unmarshal reads
+// and Convert drops. The reverse direction needs no conversion, since the
+// Version0 encoding of a blob is a valid BlobInfo.
+package v2compat
+
+import (
+	"errors"
+	"fmt"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Field numbers of the BlobInfo fields EncryptedBlobInfo lacks
+const (
+	fieldPlaintext  = 7
+	fieldClientData = 8
+)
+
+// Field numbers of the KeyInfo fields this module's KeyInfo lacks
+const (
+	fieldKeyType            = 7
+	fieldKey                = 8
+	fieldKeyPurposes        = 9
+	fieldKeyEncoding        = 10
+	fieldWrappedKeyEncoding = 11
+)
+
+// BlobInfo is a decoded upstream BlobInfo
+type BlobInfo struct {
+	// Blob holds the fields both modules share
+	Blob *wrapping.EncryptedBlobInfo
+
+	// Plaintext is set by upstream callers that pass decrypted values in a
+	// BlobInfo; it is empty in encrypted blobs
+	Plaintext []byte
+
+	// ClientData is the upstream replacement for ValuePath
+	ClientData *structpb.Struct
+
+	// The upstream KeyInfo fields, as their enum numbers: the type, public
+	// key and purposes of the key, and the encodings of the key and the
+	// wrapped key
+	KeyType            uint64
+	Key                []byte
+	KeyPurposes        []uint64
+	KeyEncoding        uint64
+	WrappedKeyEncoding uint64
+}
+
+// Unmarshal decodes the protobuf encoding of an upstream BlobInfo. Fields
+// unknown to both modules are rejected rather than dropped.
+func Unmarshal(data []byte) (*BlobInfo, error) {
+	var blob wrapping.EncryptedBlobInfo
+	if err := proto.Unmarshal(data, &blob); err != nil {
+		return nil, fmt.Errorf("error unmarshaling blob: %w", err)
+	}
+	info := &BlobInfo{Blob: &blob}
+
+	m := blob.ProtoReflect()
+	err := consumeFields(m.GetUnknown(), func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldPlaintext && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			info.Plaintext = append([]byte(nil), v...)
+			return n, nil
+		case num == fieldClientData && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			if info.ClientData == nil {
+				info.ClientData = new(structpb.Struct)
+			}
+			// Repeated occurrences of a message field are merged
+			if err := (proto.UnmarshalOptions{Merge: true}).Unmarshal(v, info.ClientData); err != nil {
+				return 0, fmt.Errorf("error unmarshaling client data: %w", err)
+			}
+			return n, nil
+		}
+		return 0, fmt.Errorf("unknown blob field %d", num)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.SetUnknown(nil)
+
+	if blob.KeyInfo == nil {
+		return info, nil
+	}
+	m = blob.KeyInfo.ProtoReflect()
+	err = consumeFields(m.GetUnknown(), func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldKeyType && typ == protowire.VarintType:
+			return consumeVarint(b, &info.KeyType)
+		case num == fieldKey && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			info.Key = append([]byte(nil), v...)
+			return n, nil
+		case num == fieldKeyPurposes && typ == protowire.VarintType:
+			var p uint64
+			n, err := consumeVarint(b, &p)
+			info.KeyPurposes = append(info.KeyPurposes, p)
+			return n, err
+		case num == fieldKeyPurposes && typ == protowire.BytesType:
+			// Repeated enums are packed by default
+			v, n := protowire.ConsumeBytes(b)
+			for len(v) > 0 {
+				p, m := protowire.ConsumeVarint(v)
+				if m < 0 {
+					return m, nil
+				}
+				info.KeyPurposes = append(info.KeyPurposes, p)
+				v = v[m:]
+			}
+			return n, nil
+		case num == fieldKeyEncoding && typ == protowire.VarintType:
+			return consumeVarint(b, &info.KeyEncoding)
+		case num == fieldWrappedKeyEncoding && typ == protowire.VarintType:
+			return consumeVarint(b, &info.WrappedKeyEncoding)
+		}
+		return 0, fmt.Errorf("unknown key info field %d", num)
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.SetUnknown(nil)
+
+	return info, nil
+}
+
+// Convert decodes an upstream BlobInfo into a blob of this module. The
+// upstream-only fields are dropped, except for Plaintext, which is an error
+// since the input is then not an encrypted blob.
+func Convert(data []byte) (*wrapping.EncryptedBlobInfo, error) {
+	info, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.Plaintext) > 0 {
+		return nil, errors.New("blob holds plaintext")
+	}
+	return info.Blob, nil
+}
+
+// consumeFields calls fn with the value of each field in b. fn returns the
+// length of the value, or a negative protowire error code.
+func consumeFields(b []byte, fn func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("error unmarshaling blob: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("error unmarshaling blob: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeVarint(b []byte, v *uint64) (int, error) {
+	var n int
+	*v, n = protowire.ConsumeVarint(b)
+	return n, nil
+}
@@ -0,0 +1,131 @@
+package v2compat
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// v2Blob appends upstream-only fields to the encoding of blob, as an
+// upstream wrapper would write them
+func v2Blob(t *testing.T, blob *wrapping.EncryptedBlobInfo, keyInfo []byte, extra []byte) []byte {
+	t.Helper()
+	b := proto.Clone(blob).(*wrapping.EncryptedBlobInfo)
+	b.KeyInfo = nil
+	data, err := format.Marshal(b, format.Version0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ki, err := proto.Marshal(blob.KeyInfo)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	data = protowire.AppendBytes(protowire.AppendTag(data, 5, protowire.BytesType), append(ki, keyInfo...))
+	return append(data, extra...)
+}
+
+func TestConvert(t *testing.T) {
+	ctx := context.Background()
+	w := wrapping.NewTestEnvelopeWrapper([]byte("secret"))
+	blob, err := w.Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	clientData, err := proto.Marshal(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"path": {Kind: &structpb.Value_StringValue{StringValue: "secret/foo"}},
+	}})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var keyInfo []byte
+	keyInfo = protowire.AppendVarint(protowire.AppendTag(keyInfo, fieldKeyType, protowire.VarintType), 4)
+	keyInfo = protowire.AppendBytes(protowire.AppendTag(keyInfo, fieldKey, protowire.BytesType), []byte("public"))
+	keyInfo = protowire.AppendBytes(protowire.AppendTag(keyInfo, fieldKeyPurposes, protowire.BytesType), []byte{1, 2})
+	keyInfo = protowire.AppendVarint(protowire.AppendTag(keyInfo, fieldKeyPurposes, protowire.VarintType), 3)
+	keyInfo = protowire.AppendVarint(protowire.AppendTag(keyInfo, fieldKeyEncoding, protowire.VarintType), 1)
+	keyInfo = protowire.AppendVarint(protowire.AppendTag(keyInfo, fieldWrappedKeyEncoding, protowire.VarintType), 2)
+	extra := protowire.AppendBytes(protowire.AppendTag(nil, fieldClientData, protowire.BytesType), clientData)
+	data := v2Blob(t, blob, keyInfo, extra)
+
+	info, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info.KeyType != 4 || string(info.Key) != "public" || !reflect.DeepEqual(info.KeyPurposes, []uint64{1, 2, 3}) ||
+		info.KeyEncoding != 1 || info.WrappedKeyEncoding != 2 {
+		t.Fatalf("unexpected key info fields %+v", info)
+	}
+	if info.ClientData.GetFields()["path"].GetStringValue() != "secret/foo" {
+		t.Fatalf("unexpected client data %v", info.ClientData)
+	}
+
+	converted, err := Convert(data)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !proto.Equal(converted, blob) {
+		t.Fatalf("expected %v, got %v", blob, converted)
+	}
+	// The upstream fields are gone from the converted blob
+	raw, err := format.Marshal(converted, format.Version1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !format.IsCanonical(raw) {
+		t.Fatal("expected a canonical blob")
+	}
+	pt, err := w.Decrypt(ctx, converted, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %q", pt)
+	}
+
+	// A blob without upstream fields converts as it is
+	plain, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if converted, err := Convert(plain); err != nil || !proto.Equal(converted, blob) {
+		t.Fatalf("expected %v, got %v: %v", blob, converted, err)
+	}
+}
+
+func TestConvert_Errors(t *testing.T) {
+	blob := &wrapping.EncryptedBlobInfo{
+		Ciphertext: []byte("ciphertext"),
+		KeyInfo:    &wrapping.KeyInfo{KeyID: "key"},
+	}
+
+	cases := []struct {
+		Title   string
+		KeyInfo []byte
+		Extra   []byte
+	}{
+		{"plaintext", nil, protowire.AppendBytes(protowire.AppendTag(nil, fieldPlaintext, protowire.BytesType), []byte("foo"))},
+		{"unknown blob field", nil, protowire.AppendVarint(protowire.AppendTag(nil, 20, protowire.VarintType), 1)},
+		{"unknown key info field", protowire.AppendVarint(protowire.AppendTag(nil, 20, protowire.VarintType), 1), nil},
+		{"wrong wire type", protowire.AppendBytes(protowire.AppendTag(nil, fieldKeyType, protowire.BytesType), []byte("x")), nil},
+		{"invalid client data", nil, protowire.AppendBytes(protowire.AppendTag(nil, fieldClientData, protowire.BytesType), []byte{0xff})},
+	}
+	for _, c := range cases {
+		t.Run(c.Title, func(t *testing.T) {
+			if _, err := Convert(v2Blob(t, blob, c.KeyInfo, c.Extra)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	if _, err := Convert(bytes.Repeat([]byte{0xff}, 3)); err == nil {
+		t.Fatal("expected error for an invalid encoding")
+	}
+}
@@ -0,0 +1,150 @@
+package vaultcompat
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+)
+
+// Barrier entries start with the key term and a version byte
+const (
+	termSize = 4
+
+	// barrierVersion1 binds no additional data
+	barrierVersion1 = 0x1
+	// barrierVersion2 binds the storage path
+	barrierVersion2 = 0x2
+)
+
+// Keyring holds the barrier keys of a Vault cluster, by term
+type Keyring struct {
+	keys   map[uint32][]byte
+	active uint32
+}
+
+// encodedKeyring is the JSON form of the keyring. Vault still names its
+// root key MasterKey there.
+type encodedKeyring struct {
+	MasterKey []byte
+	Keys      []struct {
+		Term  uint32
+		Value []byte
+	}
+}
+
+// Unseal decrypts the stored barrier keys with the seal's wrapper and opens
+// the keyring with the unseal key, as Vault does for auto-unseal. Both values
+// are raw storage values, of StoredBarrierKeysPath and KeyringPath.
+func Unseal(ctx context.Context, w wrapping.Wrapper, storedKeys, keyring []byte) (*Keyring, error) {
+	keys, err := DecryptStoredKeys(ctx, w, storedKeys)
+	if err != nil {
+		return nil, err
+	}
+	return OpenKeyring(keys[0], keyring)
+}
+
+// OpenKeyring decrypts the value stored at KeyringPath with the unseal key
+func OpenKeyring(unsealKey, value []byte) (*Keyring, error) {
+	buf, err := barrierDecrypt(unsealKey, KeyringPath, value)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting keyring: %w", err)
+	}
+	var encoded encodedKeyring
+	if err := json.Unmarshal(buf, &encoded); err != nil {
+		return nil, fmt.Errorf("error decoding keyring: %w", err)
+	}
+	k := &Keyring{keys: make(map[uint32][]byte, len(encoded.Keys))}
+	for _, key := range encoded.Keys {
+		if _, ok := k.keys[key.Term]; ok {
+			return nil, fmt.Errorf("keyring has term %d twice", key.Term)
+		}
+		k.keys[key.Term] = key.Value
+		if key.Term > k.active {
+			k.active = key.Term
+		}
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("keyring has no keys")
+	}
+	return k, nil
+}
+
+// ActiveTerm returns the term of the key that new entries are encrypted with
+func (k *Keyring) ActiveTerm() uint32 {
+	return k.active
+}
+
+// Decrypt decrypts a barrier entry stored at path, under whichever term
+// encrypted it
+func (k *Keyring) Decrypt(path string, value []byte) ([]byte, error) {
+	if len(value) < termSize {
+		return nil, errors.New("entry is too short")
+	}
+	term := binary.BigEndian.Uint32(value)
+	key, ok := k.keys[term]
+	if !ok {
+		return nil, fmt.Errorf("no key for term %d", term)
+	}
+	return barrierDecrypt(key, path, value)
+}
+
+// Encrypt encrypts plaintext into a barrier entry for path, under the
+// active term
+func (k *Keyring) Encrypt(path string, plaintext []byte) ([]byte, error) {
+	return barrierEncrypt(rand.Reader, k.active, k.keys[k.active], path, plaintext)
+}
+
+func barrierEncrypt(randReader io.Reader, term uint32, key []byte, path string, plaintext []byte) ([]byte, error) {
+	gcm, err := barrierAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, termSize+1+gcm.NonceSize(), termSize+1+gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	binary.BigEndian.PutUint32(out, term)
+	out[termSize] = barrierVersion2
+	nonce := out[termSize+1:]
+	if _, err := io.ReadFull(randReader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return gcm.Seal(out, nonce, plaintext, []byte(path)), nil
+}
+
+func barrierDecrypt(key []byte, path string, value []byte) ([]byte, error) {
+	gcm, err := barrierAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < termSize+1+gcm.NonceSize()+gcm.Overhead() {
+		return nil, errors.New("entry is too short")
+	}
+	var aad []byte
+	switch value[termSize] {
+	case barrierVersion1:
+	case barrierVersion2:
+		aad = []byte(path)
+	default:
+		return nil, fmt.Errorf("unsupported barrier version %d", value[termSize])
+	}
+	nonce := value[termSize+1 : termSize+1+gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, value[termSize+1+gcm.NonceSize():], aad)
+	if err != nil {
+		return nil, errors.New("error decrypting entry; wrong key or path")
+	}
+	return plaintext, nil
+}
+
+func barrierAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid barrier key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
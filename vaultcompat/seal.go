@@ -0,0 +1,140 @@
+// Package vaultcompat reads and writes values in the formats Vault stores
+// them in, so that data exported from a Vault cluster's storage can be
+// decrypted outside of Vault with the wrapper of its seal.
+//
+// Three layers are covered. Seal-wrapped entries are blobs of the seal's
+// wrapper with a trailing canary byte. The stored barrier keys of an
+// auto-unsealed cluster are a blob holding the unseal key. The keyring and
+// every other storage entry are encrypted by the barrier, with AES-GCM under
+// keys from the keyring, which is itself encrypted by the unseal key.
+package vaultcompat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+)
+
+// Storage paths of the values that auto-unseal reads
+const (
+	// StoredBarrierKeysPath holds the unseal keys, encrypted by the seal
+	StoredBarrierKeysPath = "core/hsm/barrier-unseal-keys"
+	// RecoveryKeyPath holds the recovery key, encrypted by the seal
+	RecoveryKeyPath = "core/recovery-key"
+	// KeyringPath holds the barrier keyring, encrypted by the unseal key
+	KeyringPath = "core/keyring"
+)
+
+// sealWrapCanary ends every seal-wrapped storage entry
+const sealWrapCanary = 's'
+
+// IsSealWrapped reports whether a raw storage value is a seal-wrapped entry,
+// by the same test Vault uses: the canary byte follows a valid blob
+func IsSealWrapped(value []byte) bool {
+	_, err := UnmarshalSealWrapped(value)
+	return err == nil
+}
+
+// MarshalSealWrapped encodes a blob as a seal-wrapped storage value
+func MarshalSealWrapped(blob *wrapping.EncryptedBlobInfo) ([]byte, error) {
+	value, err := format.Marshal(blob, format.Version0)
+	if err != nil {
+		return nil, err
+	}
+	return append(value, sealWrapCanary), nil
+}
+
+// UnmarshalSealWrapped decodes the blob of a seal-wrapped storage value
+func UnmarshalSealWrapped(value []byte) (*wrapping.EncryptedBlobInfo, error) {
+	if len(value) == 0 || value[len(value)-1] != sealWrapCanary {
+		return nil, errors.New("value is not seal-wrapped")
+	}
+	blob, err := format.UnmarshalVersion(value[:len(value)-1], format.Version0)
+	if err != nil {
+		return nil, fmt.Errorf("value is not seal-wrapped: %w", err)
+	}
+	return blob, nil
+}
+
+// SealWrap encrypts value with the seal's wrapper into a seal-wrapped
+// storage value, marked as wrapped. Vault binds no additional data to
+// seal-wrapped entries.
+func SealWrap(ctx context.Context, w wrapping.Wrapper, value []byte) ([]byte, error) {
+	blob, err := w.Encrypt(ctx, value, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting entry: %w", err)
+	}
+	blob.Wrapped = true
+	return MarshalSealWrapped(blob)
+}
+
+// SealUnwrap decrypts a seal-wrapped storage value. The result is whatever
+// Vault handed to storage, usually an entry still encrypted by the barrier.
+// Entries not marked as wrapped hold that value in the clear, as Vault
+// writes them while the seal cannot encrypt, and it is returned as it is.
+func SealUnwrap(ctx context.Context, w wrapping.Wrapper, value []byte) ([]byte, error) {
+	blob, err := UnmarshalSealWrapped(value)
+	if err != nil {
+		return nil, err
+	}
+	if !blob.Wrapped {
+		return blob.Ciphertext, nil
+	}
+	out, err := w.Decrypt(ctx, blob, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting entry: %w", err)
+	}
+	return out, nil
+}
+
+// EncryptStoredKeys encrypts unseal keys into the value Vault stores at
+// StoredBarrierKeysPath: a blob of their JSON encoding
+func EncryptStoredKeys(ctx context.Context, w wrapping.Wrapper, keys [][]byte) ([]byte, error) {
+	buf, err := json.Marshal(keys)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding keys: %w", err)
+	}
+	blob, err := w.Encrypt(ctx, buf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting keys: %w", err)
+	}
+	return format.Marshal(blob, format.Version0)
+}
+
+// DecryptStoredKeys decrypts the value stored at StoredBarrierKeysPath into
+// the unseal keys
+func DecryptStoredKeys(ctx context.Context, w wrapping.Wrapper, value []byte) ([][]byte, error) {
+	buf, err := decryptBlob(ctx, w, value)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting keys: %w", err)
+	}
+	var keys [][]byte
+	if err := json.Unmarshal(buf, &keys); err != nil {
+		return nil, fmt.Errorf("error decoding keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no stored keys found")
+	}
+	return keys, nil
+}
+
+// DecryptRecoveryKey decrypts the value stored at RecoveryKeyPath
+func DecryptRecoveryKey(ctx context.Context, w wrapping.Wrapper, value []byte) ([]byte, error) {
+	key, err := decryptBlob(ctx, w, value)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting recovery key: %w", err)
+	}
+	return key, nil
+}
+
+func decryptBlob(ctx context.Context, w wrapping.Wrapper, value []byte) ([]byte, error) {
+	blob, err := format.UnmarshalVersion(value, format.Version0)
+	if err != nil {
+		return nil, err
+	}
+	return w.Decrypt(ctx, blob, nil)
+}
@@ -0,0 +1,154 @@
+package vaultcompat
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"google.golang.org/protobuf/proto"
+)
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return key
+}
+
+func TestSealWrap(t *testing.T) {
+	ctx := context.Background()
+	w := wrapping.NewTestEnvelopeWrapper([]byte("secret"))
+
+	value, err := SealWrap(ctx, w, []byte("entry"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// The layout Vault's seal unwrapper checks for
+	if value[len(value)-1] != 's' {
+		t.Fatalf("missing canary in %x", value)
+	}
+	var blob wrapping.EncryptedBlobInfo
+	if err := proto.Unmarshal(value[:len(value)-1], &blob); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !blob.Wrapped {
+		t.Fatal("expected the entry to be marked as wrapped")
+	}
+	if !IsSealWrapped(value) {
+		t.Fatal("expected a seal-wrapped value")
+	}
+
+	out, err := SealUnwrap(ctx, w, value)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(out) != "entry" {
+		t.Fatalf("expected entry, got %q", out)
+	}
+
+	// An entry Vault passed through is not decrypted
+	plain, err := MarshalSealWrapped(&wrapping.EncryptedBlobInfo{Ciphertext: []byte("entry")})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	out, err = SealUnwrap(ctx, w, plain)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(out) != "entry" {
+		t.Fatalf("expected entry, got %q", out)
+	}
+
+	for _, value := range [][]byte{nil, []byte("entry"), append(value[:len(value)-1:len(value)-1], 'x'), {0xff, 's'}} {
+		if IsSealWrapped(value) {
+			t.Fatalf("%x: unexpected seal-wrapped value", value)
+		}
+	}
+}
+
+func TestUnseal(t *testing.T) {
+	ctx := context.Background()
+	w := wrapping.NewTestEnvelopeWrapper([]byte("secret"))
+	unsealKey, oldKey, activeKey := randomKey(t), randomKey(t), randomKey(t)
+	b64 := base64.StdEncoding.EncodeToString
+
+	storedKeys, err := EncryptStoredKeys(ctx, w, [][]byte{unsealKey})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	// As serialized by Vault, including the fields that are not read
+	keyringJSON := fmt.Sprintf(`{"MasterKey":%q,"Keys":[`+
+		`{"Term":1,"Version":1,"Value":%q,"InstallTime":"2020-01-01T00:00:00Z"},`+
+		`{"Term":2,"Version":1,"Value":%q,"InstallTime":"2020-06-01T00:00:00Z","encryptions":10}],`+
+		`"RotationConfig":{"Disabled":false,"MaxOperations":3865470566,"Interval":0}}`,
+		b64(unsealKey), b64(oldKey), b64(activeKey))
+	keyring, err := barrierEncrypt(rand.Reader, 1, unsealKey, KeyringPath, []byte(keyringJSON))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	k, err := Unseal(ctx, w, storedKeys, keyring)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if k.ActiveTerm() != 2 {
+		t.Fatalf("expected term 2, got %d", k.ActiveTerm())
+	}
+
+	entry, err := k.Encrypt("sys/token/id", []byte("foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if binary.BigEndian.Uint32(entry) != 2 || entry[4] != barrierVersion2 {
+		t.Fatalf("unexpected entry header %x", entry[:5])
+	}
+	pt, err := k.Decrypt("sys/token/id", entry)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %q", pt)
+	}
+	// Version 2 entries are bound to their path
+	if _, err := k.Decrypt("sys/token/other", entry); err == nil {
+		t.Fatal("expected error for another path")
+	}
+
+	// Version 1 entries under an older term ignore the path
+	old, err := barrierEncrypt(rand.Reader, 1, oldKey, "", []byte("bar"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	old[4] = barrierVersion1
+	if pt, err := k.Decrypt("anything", old); err != nil || string(pt) != "bar" {
+		t.Fatalf("expected bar, got %q: %v", pt, err)
+	}
+
+	// A seal-wrapped entry holds a barrier entry
+	wrapped, err := SealWrap(ctx, w, entry)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	unwrapped, err := SealUnwrap(ctx, w, wrapped)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(unwrapped, entry) {
+		t.Fatal("seal-wrapped entry mismatch")
+	}
+
+	binary.BigEndian.PutUint32(entry, 3)
+	if _, err := k.Decrypt("sys/token/id", entry); err == nil {
+		t.Fatal("expected error for an unknown term")
+	}
+	if _, err := OpenKeyring(oldKey, keyring); err == nil {
+		t.Fatal("expected error for the wrong unseal key")
+	}
+}
@@ -17,6 +17,7 @@ const (
 	MultiWrapper    = "multiwrapper"
 	OCIKMS          = "ocikms"
 	PKCS11          = "pkcs11"
+	SealedBox       = "sealedbox"
 	Shamir          = "shamir"
 	TencentCloudKMS = "tencentcloudkms"
 	Transit         = "transit"
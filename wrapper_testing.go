@@ -2,7 +2,12 @@ package wrapping
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
+	mathrand "math/rand"
+	"sync"
 
 	"github.com/hashicorp/go-kms-wrapping/internal/xor"
 )
@@ -14,6 +19,9 @@ type TestWrapper struct {
 	keyID       string
 
 	envelope bool
+
+	// randReader is the source of DEKs and IVs for envelope encryption
+	randReader io.Reader
 }
 
 var _ Wrapper = (*TestWrapper)(nil)
@@ -37,6 +45,42 @@ func NewTestEnvelopeWrapper(secret []byte) *TestWrapper {
 	}
 }
 
+// NewTestDeterministicWrapper constructs an envelope test wrapper whose secret,
+// DEKs, and IVs are all drawn from a source seeded with the given value. Two
+// wrappers created with the same seed produce identical output for the same
+// sequence of Encrypt calls, which makes the output usable in golden-file
+// tests. It must never be used to protect real data.
+func NewTestDeterministicWrapper(seed int64) *TestWrapper {
+	randReader := &lockedReader{r: mathrand.New(mathrand.NewSource(seed))}
+
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(randReader, secret); err != nil {
+		// Reads from a math/rand source never fail
+		panic(err)
+	}
+
+	return &TestWrapper{
+		wrapperType: Test,
+		secret:      secret,
+		keyID:       "static-key",
+		envelope:    true,
+		randReader:  randReader,
+	}
+}
+
+// lockedReader serializes reads from a reader that is not safe for
+// concurrent use
+type lockedReader struct {
+	l sync.Mutex
+	r io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	return l.r.Read(p)
+}
+
 // Init initializes the test wrapper
 func (t *TestWrapper) Init(_ context.Context) error {
 	return nil
@@ -71,7 +115,11 @@ func (t *TestWrapper) SetKeyID(k string) {
 func (t *TestWrapper) Encrypt(_ context.Context, plaintext, _ []byte) (*EncryptedBlobInfo, error) {
 	switch t.envelope {
 	case true:
-		env, err := NewEnvelope(nil).Encrypt(plaintext, nil)
+		randReader := t.randReader
+		if randReader == nil {
+			randReader = rand.Reader
+		}
+		env, err := NewEnvelope(nil).encrypt(randReader, plaintext, nil)
 		if err != nil {
 			return nil, fmt.Errorf("error wrapping data: %w", err)
 		}
@@ -106,8 +154,15 @@ func (t *TestWrapper) Encrypt(_ context.Context, plaintext, _ []byte) (*Encrypte
 
 // Decrypt allows decrypting via the test wrapper
 func (t *TestWrapper) Decrypt(_ context.Context, dwi *EncryptedBlobInfo, _ []byte) ([]byte, error) {
+	if dwi == nil {
+		return nil, errors.New("given input for decryption is nil")
+	}
+
 	switch t.envelope {
 	case true:
+		if dwi.KeyInfo == nil {
+			return nil, errors.New("key info is nil")
+		}
 		keyPlaintext, err := t.obscureBytes(dwi.KeyInfo.WrappedKey)
 		if err != nil {
 			return nil, err
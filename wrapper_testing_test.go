@@ -0,0 +1,75 @@
+package wrapping
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDeterministicTestWrapper(t *testing.T) {
+	ctx := context.Background()
+	input := []byte("foo")
+
+	encryptN := func(w *TestWrapper, n int) [][]byte {
+		var ret [][]byte
+		for i := 0; i < n; i++ {
+			blob, err := w.Encrypt(ctx, input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pt, err := w.Decrypt(ctx, blob, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(input, pt) {
+				t.Fatalf("expected %s, got %s", input, pt)
+			}
+			marshaled, err := proto.Marshal(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ret = append(ret, marshaled)
+		}
+		return ret
+	}
+
+	first := encryptN(NewTestDeterministicWrapper(1), 3)
+	second := encryptN(NewTestDeterministicWrapper(1), 3)
+	other := encryptN(NewTestDeterministicWrapper(2), 3)
+
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Fatalf("expected identical output for the same seed at call %d", i)
+		}
+		if bytes.Equal(first[i], other[i]) {
+			t.Fatalf("expected different output for different seeds at call %d", i)
+		}
+	}
+	if bytes.Equal(first[0], first[1]) {
+		t.Fatal("expected successive calls to use different DEKs")
+	}
+}
+
+func TestTestWrapper_NilPlaintext(t *testing.T) {
+	ctx := context.Background()
+
+	// The test wrappers encrypt a nil plaintext as an empty one
+	for _, w := range []*TestWrapper{
+		NewTestWrapper([]byte("secret")),
+		NewTestEnvelopeWrapper([]byte("secret")),
+	} {
+		blob, err := w.Encrypt(ctx, nil, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		pt, err := w.Decrypt(ctx, blob, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if len(pt) != 0 {
+			t.Fatalf("expected an empty plaintext, got %q", pt)
+		}
+	}
+}
@@ -9,14 +9,17 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"sync"
 
 	wrapping "github.com/hashicorp/go-kms-wrapping"
 	"github.com/hashicorp/go-uuid"
 	"golang.org/x/crypto/hkdf"
 )
 
-// Wrapper implements the wrapping.Wrapper interface for AEAD
+// Wrapper implements the wrapping.Wrapper interface for AEAD. It is safe to
+// reconfigure via SetConfig or the key setters while it is in use.
 type Wrapper struct {
+	l        sync.RWMutex
 	keyID    string
 	keyBytes []byte
 	aead     cipher.AEAD
@@ -69,7 +72,8 @@ func (s *Wrapper) NewDerivedWrapper(opts *DerivedWrapperOptions) (*Wrapper, erro
 	if opts == nil {
 		opts = new(DerivedWrapperOptions)
 	}
-	if len(s.keyBytes) == 0 {
+	keyBytes := s.GetKeyBytes()
+	if len(keyBytes) == 0 {
 		return nil, errors.New("cannot create a sub-wrapper when key byte are not set")
 	}
 
@@ -81,19 +85,19 @@ func (s *Wrapper) NewDerivedWrapper(opts *DerivedWrapperOptions) (*Wrapper, erro
 	ret := &Wrapper{
 		keyID: opts.KeyID,
 	}
-	reader := hkdf.New(h, s.keyBytes, opts.Salt, opts.Info)
+	reader := hkdf.New(h, keyBytes, opts.Salt, opts.Info)
 
 	switch opts.AEADType {
 	case "", "aes-gcm":
-		ret.keyBytes = make([]byte, len(s.keyBytes))
-		n, err := reader.Read(ret.keyBytes)
+		derivedKey := make([]byte, len(keyBytes))
+		n, err := reader.Read(derivedKey)
 		if err != nil {
 			return nil, fmt.Errorf("error reading bytes from derived reader: %w", err)
 		}
-		if n != len(s.keyBytes) {
-			return nil, fmt.Errorf("expected to read %d bytes, but read %d bytes from derived reader", len(s.keyBytes), n)
+		if n != len(keyBytes) {
+			return nil, fmt.Errorf("expected to read %d bytes, but read %d bytes from derived reader", len(keyBytes), n)
 		}
-		if err := ret.SetAESGCMKeyBytes(ret.keyBytes); err != nil {
+		if err := ret.SetAESGCMKeyBytes(derivedKey); err != nil {
 			return nil, fmt.Errorf("error setting derived AES GCM key: %w", err)
 		}
 
@@ -111,10 +115,13 @@ func (s *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 		config = map[string]string{}
 	}
 
-	s.keyID = config["key_id"]
+	keyID := config["key_id"]
 
 	key := config["key"]
 	if key == "" {
+		s.l.Lock()
+		s.keyID = keyID
+		s.l.Unlock()
 		return nil, nil
 	}
 
@@ -125,10 +132,19 @@ func (s *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 		if err != nil {
 			return nil, fmt.Errorf("error base64-decoding key: %w", err)
 		}
-		if err := s.SetAESGCMKeyBytes(keyRaw); err != nil {
+		aead, err := newAESGCM(keyRaw)
+		if err != nil {
 			return nil, fmt.Errorf("error setting AES GCM key: %w", err)
 		}
 
+		// Swap the key ID and key together so concurrent encryptions never
+		// label output with the wrong key ID
+		s.l.Lock()
+		s.keyID = keyID
+		s.keyBytes = keyRaw
+		s.aead = aead
+		s.l.Unlock()
+
 	default:
 		return nil, fmt.Errorf("unknown aead_type %q", aeadType)
 	}
@@ -141,30 +157,40 @@ func (s *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 }
 
 func (s *Wrapper) GetKeyBytes() []byte {
+	s.l.RLock()
+	defer s.l.RUnlock()
 	return s.keyBytes
 }
 
 func (s *Wrapper) SetAEAD(aead cipher.AEAD) {
+	s.l.Lock()
+	defer s.l.Unlock()
 	s.aead = aead
 }
 
 // SetAESGCMKeyBytes takes in a byte slice and constucts an AES-GCM AEAD from it
 func (s *Wrapper) SetAESGCMKeyBytes(key []byte) error {
-	aesCipher, err := aes.NewCipher(key)
-	if err != nil {
-		return err
-	}
-
-	aead, err := cipher.NewGCM(aesCipher)
+	aead, err := newAESGCM(key)
 	if err != nil {
 		return err
 	}
 
+	s.l.Lock()
+	defer s.l.Unlock()
 	s.keyBytes = key
 	s.aead = aead
 	return nil
 }
 
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	aesCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(aesCipher)
+}
+
 // Init is a no-op at the moment
 func (s *Wrapper) Init(_ context.Context) error {
 	return nil
@@ -187,6 +213,8 @@ func (s *ShamirWrapper) Type() string {
 
 // KeyID returns the last known key id
 func (s *Wrapper) KeyID() string {
+	s.l.RLock()
+	defer s.l.RUnlock()
 	return s.keyID
 }
 
@@ -201,7 +229,11 @@ func (s *Wrapper) Encrypt(_ context.Context, plaintext, aad []byte) (*wrapping.E
 		return nil, errors.New("given plaintext for encryption is nil")
 	}
 
-	if s.aead == nil {
+	s.l.RLock()
+	aead, keyID := s.aead, s.keyID
+	s.l.RUnlock()
+
+	if aead == nil {
 		return nil, errors.New("aead is not configured in the seal")
 	}
 
@@ -210,12 +242,12 @@ func (s *Wrapper) Encrypt(_ context.Context, plaintext, aad []byte) (*wrapping.E
 		return nil, err
 	}
 
-	ciphertext := s.aead.Seal(nil, iv, plaintext, aad)
+	ciphertext := aead.Seal(nil, iv, plaintext, aad)
 
 	return &wrapping.EncryptedBlobInfo{
 		Ciphertext: append(iv, ciphertext...),
 		KeyInfo: &wrapping.KeyInfo{
-			KeyID: s.keyID,
+			KeyID: keyID,
 		},
 	}, nil
 }
@@ -225,13 +257,21 @@ func (s *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 		return nil, errors.New("given plaintext for encryption is nil")
 	}
 
-	if s.aead == nil {
+	s.l.RLock()
+	aead := s.aead
+	s.l.RUnlock()
+
+	if aead == nil {
 		return nil, errors.New("aead is not configured in the seal")
 	}
 
+	if len(in.Ciphertext) < aead.NonceSize() {
+		return nil, errors.New("given ciphertext is too short to contain an IV")
+	}
+
 	iv, ciphertext := in.Ciphertext[:12], in.Ciphertext[12:]
 
-	plaintext, err := s.aead.Open(nil, iv, ciphertext, aad)
+	plaintext, err := aead.Open(nil, iv, ciphertext, aad)
 	if err != nil {
 		return nil, err
 	}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
 )
 
 func TestShamirVsAEAD(t *testing.T) {
@@ -115,3 +116,18 @@ func TestWrapperAndDerivedWrapper(t *testing.T) {
 		t.Fatal("expected an error")
 	}
 }
+
+func TestWrapper_Conformance(t *testing.T) {
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		w := NewWrapper(nil)
+		w.SetConfig(map[string]string{"key_id": "conformance"})
+		if err := w.SetAESGCMKeyBytes(key); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}, nil)
+}
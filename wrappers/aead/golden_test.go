@@ -0,0 +1,86 @@
+package aead
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/golden"
+	"google.golang.org/protobuf/proto"
+)
+
+// goldenDerivedOptions must never change; the derived vector depends on them
+var goldenDerivedOptions = &DerivedWrapperOptions{
+	KeyID: "golden-derived",
+	Salt:  []byte("golden salt"),
+	Info:  []byte("golden info"),
+}
+
+func TestGoldenVectors(t *testing.T) {
+	vectors := golden.Load(t, "testdata/golden.json", func(t *testing.T) []golden.Vector {
+		key := bytes.Repeat([]byte{0x01}, 32)
+		root := NewWrapper(nil)
+		root.SetConfig(map[string]string{"key_id": "golden"})
+		if err := root.SetAESGCMKeyBytes(key); err != nil {
+			t.Fatal(err)
+		}
+		derived, err := root.NewDerivedWrapper(goldenDerivedOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ret []golden.Vector
+		for _, tc := range []struct {
+			name string
+			w    *Wrapper
+		}{
+			{"aes-gcm", root},
+			{"aes-gcm-derived", derived},
+		} {
+			pt, aad := []byte("golden "+tc.name+" plaintext"), []byte("golden aad")
+			blob, err := tc.w.Encrypt(context.Background(), pt, aad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := proto.Marshal(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ret = append(ret, golden.Vector{Name: tc.name, Key: key, Plaintext: pt, AAD: aad, Blob: raw})
+		}
+		return ret
+	})
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			w := NewWrapper(nil)
+			if err := w.SetAESGCMKeyBytes(v.Key); err != nil {
+				t.Fatal(err)
+			}
+			switch v.Name {
+			case "aes-gcm":
+			case "aes-gcm-derived":
+				var err error
+				w, err = w.NewDerivedWrapper(goldenDerivedOptions)
+				if err != nil {
+					t.Fatal(err)
+				}
+			default:
+				t.Fatalf("unknown vector %q", v.Name)
+			}
+
+			var blob wrapping.EncryptedBlobInfo
+			if err := proto.Unmarshal(v.Blob, &blob); err != nil {
+				t.Fatal(err)
+			}
+			pt, err := w.Decrypt(context.Background(), &blob, v.AAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.Plaintext, pt) {
+				t.Fatalf("expected %q, got %q", v.Plaintext, pt)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package aead
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+)
+
+func TestWrapper_Rotation(t *testing.T) {
+	configs := make([]map[string]string, 4)
+	decrypters := make(map[string]wrapping.Wrapper, len(configs))
+	for i := range configs {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		configs[i] = map[string]string{
+			"key_id":    fmt.Sprintf("key-%d", i),
+			"aead_type": "aes-gcm",
+			"key":       base64.StdEncoding.EncodeToString(key),
+		}
+
+		dec := NewWrapper(nil)
+		if _, err := dec.SetConfig(configs[i]); err != nil {
+			t.Fatal(err)
+		}
+		decrypters[configs[i]["key_id"]] = dec
+	}
+
+	w := NewWrapper(nil)
+	if _, err := w.SetConfig(configs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	wraptest.RunRotationTests(t, w, &wraptest.RotationOptions{
+		Rotate: func(i int) error {
+			_, err := w.SetConfig(configs[i%len(configs)])
+			return err
+		},
+		Decrypter: func(keyID string) (wrapping.Wrapper, error) {
+			dec, ok := decrypters[keyID]
+			if !ok {
+				return nil, fmt.Errorf("unknown key ID %q", keyID)
+			}
+			return dec, nil
+		},
+	})
+}
@@ -23,6 +23,7 @@ const (
 // Wrapper is a Wrapper that uses AliCloud's KMS
 type Wrapper struct {
 	client       kmsClient
+	factory      clientFactory
 	domain       string
 	keyID        string
 	currentKeyID *atomic.Value
@@ -38,6 +39,7 @@ func NewWrapper(opts *wrapping.WrapperOptions) *Wrapper {
 	}
 	k := &Wrapper{
 		currentKeyID: new(atomic.Value),
+		factory:      defaultClientFactory{},
 	}
 	k.currentKeyID.Store("")
 	return k
@@ -101,20 +103,7 @@ func (k *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 			}
 		}
 
-		credentialChain := []providers.Provider{
-			providers.NewEnvCredentialProvider(),
-			providers.NewConfigurationCredentialProvider(credConfig),
-			providers.NewInstanceMetadataProvider(),
-		}
-		credProvider := providers.NewChainProvider(credentialChain)
-
-		creds, err := credProvider.Retrieve()
-		if err != nil {
-			return nil, err
-		}
-		clientConfig := sdk.NewConfig()
-		clientConfig.Scheme = "https"
-		client, err := kms.NewClientWithOptions(region, clientConfig, creds)
+		client, err := k.factory.newClient(region, credConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -219,6 +208,9 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 	if in == nil {
 		return nil, fmt.Errorf("given input for decryption is nil")
 	}
+	if in.KeyInfo == nil {
+		return nil, fmt.Errorf("key info is nil")
+	}
 
 	// KeyID is not passed to this call because AliCloud handles this
 	// internally based on the metadata stored with the encrypted data
@@ -249,6 +241,33 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 	return plaintext, nil
 }
 
+// clientFactory builds the KMS client from the region and the
+// configuration-based credentials resolved by SetConfig
+type clientFactory interface {
+	newClient(region string, credConfig *providers.Configuration) (kmsClient, error)
+}
+
+type defaultClientFactory struct{}
+
+// newClient retrieves credentials from the environment, then the given
+// configuration, then instance metadata, in that order
+func (defaultClientFactory) newClient(region string, credConfig *providers.Configuration) (kmsClient, error) {
+	credentialChain := []providers.Provider{
+		providers.NewEnvCredentialProvider(),
+		providers.NewConfigurationCredentialProvider(credConfig),
+		providers.NewInstanceMetadataProvider(),
+	}
+	credProvider := providers.NewChainProvider(credentialChain)
+
+	creds, err := credProvider.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	clientConfig := sdk.NewConfig()
+	clientConfig.Scheme = "https"
+	return kms.NewClientWithOptions(region, clientConfig, creds)
+}
+
 type kmsClient interface {
 	Decrypt(request *kms.DecryptRequest) (response *kms.DecryptResponse, err error)
 	DescribeKey(request *kms.DescribeKeyRequest) (response *kms.DescribeKeyResponse, err error)
@@ -8,7 +8,9 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials/providers"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/kms"
+	"github.com/hashicorp/go-kms-wrapping/internal/testenv"
 )
 
 const aliCloudTestKeyID = "foo"
@@ -72,6 +74,124 @@ func TestAliCloudKMSWrapper_Lifecycle(t *testing.T) {
 	}
 }
 
+func TestAliCloudKMSWrapper_ConfigPrecedence(t *testing.T) {
+	testCases := []struct {
+		Title          string
+		Env            map[string]string
+		Config         map[string]string
+		ExpectedKeyID  string
+		ExpectedRegion string
+		ExpectedDomain string
+		ExpectedCreds  providers.Configuration
+	}{
+		{
+			Title:          "Default",
+			Config:         map[string]string{"kms_key_id": "config-key"},
+			ExpectedKeyID:  "config-key",
+			ExpectedRegion: "cn-beijing",
+		},
+		{
+			Title: "Config",
+			Config: map[string]string{
+				"kms_key_id":    "config-key",
+				"region":        "us-east-1",
+				"domain":        "kms.us-east-1.aliyuncs.com",
+				"access_key":    "config-access",
+				"access_secret": "config-access-secret",
+			},
+			ExpectedKeyID:  "config-key",
+			ExpectedRegion: "us-east-1",
+			ExpectedDomain: "kms.us-east-1.aliyuncs.com",
+			ExpectedCreds: providers.Configuration{
+				AccessKeyID:     "config-access",
+				AccessKeySecret: "config-access-secret",
+			},
+		},
+		{
+			// secret_key takes precedence over the access_secret alias
+			Title: "Secret-Key",
+			Config: map[string]string{
+				"kms_key_id":    "config-key",
+				"access_key":    "config-access",
+				"secret_key":    "config-secret",
+				"access_secret": "config-access-secret",
+			},
+			ExpectedKeyID:  "config-key",
+			ExpectedRegion: "cn-beijing",
+			ExpectedCreds: providers.Configuration{
+				AccessKeyID:     "config-access",
+				AccessKeySecret: "config-secret",
+			},
+		},
+		{
+			Title: "Env",
+			Env: map[string]string{
+				EnvAliCloudKMSWrapperKeyID:   "env-key",
+				EnvVaultAliCloudKMSSealKeyID: "vault-env-key",
+				"ALICLOUD_REGION":            "ap-south-1",
+				"ALICLOUD_DOMAIN":            "kms.ap-south-1.aliyuncs.com",
+			},
+			Config: map[string]string{
+				"kms_key_id": "config-key",
+				"region":     "us-east-1",
+				"domain":     "kms.us-east-1.aliyuncs.com",
+			},
+			ExpectedKeyID:  "env-key",
+			ExpectedRegion: "ap-south-1",
+			ExpectedDomain: "kms.ap-south-1.aliyuncs.com",
+		},
+		{
+			Title:          "Vault-Env",
+			Env:            map[string]string{EnvVaultAliCloudKMSSealKeyID: "vault-env-key"},
+			Config:         map[string]string{"kms_key_id": "config-key"},
+			ExpectedKeyID:  "vault-env-key",
+			ExpectedRegion: "cn-beijing",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			defer testenv.Set(t, envNames, tc.Env)()
+
+			factory := &mockClientFactory{}
+			s := NewWrapper(nil)
+			s.factory = factory
+			info, err := s.SetConfig(tc.Config)
+			if err != nil {
+				t.Fatalf("error setting config: %s", err)
+			}
+
+			if s.keyID != tc.ExpectedKeyID {
+				t.Fatalf("expected key ID %q, got %q", tc.ExpectedKeyID, s.keyID)
+			}
+			if factory.region != tc.ExpectedRegion || info["region"] != tc.ExpectedRegion {
+				t.Fatalf("expected region %q, got %q", tc.ExpectedRegion, factory.region)
+			}
+			if s.domain != tc.ExpectedDomain {
+				t.Fatalf("expected domain %q, got %q", tc.ExpectedDomain, s.domain)
+			}
+			if !reflect.DeepEqual(*factory.credConfig, tc.ExpectedCreds) {
+				t.Fatalf("expected credentials %#v, got %#v", tc.ExpectedCreds, *factory.credConfig)
+			}
+		})
+	}
+}
+
+// mockClientFactory keeps the region and credentials SetConfig resolved
+type mockClientFactory struct {
+	region     string
+	credConfig *providers.Configuration
+}
+
+func (f *mockClientFactory) newClient(region string, credConfig *providers.Configuration) (kmsClient, error) {
+	f.region = region
+	f.credConfig = credConfig
+	return &mockAliCloudKMSWrapperClient{keyID: aliCloudTestKeyID}, nil
+}
+
+// envNames are the environment variables SetConfig reads
+var envNames = []string{EnvAliCloudKMSWrapperKeyID, EnvVaultAliCloudKMSSealKeyID, "ALICLOUD_REGION", "ALICLOUD_DOMAIN"}
+
 type mockAliCloudKMSWrapperClient struct {
 	keyID string
 }
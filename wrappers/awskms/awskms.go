@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"sync/atomic"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -31,8 +32,13 @@ const (
 )
 
 // Wrapper represents credentials and Key information for the KMS Key used to
-// encryption and decryption
+// encryption and decryption. It is safe to call SetConfig while the wrapper is
+// in use, for example to rotate credentials.
 type Wrapper struct {
+	// l guards the configuration and client, which SetConfig may replace
+	// while operations are in flight
+	l sync.RWMutex
+
 	accessKey    string
 	secretKey    string
 	sessionToken string
@@ -42,7 +48,21 @@ type Wrapper struct {
 
 	currentKeyID *atomic.Value
 
-	client kmsiface.KMSAPI
+	client  kmsiface.KMSAPI
+	factory clientFactory
+}
+
+// clientFactory builds the KMS client once SetConfig has resolved the
+// credentials, region and endpoint. Tests replace it to check which values
+// won without talking to AWS.
+type clientFactory interface {
+	newClient(credsConfig *awsutil.CredentialsConfig, endpoint string) (kmsiface.KMSAPI, error)
+}
+
+type defaultClientFactory struct{}
+
+func (defaultClientFactory) newClient(credsConfig *awsutil.CredentialsConfig, endpoint string) (kmsiface.KMSAPI, error) {
+	return newAWSKMSClient(credsConfig, endpoint)
 }
 
 // Ensure that we are implementing Wrapper
@@ -55,13 +75,16 @@ func NewWrapper(opts *wrapping.WrapperOptions) *Wrapper {
 	}
 	k := &Wrapper{
 		currentKeyID: new(atomic.Value),
+		factory:      defaultClientFactory{},
 	}
 	k.currentKeyID.Store("")
 	return k
 }
 
 // SetConfig sets the fields on the Wrapper object based on
-// values from the config parameter.
+// values from the config parameter. Calling it again on a configured wrapper
+// builds a new client and looks up the key again, which is how credentials
+// and keys are rotated.
 //
 // Order of precedence AWS values:
 // * Environment variable
@@ -73,47 +96,53 @@ func (k *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 		config = map[string]string{}
 	}
 
-	// Check and set KeyID
+	// The new configuration is resolved and its client built and tested
+	// without the lock, so that operations in flight keep using the old one
+	// until both are swapped in
+	var keyID string
 	switch {
 	case os.Getenv(EnvAWSKMSWrapperKeyID) != "":
-		k.keyID = os.Getenv(EnvAWSKMSWrapperKeyID)
+		keyID = os.Getenv(EnvAWSKMSWrapperKeyID)
 	case os.Getenv(EnvVaultAWSKMSSealKeyID) != "":
-		k.keyID = os.Getenv(EnvVaultAWSKMSSealKeyID)
+		keyID = os.Getenv(EnvVaultAWSKMSSealKeyID)
 	case config["kms_key_id"] != "":
-		k.keyID = config["kms_key_id"]
+		keyID = config["kms_key_id"]
 	default:
 		return nil, fmt.Errorf("'kms_key_id' not found for AWS KMS wrapper configuration")
 	}
 
 	// Please see GetRegion for an explanation of the order in which region is parsed.
-	var err error
-	k.region, err = awsutil.GetRegion(config["region"])
+	region, err := awsutil.GetRegion(config["region"])
 	if err != nil {
 		return nil, err
 	}
 
-	// Check and set AWS access key, secret key, and session token
-	k.accessKey = config["access_key"]
-	k.secretKey = config["secret_key"]
-	k.sessionToken = config["session_token"]
-
-	k.endpoint = os.Getenv("AWS_KMS_ENDPOINT")
-	if k.endpoint == "" {
-		if endpoint, ok := config["endpoint"]; ok {
-			k.endpoint = endpoint
-		}
+	endpoint := os.Getenv("AWS_KMS_ENDPOINT")
+	if endpoint == "" {
+		endpoint = config["endpoint"]
 	}
 
-	// Check and set k.client
-	if k.client == nil {
-		client, err := k.GetAWSKMSClient()
+	// Check and set AWS access key, secret key, and session token
+	accessKey, secretKey, sessionToken := config["access_key"], config["secret_key"], config["session_token"]
+
+	// A client is built only when there is none yet, or when a configured
+	// wrapper is being reconfigured, so that a client set beforehand is kept
+	// on the first call
+	k.l.RLock()
+	rebuild := k.client == nil || k.keyID != ""
+	k.l.RUnlock()
+
+	var client kmsiface.KMSAPI
+	var currentKeyID string
+	if rebuild {
+		client, err = k.factory.newClient(newCredentialsConfig(accessKey, secretKey, sessionToken, region), endpoint)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing AWS KMS wrapping client: %w", err)
 		}
 
 		// Test the client connection using provided key ID
 		keyInfo, err := client.DescribeKey(&kms.DescribeKeyInput{
-			KeyId: aws.String(k.keyID),
+			KeyId: aws.String(keyID),
 		})
 		if err != nil {
 			return nil, fmt.Errorf("error fetching AWS KMS wrapping key information: %w", err)
@@ -121,17 +150,28 @@ func (k *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 		if keyInfo == nil || keyInfo.KeyMetadata == nil || keyInfo.KeyMetadata.KeyId == nil {
 			return nil, errors.New("no key information returned")
 		}
-		k.currentKeyID.Store(aws.StringValue(keyInfo.KeyMetadata.KeyId))
+		currentKeyID = aws.StringValue(keyInfo.KeyMetadata.KeyId)
+	}
 
+	k.l.Lock()
+	k.accessKey = accessKey
+	k.secretKey = secretKey
+	k.sessionToken = sessionToken
+	k.region = region
+	k.keyID = keyID
+	k.endpoint = endpoint
+	if rebuild {
 		k.client = client
+		k.currentKeyID.Store(currentKeyID)
 	}
+	k.l.Unlock()
 
 	// Map that holds non-sensitive configuration info
 	wrappingInfo := make(map[string]string)
-	wrappingInfo["region"] = k.region
-	wrappingInfo["kms_key_id"] = k.keyID
-	if k.endpoint != "" {
-		wrappingInfo["endpoint"] = k.endpoint
+	wrappingInfo["region"] = region
+	wrappingInfo["kms_key_id"] = keyID
+	if endpoint != "" {
+		wrappingInfo["endpoint"] = endpoint
 	}
 
 	return wrappingInfo, nil
@@ -176,15 +216,19 @@ func (k *Wrapper) Encrypt(_ context.Context, plaintext, aad []byte) (blob *wrapp
 		return nil, fmt.Errorf("error wrapping data: %w", err)
 	}
 
-	if k.client == nil {
+	k.l.RLock()
+	client, configuredKeyID := k.client, k.keyID
+	k.l.RUnlock()
+
+	if client == nil {
 		return nil, fmt.Errorf("nil client")
 	}
 
 	input := &kms.EncryptInput{
-		KeyId:     aws.String(k.keyID),
+		KeyId:     aws.String(configuredKeyID),
 		Plaintext: env.Key,
 	}
-	output, err := k.client.Encrypt(input)
+	output, err := client.Encrypt(input)
 	if err != nil {
 		return nil, fmt.Errorf("error encrypting data: %w", err)
 	}
@@ -214,6 +258,85 @@ func (k *Wrapper) Encrypt(_ context.Context, plaintext, aad []byte) (blob *wrapp
 	return ret, nil
 }
 
+// EncryptDirect encrypts plaintext with the KMS key itself rather than a data
+// key, producing an AWSKMSEncrypt blob whose ciphertext is the KMS ciphertext
+// blob. KMS limits plaintext to 4096 bytes; this is meant for keys and other
+// small values that other KMS clients must be able to decrypt.
+func (k *Wrapper) EncryptDirect(ctx context.Context, plaintext []byte) (*wrapping.EncryptedBlobInfo, error) {
+	return k.EncryptDirectWithContext(ctx, plaintext, nil)
+}
+
+// EncryptDirectWithContext is EncryptDirect with a KMS encryption context,
+// which KMS binds to the ciphertext. The same context must be given to
+// DecryptDirectWithContext.
+func (k *Wrapper) EncryptDirectWithContext(_ context.Context, plaintext []byte, encryptionContext map[string]string) (*wrapping.EncryptedBlobInfo, error) {
+	if plaintext == nil {
+		return nil, fmt.Errorf("given plaintext for encryption is nil")
+	}
+
+	k.l.RLock()
+	client, configuredKeyID := k.client, k.keyID
+	k.l.RUnlock()
+
+	if client == nil {
+		return nil, fmt.Errorf("nil client")
+	}
+
+	input := &kms.EncryptInput{
+		KeyId:     aws.String(configuredKeyID),
+		Plaintext: plaintext,
+	}
+	if len(encryptionContext) != 0 {
+		input.EncryptionContext = aws.StringMap(encryptionContext)
+	}
+	output, err := client.Encrypt(input)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting data: %w", err)
+	}
+
+	keyID := aws.StringValue(output.KeyId)
+	k.currentKeyID.Store(keyID)
+
+	return &wrapping.EncryptedBlobInfo{
+		Ciphertext: output.CiphertextBlob,
+		KeyInfo: &wrapping.KeyInfo{
+			Mechanism: AWSKMSEncrypt,
+			KeyID:     keyID,
+		},
+	}, nil
+}
+
+// DecryptDirectWithContext decrypts the KMS ciphertext of an AWSKMSEncrypt
+// blob that was encrypted with the given encryption context
+func (k *Wrapper) DecryptDirectWithContext(_ context.Context, in *wrapping.EncryptedBlobInfo, encryptionContext map[string]string) ([]byte, error) {
+	if in == nil {
+		return nil, fmt.Errorf("given input for decryption is nil")
+	}
+	if in.KeyInfo != nil && in.KeyInfo.Mechanism != AWSKMSEncrypt {
+		return nil, fmt.Errorf("invalid mechanism: %d", in.KeyInfo.Mechanism)
+	}
+
+	k.l.RLock()
+	client := k.client
+	k.l.RUnlock()
+
+	if client == nil {
+		return nil, fmt.Errorf("nil client")
+	}
+
+	input := &kms.DecryptInput{
+		CiphertextBlob: in.Ciphertext,
+	}
+	if len(encryptionContext) != 0 {
+		input.EncryptionContext = aws.StringMap(encryptionContext)
+	}
+	output, err := client.Decrypt(input)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data: %w", err)
+	}
+	return output.Plaintext, nil
+}
+
 // Decrypt is used to decrypt the ciphertext. This should be called after Init.
 func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad []byte) (pt []byte, err error) {
 	if in == nil {
@@ -227,6 +350,14 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 		}
 	}
 
+	k.l.RLock()
+	client := k.client
+	k.l.RUnlock()
+
+	if client == nil {
+		return nil, fmt.Errorf("nil client")
+	}
+
 	var plaintext []byte
 	switch in.KeyInfo.Mechanism {
 	case AWSKMSEncrypt:
@@ -234,7 +365,7 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 			CiphertextBlob: in.Ciphertext,
 		}
 
-		output, err := k.client.Decrypt(input)
+		output, err := client.Decrypt(input)
 		if err != nil {
 			return nil, fmt.Errorf("error decrypting data: %w", err)
 		}
@@ -246,7 +377,7 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 		input := &kms.DecryptInput{
 			CiphertextBlob: in.KeyInfo.WrappedKey,
 		}
-		output, err := k.client.Decrypt(input)
+		output, err := client.Decrypt(input)
 		if err != nil {
 			return nil, fmt.Errorf("error decrypting data encryption key: %w", err)
 		}
@@ -270,15 +401,28 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 
 // GetAWSKMSClient returns an instance of the KMS client.
 func (k *Wrapper) GetAWSKMSClient() (*kms.KMS, error) {
-	credsConfig := &awsutil.CredentialsConfig{}
+	k.l.RLock()
+	defer k.l.RUnlock()
+	return newAWSKMSClient(k.credentialsConfig(), k.endpoint)
+}
 
-	credsConfig.AccessKey = k.accessKey
-	credsConfig.SecretKey = k.secretKey
-	credsConfig.SessionToken = k.sessionToken
-	credsConfig.Region = k.region
+// credentialsConfig gathers the configured credentials. The lock must be
+// held.
+func (k *Wrapper) credentialsConfig() *awsutil.CredentialsConfig {
+	return newCredentialsConfig(k.accessKey, k.secretKey, k.sessionToken, k.region)
+}
 
-	credsConfig.HTTPClient = cleanhttp.DefaultClient()
+func newCredentialsConfig(accessKey, secretKey, sessionToken, region string) *awsutil.CredentialsConfig {
+	return &awsutil.CredentialsConfig{
+		AccessKey:    accessKey,
+		SecretKey:    secretKey,
+		SessionToken: sessionToken,
+		Region:       region,
+		HTTPClient:   cleanhttp.DefaultClient(),
+	}
+}
 
+func newAWSKMSClient(credsConfig *awsutil.CredentialsConfig, endpoint string) (*kms.KMS, error) {
 	creds, err := credsConfig.GenerateCredentialChain()
 	if err != nil {
 		return nil, err
@@ -290,8 +434,8 @@ func (k *Wrapper) GetAWSKMSClient() (*kms.KMS, error) {
 		HTTPClient:  cleanhttp.DefaultClient(),
 	}
 
-	if k.endpoint != "" {
-		awsConfig.Endpoint = aws.String(k.endpoint)
+	if endpoint != "" {
+		awsConfig.Endpoint = aws.String(endpoint)
 	}
 
 	sess, err := session.NewSession(awsConfig)
@@ -0,0 +1,87 @@
+//go:build integration
+// +build integration
+
+package awskms
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+)
+
+// These tests run against LocalStack; see integration/README.md
+func localstackConfig(t *testing.T) map[string]string {
+	t.Helper()
+
+	endpoint := os.Getenv("LOCALSTACK_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://localhost:4566"
+	}
+	config := map[string]string{
+		"region":     "us-east-1",
+		"access_key": "test",
+		"secret_key": "test",
+		"endpoint":   endpoint,
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials(config["access_key"], config["secret_key"], ""),
+		Region:      aws.String(config["region"]),
+		Endpoint:    aws.String(endpoint),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := kms.New(sess).CreateKey(&kms.CreateKeyInput{
+		Description: aws.String("go-kms-wrapping integration test"),
+	})
+	if err != nil {
+		t.Fatalf("error creating LocalStack KMS key: %v", err)
+	}
+	config["kms_key_id"] = aws.StringValue(key.KeyMetadata.KeyId)
+
+	return config
+}
+
+func TestIntegrationAWSKMSWrapper_Lifecycle(t *testing.T) {
+	s := NewWrapper(nil)
+	if _, err := s.SetConfig(localstackConfig(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	input := []byte("foo")
+	swi, err := s.Encrypt(context.Background(), input, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+	if swi.KeyInfo.Mechanism != AWSKMSEnvelopeAESGCMEncrypt {
+		t.Fatalf("unexpected mechanism %d", swi.KeyInfo.Mechanism)
+	}
+
+	pt, err := s.Decrypt(context.Background(), swi, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+	if !reflect.DeepEqual(input, pt) {
+		t.Fatalf("expected %s, got %s", input, pt)
+	}
+}
+
+func TestIntegrationAWSKMSWrapper_Conformance(t *testing.T) {
+	config := localstackConfig(t)
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		s := NewWrapper(nil)
+		if _, err := s.SetConfig(config); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}, nil)
+}
@@ -2,18 +2,19 @@ package awskms
 
 import (
 	"context"
+	"errors"
 	"os"
 	"reflect"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/testenv"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
 )
 
 func TestAWSKMSWrapper(t *testing.T) {
-	s := NewWrapper(nil)
-	s.client = &mockClient{
-		keyID: aws.String(awsTestKeyID),
-	}
+	s := NewAWSKMSTestWrapper()
 
 	_, err := s.SetConfig(nil)
 	if err == nil {
@@ -34,10 +35,7 @@ func TestAWSKMSWrapper_Lifecycle(t *testing.T) {
 	if os.Getenv(EnvAWSKMSWrapperKeyID) == "" && os.Getenv(EnvVaultAWSKMSSealKeyID) == "" {
 		t.SkipNow()
 	}
-	s := NewWrapper(nil)
-	s.client = &mockClient{
-		keyID: aws.String(awsTestKeyID),
-	}
+	s := NewAWSKMSTestWrapper()
 	oldKeyID := os.Getenv(EnvAWSKMSWrapperKeyID)
 	os.Setenv(EnvAWSKMSWrapperKeyID, awsTestKeyID)
 	defer os.Setenv(EnvAWSKMSWrapperKeyID, oldKeyID)
@@ -127,11 +125,7 @@ func TestAWSKMSWrapper_custom_endpoint(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.Title, func(t *testing.T) {
-			s := NewWrapper(nil)
-
-			s.client = &mockClient{
-				keyID: aws.String(awsTestKeyID),
-			}
+			s := NewAWSKMSTestWrapper()
 
 			if tc.Env != "" {
 				if err := os.Setenv(endpointENV, tc.Env); err != nil {
@@ -177,3 +171,152 @@ func TestAWSKMSWrapper_custom_endpoint(t *testing.T) {
 	}
 
 }
+
+func TestAWSKMSWrapper_ConfigPrecedence(t *testing.T) {
+	config := map[string]string{
+		"kms_key_id":    "config-key",
+		"region":        "eu-west-1",
+		"access_key":    "config-access",
+		"secret_key":    "config-secret",
+		"session_token": "config-token",
+		"endpoint":      "https://config.endpoint",
+	}
+
+	testCases := []struct {
+		Title            string
+		Env              map[string]string
+		ExpectedKeyID    string
+		ExpectedEndpoint string
+	}{
+		{
+			Title:            "Config",
+			ExpectedKeyID:    "config-key",
+			ExpectedEndpoint: "https://config.endpoint",
+		},
+		{
+			Title:            "Vault-Env",
+			Env:              map[string]string{EnvVaultAWSKMSSealKeyID: "vault-env-key"},
+			ExpectedKeyID:    "vault-env-key",
+			ExpectedEndpoint: "https://config.endpoint",
+		},
+		{
+			Title: "Env",
+			Env: map[string]string{
+				EnvAWSKMSWrapperKeyID:   "env-key",
+				EnvVaultAWSKMSSealKeyID: "vault-env-key",
+				"AWS_KMS_ENDPOINT":      "https://env.endpoint",
+			},
+			ExpectedKeyID:    "env-key",
+			ExpectedEndpoint: "https://env.endpoint",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			defer testenv.Set(t, envNames, tc.Env)()
+
+			factory := &mockClientFactory{}
+			s := NewWrapper(nil)
+			s.factory = factory
+			if _, err := s.SetConfig(config); err != nil {
+				t.Fatalf("error setting config: %s", err)
+			}
+
+			if s.keyID != tc.ExpectedKeyID {
+				t.Fatalf("expected key ID %q, got %q", tc.ExpectedKeyID, s.keyID)
+			}
+			if factory.endpoint != tc.ExpectedEndpoint {
+				t.Fatalf("expected endpoint %q, got %q", tc.ExpectedEndpoint, factory.endpoint)
+			}
+			creds := factory.credsConfig
+			if creds.Region != "eu-west-1" || creds.AccessKey != "config-access" ||
+				creds.SecretKey != "config-secret" || creds.SessionToken != "config-token" {
+				t.Fatalf("unexpected credentials config: %#v", creds)
+			}
+		})
+	}
+
+	t.Run("Factory-Error", func(t *testing.T) {
+		defer testenv.Set(t, envNames, nil)()
+
+		s := NewWrapper(nil)
+		s.factory = &mockClientFactory{err: errors.New("no credentials")}
+		if _, err := s.SetConfig(config); err == nil {
+			t.Fatal("expected client construction error")
+		}
+	})
+}
+
+// envNames are the environment variables SetConfig reads
+var envNames = []string{EnvAWSKMSWrapperKeyID, EnvVaultAWSKMSSealKeyID, "AWS_KMS_ENDPOINT"}
+
+func TestAWSKMSWrapper_Conformance(t *testing.T) {
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		s := NewAWSKMSTestWrapper()
+		if _, err := s.SetConfig(map[string]string{"kms_key_id": awsTestKeyID}); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}, nil)
+}
+
+func TestAWSKMSWrapper_EncryptDirect(t *testing.T) {
+	s := NewAWSKMSTestWrapper()
+	if _, err := s.SetConfig(map[string]string{"kms_key_id": awsTestKeyID}); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := s.EncryptDirect(context.Background(), []byte("foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if blob.KeyInfo.Mechanism != AWSKMSEncrypt || len(blob.IV) != 0 || len(blob.KeyInfo.WrappedKey) != 0 {
+		t.Fatalf("expected a direct blob, got %v", blob)
+	}
+	if blob.KeyInfo.KeyID != awsTestKeyID {
+		t.Fatalf("expected key ID %s, got %s", awsTestKeyID, blob.KeyInfo.KeyID)
+	}
+
+	// The ciphertext is usable without the key info, as other KMS clients see it
+	pt, err := s.Decrypt(context.Background(), &wrapping.EncryptedBlobInfo{Ciphertext: blob.Ciphertext}, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %q", pt)
+	}
+}
+
+func TestAWSKMSWrapper_EncryptionContext(t *testing.T) {
+	s := NewAWSKMSTestWrapper()
+	if _, err := s.SetConfig(map[string]string{"kms_key_id": awsTestKeyID}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	encryptionContext := map[string]string{"purpose": "test"}
+
+	blob, err := s.EncryptDirectWithContext(ctx, []byte("foo"), encryptionContext)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	pt, err := s.DecryptDirectWithContext(ctx, blob, encryptionContext)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %q", pt)
+	}
+
+	if _, err := s.DecryptDirectWithContext(ctx, blob, map[string]string{"purpose": "other"}); err == nil {
+		t.Fatal("expected error for a different encryption context")
+	}
+	if _, err := s.Decrypt(ctx, blob, nil); err == nil {
+		t.Fatal("expected error without the encryption context")
+	}
+	if _, err := s.DecryptDirectWithContext(ctx, &wrapping.EncryptedBlobInfo{
+		Ciphertext: blob.Ciphertext,
+		KeyInfo:    &wrapping.KeyInfo{Mechanism: AWSKMSEnvelopeAESGCMEncrypt},
+	}, encryptionContext); err == nil {
+		t.Fatal("expected error for an envelope blob")
+	}
+}
@@ -0,0 +1,64 @@
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/golden"
+	"google.golang.org/protobuf/proto"
+)
+
+// The vectors are produced and consumed through the mock client, so they pin
+// this package's blob layout and mechanism handling rather than AWS itself
+func TestGoldenVectors(t *testing.T) {
+	vectors := golden.Load(t, "testdata/golden.json", func(t *testing.T) []golden.Vector {
+		s := NewAWSKMSTestWrapper()
+		if _, err := s.SetConfig(map[string]string{"kms_key_id": awsTestKeyID}); err != nil {
+			t.Fatal(err)
+		}
+
+		pt, aad := []byte("golden envelope plaintext"), []byte("golden aad")
+		envBlob, err := s.Encrypt(context.Background(), pt, aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envRaw, err := proto.Marshal(envBlob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Blobs from before envelopes were used hold the KMS ciphertext
+		// directly and carry no key info
+		directPt := []byte("golden direct plaintext")
+		directRaw, err := proto.Marshal(&wrapping.EncryptedBlobInfo{
+			Ciphertext: []byte(base64.StdEncoding.EncodeToString(directPt)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return []golden.Vector{
+			{Name: "envelope-aes-gcm", Plaintext: pt, AAD: aad, Blob: envRaw},
+			{Name: "kms-encrypt", Plaintext: directPt, Blob: directRaw},
+		}
+	})
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			var blob wrapping.EncryptedBlobInfo
+			if err := proto.Unmarshal(v.Blob, &blob); err != nil {
+				t.Fatal(err)
+			}
+			pt, err := NewAWSKMSTestWrapper().Decrypt(context.Background(), &blob, v.AAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.Plaintext, pt) {
+				t.Fatalf("expected %q, got %q", v.Plaintext, pt)
+			}
+		})
+	}
+}
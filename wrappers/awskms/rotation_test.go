@@ -0,0 +1,79 @@
+package awskms
+
+import (
+	"fmt"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+)
+
+func TestAWSKMSWrapper_Rotation(t *testing.T) {
+	keyIDs := map[string]bool{"key-0": true, "key-1": true, "key-2": true}
+	config := func(i int) map[string]string {
+		return map[string]string{
+			"kms_key_id": fmt.Sprintf("key-%d", i%len(keyIDs)),
+			"region":     "us-east-1",
+			"access_key": fmt.Sprintf("access-%d", i),
+			"secret_key": fmt.Sprintf("secret-%d", i),
+		}
+	}
+
+	// Without a client set beforehand, every SetConfig builds one
+	s := NewWrapper(nil)
+	s.factory = &mockClientFactory{}
+	if _, err := s.SetConfig(config(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRotated(s, config(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	wraptest.RunRotationTests(t, s, &wraptest.RotationOptions{
+		Rotate: func(i int) error {
+			if _, err := s.SetConfig(config(i)); err != nil {
+				return err
+			}
+			return checkRotated(s, config(i))
+		},
+		// The mock client decrypts regardless of key, so only the labels
+		// are checked
+		Decrypter: func(keyID string) (wrapping.Wrapper, error) {
+			if !keyIDs[keyID] {
+				return nil, fmt.Errorf("unknown key ID %q", keyID)
+			}
+			return s, nil
+		},
+	})
+}
+
+// checkRotated verifies that the client in use was built with the
+// credentials of config, and that the key ID was looked up again
+func checkRotated(s *Wrapper, config map[string]string) error {
+	s.l.RLock()
+	client := s.client.(*mockClient)
+	s.l.RUnlock()
+
+	creds := client.credsConfig
+	if creds == nil || creds.AccessKey != config["access_key"] || creds.SecretKey != config["secret_key"] {
+		return fmt.Errorf("expected the client to use credentials %q, got %#v", config["access_key"], creds)
+	}
+	if s.KeyID() != config["kms_key_id"] {
+		return fmt.Errorf("expected key ID %q, got %q", config["kms_key_id"], s.KeyID())
+	}
+	return nil
+}
+
+func TestAWSKMSWrapper_KeepsClientOnFirstSetConfig(t *testing.T) {
+	s := NewAWSKMSTestWrapper()
+	client := s.client
+	if _, err := s.SetConfig(map[string]string{"kms_key_id": awsTestKeyID}); err != nil {
+		t.Fatal(err)
+	}
+	if s.client != client {
+		t.Fatal("expected the client set beforehand to be kept")
+	}
+	if f := s.factory.(*mockClientFactory); f.credsConfig != nil {
+		t.Fatal("expected no client to be built")
+	}
+}
@@ -1,12 +1,18 @@
 package awskms
 
 import (
+	"bytes"
 	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/hashicorp/vault/sdk/helper/awsutil"
 )
 
 const awsTestKeyID = "foo"
@@ -16,20 +22,55 @@ func NewAWSKMSTestWrapper() *Wrapper {
 	s.client = &mockClient{
 		keyID: aws.String(awsTestKeyID),
 	}
+	s.factory = &mockClientFactory{}
 	return s
 }
 
+// mockClientFactory records the values SetConfig resolved and returns a
+// mockClient built with them in place of a real KMS client
+type mockClientFactory struct {
+	credsConfig *awsutil.CredentialsConfig
+	endpoint    string
+	err         error
+}
+
+func (f *mockClientFactory) newClient(credsConfig *awsutil.CredentialsConfig, endpoint string) (kmsiface.KMSAPI, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.credsConfig = credsConfig
+	f.endpoint = endpoint
+	return &mockClient{
+		keyID:       aws.String(awsTestKeyID),
+		credsConfig: credsConfig,
+		endpoint:    endpoint,
+	}, nil
+}
+
 type mockClient struct {
 	kmsiface.KMSAPI
+
+	l     sync.Mutex
 	keyID *string
+
+	// credsConfig and endpoint are what the client was built with
+	credsConfig *awsutil.CredentialsConfig
+	endpoint    string
 }
 
-// Encrypt is a mocked call that returns a base64 encoded string.
+// Encrypt is a mocked call that returns a base64 encoded string. An
+// encryption context is appended after a separator, so that Decrypt can
+// insist on the same one.
 func (m *mockClient) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
+	m.l.Lock()
 	m.keyID = input.KeyId
+	m.l.Unlock()
 
 	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(input.Plaintext)))
 	base64.StdEncoding.Encode(encoded, input.Plaintext)
+	if len(input.EncryptionContext) != 0 {
+		encoded = append(encoded, mockContext(input.EncryptionContext)...)
+	}
 
 	return &kms.EncryptOutput{
 		CiphertextBlob: encoded,
@@ -39,9 +80,18 @@ func (m *mockClient) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error
 
 // Decrypt is a mocked call that returns a decoded base64 string.
 func (m *mockClient) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
-	decLen := base64.StdEncoding.DecodedLen(len(input.CiphertextBlob))
+	ciphertext := input.CiphertextBlob
+	var context string
+	if i := bytes.IndexByte(ciphertext, '|'); i >= 0 {
+		ciphertext, context = ciphertext[:i], string(ciphertext[i:])
+	}
+	if context != mockContext(input.EncryptionContext) {
+		return nil, awserr.New(kms.ErrCodeInvalidCiphertextException, "encryption context mismatch", nil)
+	}
+
+	decLen := base64.StdEncoding.DecodedLen(len(ciphertext))
 	decoded := make([]byte, decLen)
-	len, err := base64.StdEncoding.Decode(decoded, input.CiphertextBlob)
+	len, err := base64.StdEncoding.Decode(decoded, ciphertext)
 	if err != nil {
 		return nil, err
 	}
@@ -50,21 +100,47 @@ func (m *mockClient) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error
 		decoded = decoded[:len]
 	}
 
+	m.l.Lock()
+	defer m.l.Unlock()
 	return &kms.DecryptOutput{
 		KeyId:     m.keyID,
 		Plaintext: decoded,
 	}, nil
 }
 
-// DescribeKey is a mocked call that returns the keyID.
+// mockContext serializes an encryption context for the mock ciphertexts
+func mockContext(encryptionContext map[string]*string) string {
+	if len(encryptionContext) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(encryptionContext))
+	for k := range encryptionContext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "|%s=%s", k, aws.StringValue(encryptionContext[k]))
+	}
+	return b.String()
+}
+
+// DescribeKey is a mocked call that returns the requested key ID, or the
+// keyID if none is requested.
 func (m *mockClient) DescribeKey(input *kms.DescribeKeyInput) (*kms.DescribeKeyOutput, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
 	if m.keyID == nil {
 		return nil, awserr.New(kms.ErrCodeNotFoundException, "key not found", nil)
 	}
 
+	keyID := m.keyID
+	if input.KeyId != nil {
+		keyID = input.KeyId
+	}
 	return &kms.DescribeKeyOutput{
 		KeyMetadata: &kms.KeyMetadata{
-			KeyId: m.keyID,
+			KeyId: keyID,
 		},
 	}, nil
 }
@@ -41,7 +41,27 @@ type Wrapper struct {
 	currentKeyID *atomic.Value
 
 	environment azure.Environment
-	client      *keyvault.BaseClient
+	client      keyVaultClient
+	factory     clientFactory
+}
+
+// keyVaultClient is the subset of keyvault.BaseClient used by the wrapper
+type keyVaultClient interface {
+	GetKey(ctx context.Context, vaultBaseURL, keyName, keyVersion string) (keyvault.KeyBundle, error)
+	WrapKey(ctx context.Context, vaultBaseURL, keyName, keyVersion string, parameters keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error)
+	UnwrapKey(ctx context.Context, vaultBaseURL, keyName, keyVersion string, parameters keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error)
+}
+
+// clientFactory builds the Key Vault client from the credentials and
+// environment resolved by SetConfig
+type clientFactory interface {
+	newClient(tenantID, clientID, clientSecret string, environment azure.Environment) (keyVaultClient, error)
+}
+
+type defaultClientFactory struct{}
+
+func (defaultClientFactory) newClient(tenantID, clientID, clientSecret string, environment azure.Environment) (keyVaultClient, error) {
+	return getKeyVaultClient(tenantID, clientID, clientSecret, environment)
 }
 
 // Ensure that we are implementing Wrapper
@@ -54,6 +74,7 @@ func NewWrapper(opts *wrapping.WrapperOptions) *Wrapper {
 	}
 	v := &Wrapper{
 		currentKeyID: new(atomic.Value),
+		factory:      defaultClientFactory{},
 	}
 	v.currentKeyID.Store("")
 	return v
@@ -129,7 +150,7 @@ func (v *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 	}
 
 	if v.client == nil {
-		client, err := v.getKeyVaultClient()
+		client, err := v.factory.newClient(v.tenantID, v.clientID, v.clientSecret, v.environment)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing Azure Key Vault wrapper client: %w", err)
 		}
@@ -257,15 +278,15 @@ func (v *Wrapper) buildBaseURL() string {
 	return fmt.Sprintf("https://%s.%s/", v.vaultName, v.environment.KeyVaultDNSSuffix)
 }
 
-func (v *Wrapper) getKeyVaultClient() (*keyvault.BaseClient, error) {
+func getKeyVaultClient(tenantID, clientID, clientSecret string, environment azure.Environment) (*keyvault.BaseClient, error) {
 	var authorizer autorest.Authorizer
 	var err error
 
 	switch {
-	case v.clientID != "" && v.clientSecret != "":
-		config := auth.NewClientCredentialsConfig(v.clientID, v.clientSecret, v.tenantID)
-		config.AADEndpoint = v.environment.ActiveDirectoryEndpoint
-		config.Resource = strings.TrimSuffix(v.environment.KeyVaultEndpoint, "/")
+	case clientID != "" && clientSecret != "":
+		config := auth.NewClientCredentialsConfig(clientID, clientSecret, tenantID)
+		config.AADEndpoint = environment.ActiveDirectoryEndpoint
+		config.Resource = strings.TrimSuffix(environment.KeyVaultEndpoint, "/")
 		authorizer, err = config.Authorizer()
 		if err != nil {
 			return nil, err
@@ -273,7 +294,7 @@ func (v *Wrapper) getKeyVaultClient() (*keyvault.BaseClient, error) {
 	// By default use MSI
 	default:
 		config := auth.NewMSIConfig()
-		config.Resource = strings.TrimSuffix(v.environment.KeyVaultEndpoint, "/")
+		config.Resource = strings.TrimSuffix(environment.KeyVaultEndpoint, "/")
 		authorizer, err = config.Authorizer()
 		if err != nil {
 			return nil, err
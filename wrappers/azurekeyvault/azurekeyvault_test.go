@@ -0,0 +1,231 @@
+package azurekeyvault
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/hashicorp/go-kms-wrapping/internal/testenv"
+)
+
+const azureTestKeyVersion = "0123456789abcdef"
+
+func TestAzureKeyVault_ConfigPrecedence(t *testing.T) {
+	config := map[string]string{
+		"tenant_id":     "config-tenant",
+		"client_id":     "config-client",
+		"client_secret": "config-secret",
+		"vault_name":    "config-vault",
+		"key_name":      "config-key",
+	}
+
+	testCases := []struct {
+		Title               string
+		Env                 map[string]string
+		Config              map[string]string
+		ExpectedTenantID    string
+		ExpectedClientID    string
+		ExpectedSecret      string
+		ExpectedEnvironment string
+		ExpectedVaultName   string
+		ExpectedKeyName     string
+	}{
+		{
+			Title:               "Config",
+			Config:              config,
+			ExpectedTenantID:    "config-tenant",
+			ExpectedClientID:    "config-client",
+			ExpectedSecret:      "config-secret",
+			ExpectedEnvironment: azure.PublicCloud.Name,
+			ExpectedVaultName:   "config-vault",
+			ExpectedKeyName:     "config-key",
+		},
+		{
+			Title: "Vault-Env",
+			Env: map[string]string{
+				EnvVaultAzureKeyVaultVaultName: "vault-env-vault",
+				EnvVaultAzureKeyVaultKeyName:   "vault-env-key",
+			},
+			Config:              config,
+			ExpectedTenantID:    "config-tenant",
+			ExpectedClientID:    "config-client",
+			ExpectedSecret:      "config-secret",
+			ExpectedEnvironment: azure.PublicCloud.Name,
+			ExpectedVaultName:   "vault-env-vault",
+			ExpectedKeyName:     "vault-env-key",
+		},
+		{
+			Title: "Env",
+			Env: map[string]string{
+				"AZURE_TENANT_ID":                "env-tenant",
+				"AZURE_CLIENT_ID":                "env-client",
+				"AZURE_CLIENT_SECRET":            "env-secret",
+				"AZURE_ENVIRONMENT":              "AzureUSGovernmentCloud",
+				EnvAzureKeyVaultWrapperVaultName: "env-vault",
+				EnvVaultAzureKeyVaultVaultName:   "vault-env-vault",
+				EnvAzureKeyVaultWrapperKeyName:   "env-key",
+				EnvVaultAzureKeyVaultKeyName:     "vault-env-key",
+			},
+			Config:              config,
+			ExpectedTenantID:    "env-tenant",
+			ExpectedClientID:    "env-client",
+			ExpectedSecret:      "env-secret",
+			ExpectedEnvironment: azure.USGovernmentCloud.Name,
+			ExpectedVaultName:   "env-vault",
+			ExpectedKeyName:     "env-key",
+		},
+		{
+			// With no client credentials the default factory falls back to
+			// managed service identity
+			Title: "MSI",
+			Config: map[string]string{
+				"environment": "AzureGermanCloud",
+				"vault_name":  "config-vault",
+				"key_name":    "config-key",
+			},
+			ExpectedEnvironment: azure.GermanCloud.Name,
+			ExpectedVaultName:   "config-vault",
+			ExpectedKeyName:     "config-key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			defer testenv.Set(t, envNames, tc.Env)()
+
+			factory := &mockClientFactory{}
+			s := NewWrapper(nil)
+			s.factory = factory
+			info, err := s.SetConfig(tc.Config)
+			if err != nil {
+				t.Fatalf("error setting config: %s", err)
+			}
+
+			if factory.tenantID != tc.ExpectedTenantID {
+				t.Fatalf("expected tenant ID %q, got %q", tc.ExpectedTenantID, factory.tenantID)
+			}
+			if factory.clientID != tc.ExpectedClientID {
+				t.Fatalf("expected client ID %q, got %q", tc.ExpectedClientID, factory.clientID)
+			}
+			if factory.clientSecret != tc.ExpectedSecret {
+				t.Fatalf("expected client secret %q, got %q", tc.ExpectedSecret, factory.clientSecret)
+			}
+			if factory.environment.Name != tc.ExpectedEnvironment {
+				t.Fatalf("expected environment %q, got %q", tc.ExpectedEnvironment, factory.environment.Name)
+			}
+			if info["vault_name"] != tc.ExpectedVaultName {
+				t.Fatalf("expected vault name %q, got %q", tc.ExpectedVaultName, info["vault_name"])
+			}
+			if info["key_name"] != tc.ExpectedKeyName {
+				t.Fatalf("expected key name %q, got %q", tc.ExpectedKeyName, info["key_name"])
+			}
+			if s.KeyID() != azureTestKeyVersion {
+				t.Fatalf("expected key ID %q, got %q", azureTestKeyVersion, s.KeyID())
+			}
+		})
+	}
+
+	t.Run("Missing", func(t *testing.T) {
+		defer testenv.Set(t, envNames, nil)()
+
+		s := NewWrapper(nil)
+		s.factory = &mockClientFactory{}
+		if _, err := s.SetConfig(map[string]string{"key_name": "config-key"}); err == nil {
+			t.Fatal("expected error when vault name is not provided")
+		}
+		if _, err := s.SetConfig(map[string]string{"vault_name": "config-vault"}); err == nil {
+			t.Fatal("expected error when key name is not provided")
+		}
+	})
+}
+
+func TestAzureKeyVault_MockLifecycle(t *testing.T) {
+	defer testenv.Set(t, envNames, nil)()
+
+	s := NewWrapper(nil)
+	s.factory = &mockClientFactory{}
+	if _, err := s.SetConfig(map[string]string{
+		"vault_name": "config-vault",
+		"key_name":   "config-key",
+	}); err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	input := []byte("foo")
+	swi, err := s.Encrypt(context.Background(), input, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+	if swi.KeyInfo.KeyID != azureTestKeyVersion {
+		t.Fatalf("expected key ID %q, got %q", azureTestKeyVersion, swi.KeyInfo.KeyID)
+	}
+
+	pt, err := s.Decrypt(context.Background(), swi, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(input, pt) {
+		t.Fatalf("expected %s, got %s", input, pt)
+	}
+}
+
+// mockClientFactory keeps the service principal and environment SetConfig
+// resolved
+type mockClientFactory struct {
+	tenantID     string
+	clientID     string
+	clientSecret string
+	environment  azure.Environment
+}
+
+func (f *mockClientFactory) newClient(tenantID, clientID, clientSecret string, environment azure.Environment) (keyVaultClient, error) {
+	f.tenantID = tenantID
+	f.clientID = clientID
+	f.clientSecret = clientSecret
+	f.environment = environment
+	return &mockClient{}, nil
+}
+
+// mockClient "wraps" keys by passing them through unchanged
+type mockClient struct{}
+
+func (m *mockClient) kid(vaultBaseURL, keyName string) *string {
+	return to.StringPtr(vaultBaseURL + "keys/" + keyName + "/" + azureTestKeyVersion)
+}
+
+func (m *mockClient) GetKey(_ context.Context, vaultBaseURL, keyName, _ string) (keyvault.KeyBundle, error) {
+	return keyvault.KeyBundle{
+		Key: &keyvault.JSONWebKey{Kid: m.kid(vaultBaseURL, keyName)},
+	}, nil
+}
+
+func (m *mockClient) WrapKey(_ context.Context, vaultBaseURL, keyName, _ string, parameters keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error) {
+	// Key Vault returns unpadded base64
+	key, err := base64.URLEncoding.DecodeString(to.String(parameters.Value))
+	if err != nil {
+		return keyvault.KeyOperationResult{}, err
+	}
+	return keyvault.KeyOperationResult{
+		Kid:    m.kid(vaultBaseURL, keyName),
+		Result: to.StringPtr(base64.RawURLEncoding.EncodeToString(key)),
+	}, nil
+}
+
+func (m *mockClient) UnwrapKey(_ context.Context, vaultBaseURL, keyName, _ string, parameters keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error) {
+	return keyvault.KeyOperationResult{
+		Kid:    m.kid(vaultBaseURL, keyName),
+		Result: parameters.Value,
+	}, nil
+}
+
+// envNames are the environment variables SetConfig reads
+var envNames = []string{
+	"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_ENVIRONMENT",
+	EnvAzureKeyVaultWrapperVaultName, EnvVaultAzureKeyVaultVaultName,
+	EnvAzureKeyVaultWrapperKeyName, EnvVaultAzureKeyVaultKeyName,
+}
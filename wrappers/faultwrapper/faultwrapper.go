@@ -0,0 +1,220 @@
+package faultwrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/multiwrapper"
+)
+
+var _ wrapping.Wrapper = (*FaultWrapper)(nil)
+
+// ErrInjectedFault is returned (wrapped) by operations that failed because of
+// an injected error
+var ErrInjectedFault = errors.New("injected fault")
+
+// LatencyFunc returns the latency to add to a single operation. It is given
+// the wrapper's random source so that latency can be drawn from a
+// distribution reproducibly.
+type LatencyFunc func(r *rand.Rand) time.Duration
+
+// FixedLatency returns a LatencyFunc that always adds d
+func FixedLatency(d time.Duration) LatencyFunc {
+	return func(*rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency returns a LatencyFunc that adds a latency uniformly
+// distributed in [min, max)
+func UniformLatency(min, max time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// NormalLatency returns a LatencyFunc that adds a normally distributed latency
+// with the given mean and standard deviation; negative values are clamped to
+// zero
+func NormalLatency(mean, stddev time.Duration) LatencyFunc {
+	return func(r *rand.Rand) time.Duration {
+		d := time.Duration(r.NormFloat64()*float64(stddev)) + mean
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// FaultWrapperOptions configures the faults injected by a FaultWrapper. Rates
+// are probabilities in the range [0, 1]; a zero value disables that fault.
+type FaultWrapperOptions struct {
+	// EncryptErrorRate is the probability that Encrypt fails without calling
+	// the underlying wrapper
+	EncryptErrorRate float64
+
+	// DecryptErrorRate is the probability that Decrypt fails without calling
+	// the underlying wrapper
+	DecryptErrorRate float64
+
+	// KeyNotFoundRate is the probability that Decrypt fails with an error
+	// matching multiwrapper.ErrKeyNotFound, simulating a key that is
+	// intermittently unavailable
+	KeyNotFoundRate float64
+
+	// EncryptLatency and DecryptLatency, if set, are added before the
+	// respective operation is performed or fails
+	EncryptLatency LatencyFunc
+	DecryptLatency LatencyFunc
+
+	// Seed seeds the random source used to decide when faults occur. The same
+	// seed yields the same sequence of faults for the same sequence of calls.
+	Seed int64
+}
+
+// FaultWrapper delegates to another Wrapper but injects configurable failures
+// and latency into Encrypt and Decrypt. It is intended for testing how
+// applications behave when their KMS misbehaves.
+type FaultWrapper struct {
+	base wrapping.Wrapper
+
+	l    sync.Mutex
+	opts FaultWrapperOptions
+	rand *rand.Rand
+}
+
+// NewFaultWrapper creates a FaultWrapper around base. It is valid to pass nil
+// opts, in which case no faults are injected until SetOptions is called. This
+// function will panic if base is nil.
+func NewFaultWrapper(base wrapping.Wrapper, opts *FaultWrapperOptions) *FaultWrapper {
+	if base == nil {
+		panic("nil base wrapper")
+	}
+	if opts == nil {
+		opts = new(FaultWrapperOptions)
+	}
+	return &FaultWrapper{
+		base: base,
+		opts: *opts,
+		rand: rand.New(rand.NewSource(opts.Seed)),
+	}
+}
+
+// SetOptions replaces the fault configuration, allowing faults to be turned on
+// or off while the wrapper is in use. The random source is reseeded from the
+// new options.
+func (f *FaultWrapper) SetOptions(opts *FaultWrapperOptions) {
+	if opts == nil {
+		opts = new(FaultWrapperOptions)
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.opts = *opts
+	f.rand = rand.New(rand.NewSource(opts.Seed))
+}
+
+// Type returns the type of the underlying wrapper
+func (f *FaultWrapper) Type() string {
+	return f.base.Type()
+}
+
+// KeyID returns the KeyID of the underlying wrapper
+func (f *FaultWrapper) KeyID() string {
+	return f.base.KeyID()
+}
+
+// HMACKeyID returns the HMACKeyID of the underlying wrapper
+func (f *FaultWrapper) HMACKeyID() string {
+	return f.base.HMACKeyID()
+}
+
+// Init initializes the underlying wrapper
+func (f *FaultWrapper) Init(ctx context.Context) error {
+	return f.base.Init(ctx)
+}
+
+// Finalize finalizes the underlying wrapper
+func (f *FaultWrapper) Finalize(ctx context.Context) error {
+	return f.base.Finalize(ctx)
+}
+
+// Encrypt encrypts using the underlying wrapper unless a fault is injected
+func (f *FaultWrapper) Encrypt(ctx context.Context, pt []byte, aad []byte) (*wrapping.EncryptedBlobInfo, error) {
+	f.l.Lock()
+	latency := f.latency(f.opts.EncryptLatency)
+	fail := f.roll(f.opts.EncryptErrorRate)
+	f.l.Unlock()
+
+	if err := sleep(ctx, latency); err != nil {
+		return nil, err
+	}
+	if fail {
+		return nil, fmt.Errorf("error encrypting data: %w", ErrInjectedFault)
+	}
+	return f.base.Encrypt(ctx, pt, aad)
+}
+
+// Decrypt decrypts using the underlying wrapper unless a fault is injected
+func (f *FaultWrapper) Decrypt(ctx context.Context, ct *wrapping.EncryptedBlobInfo, aad []byte) ([]byte, error) {
+	f.l.Lock()
+	latency := f.latency(f.opts.DecryptLatency)
+	fail := f.roll(f.opts.DecryptErrorRate)
+	keyNotFound := f.roll(f.opts.KeyNotFoundRate)
+	f.l.Unlock()
+
+	if err := sleep(ctx, latency); err != nil {
+		return nil, err
+	}
+	switch {
+	case fail:
+		return nil, fmt.Errorf("error decrypting data: %w", ErrInjectedFault)
+	case keyNotFound:
+		return nil, fmt.Errorf("injected: %w", multiwrapper.ErrKeyNotFound)
+	}
+	return f.base.Decrypt(ctx, ct, aad)
+}
+
+// roll reports whether an event with the given probability happens. The lock
+// must be held.
+func (f *FaultWrapper) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	return f.rand.Float64() < rate
+}
+
+// latency evaluates fn against the random source. The lock must be held.
+func (f *FaultWrapper) latency(fn LatencyFunc) time.Duration {
+	if fn == nil {
+		return 0
+	}
+	return fn(f.rand)
+}
+
+// sleep waits for d, returning early with the context's error if it is done
+// first. A nil context is treated as one that is never done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	if ctx == nil {
+		time.Sleep(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,122 @@
+package faultwrapper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/multiwrapper"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+)
+
+func TestFaultWrapper(t *testing.T) {
+	ctx := context.Background()
+	base := wrapping.NewTestWrapper([]byte("secret"))
+
+	// With no options nothing is injected
+	f := NewFaultWrapper(base, nil)
+	blob, err := f.Encrypt(ctx, []byte("foobar"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := f.Decrypt(ctx, blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "foobar" {
+		t.Fatalf("expected foobar, got %s", pt)
+	}
+	if f.Type() != base.Type() || f.KeyID() != base.KeyID() {
+		t.Fatal("expected type and key ID to be delegated")
+	}
+
+	f.SetOptions(&FaultWrapperOptions{EncryptErrorRate: 1})
+	if _, err := f.Encrypt(ctx, []byte("foobar"), nil); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("expected injected fault, got %v", err)
+	}
+
+	f.SetOptions(&FaultWrapperOptions{DecryptErrorRate: 1})
+	if _, err := f.Decrypt(ctx, blob, nil); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("expected injected fault, got %v", err)
+	}
+
+	f.SetOptions(&FaultWrapperOptions{KeyNotFoundRate: 1})
+	if _, err := f.Decrypt(ctx, blob, nil); !errors.Is(err, multiwrapper.ErrKeyNotFound) {
+		t.Fatalf("expected key not found, got %v", err)
+	}
+}
+
+func TestFaultWrapper_Rates(t *testing.T) {
+	ctx := context.Background()
+	run := func(seed int64) []bool {
+		f := NewFaultWrapper(wrapping.NewTestWrapper(nil), &FaultWrapperOptions{
+			EncryptErrorRate: 0.5,
+			Seed:             seed,
+		})
+		var ret []bool
+		for i := 0; i < 1000; i++ {
+			_, err := f.Encrypt(ctx, []byte("foobar"), nil)
+			ret = append(ret, err != nil)
+		}
+		return ret
+	}
+
+	first, second := run(1), run(1)
+	var failures int
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same seed to give the same faults, differed at %d", i)
+		}
+		if first[i] {
+			failures++
+		}
+	}
+	if failures < 400 || failures > 600 {
+		t.Fatalf("expected about half of calls to fail, got %d failures", failures)
+	}
+}
+
+func TestFaultWrapper_Latency(t *testing.T) {
+	f := NewFaultWrapper(wrapping.NewTestWrapper(nil), &FaultWrapperOptions{
+		EncryptLatency: FixedLatency(50 * time.Millisecond),
+	})
+
+	start := time.Now()
+	if _, err := f.Encrypt(context.Background(), []byte("foobar"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("expected latency to be added")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	f.SetOptions(&FaultWrapperOptions{
+		EncryptLatency: FixedLatency(time.Minute),
+	})
+	if _, err := f.Encrypt(ctx, []byte("foobar"), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	r := NewFaultWrapper(wrapping.NewTestWrapper(nil), nil).rand
+	for i := 0; i < 100; i++ {
+		if d := UniformLatency(time.Millisecond, 2*time.Millisecond)(r); d < time.Millisecond || d >= 2*time.Millisecond {
+			t.Fatalf("uniform latency out of range: %s", d)
+		}
+		if d := NormalLatency(0, time.Millisecond)(r); d < 0 {
+			t.Fatalf("normal latency is negative: %s", d)
+		}
+	}
+}
+
+func TestFaultWrapper_Conformance(t *testing.T) {
+	// With no faults configured the decorator must be transparent
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		return NewFaultWrapper(wrapping.NewTestEnvelopeWrapper([]byte("secret")), nil)
+	}, &wraptest.ConformanceOptions{
+		IgnoresAAD:          true,
+		AcceptsNilPlaintext: true,
+	})
+}
@@ -3,10 +3,13 @@ package gcpckms
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sync"
 	"sync/atomic"
 
 	cloudkms "cloud.google.com/go/kms/apiv1"
+	gax "github.com/googleapis/gax-go/v2"
 	wrapping "github.com/hashicorp/go-kms-wrapping"
 	context "golang.org/x/net/context"
 	"google.golang.org/api/option"
@@ -49,7 +52,41 @@ type Wrapper struct {
 
 	currentKeyID *atomic.Value
 
-	client *cloudkms.KeyManagementClient
+	// l guards client, which Finalize drops while operations may be in
+	// flight
+	l       sync.RWMutex
+	client  kmsClient
+	factory clientFactory
+
+	// encryptUnchecked is set while the client was built without the
+	// permission check SetConfig makes
+	encryptUnchecked bool
+}
+
+// kmsClient is the subset of cloudkms.KeyManagementClient used by the wrapper
+type kmsClient interface {
+	Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error)
+	Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error)
+}
+
+// clientFactory builds the KMS client from the credentials file and user
+// agent resolved by SetConfig
+type clientFactory interface {
+	newClient(credsPath, userAgent string) (kmsClient, error)
+}
+
+type defaultClientFactory struct{}
+
+func (defaultClientFactory) newClient(credsPath, userAgent string) (kmsClient, error) {
+	client, err := cloudkms.NewKeyManagementClient(context.Background(),
+		option.WithCredentialsFile(credsPath),
+		option.WithUserAgent(userAgent),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create KMS client: %w", err)
+	}
+
+	return client, nil
 }
 
 var _ wrapping.Wrapper = (*Wrapper)(nil)
@@ -60,6 +97,7 @@ func NewWrapper(opts *wrapping.WrapperOptions) *Wrapper {
 	}
 	s := &Wrapper{
 		currentKeyID: new(atomic.Value),
+		factory:      defaultClientFactory{},
 	}
 	s.currentKeyID.Store("")
 	return s
@@ -74,6 +112,18 @@ func NewWrapper(opts *wrapping.WrapperOptions) *Wrapper {
 // * `credentials` value from Value configuration file
 // * GOOGLE_APPLICATION_CREDENTIALS (https://developers.google.com/identity/protocols/application-default-credentials)
 func (s *Wrapper) SetConfig(config map[string]string) (map[string]string, error) {
+	return s.setConfig(config, true)
+}
+
+// SetConfigForKeyAdmin configures the wrapper and builds its client like
+// SetConfig, but skips the test encryption SetConfig uses to check
+// permissions. Read-only key administration through KeyManagementClient then
+// needs no permission to encrypt.
+func (s *Wrapper) SetConfigForKeyAdmin(config map[string]string) (map[string]string, error) {
+	return s.setConfig(config, false)
+}
+
+func (s *Wrapper) setConfig(config map[string]string, checkEncrypt bool) (map[string]string, error) {
 	if config == nil {
 		config = map[string]string{}
 	}
@@ -136,20 +186,28 @@ func (s *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 	s.parentName = fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", s.project, s.location, s.keyRing, s.cryptoKey)
 
 	// Set and check s.client
+	s.l.Lock()
 	if s.client == nil {
-		kmsClient, err := s.getClient()
+		kmsClient, err := s.factory.newClient(s.credsPath, s.userAgent)
 		if err != nil {
+			s.l.Unlock()
 			return nil, fmt.Errorf("error initializing GCP CKMS wrapper client: %w", err)
 		}
 		s.client = kmsClient
+		s.encryptUnchecked = true
+	}
+	s.l.Unlock()
 
-		// Make sure user has permissions to encrypt (also checks if key exists)
+	// Make sure user has permissions to encrypt (also checks if key exists)
+	if checkEncrypt && s.encryptUnchecked {
 		ctx := context.Background()
 		if _, err := s.Encrypt(ctx, []byte("vault-gcpckms-test"), nil); err != nil {
+			s.Finalize(ctx)
 			return nil, fmt.Errorf("failed to encrypt with GCP CKMS - ensure the "+
 				"key exists and the service account has at least "+
 				"roles/cloudkms.cryptoKeyEncrypterDecrypter permission: %w", err)
 		}
+		s.encryptUnchecked = false
 	}
 
 	// Map that holds non-sensitive configuration info to return
@@ -167,9 +225,16 @@ func (s *Wrapper) Init(_ context.Context) error {
 	return nil
 }
 
-// Finalize is called during shutdown. This is a no-op since
-// Wrapper doesn't require any cleanup.
+// Finalize is called during shutdown. It closes the KMS client, so that a
+// later SetConfig builds a new one.
 func (s *Wrapper) Finalize(_ context.Context) error {
+	s.l.Lock()
+	c, ok := s.client.(io.Closer)
+	s.client = nil
+	s.l.Unlock()
+	if ok {
+		return c.Close()
+	}
 	return nil
 }
 
@@ -188,6 +253,17 @@ func (s *Wrapper) HMACKeyID() string {
 	return ""
 }
 
+// getClient returns the client built by SetConfig, or an error once Finalize
+// has dropped it
+func (s *Wrapper) getClient() (kmsClient, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if s.client == nil {
+		return nil, errors.New("client is not configured")
+	}
+	return s.client, nil
+}
+
 // Encrypt is used to encrypt the master key using the the AWS CMK.
 // This returns the ciphertext, and/or any errors from this
 // call. This should be called after s.client has been instantiated.
@@ -196,12 +272,17 @@ func (s *Wrapper) Encrypt(ctx context.Context, plaintext, aad []byte) (blob *wra
 		return nil, errors.New("given plaintext for encryption is nil")
 	}
 
+	client, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+
 	env, err := wrapping.NewEnvelope(nil).Encrypt(plaintext, aad)
 	if err != nil {
 		return nil, fmt.Errorf("error wrapping data: %w", err)
 	}
 
-	resp, err := s.client.Encrypt(ctx, &kmspb.EncryptRequest{
+	resp, err := client.Encrypt(ctx, &kmspb.EncryptRequest{
 		Name:      s.parentName,
 		Plaintext: env.Key,
 	})
@@ -228,12 +309,53 @@ func (s *Wrapper) Encrypt(ctx context.Context, plaintext, aad []byte) (blob *wra
 	return ret, nil
 }
 
+// EncryptDirect encrypts plaintext with the crypto key itself rather than a
+// data key, producing a GCPKMSEncrypt blob whose ciphertext is the Cloud KMS
+// ciphertext. Cloud KMS limits plaintext to 64KiB; this is meant for keys and
+// other small values that other KMS clients must be able to decrypt.
+func (s *Wrapper) EncryptDirect(ctx context.Context, plaintext []byte) (*wrapping.EncryptedBlobInfo, error) {
+	if plaintext == nil {
+		return nil, errors.New("given plaintext for encryption is nil")
+	}
+
+	client, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:      s.parentName,
+		Plaintext: plaintext,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.currentKeyID.Store(resp.Name)
+
+	return &wrapping.EncryptedBlobInfo{
+		Ciphertext: resp.Ciphertext,
+		KeyInfo: &wrapping.KeyInfo{
+			Mechanism: GCPKMSEncrypt,
+			KeyID:     resp.Name,
+		},
+	}, nil
+}
+
 // Decrypt is used to decrypt the ciphertext.
 func (s *Wrapper) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, aad []byte) (pt []byte, err error) {
+	if in == nil {
+		return nil, fmt.Errorf("given input for decryption is nil")
+	}
 	if in.Ciphertext == nil {
 		return nil, fmt.Errorf("given ciphertext for decryption is nil")
 	}
 
+	client, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+
 	// Default to mechanism used before key info was stored
 	if in.KeyInfo == nil {
 		in.KeyInfo = &wrapping.KeyInfo{
@@ -244,7 +366,7 @@ func (s *Wrapper) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, a
 	var plaintext []byte
 	switch in.KeyInfo.Mechanism {
 	case GCPKMSEncrypt:
-		resp, err := s.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		resp, err := client.Decrypt(ctx, &kmspb.DecryptRequest{
 			Name:       s.parentName,
 			Ciphertext: in.Ciphertext,
 		})
@@ -255,7 +377,7 @@ func (s *Wrapper) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, a
 		plaintext = resp.Plaintext
 
 	case GCPKMSEnvelopeAESGCMEncrypt:
-		resp, err := s.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		resp, err := client.Decrypt(ctx, &kmspb.DecryptRequest{
 			Name:       s.parentName,
 			Ciphertext: in.KeyInfo.WrappedKey,
 		})
@@ -280,14 +402,20 @@ func (s *Wrapper) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, a
 	return plaintext, nil
 }
 
-func (s *Wrapper) getClient() (*cloudkms.KeyManagementClient, error) {
-	client, err := cloudkms.NewKeyManagementClient(context.Background(),
-		option.WithCredentialsFile(s.credsPath),
-		option.WithUserAgent(s.userAgent),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create KMS client: %w", err)
+// KeyManagementClient returns the KMS client built by SetConfig, for key
+// administration the wrapper does not perform itself. It is closed by
+// Finalize.
+func (s *Wrapper) KeyManagementClient() (*cloudkms.KeyManagementClient, error) {
+	s.l.RLock()
+	client, ok := s.client.(*cloudkms.KeyManagementClient)
+	s.l.RUnlock()
+	if !ok {
+		return nil, errors.New("no Cloud KMS client has been built; SetConfig must be called first")
 	}
-
 	return client, nil
 }
+
+// CryptoKeyName returns the resource name of the configured crypto key
+func (s *Wrapper) CryptoKeyName() string {
+	return s.parentName
+}
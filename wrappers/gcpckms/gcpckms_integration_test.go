@@ -0,0 +1,220 @@
+//go:build integration
+// +build integration
+
+package gcpckms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	cloudkms "cloud.google.com/go/kms/apiv1"
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/testenv"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+	context "golang.org/x/net/context"
+	"google.golang.org/api/option"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// These tests run against an in-process fake of the Cloud KMS gRPC API, as
+// Cloud KMS has no official emulator; see integration/README.md. The wrapper
+// uses a real Cloud KMS client, pointed at the fake without authentication.
+
+// fakeKMS serves Encrypt and Decrypt for the crypto keys it was given. Each
+// version of a key is a random AES-256 key, and the last one is primary;
+// ciphertexts are the version number followed by the AES-GCM nonce and
+// sealed plaintext.
+type fakeKMS struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+
+	l    sync.Mutex
+	keys map[string][]cipher.AEAD
+}
+
+func (f *fakeKMS) addKey(name string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	f.l.Lock()
+	defer f.l.Unlock()
+	f.keys[name] = append(f.keys[name], gcm)
+	return nil
+}
+
+func (f *fakeKMS) versions(name string) ([]cipher.AEAD, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	versions, ok := f.keys[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "CryptoKey %s not found.", name)
+	}
+	return versions, nil
+}
+
+func (f *fakeKMS) Encrypt(_ context.Context, req *kmspb.EncryptRequest) (*kmspb.EncryptResponse, error) {
+	versions, err := f.versions(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	version := uint32(len(versions))
+	out := make([]byte, 4+versions[version-1].NonceSize())
+	binary.BigEndian.PutUint32(out, version)
+	if _, err := rand.Read(out[4:]); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out = versions[version-1].Seal(out, out[4:], req.Plaintext, req.AdditionalAuthenticatedData)
+	return &kmspb.EncryptResponse{
+		Name:       fmt.Sprintf("%s/cryptoKeyVersions/%d", req.Name, version),
+		Ciphertext: out,
+	}, nil
+}
+
+func (f *fakeKMS) Decrypt(_ context.Context, req *kmspb.DecryptRequest) (*kmspb.DecryptResponse, error) {
+	versions, err := f.versions(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Ciphertext) < 4 {
+		return nil, status.Error(codes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	version := binary.BigEndian.Uint32(req.Ciphertext)
+	if version == 0 || int(version) > len(versions) {
+		return nil, status.Error(codes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	gcm := versions[version-1]
+	rest := req.Ciphertext[4:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, status.Error(codes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	pt, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], req.AdditionalAuthenticatedData)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Decryption failed: the ciphertext is invalid.")
+	}
+	return &kmspb.DecryptResponse{Plaintext: pt}, nil
+}
+
+// fakeClientFactory builds real Cloud KMS clients connected to a fakeKMS
+type fakeClientFactory struct {
+	endpoint string
+}
+
+func (f fakeClientFactory) newClient(_, userAgent string) (kmsClient, error) {
+	return cloudkms.NewKeyManagementClient(context.Background(),
+		option.WithEndpoint(f.endpoint),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()),
+		option.WithUserAgent(userAgent),
+	)
+}
+
+// fakeKMSConfig serves a fakeKMS holding one crypto key on a loopback port,
+// and returns the wrapper config for that key, the factory connecting to it
+// and a func stopping the server
+func fakeKMSConfig(t *testing.T) (map[string]string, fakeClientFactory, func()) {
+	t.Helper()
+
+	config := map[string]string{
+		"project":    "test-project",
+		"region":     "global",
+		"key_ring":   "test-ring",
+		"crypto_key": "test-key",
+	}
+	fake := &fakeKMS{keys: make(map[string][]cipher.AEAD)}
+	name := fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", config["project"], config["region"], config["key_ring"], config["crypto_key"])
+	if err := fake.addKey(name); err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	kmspb.RegisterKeyManagementServiceServer(srv, fake)
+	go srv.Serve(lis)
+
+	return config, fakeClientFactory{endpoint: lis.Addr().String()}, srv.Stop
+}
+
+func TestIntegrationGCPCKMSWrapper_Lifecycle(t *testing.T) {
+	defer testenv.Set(t, envNames, nil)()
+	config, factory, stop := fakeKMSConfig(t)
+	defer stop()
+
+	s := NewWrapper(nil)
+	s.factory = factory
+	if _, err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Finalize(context.Background())
+	if !strings.HasSuffix(s.KeyID(), "/cryptoKeyVersions/1") {
+		t.Fatalf("unexpected key ID %q", s.KeyID())
+	}
+	// Key administration shares the client the wrapper built
+	if _, err := s.KeyManagementClient(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := []byte("foo")
+	for _, encrypt := range []func(context.Context, []byte) (*wrapping.EncryptedBlobInfo, error){
+		func(ctx context.Context, pt []byte) (*wrapping.EncryptedBlobInfo, error) {
+			return s.Encrypt(ctx, pt, nil)
+		},
+		s.EncryptDirect,
+	} {
+		swi, err := encrypt(context.Background(), input)
+		if err != nil {
+			t.Fatalf("err: %s", err.Error())
+		}
+		pt, err := s.Decrypt(context.Background(), swi, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err.Error())
+		}
+		if !reflect.DeepEqual(input, pt) {
+			t.Fatalf("expected %s, got %s", input, pt)
+		}
+	}
+
+	// Keys the backend does not hold are rejected by SetConfig
+	other := NewWrapper(nil)
+	other.factory = factory
+	config["crypto_key"] = "missing-key"
+	if _, err := other.SetConfig(config); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected error for a missing key, got %v", err)
+	}
+}
+
+func TestIntegrationGCPCKMSWrapper_Conformance(t *testing.T) {
+	defer testenv.Set(t, envNames, nil)()
+	config, factory, stop := fakeKMSConfig(t)
+	defer stop()
+
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		s := NewWrapper(nil)
+		s.factory = factory
+		if _, err := s.SetConfig(config); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}, nil)
+}
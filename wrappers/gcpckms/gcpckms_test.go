@@ -0,0 +1,271 @@
+package gcpckms
+
+import (
+	"reflect"
+	"testing"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"github.com/hashicorp/go-kms-wrapping/internal/testenv"
+	context "golang.org/x/net/context"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+func TestGCPCKMSSeal_ConfigPrecedence(t *testing.T) {
+	config := map[string]string{
+		"credentials": "/config/creds.json",
+		"project":     "config-project",
+		"region":      "config-region",
+		"key_ring":    "config-ring",
+		"crypto_key":  "config-key",
+		"user_agent":  "config-agent",
+	}
+
+	testCases := []struct {
+		Title              string
+		Env                map[string]string
+		Config             map[string]string
+		ExpectedCredsPath  string
+		ExpectedParentName string
+	}{
+		{
+			Title:              "Config",
+			Config:             config,
+			ExpectedCredsPath:  "/config/creds.json",
+			ExpectedParentName: "projects/config-project/locations/config-region/keyRings/config-ring/cryptoKeys/config-key",
+		},
+		{
+			Title: "Vault-Env",
+			Env: map[string]string{
+				EnvVaultGCPCKMSSealKeyRing:   "vault-env-ring",
+				EnvVaultGCPCKMSSealCryptoKey: "vault-env-key",
+			},
+			Config:             config,
+			ExpectedCredsPath:  "/config/creds.json",
+			ExpectedParentName: "projects/config-project/locations/config-region/keyRings/vault-env-ring/cryptoKeys/vault-env-key",
+		},
+		{
+			Title: "Env",
+			Env: map[string]string{
+				EnvGCPCKMSWrapperCredsPath:   "/env/creds.json",
+				EnvGCPCKMSWrapperProject:     "env-project",
+				EnvGCPCKMSWrapperLocation:    "env-region",
+				EnvGCPCKMSWrapperKeyRing:     "env-ring",
+				EnvVaultGCPCKMSSealKeyRing:   "vault-env-ring",
+				EnvGCPCKMSWrapperCryptoKey:   "env-key",
+				EnvVaultGCPCKMSSealCryptoKey: "vault-env-key",
+			},
+			Config:             config,
+			ExpectedCredsPath:  "/env/creds.json",
+			ExpectedParentName: "projects/env-project/locations/env-region/keyRings/env-ring/cryptoKeys/env-key",
+		},
+		{
+			// Without an explicit credentials file the SDK's application
+			// default credentials are used
+			Title: "Default-Credentials",
+			Config: map[string]string{
+				"project":    "config-project",
+				"region":     "config-region",
+				"key_ring":   "config-ring",
+				"crypto_key": "config-key",
+			},
+			ExpectedParentName: "projects/config-project/locations/config-region/keyRings/config-ring/cryptoKeys/config-key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			defer testenv.Set(t, envNames, tc.Env)()
+
+			factory := &mockClientFactory{}
+			s := NewWrapper(nil)
+			s.factory = factory
+			if _, err := s.SetConfig(tc.Config); err != nil {
+				t.Fatalf("error setting config: %s", err)
+			}
+
+			if factory.credsPath != tc.ExpectedCredsPath {
+				t.Fatalf("expected credentials path %q, got %q", tc.ExpectedCredsPath, factory.credsPath)
+			}
+			if factory.userAgent != tc.Config["user_agent"] {
+				t.Fatalf("expected user agent %q, got %q", tc.Config["user_agent"], factory.userAgent)
+			}
+			if s.parentName != tc.ExpectedParentName {
+				t.Fatalf("expected parent name %q, got %q", tc.ExpectedParentName, s.parentName)
+			}
+			// SetConfig checks permissions with a test encryption
+			if s.KeyID() != tc.ExpectedParentName+"/cryptoKeyVersions/1" {
+				t.Fatalf("unexpected key ID %q", s.KeyID())
+			}
+		})
+	}
+}
+
+func TestGCPCKMSSeal_MockLifecycle(t *testing.T) {
+	defer testenv.Set(t, envNames, nil)()
+
+	s := NewWrapper(nil)
+	s.factory = &mockClientFactory{}
+	if _, err := s.SetConfig(map[string]string{
+		"project":    "config-project",
+		"region":     "config-region",
+		"key_ring":   "config-ring",
+		"crypto_key": "config-key",
+	}); err != nil {
+		t.Fatalf("error setting seal config: %v", err)
+	}
+
+	input := []byte("foo")
+	swi, err := s.Encrypt(context.Background(), input, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	pt, err := s.Decrypt(context.Background(), swi, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(input, pt) {
+		t.Fatalf("expected %s, got %s", input, pt)
+	}
+}
+
+func TestGCPCKMSSeal_KeyAdmin(t *testing.T) {
+	defer testenv.Set(t, envNames, nil)()
+	config := map[string]string{
+		"project":    "config-project",
+		"region":     "config-region",
+		"key_ring":   "config-ring",
+		"crypto_key": "config-key",
+	}
+
+	// SetConfig checks that it can encrypt; key administration does not
+	for _, tc := range []struct {
+		Title    string
+		Admin    bool
+		Encrypts int
+	}{
+		{"SetConfig", false, 1},
+		{"SetConfigForKeyAdmin", true, 0},
+	} {
+		t.Run(tc.Title, func(t *testing.T) {
+			factory := &mockClientFactory{}
+			s := NewWrapper(nil)
+			s.factory = factory
+			setConfig := s.SetConfig
+			if tc.Admin {
+				setConfig = s.SetConfigForKeyAdmin
+			}
+			if _, err := setConfig(config); err != nil {
+				t.Fatalf("error setting config: %s", err)
+			}
+			if factory.client.encrypts != tc.Encrypts {
+				t.Fatalf("expected %d encryptions, got %d", tc.Encrypts, factory.client.encrypts)
+			}
+			if s.CryptoKeyName() != "projects/config-project/locations/config-region/keyRings/config-ring/cryptoKeys/config-key" {
+				t.Fatalf("unexpected crypto key name %q", s.CryptoKeyName())
+			}
+
+			// The mock is not a Cloud KMS client to administer keys with
+			if _, err := s.KeyManagementClient(); err == nil {
+				t.Fatal("expected error without a Cloud KMS client")
+			}
+			if err := s.Finalize(context.Background()); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !factory.client.closed {
+				t.Fatal("expected Finalize to close the client")
+			}
+		})
+	}
+
+	// A later SetConfig makes the check key administration skipped
+	factory := &mockClientFactory{}
+	s := NewWrapper(nil)
+	s.factory = factory
+	if _, err := s.SetConfigForKeyAdmin(config); err != nil {
+		t.Fatalf("error setting config: %s", err)
+	}
+	client := factory.client
+	if _, err := s.SetConfig(config); err != nil {
+		t.Fatalf("error setting config: %s", err)
+	}
+	if factory.client != client || client.encrypts != 1 {
+		t.Fatalf("expected the client to be kept and checked once, got %d encryptions", client.encrypts)
+	}
+
+	// Operations after Finalize fail rather than use the closed client
+	ctx := context.Background()
+	blob, err := s.Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.Finalize(ctx); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := s.Encrypt(ctx, []byte("foo"), nil); err == nil {
+		t.Fatal("expected an error encrypting after Finalize")
+	}
+	if _, err := s.EncryptDirect(ctx, []byte("foo")); err == nil {
+		t.Fatal("expected an error encrypting directly after Finalize")
+	}
+	if _, err := s.Decrypt(ctx, blob, nil); err == nil {
+		t.Fatal("expected an error decrypting after Finalize")
+	}
+
+	// and a later SetConfig builds a new client rather than the closed one
+	if _, err := s.SetConfig(config); err != nil {
+		t.Fatalf("error setting config: %s", err)
+	}
+	if factory.client == client || factory.client.encrypts != 1 {
+		t.Fatal("expected a new, checked client after Finalize")
+	}
+}
+
+// mockClientFactory keeps the credentials path and user agent SetConfig
+// resolved, and the last client it built
+type mockClientFactory struct {
+	credsPath string
+	userAgent string
+	client    *mockClient
+}
+
+func (f *mockClientFactory) newClient(credsPath, userAgent string) (kmsClient, error) {
+	f.credsPath = credsPath
+	f.userAgent = userAgent
+	f.client = &mockClient{}
+	return f.client, nil
+}
+
+// mockClient "encrypts" by passing data through unchanged, and counts the
+// calls made to encrypt
+type mockClient struct {
+	encrypts int
+	closed   bool
+}
+
+func (m *mockClient) Encrypt(_ context.Context, req *kmspb.EncryptRequest, _ ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+	m.encrypts++
+	return &kmspb.EncryptResponse{
+		Name:       req.Name + "/cryptoKeyVersions/1",
+		Ciphertext: req.Plaintext,
+	}, nil
+}
+
+func (m *mockClient) Close() error {
+	m.closed = true
+	return nil
+}
+
+func (m *mockClient) Decrypt(_ context.Context, req *kmspb.DecryptRequest, _ ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+	return &kmspb.DecryptResponse{
+		Plaintext: req.Ciphertext,
+	}, nil
+}
+
+// envNames are the environment variables SetConfig reads
+var envNames = []string{
+	EnvGCPCKMSWrapperCredsPath, EnvGCPCKMSWrapperProject, EnvGCPCKMSWrapperLocation,
+	EnvGCPCKMSWrapperKeyRing, EnvVaultGCPCKMSSealKeyRing,
+	EnvGCPCKMSWrapperCryptoKey, EnvVaultGCPCKMSSealCryptoKey,
+}
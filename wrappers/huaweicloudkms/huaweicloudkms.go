@@ -23,6 +23,7 @@ const (
 // Wrapper is a Wrapper that uses HuaweiCloud's KMS
 type Wrapper struct {
 	client       kmsClient
+	factory      clientFactory
 	keyID        string
 	currentKeyID *atomic.Value
 }
@@ -37,6 +38,7 @@ func NewWrapper(opts *wrapping.WrapperOptions) *Wrapper {
 	}
 	k := &Wrapper{
 		currentKeyID: new(atomic.Value),
+		factory:      defaultClientFactory{},
 	}
 	k.currentKeyID.Store("")
 	return k
@@ -65,7 +67,11 @@ func (k *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 	k.keyID = keyID
 
 	if k.client == nil {
-		client, err := buildKMSClient(config)
+		option, err := buildAuthOptions(config)
+		if err != nil {
+			return nil, err
+		}
+		client, err := k.factory.newClient(option)
 		if err != nil {
 			return nil, err
 		}
@@ -156,6 +162,9 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 	if in == nil {
 		return nil, fmt.Errorf("given input for decryption is nil")
 	}
+	if in.KeyInfo == nil {
+		return nil, fmt.Errorf("key info is nil")
+	}
 
 	// KeyID is not passed to this call because HuaweiCloud handles this
 	// internally based on the metadata stored with the encrypted data
@@ -191,29 +200,29 @@ func getConfig(name string, values ...string) (string, error) {
 	return "", fmt.Errorf("'%s' not found for HuaweiCloud KMS wrapper configuration", name)
 }
 
-func buildKMSClient(config map[string]string) (kmsClient, error) {
+func buildAuthOptions(config map[string]string) (golangsdk.AKSKAuthOptions, error) {
 	// Check and set region.
 	region, err := getConfig("region", os.Getenv("HUAWEICLOUD_REGION"), config["region"])
 	if err != nil {
-		return nil, err
+		return golangsdk.AKSKAuthOptions{}, err
 	}
 
 	// Check and set project.
 	project, err := getConfig("project", os.Getenv("HUAWEICLOUD_PROJECT"), config["project"])
 	if err != nil {
-		return nil, err
+		return golangsdk.AKSKAuthOptions{}, err
 	}
 
 	// Check and set access key.
 	accessKey, err := getConfig("access_key", os.Getenv("HUAWEICLOUD_ACCESS_KEY"), config["access_key"])
 	if err != nil {
-		return nil, err
+		return golangsdk.AKSKAuthOptions{}, err
 	}
 
 	// Check and set project.
 	secretKey, err := getConfig("secret_key", os.Getenv("HUAWEICLOUD_SECRET_KEY"), config["secret_key"])
 	if err != nil {
-		return nil, err
+		return golangsdk.AKSKAuthOptions{}, err
 	}
 
 	// Check and set endpoint.
@@ -223,20 +232,30 @@ func buildKMSClient(config map[string]string) (kmsClient, error) {
 		config["identity_endpoint"],
 		"https://iam.myhwclouds.com:443/v3")
 
-	option := golangsdk.AKSKAuthOptions{
+	return golangsdk.AKSKAuthOptions{
 		Region:           region,
 		ProjectName:      project,
 		AccessKey:        accessKey,
 		SecretKey:        secretKey,
 		IdentityEndpoint: endpoint,
-	}
+	}, nil
+}
+
+// clientFactory builds the KMS client from the authentication options
+// resolved by SetConfig
+type clientFactory interface {
+	newClient(option golangsdk.AKSKAuthOptions) (kmsClient, error)
+}
+
+type defaultClientFactory struct{}
 
+func (defaultClientFactory) newClient(option golangsdk.AKSKAuthOptions) (kmsClient, error) {
 	client, err := buildServiceClient(option)
 	if err != nil {
 		return nil, err
 	}
 
-	return &kmsClientImpl{region: region, project: project, client: client}, nil
+	return &kmsClientImpl{region: option.Region, project: option.ProjectName, client: client}, nil
 }
 
 func buildServiceClient(option golangsdk.AKSKAuthOptions) (*golangsdk.ServiceClient, error) {
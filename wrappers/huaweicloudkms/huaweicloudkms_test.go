@@ -7,6 +7,10 @@ import (
 	"reflect"
 	"testing"
 
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/testenv"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+	"github.com/huaweicloud/golangsdk"
 	kmsKeys "github.com/huaweicloud/golangsdk/openstack/kms/v1/keys"
 )
 
@@ -67,6 +71,123 @@ func TestHuaweiCloudKMSWrapper_Lifecycle(t *testing.T) {
 	}
 }
 
+func TestHuaweiCloudKMSWrapper_Conformance(t *testing.T) {
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		s := NewWrapper(nil)
+		s.client = &mockHuaweiCloudKMSWrapperClient{}
+		if _, err := s.SetConfig(map[string]string{"kms_key_id": huaweiCloudTestKeyID}); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}, nil)
+}
+
+func TestHuaweiCloudKMSWrapper_ConfigPrecedence(t *testing.T) {
+	config := map[string]string{
+		"kms_key_id": "config-key",
+		"region":     "config-region",
+		"project":    "config-project",
+		"access_key": "config-access",
+		"secret_key": "config-secret",
+	}
+
+	testCases := []struct {
+		Title         string
+		Env           map[string]string
+		Config        map[string]string
+		ExpectedKeyID string
+		Expected      golangsdk.AKSKAuthOptions
+	}{
+		{
+			Title:         "Config",
+			Config:        config,
+			ExpectedKeyID: "config-key",
+			Expected: golangsdk.AKSKAuthOptions{
+				Region:           "config-region",
+				ProjectName:      "config-project",
+				AccessKey:        "config-access",
+				SecretKey:        "config-secret",
+				IdentityEndpoint: "https://iam.myhwclouds.com:443/v3",
+			},
+		},
+		{
+			Title: "Env",
+			Env: map[string]string{
+				EnvHuaweiCloudKMSWrapperKeyID:   "env-key",
+				"HUAWEICLOUD_REGION":            "env-region",
+				"HUAWEICLOUD_PROJECT":           "env-project",
+				"HUAWEICLOUD_ACCESS_KEY":        "env-access",
+				"HUAWEICLOUD_SECRET_KEY":        "env-secret",
+				"HUAWEICLOUD_IDENTITY_ENDPOINT": "https://env.endpoint/v3",
+			},
+			Config:        config,
+			ExpectedKeyID: "env-key",
+			Expected: golangsdk.AKSKAuthOptions{
+				Region:           "env-region",
+				ProjectName:      "env-project",
+				AccessKey:        "env-access",
+				SecretKey:        "env-secret",
+				IdentityEndpoint: "https://env.endpoint/v3",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			defer testenv.Set(t, envNames, tc.Env)()
+
+			factory := &mockClientFactory{}
+			s := NewWrapper(nil)
+			s.factory = factory
+			if _, err := s.SetConfig(tc.Config); err != nil {
+				t.Fatalf("error setting config: %s", err)
+			}
+
+			if s.keyID != tc.ExpectedKeyID {
+				t.Fatalf("expected key ID %q, got %q", tc.ExpectedKeyID, s.keyID)
+			}
+			if !reflect.DeepEqual(factory.option, tc.Expected) {
+				t.Fatalf("expected %#v, got %#v", tc.Expected, factory.option)
+			}
+		})
+	}
+
+	// Each credential is required
+	for _, name := range []string{"region", "project", "access_key", "secret_key"} {
+		t.Run("Missing-"+name, func(t *testing.T) {
+			defer testenv.Set(t, envNames, nil)()
+
+			partial := make(map[string]string, len(config))
+			for k, v := range config {
+				if k != name {
+					partial[k] = v
+				}
+			}
+			s := NewWrapper(nil)
+			s.factory = &mockClientFactory{}
+			if _, err := s.SetConfig(partial); err == nil {
+				t.Fatalf("expected error when %s is not provided", name)
+			}
+		})
+	}
+}
+
+// mockClientFactory keeps the auth options SetConfig resolved
+type mockClientFactory struct {
+	option golangsdk.AKSKAuthOptions
+}
+
+func (f *mockClientFactory) newClient(option golangsdk.AKSKAuthOptions) (kmsClient, error) {
+	f.option = option
+	return &mockHuaweiCloudKMSWrapperClient{}, nil
+}
+
+// envNames are the environment variables SetConfig reads
+var envNames = []string{
+	EnvHuaweiCloudKMSWrapperKeyID, "HUAWEICLOUD_REGION", "HUAWEICLOUD_PROJECT",
+	"HUAWEICLOUD_ACCESS_KEY", "HUAWEICLOUD_SECRET_KEY", "HUAWEICLOUD_IDENTITY_ENDPOINT",
+}
+
 type mockHuaweiCloudKMSWrapperClient struct {
 }
 
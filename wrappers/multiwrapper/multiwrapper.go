@@ -133,6 +133,10 @@ func (m *MultiWrapper) Encrypt(ctx context.Context, pt []byte, aad []byte) (*wra
 // decryption with the current encryptor. It will return an ErrKeyNotFound if
 // it cannot find a suitable key.
 func (m *MultiWrapper) Decrypt(ctx context.Context, ct *wrapping.EncryptedBlobInfo, aad []byte) ([]byte, error) {
+	if ct == nil {
+		return nil, errors.New("given input for decryption is nil")
+	}
+
 	// First check the encryptor
 	enc := m.encryptor()
 	if ct.KeyInfo == nil || ct.KeyInfo.KeyID == enc.KeyID() {
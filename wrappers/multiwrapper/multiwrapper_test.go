@@ -6,6 +6,7 @@ import (
 
 	wrapping "github.com/hashicorp/go-kms-wrapping"
 	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
 )
 
 func TestMultiWrapper(t *testing.T) {
@@ -131,3 +132,25 @@ func TestMultiWrapper(t *testing.T) {
 		}
 	}
 }
+
+func TestMultiWrapper_Conformance(t *testing.T) {
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		newAEAD := func(keyID string) wrapping.Wrapper {
+			key := make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				t.Fatal(err)
+			}
+			w := aead.NewWrapper(nil)
+			w.SetConfig(map[string]string{"key_id": keyID})
+			if err := w.SetAESGCMKeyBytes(key); err != nil {
+				t.Fatal(err)
+			}
+			return w
+		}
+		multi := NewMultiWrapper(newAEAD("w1"))
+		if !multi.AddWrapper(newAEAD("w2")) {
+			t.Fatal("failed to add wrapper")
+		}
+		return multi
+	}, nil)
+}
@@ -0,0 +1,39 @@
+package multiwrapper
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wrappers/aead"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+)
+
+func TestMultiWrapper_Rotation(t *testing.T) {
+	newAEAD := func(keyID string) wrapping.Wrapper {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		w := aead.NewWrapper(nil)
+		w.SetConfig(map[string]string{"key_id": keyID})
+		if err := w.SetAESGCMKeyBytes(key); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	multi := NewMultiWrapper(newAEAD("key-0"))
+
+	// Old encryptors stay registered for decryption, so blobs encrypted just
+	// before a rotation must still decrypt through the MultiWrapper
+	wraptest.RunRotationTests(t, multi, &wraptest.RotationOptions{
+		Rotate: func(i int) error {
+			if !multi.SetEncryptingWrapper(newAEAD(fmt.Sprintf("key-%d", i+1))) {
+				return fmt.Errorf("failed to set encrypting wrapper %d", i+1)
+			}
+			return nil
+		},
+	})
+}
@@ -47,12 +47,43 @@ type Wrapper struct {
 	cryptoEndpoint     string // OCI KMS crypto endpoint
 	managementEndpoint string // OCI KMS management endpoint
 
-	cryptoClient     *keymanagement.KmsCryptoClient     // OCI KMS crypto client
-	managementClient *keymanagement.KmsManagementClient // OCI KMS management client
+	cryptoClient     kmsCryptoClient     // OCI KMS crypto client
+	managementClient kmsManagementClient // OCI KMS management client
+	factory          clientFactory       // Builds the clients above
 
 	currentKeyID *atomic.Value // Current key version which is used for encryption/decryption
 }
 
+// kmsCryptoClient is the subset of keymanagement.KmsCryptoClient used by the
+// wrapper
+type kmsCryptoClient interface {
+	Encrypt(ctx context.Context, request keymanagement.EncryptRequest) (keymanagement.EncryptResponse, error)
+	Decrypt(ctx context.Context, request keymanagement.DecryptRequest) (keymanagement.DecryptResponse, error)
+}
+
+// kmsManagementClient is the subset of keymanagement.KmsManagementClient used
+// by the wrapper
+type kmsManagementClient interface {
+	GetKey(ctx context.Context, request keymanagement.GetKeyRequest) (keymanagement.GetKeyResponse, error)
+}
+
+// clientFactory builds the OCI KMS clients for the principal type and
+// endpoints resolved by SetConfig
+type clientFactory interface {
+	newCryptoClient(authTypeAPIKey bool, endpoint string) (kmsCryptoClient, error)
+	newManagementClient(authTypeAPIKey bool, endpoint string) (kmsManagementClient, error)
+}
+
+type defaultClientFactory struct{}
+
+func (defaultClientFactory) newCryptoClient(authTypeAPIKey bool, endpoint string) (kmsCryptoClient, error) {
+	return getOCIKMSCryptoClient(authTypeAPIKey, endpoint)
+}
+
+func (defaultClientFactory) newManagementClient(authTypeAPIKey bool, endpoint string) (kmsManagementClient, error) {
+	return getOCIKMSManagementClient(authTypeAPIKey, endpoint)
+}
+
 var _ wrapping.Wrapper = (*Wrapper)(nil)
 
 // NewWrapper creates a new Wrapper seal with the provided logger
@@ -62,6 +93,7 @@ func NewWrapper(opts *wrapping.WrapperOptions) *Wrapper {
 	}
 	k := &Wrapper{
 		currentKeyID: new(atomic.Value),
+		factory:      defaultClientFactory{},
 	}
 	k.currentKeyID.Store("")
 	return k
@@ -120,7 +152,7 @@ func (k *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 
 	// Check and set OCI KMS crypto client
 	if k.cryptoClient == nil {
-		kmsCryptoClient, err := k.getOCIKMSCryptoClient()
+		kmsCryptoClient, err := k.factory.newCryptoClient(k.authTypeAPIKey, k.cryptoEndpoint)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing OCI KMS client: %w", err)
 		}
@@ -129,7 +161,7 @@ func (k *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 
 	// Check and set OCI KMS management client
 	if k.managementClient == nil {
-		kmsManagementClient, err := k.getOCIKMSManagementClient()
+		kmsManagementClient, err := k.factory.newManagementClient(k.authTypeAPIKey, k.managementEndpoint)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing OCI KMS client: %w", err)
 		}
@@ -235,6 +267,9 @@ func (k *Wrapper) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, a
 	if in == nil {
 		return nil, fmt.Errorf("given input for decryption is nil")
 	}
+	if in.KeyInfo == nil {
+		return nil, fmt.Errorf("key info is nil")
+	}
 
 	requestMetadata := k.getRequestMetadata()
 	cipherTextBlob := string(in.KeyInfo.WrappedKey)
@@ -268,10 +303,10 @@ func (k *Wrapper) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, a
 	return plaintext, nil
 }
 
-func (k *Wrapper) getConfigProvider() (common.ConfigurationProvider, error) {
+func getConfigProvider(authTypeAPIKey bool) (common.ConfigurationProvider, error) {
 	var cp common.ConfigurationProvider
 	var err error
-	if k.authTypeAPIKey {
+	if authTypeAPIKey {
 		cp = common.DefaultConfigProvider()
 	} else {
 		cp, err = auth.InstancePrincipalConfigurationProvider()
@@ -283,14 +318,14 @@ func (k *Wrapper) getConfigProvider() (common.ConfigurationProvider, error) {
 }
 
 // Build OCI KMS crypto client
-func (k *Wrapper) getOCIKMSCryptoClient() (*keymanagement.KmsCryptoClient, error) {
-	cp, err := k.getConfigProvider()
+func getOCIKMSCryptoClient(authTypeAPIKey bool, cryptoEndpoint string) (*keymanagement.KmsCryptoClient, error) {
+	cp, err := getConfigProvider(authTypeAPIKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed creating configuration provider: %w", err)
 	}
 
 	// Build crypto client
-	kmsCryptoClient, err := keymanagement.NewKmsCryptoClientWithConfigurationProvider(cp, k.cryptoEndpoint)
+	kmsCryptoClient, err := keymanagement.NewKmsCryptoClientWithConfigurationProvider(cp, cryptoEndpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed creating NewKmsCryptoClientWithConfigurationProvider: %w", err)
 	}
@@ -299,14 +334,14 @@ func (k *Wrapper) getOCIKMSCryptoClient() (*keymanagement.KmsCryptoClient, error
 }
 
 // Build OCI KMS management client
-func (k *Wrapper) getOCIKMSManagementClient() (*keymanagement.KmsManagementClient, error) {
-	cp, err := k.getConfigProvider()
+func getOCIKMSManagementClient(authTypeAPIKey bool, managementEndpoint string) (*keymanagement.KmsManagementClient, error) {
+	cp, err := getConfigProvider(authTypeAPIKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed creating configuration provider: %w", err)
 	}
 
 	// Build crypto client
-	kmsManagementClient, err := keymanagement.NewKmsManagementClientWithConfigurationProvider(cp, k.managementEndpoint)
+	kmsManagementClient, err := keymanagement.NewKmsManagementClientWithConfigurationProvider(cp, managementEndpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed creating NewKmsCryptoClientWithConfigurationProvider: %w", err)
 	}
@@ -6,9 +6,13 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/hashicorp/go-kms-wrapping/internal/testenv"
+	"github.com/oracle/oci-go-sdk/keymanagement"
 	"golang.org/x/net/context"
 )
 
+const ociTestKeyVersion = "ocid1.keyversion.test"
+
 /*
 * To run these tests, ensure you setup:
 * 1. OCI SDK with your credentials. Refer to here:
@@ -64,3 +68,192 @@ func initSeal(t *testing.T) *Wrapper {
 
 	return s
 }
+
+func TestWrapper_ConfigPrecedence(t *testing.T) {
+	config := map[string]string{
+		KMSConfigKeyID:              "config-key",
+		KMSConfigCryptoEndpoint:     "https://config.crypto",
+		KMSConfigManagementEndpoint: "https://config.management",
+	}
+
+	testCases := []struct {
+		Title                      string
+		Env                        map[string]string
+		Config                     map[string]string
+		ExpectedKeyID              string
+		ExpectedCryptoEndpoint     string
+		ExpectedManagementEndpoint string
+		ExpectedAuthTypeAPIKey     bool
+	}{
+		{
+			Title:                      "Config",
+			Config:                     config,
+			ExpectedKeyID:              "config-key",
+			ExpectedCryptoEndpoint:     "https://config.crypto",
+			ExpectedManagementEndpoint: "https://config.management",
+		},
+		{
+			Title: "User-Principal",
+			Config: map[string]string{
+				KMSConfigKeyID:              "config-key",
+				KMSConfigCryptoEndpoint:     "https://config.crypto",
+				KMSConfigManagementEndpoint: "https://config.management",
+				KMSConfigAuthTypeAPIKey:     "true",
+			},
+			ExpectedKeyID:              "config-key",
+			ExpectedCryptoEndpoint:     "https://config.crypto",
+			ExpectedManagementEndpoint: "https://config.management",
+			ExpectedAuthTypeAPIKey:     true,
+		},
+		{
+			Title: "Vault-Env",
+			Env: map[string]string{
+				EnvVaultOCIKMSSealKeyID:              "vault-env-key",
+				EnvVaultOCIKMSSealCryptoEndpoint:     "https://vault-env.crypto",
+				EnvVaultOCIKMSSealManagementEndpoint: "https://vault-env.management",
+			},
+			Config:                     config,
+			ExpectedKeyID:              "vault-env-key",
+			ExpectedCryptoEndpoint:     "https://vault-env.crypto",
+			ExpectedManagementEndpoint: "https://vault-env.management",
+		},
+		{
+			Title: "Env",
+			Env: map[string]string{
+				EnvOCIKMSWrapperKeyID:                "env-key",
+				EnvVaultOCIKMSSealKeyID:              "vault-env-key",
+				EnvOCIKMSWrapperCryptoEndpoint:       "https://env.crypto",
+				EnvVaultOCIKMSSealCryptoEndpoint:     "https://vault-env.crypto",
+				EnvOCIKMSWrapperManagementEndpoint:   "https://env.management",
+				EnvVaultOCIKMSSealManagementEndpoint: "https://vault-env.management",
+			},
+			Config:                     config,
+			ExpectedKeyID:              "env-key",
+			ExpectedCryptoEndpoint:     "https://env.crypto",
+			ExpectedManagementEndpoint: "https://env.management",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			defer testenv.Set(t, envNames, tc.Env)()
+
+			factory := &mockClientFactory{}
+			s := NewWrapper(nil)
+			s.factory = factory
+			if _, err := s.SetConfig(tc.Config); err != nil {
+				t.Fatalf("error setting seal config: %v", err)
+			}
+
+			if s.keyID != tc.ExpectedKeyID {
+				t.Fatalf("expected key ID %q, got %q", tc.ExpectedKeyID, s.keyID)
+			}
+			if factory.cryptoEndpoint != tc.ExpectedCryptoEndpoint {
+				t.Fatalf("expected crypto endpoint %q, got %q", tc.ExpectedCryptoEndpoint, factory.cryptoEndpoint)
+			}
+			if factory.managementEndpoint != tc.ExpectedManagementEndpoint {
+				t.Fatalf("expected management endpoint %q, got %q", tc.ExpectedManagementEndpoint, factory.managementEndpoint)
+			}
+			if factory.authTypeAPIKey != tc.ExpectedAuthTypeAPIKey {
+				t.Fatalf("expected API key auth %t, got %t", tc.ExpectedAuthTypeAPIKey, factory.authTypeAPIKey)
+			}
+			if s.KeyID() != ociTestKeyVersion {
+				t.Fatalf("expected key version %q, got %q", ociTestKeyVersion, s.KeyID())
+			}
+		})
+	}
+
+	t.Run("Invalid-Auth-Type", func(t *testing.T) {
+		defer testenv.Set(t, envNames, nil)()
+
+		s := NewWrapper(nil)
+		s.factory = &mockClientFactory{}
+		if _, err := s.SetConfig(map[string]string{
+			KMSConfigKeyID:              "config-key",
+			KMSConfigCryptoEndpoint:     "https://config.crypto",
+			KMSConfigManagementEndpoint: "https://config.management",
+			KMSConfigAuthTypeAPIKey:     "maybe",
+		}); err == nil {
+			t.Fatal("expected error parsing auth_type_api_key")
+		}
+	})
+}
+
+func TestWrapper_MockLifeCycle(t *testing.T) {
+	defer testenv.Set(t, envNames, nil)()
+
+	s := NewWrapper(nil)
+	s.factory = &mockClientFactory{}
+	if _, err := s.SetConfig(map[string]string{
+		KMSConfigKeyID:              "config-key",
+		KMSConfigCryptoEndpoint:     "https://config.crypto",
+		KMSConfigManagementEndpoint: "https://config.management",
+	}); err != nil {
+		t.Fatalf("error setting seal config: %v", err)
+	}
+
+	input := []byte("foo")
+	swi, err := s.Encrypt(context.Background(), input, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	pt, err := s.Decrypt(context.Background(), swi, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(input, pt) {
+		t.Fatalf("expected %s, got %s", input, pt)
+	}
+}
+
+// mockClientFactory keeps the auth type and endpoints SetConfig resolved
+type mockClientFactory struct {
+	authTypeAPIKey     bool
+	cryptoEndpoint     string
+	managementEndpoint string
+}
+
+func (f *mockClientFactory) newCryptoClient(authTypeAPIKey bool, endpoint string) (kmsCryptoClient, error) {
+	f.authTypeAPIKey = authTypeAPIKey
+	f.cryptoEndpoint = endpoint
+	return &mockCryptoClient{}, nil
+}
+
+func (f *mockClientFactory) newManagementClient(authTypeAPIKey bool, endpoint string) (kmsManagementClient, error) {
+	f.authTypeAPIKey = authTypeAPIKey
+	f.managementEndpoint = endpoint
+	return &mockManagementClient{}, nil
+}
+
+// mockCryptoClient "encrypts" by passing data through unchanged
+type mockCryptoClient struct{}
+
+func (m *mockCryptoClient) Encrypt(_ context.Context, request keymanagement.EncryptRequest) (keymanagement.EncryptResponse, error) {
+	return keymanagement.EncryptResponse{
+		EncryptedData: keymanagement.EncryptedData{Ciphertext: request.EncryptDataDetails.Plaintext},
+	}, nil
+}
+
+func (m *mockCryptoClient) Decrypt(_ context.Context, request keymanagement.DecryptRequest) (keymanagement.DecryptResponse, error) {
+	return keymanagement.DecryptResponse{
+		DecryptedData: keymanagement.DecryptedData{Plaintext: request.DecryptDataDetails.Ciphertext},
+	}, nil
+}
+
+type mockManagementClient struct{}
+
+func (m *mockManagementClient) GetKey(_ context.Context, request keymanagement.GetKeyRequest) (keymanagement.GetKeyResponse, error) {
+	version := ociTestKeyVersion
+	return keymanagement.GetKeyResponse{
+		Key: keymanagement.Key{Id: request.KeyId, CurrentKeyVersion: &version},
+	}, nil
+}
+
+// envNames are the environment variables SetConfig reads
+var envNames = []string{
+	EnvOCIKMSWrapperKeyID, EnvVaultOCIKMSSealKeyID,
+	EnvOCIKMSWrapperCryptoEndpoint, EnvVaultOCIKMSSealCryptoEndpoint,
+	EnvOCIKMSWrapperManagementEndpoint, EnvVaultOCIKMSSealManagementEndpoint,
+}
@@ -0,0 +1,372 @@
+// Package recordwrapper provides a decorator that records the results of a
+// real wrapper's Encrypt and Decrypt calls to a fixture file and later replays
+// them, so tests can exercise realistic KMS output without cloud access.
+package recordwrapper
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/format"
+)
+
+var _ wrapping.Wrapper = (*RecordWrapper)(nil)
+
+// ErrNoInteraction is returned (wrapped) in replay mode when the fixture holds
+// no recorded result for a request
+var ErrNoInteraction = errors.New("no recorded interaction")
+
+// ErrRedacted is returned (wrapped) in replay mode by Decrypt when the
+// plaintext was not recorded and no replayed Encrypt call produced the blob
+var ErrRedacted = errors.New("plaintext was not recorded")
+
+// Mode selects whether a RecordWrapper records or replays
+type Mode int
+
+const (
+	// ModeReplay serves every call from the fixture file and never contacts
+	// the underlying wrapper
+	ModeReplay Mode = iota
+	// ModeRecord passes every call to the underlying wrapper and records the
+	// result; the fixture file is written on Finalize or Save
+	ModeRecord
+)
+
+// Interaction is a single recorded call. Requests are identified by an
+// HMAC of their inputs under RecordWrapperOptions.DigestKey rather than the
+// inputs themselves, so that a fixture reveals nothing about the plaintexts
+// passed to Encrypt without the key. The plaintext returned by Decrypt is not
+// written either, unless RecordWrapperOptions.KeepPlaintext is set; when
+// replaying, Decrypt returns the plaintext that a replayed Encrypt call was
+// given for the same blob.
+type Interaction struct {
+	// Method is Encrypt or Decrypt
+	Method string `json:"method"`
+
+	// Request is the hex encoded HMAC-SHA256 of the call's inputs under
+	// RecordWrapperOptions.DigestKey
+	Request string `json:"request"`
+
+	// Blob is the marshaled EncryptedBlobInfo returned by Encrypt
+	Blob []byte `json:"blob,omitempty"`
+
+	// Plaintext is the value returned by Decrypt, if it was kept
+	Plaintext []byte `json:"plaintext,omitempty"`
+
+	// Redacted is set on Decrypt interactions whose plaintext was not kept
+	Redacted bool `json:"redacted,omitempty"`
+
+	// Error is the text of the error returned by the call, if any
+	Error string `json:"error,omitempty"`
+}
+
+// fixture is the on-disk format
+type fixture struct {
+	Type         string         `json:"type"`
+	KeyID        string         `json:"key_id"`
+	HMACKeyID    string         `json:"hmac_key_id,omitempty"`
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// RecordWrapperOptions configures a RecordWrapper
+type RecordWrapperOptions struct {
+	// Mode selects recording or replaying. Defaults to ModeReplay.
+	Mode Mode
+
+	// Path is the fixture file. It is required.
+	Path string
+
+	// DigestKey keys the digests that identify requests with HMAC-SHA256,
+	// so that the fixture does not reveal the plaintexts passed to Encrypt
+	// even when they are guessable. It is required. The key is never written
+	// to the fixture and should be kept out of the repository the fixture is
+	// committed to; replaying requires the same key.
+	DigestKey []byte
+
+	// KeepPlaintext writes the plaintext returned by Decrypt to the fixture,
+	// so that it replays without a matching Encrypt call. Only set it when
+	// recording test data.
+	KeepPlaintext bool
+
+	// Redact, if set, is called on each interaction before it is written so
+	// that other sensitive values, such as error messages, can be removed or
+	// replaced. An interaction whose response is redacted will replay the
+	// redacted value.
+	Redact func(*Interaction)
+}
+
+// RecordWrapper decorates a wrapping.Wrapper, recording or replaying its
+// Encrypt and Decrypt results. It is safe for concurrent use.
+type RecordWrapper struct {
+	base wrapping.Wrapper
+	opts RecordWrapperOptions
+
+	l        sync.Mutex
+	fixture  *fixture
+	replayed map[string]int
+
+	// plaintexts holds the plaintext given to replayed Encrypt calls, by
+	// the request of the Decrypt call that would return it
+	plaintexts map[string][]byte
+}
+
+// NewRecordWrapper returns a RecordWrapper around base. In replay mode the
+// fixture is read immediately and base may be nil; in record mode base is
+// required.
+func NewRecordWrapper(base wrapping.Wrapper, opts *RecordWrapperOptions) (*RecordWrapper, error) {
+	if opts == nil || opts.Path == "" {
+		return nil, errors.New("fixture path is required")
+	}
+	if len(opts.DigestKey) == 0 {
+		return nil, errors.New("digest key is required")
+	}
+
+	r := &RecordWrapper{
+		base:       base,
+		opts:       *opts,
+		fixture:    new(fixture),
+		replayed:   make(map[string]int),
+		plaintexts: make(map[string][]byte),
+	}
+
+	switch opts.Mode {
+	case ModeRecord:
+		if base == nil {
+			return nil, errors.New("a wrapper to record is required")
+		}
+	case ModeReplay:
+		raw, err := ioutil.ReadFile(opts.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading fixture: %w", err)
+		}
+		if err := json.Unmarshal(raw, r.fixture); err != nil {
+			return nil, fmt.Errorf("error parsing fixture %s: %w", opts.Path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown mode %d", opts.Mode)
+	}
+
+	return r, nil
+}
+
+// Type returns the type of the underlying wrapper, or the recorded type when
+// replaying
+func (r *RecordWrapper) Type() string {
+	if r.opts.Mode == ModeRecord {
+		return r.base.Type()
+	}
+	return r.fixture.Type
+}
+
+// KeyID returns the key ID of the underlying wrapper, or the recorded key ID
+// when replaying
+func (r *RecordWrapper) KeyID() string {
+	if r.opts.Mode == ModeRecord {
+		return r.base.KeyID()
+	}
+	return r.fixture.KeyID
+}
+
+// HMACKeyID returns the HMAC key ID of the underlying wrapper, or the
+// recorded one when replaying
+func (r *RecordWrapper) HMACKeyID() string {
+	if r.opts.Mode == ModeRecord {
+		return r.base.HMACKeyID()
+	}
+	return r.fixture.HMACKeyID
+}
+
+// Init initializes the underlying wrapper when recording
+func (r *RecordWrapper) Init(ctx context.Context) error {
+	if r.opts.Mode == ModeRecord {
+		return r.base.Init(ctx)
+	}
+	return nil
+}
+
+// Finalize writes the fixture and finalizes the underlying wrapper when
+// recording
+func (r *RecordWrapper) Finalize(ctx context.Context) error {
+	if r.opts.Mode != ModeRecord {
+		return nil
+	}
+	if err := r.Save(); err != nil {
+		return err
+	}
+	return r.base.Finalize(ctx)
+}
+
+// Encrypt records or replays a call to the underlying wrapper's Encrypt
+func (r *RecordWrapper) Encrypt(ctx context.Context, plaintext, aad []byte) (*wrapping.EncryptedBlobInfo, error) {
+	req := r.digest("Encrypt", plaintext, aad)
+
+	if r.opts.Mode == ModeRecord {
+		blob, err := r.base.Encrypt(ctx, plaintext, aad)
+		i := &Interaction{Method: "Encrypt", Request: req}
+		if err != nil {
+			i.Error = err.Error()
+		} else if blob != nil {
+			if i.Blob, err = format.Marshal(blob, format.Version0); err != nil {
+				return nil, fmt.Errorf("error recording blob: %w", err)
+			}
+		}
+		r.record(i)
+		return blob, err
+	}
+
+	i, err := r.replay("Encrypt", req)
+	if err != nil {
+		return nil, err
+	}
+	if i.Error != "" {
+		return nil, errors.New(i.Error)
+	}
+	blob, _, err := format.Unmarshal(i.Blob)
+	if err != nil {
+		return nil, fmt.Errorf("error replaying blob: %w", err)
+	}
+	if marshaled, err := format.Marshal(blob, format.Version0); err == nil {
+		r.l.Lock()
+		r.plaintexts[r.digest("Decrypt", marshaled, aad)] = append([]byte{}, plaintext...)
+		r.l.Unlock()
+	}
+	return blob, nil
+}
+
+// Decrypt records or replays a call to the underlying wrapper's Decrypt
+func (r *RecordWrapper) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, aad []byte) ([]byte, error) {
+	var blob []byte
+	if in != nil {
+		var err error
+		blob, err = format.Marshal(in, format.Version0)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling blob: %w", err)
+		}
+	}
+	req := r.digest("Decrypt", blob, aad)
+
+	if r.opts.Mode == ModeRecord {
+		pt, err := r.base.Decrypt(ctx, in, aad)
+		i := &Interaction{Method: "Decrypt", Request: req}
+		switch {
+		case err != nil:
+			i.Error = err.Error()
+		case r.opts.KeepPlaintext:
+			i.Plaintext = pt
+		default:
+			i.Redacted = true
+		}
+		r.record(i)
+		return pt, err
+	}
+
+	i, err := r.replay("Decrypt", req)
+	if err != nil {
+		return nil, err
+	}
+	if i.Error != "" {
+		return nil, errors.New(i.Error)
+	}
+	if i.Redacted {
+		r.l.Lock()
+		pt, ok := r.plaintexts[req]
+		r.l.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("Decrypt: %w", ErrRedacted)
+		}
+		return append([]byte{}, pt...), nil
+	}
+	return i.Plaintext, nil
+}
+
+// Save writes the interactions recorded so far to the fixture file. It is
+// called by Finalize and is a no-op in replay mode.
+func (r *RecordWrapper) Save() error {
+	if r.opts.Mode != ModeRecord {
+		return nil
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.fixture.Type = r.base.Type()
+	r.fixture.KeyID = r.base.KeyID()
+	r.fixture.HMACKeyID = r.base.HMACKeyID()
+
+	raw, err := json.MarshalIndent(r.fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+	if err := os.MkdirAll(filepath.Dir(r.opts.Path), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(r.opts.Path, raw, 0644); err != nil {
+		return fmt.Errorf("error writing fixture %s: %w", r.opts.Path, err)
+	}
+	return nil
+}
+
+func (r *RecordWrapper) record(i *Interaction) {
+	if r.opts.Redact != nil {
+		r.opts.Redact(i)
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.fixture.Interactions = append(r.fixture.Interactions, i)
+}
+
+// replay returns the next recorded interaction matching req. Repeated
+// identical requests are served in the order they were recorded; once those
+// run out the last one is served again.
+func (r *RecordWrapper) replay(method, req string) (*Interaction, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	var matches []*Interaction
+	for _, i := range r.fixture.Interactions {
+		if i.Method == method && i.Request == req {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: %w", method, ErrNoInteraction)
+	}
+
+	n := r.replayed[req]
+	r.replayed[req] = n + 1
+	if n >= len(matches) {
+		n = len(matches) - 1
+	}
+	return matches[n], nil
+}
+
+// digest identifies a request by the HMAC of its method and inputs under the
+// digest key. Each input is length prefixed so that different splits of the
+// same bytes do not collide, and a nil input is distinguished from an empty
+// one.
+func (r *RecordWrapper) digest(method string, inputs ...[]byte) string {
+	h := hmac.New(sha256.New, r.opts.DigestKey)
+	h.Write([]byte(method))
+	for _, in := range inputs {
+		if in == nil {
+			h.Write([]byte{0})
+			continue
+		}
+		h.Write([]byte{1})
+		fmt.Fprintf(h, "%d:", len(in))
+		h.Write(in)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
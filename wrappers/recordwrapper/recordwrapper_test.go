@@ -0,0 +1,341 @@
+package recordwrapper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+)
+
+var testDigestKey = []byte("test digest key")
+
+func TestRecordWrapper(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "recordwrapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	// Record against a real wrapper
+	base := wrapping.NewTestEnvelopeWrapper([]byte("secret"))
+	rec, err := NewRecordWrapper(base, &RecordWrapperOptions{Mode: ModeRecord, Path: path, DigestKey: testDigestKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := rec.Encrypt(ctx, []byte("foo"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := rec.Decrypt(ctx, blob, []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %s", pt)
+	}
+	if _, err := rec.Decrypt(ctx, nil, nil); err == nil {
+		t.Fatal("expected error decrypting nil blob")
+	}
+	if err := rec.Finalize(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Fatal("fixture contains the wrapper's key")
+	}
+
+	// Replay without the real wrapper
+	rep, err := NewRecordWrapper(nil, &RecordWrapperOptions{Path: path, DigestKey: testDigestKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Type() != base.Type() || rep.KeyID() != base.KeyID() {
+		t.Fatalf("expected recorded type and key ID, got %q and %q", rep.Type(), rep.KeyID())
+	}
+	replayed, err := rep.Encrypt(ctx, []byte("foo"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replayed.Ciphertext, blob.Ciphertext) {
+		t.Fatal("expected the recorded ciphertext")
+	}
+	pt, err = rep.Decrypt(ctx, replayed, []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %s", pt)
+	}
+	if _, err := rep.Decrypt(ctx, nil, nil); err == nil {
+		t.Fatal("expected the recorded error")
+	}
+
+	// Requests that were never recorded fail
+	if _, err := rep.Encrypt(ctx, []byte("bar"), nil); !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("expected ErrNoInteraction, got %v", err)
+	}
+	if _, err := rep.Decrypt(ctx, replayed, []byte("other")); !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("expected ErrNoInteraction, got %v", err)
+	}
+}
+
+func TestRecordWrapper_RepeatedRequests(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "recordwrapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	rec, err := NewRecordWrapper(wrapping.NewTestEnvelopeWrapper(nil), &RecordWrapperOptions{Mode: ModeRecord, Path: path, DigestKey: testDigestKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded [][]byte
+	for i := 0; i < 2; i++ {
+		blob, err := rec.Encrypt(ctx, []byte("foo"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, blob.Ciphertext)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := NewRecordWrapper(nil, &RecordWrapperOptions{Path: path, DigestKey: testDigestKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Served in order, then the last one repeats
+	for i, expected := range [][]byte{recorded[0], recorded[1], recorded[1]} {
+		blob, err := rep.Encrypt(ctx, []byte("foo"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(blob.Ciphertext, expected) {
+			t.Fatalf("call %d: unexpected ciphertext", i)
+		}
+	}
+}
+
+func TestRecordWrapper_Redact(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "recordwrapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	rec, err := NewRecordWrapper(wrapping.NewTestEnvelopeWrapper(nil), &RecordWrapperOptions{Mode: ModeRecord, Path: path, DigestKey: testDigestKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := rec.Encrypt(ctx, []byte("hunter2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Decrypt(ctx, blob, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// JSON encodes byte slices as base64; "hunter2" never appears either way
+	if bytes.Contains(raw, []byte("hunter2")) || bytes.Contains(raw, []byte("aHVudGVyMg")) {
+		t.Fatal("fixture contains the plaintext")
+	}
+
+	// Without the Encrypt call the plaintext is unknown
+	rep, err := NewRecordWrapper(nil, &RecordWrapperOptions{Path: path, DigestKey: testDigestKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rep.Decrypt(ctx, blob, nil); !errors.Is(err, ErrRedacted) {
+		t.Fatalf("expected ErrRedacted, got %v", err)
+	}
+
+	// With it, Decrypt returns what Encrypt was given
+	rep, err = NewRecordWrapper(nil, &RecordWrapperOptions{Path: path, DigestKey: testDigestKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := rep.Encrypt(ctx, []byte("hunter2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := rep.Decrypt(ctx, replayed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "hunter2" {
+		t.Fatalf("expected hunter2, got %s", pt)
+	}
+}
+
+func TestRecordWrapper_KeepPlaintext(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "recordwrapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	rec, err := NewRecordWrapper(wrapping.NewTestEnvelopeWrapper(nil), &RecordWrapperOptions{
+		Mode:          ModeRecord,
+		Path:          path,
+		DigestKey:     testDigestKey,
+		KeepPlaintext: true,
+		Redact: func(i *Interaction) {
+			if i.Plaintext != nil {
+				i.Plaintext = []byte("redacted")
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := rec.Encrypt(ctx, []byte("hunter2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Decrypt(ctx, blob, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Kept plaintext replays without the Encrypt call, as redacted
+	rep, err := NewRecordWrapper(nil, &RecordWrapperOptions{Path: path, DigestKey: testDigestKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := rep.Decrypt(ctx, blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "redacted" {
+		t.Fatalf("expected redacted plaintext, got %s", pt)
+	}
+}
+
+func TestRecordWrapper_DigestKey(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "recordwrapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixture.json")
+
+	rec, err := NewRecordWrapper(wrapping.NewTestEnvelopeWrapper(nil), &RecordWrapperOptions{
+		Mode:      ModeRecord,
+		Path:      path,
+		DigestKey: []byte("digest key"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := rec.Encrypt(ctx, []byte("1234"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("digest key")) {
+		t.Fatal("fixture contains the digest key")
+	}
+
+	rep, err := NewRecordWrapper(nil, &RecordWrapperOptions{Path: path, DigestKey: []byte("digest key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := rep.Encrypt(ctx, []byte("1234"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replayed.Ciphertext, blob.Ciphertext) {
+		t.Fatal("expected the recorded ciphertext")
+	}
+
+	// Another key matches nothing, and the key cannot be left out
+	rep, err = NewRecordWrapper(nil, &RecordWrapperOptions{Path: path, DigestKey: []byte("other key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rep.Encrypt(ctx, []byte("1234"), nil); !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("expected ErrNoInteraction, got %v", err)
+	}
+	if _, err := NewRecordWrapper(nil, &RecordWrapperOptions{Path: path}); err == nil {
+		t.Fatal("expected error replaying without the digest key")
+	}
+}
+
+func TestRecordWrapper_Options(t *testing.T) {
+	if _, err := NewRecordWrapper(nil, nil); err == nil {
+		t.Fatal("expected error without a path")
+	}
+	if _, err := NewRecordWrapper(nil, &RecordWrapperOptions{Mode: ModeRecord, Path: "fixture.json", DigestKey: testDigestKey}); err == nil {
+		t.Fatal("expected error recording without a wrapper")
+	}
+	if _, err := NewRecordWrapper(wrapping.NewTestEnvelopeWrapper(nil), &RecordWrapperOptions{Mode: ModeRecord, Path: "fixture.json"}); err == nil {
+		t.Fatal("expected error recording without a digest key")
+	}
+	if _, err := NewRecordWrapper(nil, &RecordWrapperOptions{Path: "does-not-exist.json", DigestKey: testDigestKey}); err == nil {
+		t.Fatal("expected error replaying a missing fixture")
+	}
+}
+
+func TestRecordWrapper_Conformance(t *testing.T) {
+	// In record mode the decorator must be transparent
+	dir, err := ioutil.TempDir("", "recordwrapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		w, err := NewRecordWrapper(wrapping.NewTestEnvelopeWrapper([]byte("secret")), &RecordWrapperOptions{
+			Mode:      ModeRecord,
+			Path:      filepath.Join(dir, t.Name(), "fixture.json"),
+			DigestKey: testDigestKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}, &wraptest.ConformanceOptions{
+		IgnoresAAD:          true,
+		AcceptsNilPlaintext: true,
+	})
+}
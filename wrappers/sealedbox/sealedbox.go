@@ -0,0 +1,256 @@
+// Package sealedbox provides a wrapper for libsodium sealed boxes
+// (crypto_box_seal): X25519 and XSalsa20-Poly1305 under an ephemeral key, so
+// that anyone holding the public key can encrypt to the holder of the
+// private key.
+//
+// The ciphertext of a blob is the sealed box itself, byte for byte, and can
+// be handed to crypto_box_seal_open in any libsodium binding; FromSealedBox
+// wraps a sealed box produced by one in a blob. Sealed boxes do not
+// authenticate additional data, so the wrapper refuses AAD rather than
+// silently leaving it unbound.
+package sealedbox
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// KeySize is the size of X25519 public and private keys
+const KeySize = 32
+
+// Overhead is the number of bytes a sealed box adds to its plaintext: the
+// ephemeral public key and the Poly1305 tag
+const Overhead = box.AnonymousOverhead
+
+// ErrAADNotSupported is returned by Encrypt and Decrypt when given additional
+// authenticated data, which sealed boxes cannot bind
+var ErrAADNotSupported = errors.New("sealed boxes do not support additional authenticated data")
+
+// Wrapper implements the wrapping.Wrapper interface for sealed boxes. A
+// wrapper without a private key can only encrypt. It is safe to reconfigure
+// via SetConfig or SetKeys while it is in use.
+type Wrapper struct {
+	l          sync.RWMutex
+	keyID      string
+	publicKey  *[KeySize]byte
+	privateKey *[KeySize]byte
+}
+
+// Ensure that we are implementing Wrapper
+var _ wrapping.Wrapper = (*Wrapper)(nil)
+
+// NewWrapper creates a new sealed box Wrapper
+func NewWrapper(_ *wrapping.WrapperOptions) *Wrapper {
+	return new(Wrapper)
+}
+
+// SetConfig sets the fields on the Wrapper object based on values from the
+// config parameter. public_key and private_key are base64 encoded; the
+// public key is derived from the private key when only the latter is given.
+// key_id defaults to the base64 encoded public key.
+func (s *Wrapper) SetConfig(config map[string]string) (map[string]string, error) {
+	if config == nil {
+		config = map[string]string{}
+	}
+
+	var publicKey, privateKey []byte
+	var err error
+	if v := config["public_key"]; v != "" {
+		if publicKey, err = base64.StdEncoding.DecodeString(v); err != nil {
+			return nil, fmt.Errorf("error base64-decoding public_key: %w", err)
+		}
+	}
+	if v := config["private_key"]; v != "" {
+		if privateKey, err = base64.StdEncoding.DecodeString(v); err != nil {
+			return nil, fmt.Errorf("error base64-decoding private_key: %w", err)
+		}
+	}
+	if publicKey == nil && privateKey == nil {
+		return nil, errors.New("one of public_key or private_key is required")
+	}
+	if err := s.SetKeys(config["key_id"], publicKey, privateKey); err != nil {
+		return nil, err
+	}
+
+	// Map that holds non-sensitive configuration info
+	wrappingInfo := make(map[string]string)
+	wrappingInfo["key_id"] = s.KeyID()
+	wrappingInfo["public_key"] = base64.StdEncoding.EncodeToString(s.publicKeyBytes()[:])
+
+	return wrappingInfo, nil
+}
+
+// SetKeys sets the key pair. privateKey may be nil for a wrapper that only
+// encrypts, and publicKey may be nil to derive it from privateKey. An empty
+// keyID defaults to the base64 encoded public key.
+func (s *Wrapper) SetKeys(keyID string, publicKey, privateKey []byte) error {
+	var pub, priv *[KeySize]byte
+	if privateKey != nil {
+		if len(privateKey) != KeySize {
+			return fmt.Errorf("private key must be %d bytes, got %d", KeySize, len(privateKey))
+		}
+		priv = new([KeySize]byte)
+		copy(priv[:], privateKey)
+		derived, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+		if err != nil {
+			return fmt.Errorf("invalid private key: %w", err)
+		}
+		if publicKey == nil {
+			publicKey = derived
+		} else if subtle.ConstantTimeCompare(publicKey, derived) != 1 {
+			return errors.New("public key does not match the private key")
+		}
+	}
+	if len(publicKey) != KeySize {
+		return fmt.Errorf("public key must be %d bytes, got %d", KeySize, len(publicKey))
+	}
+	pub = new([KeySize]byte)
+	copy(pub[:], publicKey)
+
+	if keyID == "" {
+		keyID = base64.StdEncoding.EncodeToString(pub[:])
+	}
+
+	// Swap the key ID and keys together so concurrent encryptions never
+	// label output with the wrong key ID
+	s.l.Lock()
+	s.keyID = keyID
+	s.publicKey = pub
+	s.privateKey = priv
+	s.l.Unlock()
+	return nil
+}
+
+func (s *Wrapper) publicKeyBytes() *[KeySize]byte {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return s.publicKey
+}
+
+// Init is a no-op at the moment
+func (s *Wrapper) Init(_ context.Context) error {
+	return nil
+}
+
+// Finalize is called during shutdown. This is a no-op since
+// Wrapper doesn't require any cleanup.
+func (s *Wrapper) Finalize(_ context.Context) error {
+	return nil
+}
+
+// Type returns the seal type for this particular Wrapper implementation
+func (s *Wrapper) Type() string {
+	return wrapping.SealedBox
+}
+
+// KeyID returns the last known key id
+func (s *Wrapper) KeyID() string {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	return s.keyID
+}
+
+// HMACKeyID returns the last known HMAC key id
+func (s *Wrapper) HMACKeyID() string {
+	return ""
+}
+
+// Encrypt seals plaintext to the public key. Sealed boxes cannot bind
+// additional data, so a non-empty aad is an error.
+func (s *Wrapper) Encrypt(_ context.Context, plaintext, aad []byte) (*wrapping.EncryptedBlobInfo, error) {
+	if plaintext == nil {
+		return nil, errors.New("given plaintext for encryption is nil")
+	}
+	if len(aad) > 0 {
+		return nil, ErrAADNotSupported
+	}
+
+	s.l.RLock()
+	publicKey, keyID := s.publicKey, s.keyID
+	s.l.RUnlock()
+
+	if publicKey == nil {
+		return nil, errors.New("public key is not configured")
+	}
+
+	ciphertext, err := box.SealAnonymous(nil, plaintext, publicKey, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error sealing box: %w", err)
+	}
+	return FromSealedBox(ciphertext, keyID)
+}
+
+// Decrypt opens the sealed box of a blob with the private key. As with
+// Encrypt, a non-empty aad is an error.
+func (s *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad []byte) ([]byte, error) {
+	if in == nil {
+		return nil, errors.New("given input for decryption is nil")
+	}
+	if len(aad) > 0 {
+		return nil, ErrAADNotSupported
+	}
+
+	s.l.RLock()
+	publicKey, privateKey := s.publicKey, s.privateKey
+	s.l.RUnlock()
+
+	if privateKey == nil {
+		return nil, errors.New("private key is not configured")
+	}
+
+	sealed, err := SealedBox(in)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, ok := box.OpenAnonymous(nil, sealed, publicKey, privateKey)
+	if !ok {
+		return nil, errors.New("error opening sealed box")
+	}
+	if plaintext == nil {
+		plaintext = []byte{}
+	}
+	return plaintext, nil
+}
+
+// FromSealedBox returns a blob for a sealed box produced by crypto_box_seal,
+// labeled with keyID
+func FromSealedBox(sealed []byte, keyID string) (*wrapping.EncryptedBlobInfo, error) {
+	if len(sealed) < Overhead {
+		return nil, errors.New("sealed box is too short")
+	}
+	return &wrapping.EncryptedBlobInfo{
+		Ciphertext: sealed,
+		KeyInfo: &wrapping.KeyInfo{
+			KeyID: keyID,
+		},
+	}, nil
+}
+
+// SealedBox returns the sealed box of a blob, for crypto_box_seal_open
+func SealedBox(blob *wrapping.EncryptedBlobInfo) ([]byte, error) {
+	switch {
+	case len(blob.IV) > 0 || len(blob.HMAC) > 0 || blob.KeyInfo != nil && len(blob.KeyInfo.WrappedKey) > 0:
+		return nil, errors.New("blob is not a sealed box")
+	case len(blob.Ciphertext) < Overhead:
+		return nil, errors.New("sealed box is too short")
+	}
+	return blob.Ciphertext, nil
+}
+
+// GenerateKey returns a new key pair, base64 encoded as SetConfig takes it
+func GenerateKey() (publicKey, privateKey string, err error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub[:]), base64.StdEncoding.EncodeToString(priv[:]), nil
+}
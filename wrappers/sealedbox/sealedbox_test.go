@@ -0,0 +1,141 @@
+package sealedbox
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+)
+
+func testWrapper(t *testing.T) *Wrapper {
+	t.Helper()
+	_, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWrapper(nil)
+	if _, err := w.SetConfig(map[string]string{"private_key": priv}); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWrapper_Conformance(t *testing.T) {
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		return testWrapper(t)
+	}, &wraptest.ConformanceOptions{RejectsAAD: true})
+}
+
+func TestWrapper_EncryptOnly(t *testing.T) {
+	ctx := context.Background()
+	w := testWrapper(t)
+
+	sender := NewWrapper(nil)
+	info, err := sender.SetConfig(map[string]string{"public_key": base64.StdEncoding.EncodeToString(w.publicKeyBytes()[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender.KeyID() != w.KeyID() || info["key_id"] != w.KeyID() {
+		t.Fatalf("expected key ID %q, got %q", w.KeyID(), sender.KeyID())
+	}
+
+	blob, err := sender.Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blob.Ciphertext) != 3+Overhead {
+		t.Fatalf("unexpected ciphertext size %d", len(blob.Ciphertext))
+	}
+	if _, err := sender.Decrypt(ctx, blob, nil); err == nil {
+		t.Fatal("expected error decrypting without a private key")
+	}
+	pt, err := w.Decrypt(ctx, blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %q", pt)
+	}
+
+	if _, err := testWrapper(t).Decrypt(ctx, blob, nil); err == nil {
+		t.Fatal("expected error decrypting with another key")
+	}
+}
+
+func TestWrapper_SetConfig(t *testing.T) {
+	pub, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWrapper(nil)
+	if _, err := w.SetConfig(map[string]string{"public_key": pub, "private_key": priv, "key_id": "app"}); err != nil {
+		t.Fatal(err)
+	}
+	if w.KeyID() != "app" {
+		t.Fatalf("expected key ID app, got %q", w.KeyID())
+	}
+
+	for _, config := range []map[string]string{
+		nil,
+		{"public_key": otherPub, "private_key": priv},
+		{"public_key": "AAAA"},
+		{"private_key": "not base64"},
+	} {
+		if _, err := NewWrapper(nil).SetConfig(config); err == nil {
+			t.Fatalf("%v: expected error", config)
+		}
+	}
+}
+
+// The vector in testdata was sealed by libsodium's crypto_box_seal
+func TestLibsodium(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/libsodium.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vector struct {
+		PublicKey  string `json:"public_key"`
+		PrivateKey string `json:"private_key"`
+		Plaintext  string `json:"plaintext"`
+		SealedBox  []byte `json:"sealed_box"`
+	}
+	if err := json.Unmarshal(buf, &vector); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWrapper(nil)
+	if _, err := w.SetConfig(map[string]string{"private_key": vector.PrivateKey}); err != nil {
+		t.Fatal(err)
+	}
+	if w.KeyID() != vector.PublicKey {
+		t.Fatalf("expected the derived public key %s, got %s", vector.PublicKey, w.KeyID())
+	}
+
+	blob, err := FromSealedBox(vector.SealedBox, w.KeyID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := w.Decrypt(context.Background(), blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != vector.Plaintext {
+		t.Fatalf("expected %q, got %q", vector.Plaintext, pt)
+	}
+
+	if _, err := FromSealedBox(vector.SealedBox[:Overhead-1], ""); err == nil {
+		t.Fatal("expected error for a truncated sealed box")
+	}
+	if _, err := SealedBox(&wrapping.EncryptedBlobInfo{Ciphertext: vector.SealedBox, IV: []byte("iv")}); err == nil {
+		t.Fatal("expected error for a blob of another wrapper")
+	}
+}
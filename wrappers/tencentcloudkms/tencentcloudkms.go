@@ -32,7 +32,8 @@ type Wrapper struct {
 	keyID        string
 	currentKeyID *atomic.Value
 
-	client kmsClient
+	client  kmsClient
+	factory clientFactory
 }
 
 // Ensure that we are implementing Wrapper
@@ -46,6 +47,7 @@ func NewWrapper(opts *wrapping.WrapperOptions) *Wrapper {
 
 	k := &Wrapper{
 		currentKeyID: new(atomic.Value),
+		factory:      defaultClientFactory{},
 	}
 	k.currentKeyID.Store("")
 
@@ -108,13 +110,8 @@ func (k *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 	}
 
 	if k.client == nil {
-		cpf := profile.NewClientProfile()
-		cpf.HttpProfile.ReqMethod = "POST"
-		cpf.HttpProfile.ReqTimeout = 300
-		cpf.Language = "en-US"
-
 		credential := common.NewTokenCredential(k.accessKey, k.secretKey, k.sessionToken)
-		client, err := kms.NewClient(credential, k.region, cpf)
+		client, err := k.factory.newClient(credential, k.region)
 		if err != nil {
 			return nil, fmt.Errorf("error initializing TencentCloud KMS client: %w", err)
 		}
@@ -126,7 +123,7 @@ func (k *Wrapper) SetConfig(config map[string]string) (map[string]string, error)
 			return nil, fmt.Errorf("error fetching TencentCloud KMS information: %w", err)
 		}
 
-		if keyInfo.Response.KeyMetadata == nil || keyInfo.Response.KeyMetadata.KeyId == nil {
+		if keyInfo.Response == nil || keyInfo.Response.KeyMetadata == nil || keyInfo.Response.KeyMetadata.KeyId == nil {
 			return nil, fmt.Errorf("no key information return")
 		}
 
@@ -209,6 +206,9 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 	if in == nil {
 		return nil, fmt.Errorf("given input for decryption is nil")
 	}
+	if in.KeyInfo == nil {
+		return nil, fmt.Errorf("key info is nil")
+	}
 
 	input := kms.NewDecryptRequest()
 	input.CiphertextBlob = common.StringPtr(string(in.KeyInfo.WrappedKey))
@@ -237,6 +237,23 @@ func (k *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, aad
 	return plaintext, nil
 }
 
+// clientFactory builds the KMS client from the credential and region
+// resolved by SetConfig
+type clientFactory interface {
+	newClient(credential *common.Credential, region string) (kmsClient, error)
+}
+
+type defaultClientFactory struct{}
+
+func (defaultClientFactory) newClient(credential *common.Credential, region string) (kmsClient, error) {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.ReqMethod = "POST"
+	cpf.HttpProfile.ReqTimeout = 300
+	cpf.Language = "en-US"
+
+	return kms.NewClient(credential, region, cpf)
+}
+
 type kmsClient interface {
 	Decrypt(request *kms.DecryptRequest) (response *kms.DecryptResponse, err error)
 	DescribeKey(request *kms.DescribeKeyRequest) (response *kms.DescribeKeyResponse, err error)
@@ -8,12 +8,20 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/hashicorp/go-kms-wrapping/internal/testenv"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
 	kms "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/kms/v20190118"
 )
 
 const tencentCloudTestKeyID = "tencentcloud-test-key-id"
 
+// tencentCloudTestCreds are placeholder credentials; the mock client never
+// uses them
+var tencentCloudTestCreds = map[string]string{
+	"access_key": "tencentcloud-test-access-key",
+	"secret_key": "tencentcloud-test-secret-key",
+}
+
 func TestTencentCloudKMSWrapper(t *testing.T) {
 	s := NewWrapper(nil)
 	s.client = &mockTencentCloudKMSWrapperClient{
@@ -36,7 +44,7 @@ func TestTencentCloudKMSWrapper(t *testing.T) {
 			t.Fatal(err)
 		}
 	}()
-	if _, err := s.SetConfig(nil); err != nil {
+	if _, err := s.SetConfig(tencentCloudTestCreds); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -59,7 +67,7 @@ func TestTencentCloudKMSWrapper_Lifecycle(t *testing.T) {
 			t.Fatal(err)
 		}
 	}()
-	if _, err := s.SetConfig(nil); err != nil {
+	if _, err := s.SetConfig(tencentCloudTestCreds); err != nil {
 		t.Fatal(err)
 	}
 
@@ -79,6 +87,122 @@ func TestTencentCloudKMSWrapper_Lifecycle(t *testing.T) {
 	}
 }
 
+func TestTencentCloudKMSWrapper_ConfigPrecedence(t *testing.T) {
+	config := map[string]string{
+		"kms_key_id":    "config-key",
+		"region":        "config-region",
+		"access_key":    "config-access",
+		"secret_key":    "config-secret",
+		"session_token": "config-token",
+	}
+
+	testCases := []struct {
+		Title          string
+		Env            map[string]string
+		Config         map[string]string
+		ExpectedKeyID  string
+		ExpectedRegion string
+		Expected       common.Credential
+	}{
+		{
+			Title:          "Config",
+			Config:         config,
+			ExpectedKeyID:  "config-key",
+			ExpectedRegion: "config-region",
+			Expected: common.Credential{
+				SecretId:  "config-access",
+				SecretKey: "config-secret",
+				Token:     "config-token",
+			},
+		},
+		{
+			Title:          "Default-Region",
+			Config:         tencentCloudTestCreds,
+			Env:            map[string]string{PROVIDER_KMS_KEY_ID: "env-key"},
+			ExpectedKeyID:  "env-key",
+			ExpectedRegion: "ap-guangzhou",
+			Expected: common.Credential{
+				SecretId:  tencentCloudTestCreds["access_key"],
+				SecretKey: tencentCloudTestCreds["secret_key"],
+			},
+		},
+		{
+			Title: "Env",
+			Env: map[string]string{
+				PROVIDER_KMS_KEY_ID:     "env-key",
+				PROVIDER_REGION:         "env-region",
+				PROVIDER_SECRET_ID:      "env-access",
+				PROVIDER_SECRET_KEY:     "env-secret",
+				PROVIDER_SECURITY_TOKEN: "env-token",
+			},
+			Config:         config,
+			ExpectedKeyID:  "env-key",
+			ExpectedRegion: "env-region",
+			Expected: common.Credential{
+				SecretId:  "env-access",
+				SecretKey: "env-secret",
+				Token:     "env-token",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Title, func(t *testing.T) {
+			defer testenv.Set(t, envNames, tc.Env)()
+
+			factory := &mockClientFactory{}
+			s := NewWrapper(nil)
+			s.factory = factory
+			if _, err := s.SetConfig(tc.Config); err != nil {
+				t.Fatalf("error setting config: %s", err)
+			}
+
+			if s.keyID != tc.ExpectedKeyID {
+				t.Fatalf("expected key ID %q, got %q", tc.ExpectedKeyID, s.keyID)
+			}
+			if factory.region != tc.ExpectedRegion {
+				t.Fatalf("expected region %q, got %q", tc.ExpectedRegion, factory.region)
+			}
+			if !reflect.DeepEqual(*factory.credential, tc.Expected) {
+				t.Fatalf("expected %#v, got %#v", tc.Expected, *factory.credential)
+			}
+		})
+	}
+
+	for _, name := range []string{"access_key", "secret_key"} {
+		t.Run("Missing-"+name, func(t *testing.T) {
+			defer testenv.Set(t, envNames, nil)()
+
+			partial := make(map[string]string, len(config))
+			for k, v := range config {
+				if k != name {
+					partial[k] = v
+				}
+			}
+			s := NewWrapper(nil)
+			s.factory = &mockClientFactory{}
+			if _, err := s.SetConfig(partial); err == nil {
+				t.Fatalf("expected error when %s is not provided", name)
+			}
+		})
+	}
+}
+
+// mockClientFactory keeps the credential and region SetConfig resolved
+type mockClientFactory struct {
+	credential *common.Credential
+	region     string
+}
+
+func (f *mockClientFactory) newClient(credential *common.Credential, region string) (kmsClient, error) {
+	f.credential = credential
+	f.region = region
+	return &mockTencentCloudKMSWrapperClient{keyID: common.StringPtr(tencentCloudTestKeyID)}, nil
+}
+
+// envNames are the environment variables SetConfig reads
+var envNames = []string{PROVIDER_KMS_KEY_ID, PROVIDER_REGION, PROVIDER_SECRET_ID, PROVIDER_SECRET_KEY, PROVIDER_SECURITY_TOKEN}
+
 // mockTencentCloudKMSWrapperClient is a mock client for testing
 type mockTencentCloudKMSWrapperClient struct {
 	keyID *string
@@ -120,6 +244,6 @@ func (m *mockTencentCloudKMSWrapperClient) DescribeKey(request *kms.DescribeKeyR
 		return nil, errors.New("key not found")
 	}
 	output := &kms.DescribeKeyResponse{}
-	_ = output.FromJsonString(`{"Response": {"KeyMetadata": {"KeyId": "` + *m.keyID + `"}}`)
+	_ = output.FromJsonString(`{"Response": {"KeyMetadata": {"KeyId": "` + *m.keyID + `"}}}`)
 	return output, nil
 }
@@ -0,0 +1,52 @@
+package transit
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/internal/golden"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGoldenVectors(t *testing.T) {
+	vectors := golden.Load(t, "testdata/golden.json", func(t *testing.T) []golden.Vector {
+		s := NewWrapper(nil)
+		s.client = newTestTransitClient("golden-key")
+
+		pt := []byte("golden transit plaintext")
+		blob, err := s.Encrypt(context.Background(), pt, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := proto.Marshal(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []golden.Vector{
+			{Name: "transit", Plaintext: pt, Blob: raw},
+		}
+	})
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			var blob wrapping.EncryptedBlobInfo
+			if err := proto.Unmarshal(v.Blob, &blob); err != nil {
+				t.Fatal(err)
+			}
+			s := NewWrapper(nil)
+			s.client = newTestTransitClient("golden-key")
+			pt, err := s.Decrypt(context.Background(), &blob, v.AAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.Plaintext, pt) {
+				t.Fatalf("expected %q, got %q", v.Plaintext, pt)
+			}
+			if blob.KeyInfo.KeyID != "golden-key" {
+				t.Fatalf("expected key ID golden-key, got %q", blob.KeyInfo.KeyID)
+			}
+		})
+	}
+}
@@ -101,6 +101,10 @@ func (s *Wrapper) Encrypt(_ context.Context, plaintext, aad []byte) (blob *wrapp
 
 // Decrypt is used to decrypt the ciphertext
 func (s *Wrapper) Decrypt(_ context.Context, in *wrapping.EncryptedBlobInfo, _ []byte) (pt []byte, err error) {
+	if in == nil {
+		return nil, errors.New("given input for decryption is nil")
+	}
+
 	plaintext, err := s.client.Decrypt(in.Ciphertext)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,103 @@
+//go:build integration
+// +build integration
+
+package transit
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/api"
+)
+
+// These tests run against a Vault dev server; see integration/README.md
+func vaultDevConfig(t *testing.T) map[string]string {
+	t.Helper()
+
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		address = "http://127.0.0.1:8200"
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		token = "root"
+	}
+
+	apiConfig := api.DefaultConfig()
+	apiConfig.Address = address
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		t.Fatalf("error listing Vault mounts: %v", err)
+	}
+	if _, ok := mounts["transit/"]; !ok {
+		if err := client.Sys().Mount("transit", &api.MountInput{Type: "transit"}); err != nil {
+			t.Fatalf("error mounting transit: %v", err)
+		}
+	}
+
+	keyName, err := uuid.GenerateUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Logical().Write("transit/keys/"+keyName, nil); err != nil {
+		t.Fatalf("error creating transit key: %v", err)
+	}
+
+	return map[string]string{
+		"address":         address,
+		"token":           token,
+		"mount_path":      "transit",
+		"key_name":        keyName,
+		"disable_renewal": "true",
+	}
+}
+
+func TestIntegrationTransitWrapper_Lifecycle(t *testing.T) {
+	s := NewWrapper(nil)
+	if _, err := s.SetConfig(vaultDevConfig(t)); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Finalize(context.Background())
+
+	input := []byte("foo")
+	swi, err := s.Encrypt(context.Background(), input, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+
+	pt, err := s.Decrypt(context.Background(), swi, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err.Error())
+	}
+	if !reflect.DeepEqual(input, pt) {
+		t.Fatalf("expected %s, got %s", input, pt)
+	}
+	if s.KeyID() != "v1" {
+		t.Fatalf("expected key version v1, got %s", s.KeyID())
+	}
+}
+
+func TestIntegrationTransitWrapper_Conformance(t *testing.T) {
+	config := vaultDevConfig(t)
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		s := NewWrapper(nil)
+		if _, err := s.SetConfig(config); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}, &wraptest.ConformanceOptions{
+		// Transit does not support AAD
+		IgnoresAAD: true,
+	})
+}
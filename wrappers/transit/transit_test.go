@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/hashicorp/go-kms-wrapping/wraptest"
 )
 
 type testTransitClient struct {
@@ -77,3 +78,16 @@ func TestTransitWrapper_Lifecycle(t *testing.T) {
 		t.Fatalf("key id does not match: expected %s, got %s", keyID, s.KeyID())
 	}
 }
+
+func TestTransitWrapper_Conformance(t *testing.T) {
+	wraptest.RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		s := NewWrapper(nil)
+		s.client = newTestTransitClient("test-key")
+		return s
+	}, &wraptest.ConformanceOptions{
+		// Transit does not support AAD
+		IgnoresAAD: true,
+		// Transit sends a nil plaintext to Vault as an empty one
+		AcceptsNilPlaintext: true,
+	})
+}
@@ -0,0 +1,111 @@
+package wraptest
+
+import (
+	"context"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockWrapper is a wrapping.Wrapper built on testify's mock package. Set the
+// expected calls with On and check them with the usual assertions:
+//
+//	m := new(wraptest.MockWrapper)
+//	m.On("Encrypt", mock.Anything, []byte("foo"), mock.Anything).Return(blob, nil)
+//	...
+//	m.AssertExpectations(t)
+//
+// The context is passed as the first argument of every method that takes
+// one. Besides plain values, Return accepts a single function with the
+// method's own signature, such as func(context.Context, []byte, []byte)
+// (*wrapping.EncryptedBlobInfo, error) for Encrypt, which is then called to
+// produce the results. As with any testify mock, a call with no matching
+// expectation panics.
+type MockWrapper struct {
+	mock.Mock
+}
+
+// Ensure that we are implementing Wrapper
+var _ wrapping.Wrapper = (*MockWrapper)(nil)
+
+// NewMockWrapper returns a MockWrapper that delegates every method to w, so
+// it can be used to observe calls made to a real wrapper with AssertCalled
+// and friends. The delegating expectations are optional. testify matches
+// expectations in the order they were added, so they cannot be overridden
+// with On; start from a new MockWrapper to change behavior instead.
+func NewMockWrapper(w wrapping.Wrapper) *MockWrapper {
+	m := new(MockWrapper)
+	m.On("Type").Return(w.Type).Maybe()
+	m.On("KeyID").Return(w.KeyID).Maybe()
+	m.On("HMACKeyID").Return(w.HMACKeyID).Maybe()
+	m.On("Init", mock.Anything).Return(w.Init).Maybe()
+	m.On("Finalize", mock.Anything).Return(w.Finalize).Maybe()
+	m.On("Encrypt", mock.Anything, mock.Anything, mock.Anything).Return(w.Encrypt).Maybe()
+	m.On("Decrypt", mock.Anything, mock.Anything, mock.Anything).Return(w.Decrypt).Maybe()
+	return m
+}
+
+// Type returns the configured type
+func (m *MockWrapper) Type() string {
+	ret := m.MethodCalled("Type")
+	if f, ok := ret.Get(0).(func() string); ok {
+		return f()
+	}
+	return ret.String(0)
+}
+
+// KeyID returns the configured key ID
+func (m *MockWrapper) KeyID() string {
+	ret := m.MethodCalled("KeyID")
+	if f, ok := ret.Get(0).(func() string); ok {
+		return f()
+	}
+	return ret.String(0)
+}
+
+// HMACKeyID returns the configured HMAC key ID
+func (m *MockWrapper) HMACKeyID() string {
+	ret := m.MethodCalled("HMACKeyID")
+	if f, ok := ret.Get(0).(func() string); ok {
+		return f()
+	}
+	return ret.String(0)
+}
+
+// Init returns the configured error
+func (m *MockWrapper) Init(ctx context.Context) error {
+	ret := m.MethodCalled("Init", ctx)
+	if f, ok := ret.Get(0).(func(context.Context) error); ok {
+		return f(ctx)
+	}
+	return ret.Error(0)
+}
+
+// Finalize returns the configured error
+func (m *MockWrapper) Finalize(ctx context.Context) error {
+	ret := m.MethodCalled("Finalize", ctx)
+	if f, ok := ret.Get(0).(func(context.Context) error); ok {
+		return f(ctx)
+	}
+	return ret.Error(0)
+}
+
+// Encrypt returns the configured blob and error
+func (m *MockWrapper) Encrypt(ctx context.Context, plaintext, aad []byte) (*wrapping.EncryptedBlobInfo, error) {
+	ret := m.MethodCalled("Encrypt", ctx, plaintext, aad)
+	if f, ok := ret.Get(0).(func(context.Context, []byte, []byte) (*wrapping.EncryptedBlobInfo, error)); ok {
+		return f(ctx, plaintext, aad)
+	}
+	blob, _ := ret.Get(0).(*wrapping.EncryptedBlobInfo)
+	return blob, ret.Error(1)
+}
+
+// Decrypt returns the configured plaintext and error
+func (m *MockWrapper) Decrypt(ctx context.Context, in *wrapping.EncryptedBlobInfo, aad []byte) ([]byte, error) {
+	ret := m.MethodCalled("Decrypt", ctx, in, aad)
+	if f, ok := ret.Get(0).(func(context.Context, *wrapping.EncryptedBlobInfo, []byte) ([]byte, error)); ok {
+		return f(ctx, in, aad)
+	}
+	pt, _ := ret.Get(0).([]byte)
+	return pt, ret.Error(1)
+}
@@ -0,0 +1,80 @@
+package wraptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMockWrapper(t *testing.T) {
+	ctx := context.Background()
+	blob := &wrapping.EncryptedBlobInfo{Ciphertext: []byte("bar")}
+	failed := errors.New("failed")
+
+	m := new(MockWrapper)
+	m.On("Type").Return(wrapping.Test)
+	m.On("Init", mock.Anything).Return(nil)
+	m.On("Encrypt", mock.Anything, []byte("foo"), []byte("aad")).Return(blob, nil).Once()
+	m.On("Decrypt", mock.Anything, blob, mock.Anything).Return(nil, failed)
+
+	if m.Type() != wrapping.Test {
+		t.Fatalf("expected type %q, got %q", wrapping.Test, m.Type())
+	}
+	if err := m.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := m.Encrypt(ctx, []byte("foo"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != blob {
+		t.Fatal("expected the configured blob to be returned")
+	}
+	if _, err := m.Decrypt(ctx, blob, nil); !errors.Is(err, failed) {
+		t.Fatalf("expected the configured error, got %v", err)
+	}
+	m.AssertExpectations(t)
+	m.AssertNumberOfCalls(t, "Type", 1)
+
+	// A call nothing was set up for fails the test
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected an unexpected call to panic")
+			}
+		}()
+		m.Encrypt(ctx, []byte("foo"), []byte("aad"))
+	}()
+}
+
+func TestMockWrapper_Delegate(t *testing.T) {
+	ctx := context.Background()
+	w := wrapping.NewTestEnvelopeWrapper([]byte("secret"))
+
+	m := NewMockWrapper(w)
+	if m.Type() != w.Type() || m.KeyID() != w.KeyID() {
+		t.Fatal("expected the wrapped type and key ID")
+	}
+	blob, err := m.Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := m.Decrypt(ctx, blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "foo" {
+		t.Fatalf("expected foo, got %s", pt)
+	}
+	m.AssertCalled(t, "Encrypt", ctx, []byte("foo"), []byte(nil))
+	m.AssertNotCalled(t, "Finalize", mock.Anything)
+}
+
+func TestConformance_MockWrapper(t *testing.T) {
+	RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+		return NewMockWrapper(wrapping.NewTestEnvelopeWrapper([]byte("secret")))
+	}, &ConformanceOptions{IgnoresAAD: true, AcceptsNilPlaintext: true})
+}
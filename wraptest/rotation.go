@@ -0,0 +1,125 @@
+package wraptest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+)
+
+// RotationOptions configures RunRotationTests
+type RotationOptions struct {
+	// Rotate is called repeatedly, from its own goroutine, while encryption
+	// and decryption are in progress. It should swap the key or credentials
+	// of the wrapper under test, for instance via SetConfig. i counts the
+	// calls, starting at zero. It is required.
+	Rotate func(i int) error
+
+	// Decrypter returns a wrapper that must be able to decrypt blobs labeled
+	// with the given key ID, or an error if the key ID is not one the test
+	// knows about. Blobs are checked against it to catch output labeled with
+	// a key ID other than the one used to encrypt it. If nil, blobs are
+	// decrypted by the wrapper under test.
+	Decrypter func(keyID string) (wrapping.Wrapper, error)
+
+	// Rotations is the number of times Rotate is called. Defaults to 100.
+	Rotations int
+
+	// Concurrency is the number of goroutines encrypting and decrypting.
+	// Defaults to 8.
+	Concurrency int
+}
+
+// RunRotationTests runs concurrent Encrypt and Decrypt calls against w while
+// opts.Rotate swaps its key, and fails t if any operation fails, if a blob is
+// labeled with a key ID that cannot decrypt it, or if w.KeyID() reports a key
+// ID the decrypter does not recognize. It is most useful under the race
+// detector.
+func RunRotationTests(t *testing.T, w wrapping.Wrapper, opts *RotationOptions) {
+	t.Helper()
+
+	if opts == nil || opts.Rotate == nil {
+		t.Fatal("RunRotationTests requires a Rotate function")
+	}
+	rotations := opts.Rotations
+	if rotations <= 0 {
+		rotations = 100
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+	decrypter := opts.Decrypter
+	if decrypter == nil {
+		decrypter = func(string) (wrapping.Wrapper, error) {
+			return w, nil
+		}
+	}
+
+	ctx := context.Background()
+	done := make(chan struct{})
+	errCh := make(chan error, concurrency+1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if _, err := decrypter(w.KeyID()); err != nil {
+					errCh <- fmt.Errorf("wrapper reported an unknown key ID %q: %w", w.KeyID(), err)
+					return
+				}
+
+				input := []byte(fmt.Sprintf("goroutine-%d-iteration-%d", i, j))
+				blob, err := w.Encrypt(ctx, input, nil)
+				if err != nil {
+					errCh <- fmt.Errorf("error encrypting: %w", err)
+					return
+				}
+				if blob.KeyInfo == nil {
+					errCh <- fmt.Errorf("encrypted blob does not carry key info")
+					return
+				}
+
+				dec, err := decrypter(blob.KeyInfo.KeyID)
+				if err != nil {
+					errCh <- fmt.Errorf("blob labeled with unknown key ID %q: %w", blob.KeyInfo.KeyID, err)
+					return
+				}
+				pt, err := dec.Decrypt(ctx, blob, nil)
+				if err != nil {
+					errCh <- fmt.Errorf("error decrypting blob labeled with key ID %q: %w", blob.KeyInfo.KeyID, err)
+					return
+				}
+				if !bytes.Equal(input, pt) {
+					errCh <- fmt.Errorf("expected %s, got %s", input, pt)
+					return
+				}
+			}
+		}(i)
+	}
+
+	for i := 0; i < rotations; i++ {
+		if err := opts.Rotate(i); err != nil {
+			errCh <- fmt.Errorf("error rotating: %w", err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Error(err)
+	}
+}
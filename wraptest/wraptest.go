@@ -0,0 +1,283 @@
+// Package wraptest provides a conformance test suite that any
+// wrapping.Wrapper implementation can run from its own tests to show that it
+// behaves the way callers of the library expect.
+package wraptest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+)
+
+// ConformanceOptions adjusts the conformance suite for wrappers that
+// legitimately lack a capability
+type ConformanceOptions struct {
+	// IgnoresAAD should be set for wrappers that do not support additional
+	// authenticated data, such as Vault Transit. The AAD mismatch checks are
+	// skipped for them.
+	IgnoresAAD bool
+
+	// RejectsAAD should be set for wrappers that do not support additional
+	// authenticated data and return an error when given any, such as sealed
+	// boxes. Encrypting and decrypting with AAD are then expected to fail.
+	RejectsAAD bool
+
+	// AcceptsNilPlaintext should be set for wrappers that encrypt a nil
+	// plaintext as an empty one, such as the test wrappers of the wrapping
+	// package. Encrypting nil is then only checked not to panic.
+	AcceptsNilPlaintext bool
+
+	// Concurrency is the number of goroutines used by the concurrency check.
+	// Defaults to 8.
+	Concurrency int
+
+	// Iterations is the number of round trips each goroutine performs in the
+	// concurrency check. Defaults to 25.
+	Iterations int
+}
+
+// RunConformanceTests runs the conformance suite as subtests of t. newWrapper
+// is called once per subtest and must return a wrapper that is configured and
+// ready to encrypt; Init is called on it before use and Finalize after. It is
+// valid to pass nil opts.
+func RunConformanceTests(t *testing.T, newWrapper func(t *testing.T) wrapping.Wrapper, opts *ConformanceOptions) {
+	t.Helper()
+
+	if opts == nil {
+		opts = new(ConformanceOptions)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+	if opts.Iterations <= 0 {
+		opts.Iterations = 25
+	}
+
+	tests := []struct {
+		name string
+		fn   func(*testing.T, wrapping.Wrapper, *ConformanceOptions)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"AAD", testAAD},
+		{"NilInput", testNilInput},
+		{"KeyID", testKeyID},
+		{"Concurrency", testConcurrency},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			w := newWrapper(t)
+			if w == nil {
+				t.Fatal("newWrapper returned a nil wrapper")
+			}
+			ctx := context.Background()
+			if err := w.Init(ctx); err != nil {
+				t.Fatalf("error initializing wrapper: %v", err)
+			}
+			defer func() {
+				if err := w.Finalize(ctx); err != nil {
+					t.Errorf("error finalizing wrapper: %v", err)
+				}
+			}()
+			tc.fn(t, w, opts)
+		})
+	}
+}
+
+func testRoundTrip(t *testing.T, w wrapping.Wrapper, _ *ConformanceOptions) {
+	ctx := context.Background()
+
+	if w.Type() == "" {
+		t.Error("wrapper reports an empty type")
+	}
+
+	inputs := map[string][]byte{
+		"empty": {},
+		"short": []byte("foo"),
+		"large": bytes.Repeat([]byte("0123456789abcdef"), 4096),
+	}
+	for name, input := range inputs {
+		blob, err := w.Encrypt(ctx, input, nil)
+		if err != nil {
+			t.Fatalf("%s: error encrypting: %v", name, err)
+		}
+		if blob == nil {
+			t.Fatalf("%s: encrypt returned a nil blob", name)
+		}
+		if len(input) > 0 && bytes.Contains(blob.Ciphertext, input) {
+			t.Errorf("%s: ciphertext contains the plaintext", name)
+		}
+
+		pt, err := w.Decrypt(ctx, blob, nil)
+		if err != nil {
+			t.Fatalf("%s: error decrypting: %v", name, err)
+		}
+		if !bytes.Equal(input, pt) {
+			t.Fatalf("%s: round trip mismatch: expected %d bytes, got %d bytes", name, len(input), len(pt))
+		}
+	}
+}
+
+func testAAD(t *testing.T, w wrapping.Wrapper, opts *ConformanceOptions) {
+	ctx := context.Background()
+	input := []byte("foo")
+	aad := []byte("bar")
+
+	if opts.RejectsAAD {
+		if _, err := w.Encrypt(ctx, input, aad); err == nil {
+			t.Error("expected an error encrypting with AAD")
+		}
+		blob, err := w.Encrypt(ctx, input, nil)
+		if err != nil {
+			t.Fatalf("error encrypting: %v", err)
+		}
+		if _, err := w.Decrypt(ctx, blob, aad); err == nil {
+			t.Error("expected an error decrypting with AAD")
+		}
+		return
+	}
+
+	blob, err := w.Encrypt(ctx, input, aad)
+	if err != nil {
+		t.Fatalf("error encrypting with AAD: %v", err)
+	}
+	pt, err := w.Decrypt(ctx, blob, aad)
+	if err != nil {
+		t.Fatalf("error decrypting with AAD: %v", err)
+	}
+	if !bytes.Equal(input, pt) {
+		t.Fatalf("expected %s, got %s", input, pt)
+	}
+
+	if opts.IgnoresAAD {
+		return
+	}
+	if _, err := w.Decrypt(ctx, blob, []byte("baz")); err == nil {
+		t.Error("expected an error decrypting with mismatched AAD")
+	}
+	if _, err := w.Decrypt(ctx, blob, nil); err == nil {
+		t.Error("expected an error decrypting with missing AAD")
+	}
+}
+
+func testNilInput(t *testing.T, w wrapping.Wrapper, opts *ConformanceOptions) {
+	ctx := context.Background()
+
+	expectError := func(desc string, fn func() error) {
+		switch err := noPanic(fn); err.(type) {
+		case nil:
+			t.Errorf("expected an error %s", desc)
+		case panicError:
+			t.Errorf("%s: %v", desc, err)
+		}
+	}
+
+	encryptNil := func() error {
+		_, err := w.Encrypt(ctx, nil, nil)
+		return err
+	}
+	if opts.AcceptsNilPlaintext {
+		if err, ok := noPanic(encryptNil).(panicError); ok {
+			t.Errorf("encrypting nil plaintext: %v", err)
+		}
+	} else {
+		expectError("encrypting nil plaintext", encryptNil)
+	}
+	expectError("decrypting a nil blob", func() error {
+		_, err := w.Decrypt(ctx, nil, nil)
+		return err
+	})
+
+	// An empty blob may or may not be an error depending on the wrapper, but
+	// it must not panic
+	err := noPanic(func() error {
+		_, err := w.Decrypt(ctx, &wrapping.EncryptedBlobInfo{}, nil)
+		return err
+	})
+	if _, ok := err.(panicError); ok {
+		t.Errorf("decrypting an empty blob: %v", err)
+	}
+}
+
+func testKeyID(t *testing.T, w wrapping.Wrapper, _ *ConformanceOptions) {
+	ctx := context.Background()
+
+	blob, err := w.Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if blob.KeyInfo == nil {
+		t.Fatal("encrypted blob does not carry key info")
+	}
+	if blob.KeyInfo.KeyID != w.KeyID() {
+		t.Fatalf("key ID mismatch: blob has %q, wrapper reports %q", blob.KeyInfo.KeyID, w.KeyID())
+	}
+
+	// Key ID must be stable across calls when no rotation happens
+	again, err := w.Encrypt(ctx, []byte("foo"), nil)
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if again.KeyInfo == nil || again.KeyInfo.KeyID != blob.KeyInfo.KeyID {
+		t.Fatal("key ID changed between encryptions without rotation")
+	}
+}
+
+func testConcurrency(t *testing.T, w wrapping.Wrapper, opts *ConformanceOptions) {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, opts.Concurrency)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < opts.Iterations; j++ {
+				input := []byte(fmt.Sprintf("goroutine-%d-iteration-%d", i, j))
+				blob, err := w.Encrypt(ctx, input, nil)
+				if err != nil {
+					errCh <- fmt.Errorf("error encrypting: %w", err)
+					return
+				}
+				pt, err := w.Decrypt(ctx, blob, nil)
+				if err != nil {
+					errCh <- fmt.Errorf("error decrypting: %w", err)
+					return
+				}
+				if !bytes.Equal(input, pt) {
+					errCh <- fmt.Errorf("expected %s, got %s", input, pt)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Error(err)
+	}
+}
+
+type panicError struct {
+	val interface{}
+}
+
+func (p panicError) Error() string {
+	return fmt.Sprintf("panic: %v", p.val)
+}
+
+// noPanic runs fn, converting a panic into a returned panicError
+func noPanic(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError{val: r}
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,29 @@
+package wraptest
+
+import (
+	"testing"
+
+	wrapping "github.com/hashicorp/go-kms-wrapping"
+)
+
+func TestConformance_TestWrappers(t *testing.T) {
+	// The test wrappers obscure rather than authenticate, so AAD is ignored,
+	// and they encrypt a nil plaintext as an empty one
+	opts := &ConformanceOptions{IgnoresAAD: true, AcceptsNilPlaintext: true}
+
+	t.Run("Basic", func(t *testing.T) {
+		RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+			return wrapping.NewTestWrapper([]byte("secret"))
+		}, opts)
+	})
+	t.Run("Envelope", func(t *testing.T) {
+		RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+			return wrapping.NewTestEnvelopeWrapper([]byte("secret"))
+		}, opts)
+	})
+	t.Run("Deterministic", func(t *testing.T) {
+		RunConformanceTests(t, func(t *testing.T) wrapping.Wrapper {
+			return wrapping.NewTestDeterministicWrapper(1)
+		}, opts)
+	})
+}